@@ -0,0 +1,16 @@
+package piml
+
+// SetAppendSlices makes the decoder append the items of arrays to the
+// slices it decodes into, instead of replacing what they hold, so
+// layered configs can add to lists set by earlier files:
+//
+//	d := piml.NewDecoder(overrides)
+//	d.SetAppendSlices(true)
+//	err := d.Decode(&cfg) // cfg was decoded from the base file
+//
+// Fields tagged `piml:",append"` are appended to even when the decoder
+// doesn't, and so are the slices nested below them. Either way, an
+// explicit nil still clears a slice.
+func (d *Decoder) SetAppendSlices(on bool) {
+	d.appendSlices = on
+}
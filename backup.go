@@ -0,0 +1,117 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupLayout is the timestamp of backup names, which sort in the
+// order they were made.
+const backupLayout = "20060102T150405.000000000Z"
+
+// backupName returns the name of a backup of the named file made at t,
+// such as config.piml.20240501T120000.000000000Z.bak.
+func backupName(name string, t time.Time) string {
+	return name + "." + t.UTC().Format(backupLayout) + ".bak"
+}
+
+// Backups returns the backups of the named file that WriteFileWithOptions
+// kept, newest first.
+func Backups(name string) ([]string, error) {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	entries, err := os.ReadDir(filepath.Dir(name))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(name) + "."
+	var backups []string
+	for _, e := range entries {
+		stamp, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if stamp, ok = strings.CutSuffix(stamp, ".bak"); !ok {
+			continue
+		}
+		if _, err := time.Parse(backupLayout, stamp); err == nil {
+			backups = append(backups, filepath.Join(filepath.Dir(name), e.Name()))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// Rollback undoes the last WriteFileWithOptions of the named file that
+// kept a backup: the newest backup is written back over the file, as
+// WriteFile writes it, and removed, so that each call goes back one
+// more version. It returns ErrNoBackup if there is none left.
+//
+//	if err := piml.Rollback("config.piml"); errors.Is(err, piml.ErrNoBackup) {
+//		// Nothing to undo.
+//	}
+func Rollback(name string) error {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	backups, err := Backups(name)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		return fmt.Errorf("%w of %s", ErrNoBackup, name)
+	}
+	data, err := os.ReadFile(backups[0])
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(backups[0])
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(name, data, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Remove(backups[0])
+}
+
+// backup copies the named file to a new backup, if it exists and
+// differs from data, the document about to replace it, and removes the
+// oldest backups beyond keep.
+func backup(name string, data []byte, keep int) error {
+	old, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(old, data) {
+		return nil
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := writeAtomic(backupName(name, time.Now()), old, info.Mode().Perm()); err != nil {
+		return err
+	}
+	backups, err := Backups(name)
+	if err != nil {
+		return err
+	}
+	for _, old := range backups[min(keep, len(backups)):] {
+		if err := os.Remove(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
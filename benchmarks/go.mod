@@ -0,0 +1,9 @@
+module github.com/fezcode/go-piml/benchmarks
+
+go 1.25
+
+require github.com/fezcode/go-piml v0.0.0
+
+require gopkg.in/yaml.v3 v3.0.1
+
+replace github.com/fezcode/go-piml => ../
@@ -0,0 +1,36 @@
+// Command benchmarks compares PIML with encoding/json and YAML on the
+// documents of package pimlbench, and prints a table of the results:
+//
+//	cd benchmarks && go run .
+//
+// It is a module of its own, so that the YAML library is not a
+// dependency of go-piml. The same measures run as Go benchmarks with
+//
+//	cd benchmarks && go test -bench .
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fezcode/go-piml/pimlbench"
+	"gopkg.in/yaml.v3"
+)
+
+// YAML is the Codec of gopkg.in/yaml.v3.
+var YAML = pimlbench.Codec{Name: "yaml", Marshal: yaml.Marshal, Unmarshal: yaml.Unmarshal}
+
+// codecs are the formats compared.
+var codecs = []pimlbench.Codec{pimlbench.PIML, pimlbench.JSON, YAML}
+
+func main() {
+	results, err := pimlbench.Run(codecs, pimlbench.Documents())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := pimlbench.WriteTable(os.Stdout, results); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
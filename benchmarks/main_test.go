@@ -0,0 +1,11 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/fezcode/go-piml/pimlbench"
+)
+
+func BenchmarkFormats(b *testing.B) {
+	pimlbench.Benchmark(b, codecs, pimlbench.Documents())
+}
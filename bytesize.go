@@ -0,0 +1,128 @@
+package piml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, written in human form such as "512MiB"
+// or "10GB". It suits memory limits, quotas and buffer sizes.
+//
+// Binary (KiB, MiB, ...) and decimal (KB, MB, ...) units are accepted,
+// case-insensitively. Single-letter units (k, m, g, ...) are binary, as
+// in most command-line tools, and a bare number is a count of bytes.
+type ByteSize int64
+
+// Byte sizes of the units.
+const (
+	sizeB ByteSize = 1
+
+	sizeKiB = 1024 * sizeB
+	sizeMiB = 1024 * sizeKiB
+	sizeGiB = 1024 * sizeMiB
+	sizeTiB = 1024 * sizeGiB
+	sizePiB = 1024 * sizeTiB
+	sizeEiB = 1024 * sizePiB
+
+	sizeKB = 1000 * sizeB
+	sizeMB = 1000 * sizeKB
+	sizeGB = 1000 * sizeMB
+	sizeTB = 1000 * sizeGB
+	sizePB = 1000 * sizeTB
+	sizeEB = 1000 * sizePB
+)
+
+// byteUnits lists the units String can choose from.
+var byteUnits = []struct {
+	name string
+	size ByteSize
+}{
+	{"EiB", sizeEiB}, {"PiB", sizePiB}, {"TiB", sizeTiB}, {"GiB", sizeGiB}, {"MiB", sizeMiB}, {"KiB", sizeKiB},
+	{"EB", sizeEB}, {"PB", sizePB}, {"TB", sizeTB}, {"GB", sizeGB}, {"MB", sizeMB}, {"KB", sizeKB},
+}
+
+// byteUnitsByName maps lowercased unit names to sizes.
+var byteUnitsByName = map[string]ByteSize{
+	"": sizeB, "b": sizeB,
+	"k": sizeKiB, "ki": sizeKiB, "kib": sizeKiB, "kb": sizeKB,
+	"m": sizeMiB, "mi": sizeMiB, "mib": sizeMiB, "mb": sizeMB,
+	"g": sizeGiB, "gi": sizeGiB, "gib": sizeGiB, "gb": sizeGB,
+	"t": sizeTiB, "ti": sizeTiB, "tib": sizeTiB, "tb": sizeTB,
+	"p": sizePiB, "pi": sizePiB, "pib": sizePiB, "pb": sizePB,
+	"e": sizeEiB, "ei": sizeEiB, "eib": sizeEiB, "eb": sizeEB,
+}
+
+// ParseByteSize parses a size such as "512MiB", "10GB", "4k" or "1.5GiB".
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.' && r != '-' && r != '+'
+	})
+	if i == -1 {
+		i = len(s)
+	}
+	num, unit := s[:i], strings.ToLower(strings.TrimSpace(s[i:]))
+
+	size, ok := byteUnitsByName[unit]
+	if !ok {
+		return 0, fmt.Errorf("invalid byte size %q: unknown unit %q", s, s[i:])
+	}
+	if n, err := strconv.ParseInt(num, 10, 64); err == nil {
+		if n != 0 && (n > math.MaxInt64/int64(size) || n < math.MinInt64/int64(size)) {
+			return 0, fmt.Errorf("invalid byte size %q: out of range", s)
+		}
+		return ByteSize(n) * size, nil
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid byte size %q", s)
+	}
+	f *= float64(size)
+	if f != math.Trunc(f) {
+		return 0, fmt.Errorf("invalid byte size %q: not a whole number of bytes", s)
+	}
+	if f >= math.MaxInt64 || f < math.MinInt64 {
+		return 0, fmt.Errorf("invalid byte size %q: out of range", s)
+	}
+	return ByteSize(f), nil
+}
+
+// String returns the size in the unit that represents it exactly with the
+// smallest number, preferring binary units on a tie, e.g. "512MiB", "10GB"
+// or "100B".
+func (b ByteSize) String() string {
+	n, name := b, "B"
+	if b != 0 {
+		for _, u := range byteUnits {
+			if b%u.size == 0 && absSize(b/u.size) < absSize(n) {
+				n, name = b/u.size, u.name
+			}
+		}
+	}
+	return strconv.FormatInt(int64(n), 10) + name
+}
+
+// absSize returns the absolute value of b.
+func absSize(b ByteSize) ByteSize {
+	if b < 0 {
+		return -b
+	}
+	return b
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (b ByteSize) MarshalText() ([]byte, error) {
+	return []byte(b.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *ByteSize) UnmarshalText(text []byte) error {
+	size, err := ParseByteSize(string(text))
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
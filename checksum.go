@@ -0,0 +1,111 @@
+package piml
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// checksumPrefix starts the trailer line of a document with a checksum.
+const checksumPrefix = "(checksum) sha256:"
+
+// SetChecksum makes the encoder end every document with a trailer line
+// holding the SHA-256 of everything written before it:
+//
+//	(checksum) sha256:9f86d081884c7d659a2feaa0c55ad015...
+//
+// A decoder with SetChecksum verifies it, so truncated or tampered files
+// are caught when they are loaded.
+func (e *Encoder) SetChecksum(on bool) {
+	e.checksum = on
+}
+
+// SetChecksum makes the decoder require a checksum trailer, as
+// Encoder.SetChecksum writes it, at the end of the input, and verify it
+// against the bytes before it. A missing trailer or a mismatch is an
+// error wrapping ErrChecksum, reported before anything is decoded. The
+// trailer is not part of the document.
+func (d *Decoder) SetChecksum(on bool) {
+	d.checksum = on
+}
+
+// encodeTrailed encodes v, followed by its checksum and signature
+// trailers.
+func (e *Encoder) encodeTrailed(v reflect.Value) error {
+	var b bytes.Buffer
+	w := e.w
+	e.w = io.MultiWriter(w, &b)
+	defer func() { e.w = w }()
+	if err := e.encodeRoot(v); err != nil {
+		return err
+	}
+	// Line endings were already converted, so the trailers cover the
+	// bytes as written.
+	return e.write(func() error {
+		if e.checksum {
+			sum := sha256.Sum256(b.Bytes())
+			if err := e.writeString(checksumPrefix, hex.EncodeToString(sum[:]), "\n"); err != nil {
+				return err
+			}
+		}
+		if e.signer == nil {
+			return nil
+		}
+		sig, err := e.signer(b.Bytes())
+		if err != nil {
+			return fmt.Errorf("piml: signing: %w", err)
+		}
+		return e.writeString(signaturePrefix, base64.StdEncoding.EncodeToString(sig), "\n")
+	})
+}
+
+// verifyInput checks the signature and checksum of the input, and drops
+// their trailers. The input is checked once; a failure is returned
+// again by every later call, so decoding the same input twice can't
+// get past it.
+func (d *Decoder) verifyInput() error {
+	if !d.verified {
+		d.verified = true
+		d.verifyErr = d.checkInput()
+	}
+	return d.verifyErr
+}
+
+// checkInput does the work of verifyInput.
+func (d *Decoder) checkInput() error {
+	if d.verifier != nil {
+		if err := d.verifySignature(); err != nil {
+			return err
+		}
+	}
+	if !d.checksum {
+		return nil
+	}
+	body, sum, ok := cutTrailer(d.src, checksumPrefix)
+	if !ok {
+		return fmt.Errorf("%w: no %q trailer", ErrChecksum, checksumPrefix)
+	}
+	want, err := hex.DecodeString(sum)
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("%w: invalid SHA-256 %q", ErrChecksum, sum)
+	}
+	if got := sha256.Sum256(body); !bytes.Equal(got[:], want) {
+		return fmt.Errorf("%w: the document doesn't match its checksum", ErrChecksum)
+	}
+	d.setInput(body)
+	return nil
+}
+
+// cutTrailer splits the last line off data if it starts with prefix,
+// returning the bytes before it and the rest of the line.
+func cutTrailer(data []byte, prefix string) ([]byte, string, bool) {
+	end := len(bytes.TrimRight(data, " \t\r\n"))
+	start := bytes.LastIndexByte(data[:end], '\n') + 1
+	value, ok := strings.CutPrefix(strings.TrimSpace(string(data[start:end])), prefix)
+	return data[:start], value, ok
+}
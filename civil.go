@@ -0,0 +1,121 @@
+package piml
+
+import (
+	"fmt"
+	"time"
+)
+
+// Date is a calendar date without a time of day or location,
+// written as "2006-01-02".
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// DateOf returns the date on which t falls, in t's location.
+func DateOf(t time.Time) Date {
+	y, m, d := t.Date()
+	return Date{Year: y, Month: m, Day: d}
+}
+
+// zeroDate is the zero Date as String writes it.
+const zeroDate = "0000-00-00"
+
+// ParseDate parses a date in "2006-01-02" form. "0000-00-00" is the
+// zero Date.
+func ParseDate(s string) (Date, error) {
+	if s == zeroDate {
+		return Date{}, nil
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return Date{}, err
+	}
+	return DateOf(t), nil
+}
+
+// String returns the date in "2006-01-02" form, or "0000-00-00" for
+// the zero Date.
+func (d Date) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+// In returns the time at midnight of the date in loc.
+func (d Date) In(loc *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, 0, 0, 0, 0, loc)
+}
+
+// IsZero reports whether d is the zero Date.
+func (d Date) IsZero() bool {
+	return d == Date{}
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Date) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Date) UnmarshalText(text []byte) error {
+	parsed, err := ParseDate(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// TimeOfDay is a wall-clock time without a date or location,
+// written as "15:04", or "15:04:05" when it has seconds.
+type TimeOfDay struct {
+	Hour   int
+	Minute int
+	Second int
+}
+
+// TimeOfDayOf returns the wall-clock time of t, in t's location.
+func TimeOfDayOf(t time.Time) TimeOfDay {
+	return TimeOfDay{Hour: t.Hour(), Minute: t.Minute(), Second: t.Second()}
+}
+
+// ParseTimeOfDay parses a time in "15:04" or "15:04:05" form.
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse(time.TimeOnly, s)
+	if err != nil {
+		var err2 error
+		if t, err2 = time.Parse("15:04", s); err2 != nil {
+			return TimeOfDay{}, err
+		}
+	}
+	return TimeOfDayOf(t), nil
+}
+
+// String returns the time in "15:04" form, or "15:04:05"
+// if it has seconds.
+func (t TimeOfDay) String() string {
+	if t.Second == 0 {
+		return fmt.Sprintf("%02d:%02d", t.Hour, t.Minute)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+}
+
+// On returns the time of day on date d in loc.
+func (t TimeOfDay) On(d Date, loc *time.Location) time.Time {
+	return time.Date(d.Year, d.Month, d.Day, t.Hour, t.Minute, t.Second, 0, loc)
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (t TimeOfDay) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (t *TimeOfDay) UnmarshalText(text []byte) error {
+	parsed, err := ParseTimeOfDay(string(text))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
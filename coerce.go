@@ -0,0 +1,70 @@
+package piml
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// SetLenient makes the decoder tolerate sloppy numbers and booleans, as
+// people write them by hand: spaces around them, and
+//
+//   - integers with _ between digits, a 0x, 0o or 0b prefix, or written
+//     as a whole float such as 8080.0;
+//   - floats with _ between digits;
+//   - booleans written as yes, no, on, off, y or n, in any case.
+//
+// Fields tagged `piml:",strict"` are read strictly all the same, and
+// fields tagged `piml:",lenient"` are read leniently even when the
+// decoder isn't. Either option applies to the fields nested below the
+// field too, unless they have their own.
+func (d *Decoder) SetLenient(on bool) {
+	d.lenient = on
+}
+
+// setFieldCoercion applies the strict or lenient option of a field.
+func (d *Decoder) setFieldCoercion(opts tagOptions) {
+	switch {
+	case opts.Contains("strict"):
+		d.lenient = false
+	case opts.Contains("lenient"):
+		d.lenient = true
+	}
+}
+
+// coerce rewrites a sloppy scalar for a number or boolean of kind k the
+// way the strict parsers of setScalar read it. Anything it can't make
+// sense of is returned unchanged, for them to report.
+func coerce(k reflect.Kind, s string) string {
+	s = strings.TrimSpace(s)
+	switch k {
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "yes", "y", "on":
+			return "true"
+		case "no", "n", "off":
+			return "false"
+		}
+		return strings.ToLower(s)
+	case reflect.Float32, reflect.Float64:
+		return strings.ReplaceAll(s, "_", "")
+	}
+
+	// Integers
+	s = strings.ReplaceAll(s, "_", "")
+	digits := strings.ToLower(strings.TrimLeft(s, "+-"))
+	if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0o") || strings.HasPrefix(digits, "0b") {
+		if i, err := strconv.ParseInt(s, 0, 64); err == nil {
+			return strconv.FormatInt(i, 10)
+		}
+		if u, err := strconv.ParseUint(s, 0, 64); err == nil {
+			return strconv.FormatUint(u, 10)
+		}
+		return s
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil && f == math.Trunc(f) && math.Abs(f) < 1<<63 && strings.ContainsAny(s, ".eE") {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package piml
+
+import "strings"
+
+// SetInlineComments makes the decoder treat " #" in a single-line value
+// as the start of a comment running to the end of the line:
+//
+//	(port) 8080 # Default HTTP port
+//	(tags)
+//	  > web # Public facing
+//
+// A '#' at the start of a value starts a comment too, so "(db) # Main"
+// is a key with no value. A hash escaped as `\#` is kept literally.
+// Multi-line strings are never affected.
+func (d *Decoder) SetInlineComments(on bool) {
+	d.inlineComments = on
+}
+
+// SetInlineComments makes the encoder escape every '#' that a decoder
+// using SetInlineComments would read as the start of a comment.
+func (e *Encoder) SetInlineComments(on bool) {
+	e.inlineComments = on
+}
+
+// SetComments makes the encoder write the pimlcomment tag of each
+// struct field as a comment above its key, followed by "(required)"
+// for fields with the required option:
+//
+//	Port int `piml:"port" pimlcomment:"Port to listen on"`
+//
+// is written as
+//
+//	# Port to listen on
+//	(port) 8080
+func (e *Encoder) SetComments(on bool) {
+	e.comments = on
+}
+
+// writeFieldComment writes the comment of struct field f, if it has
+// one, for SetComments.
+func (e *Encoder) writeFieldComment(indentStr string, f *structField) error {
+	text := f.comment
+	if f.opts.Contains("required") {
+		text = strings.TrimSpace(text + " (required)")
+	}
+	if text == "" {
+		return nil
+	}
+	return e.writeComment(indentStr, text)
+}
+
+// writeComment writes text as full-line comments, one per line of text.
+func (e *Encoder) writeComment(indentStr, text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		if err := e.writeString(strings.TrimRight(indentStr+"# "+line, " "), "\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
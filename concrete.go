@@ -0,0 +1,65 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// concreteTypes maps the names given to RegisterType to their types.
+var concreteTypes sync.Map
+
+// RegisterType registers T under name, for interface fields tagged
+// `piml:"key,as=name"` to be decoded into, so plugin-style fields work
+// without an Unmarshaler on every struct that has one:
+//
+//	type Handler interface{ Serve() }
+//
+//	type Server struct {
+//		Handler Handler `piml:"handler,as=http"`
+//	}
+//
+//	piml.RegisterType[HTTPHandlerConfig]("http")
+//
+// The field is set to a T, or a *T if only that implements its
+// interface. If it already holds one, the document is merged into it.
+// Registering name again replaces its type.
+func RegisterType[T any](name string) {
+	concreteTypes.Store(name, reflect.TypeFor[T]())
+}
+
+// concreteTarget returns a pointer to the value to decode into for the
+// interface field v of an `as=name` tag, and the function that stores
+// it in the field once it is decoded.
+func concreteTarget(v reflect.Value, name string) (reflect.Value, func(), error) {
+	field := indirect(v, true)
+	if field.Kind() != reflect.Interface {
+		return reflect.Value{}, nil, fmt.Errorf("piml: as=%s needs an interface field, not %s", name, field.Type())
+	}
+	t, ok := concreteTypes.Load(name)
+	if !ok {
+		return reflect.Value{}, nil, fmt.Errorf("piml: no type registered as %q", name)
+	}
+	typ := t.(reflect.Type)
+	byValue := typ.Implements(field.Type())
+	if !byValue && !reflect.PointerTo(typ).Implements(field.Type()) {
+		return reflect.Value{}, nil, fmt.Errorf("piml: %s, registered as %q, does not implement %s", typ, name, field.Type())
+	}
+
+	ptr := reflect.New(typ)
+	if !field.IsNil() {
+		switch existing := field.Elem(); {
+		case existing.Type() == typ:
+			ptr.Elem().Set(existing)
+		case existing.Type() == ptr.Type() && !existing.IsNil():
+			ptr = existing
+		}
+	}
+	return ptr, func() {
+		if byValue {
+			field.Set(ptr.Elem())
+		} else {
+			field.Set(ptr)
+		}
+	}, nil
+}
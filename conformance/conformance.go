@@ -0,0 +1,226 @@
+// Package conformance holds the PIML conformance corpus, the documents
+// that define how PIML reads, and a runner that checks an
+// implementation against it. This package is the reference, so ports to
+// other languages can check themselves with the same cases.
+//
+// The corpus is the cases directory. Each case is a .piml document with
+// either a .json file of the same name, holding the value the document
+// reads as, or a .error file, holding the kind of error it must be
+// rejected with, such as "syntax". Values are generic: objects, lists
+// and sets are JSON objects and arrays, every scalar is a JSON string,
+// and nil and keys with nothing beneath them are null. Implementations
+// in other languages can read the directory directly, or be run as a
+// command with Command:
+//
+//	failures := conformance.Run(conformance.Command("node", "piml-cli.js"), conformance.Cases())
+//	for _, f := range failures {
+//		fmt.Println(f)
+//	}
+package conformance
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+//go:embed cases
+var corpus embed.FS
+
+// A Case is a document of the corpus with what it must read as.
+type Case struct {
+	Name  string // File name without the extension, e.g. "list-of-objects"
+	Input []byte // The PIML document
+	Want  []byte // The expected value as JSON, nil if an error is expected
+	Error string // The expected kind of error, such as "syntax", if any
+}
+
+// A Parser reads a PIML document into generic values: map[string]interface{}
+// for objects, []interface{} for lists and sets, string for scalars and
+// nil. Any value that marshals to the same JSON will do.
+type Parser func(input []byte) (interface{}, error)
+
+// A Failure is a case an implementation got wrong.
+type Failure struct {
+	Case   string // Name of the case
+	Reason string // What went wrong
+}
+
+func (f Failure) String() string {
+	return f.Case + ": " + f.Reason
+}
+
+// errorKinds maps the kinds of errors in .error files to the errors of
+// package piml.
+var errorKinds = map[string]error{
+	"syntax": piml.ErrSyntax,
+}
+
+// Cases returns the cases of the corpus, sorted by name.
+func Cases() []Case {
+	sub, err := fs.Sub(corpus, "cases")
+	if err != nil {
+		panic(err) // The corpus is embedded
+	}
+	cases, err := Load(sub)
+	if err != nil {
+		panic(err)
+	}
+	return cases
+}
+
+// Load reads a corpus laid out like the cases directory from the root
+// of fsys, for corpora of additional cases.
+func Load(fsys fs.FS) ([]Case, error) {
+	names, err := fs.Glob(fsys, "*.piml")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	cases := make([]Case, 0, len(names))
+	for _, name := range names {
+		c := Case{Name: strings.TrimSuffix(name, ".piml")}
+		if c.Input, err = fs.ReadFile(fsys, name); err != nil {
+			return nil, err
+		}
+		want, wantErr := fs.ReadFile(fsys, c.Name+".json")
+		kind, kindErr := fs.ReadFile(fsys, c.Name+".error")
+		switch {
+		case wantErr == nil && kindErr == nil:
+			return nil, fmt.Errorf("conformance: %s has both a .json and an .error file", c.Name)
+		case wantErr == nil:
+			c.Want = want
+		case kindErr == nil:
+			c.Error = strings.TrimSpace(string(kind))
+		default:
+			return nil, fmt.Errorf("conformance: %s has neither a .json nor an .error file", c.Name)
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// Reference is the Parser of this implementation, piml.Unmarshal into
+// an interface{}.
+func Reference(input []byte) (interface{}, error) {
+	var v interface{}
+	err := piml.Unmarshal(input, &v)
+	return v, err
+}
+
+// Command returns a Parser that runs an implementation as a command.
+// The command reads a document on its standard input and writes its
+// value as JSON to its standard output, or exits with a non-zero status
+// if the document is invalid.
+func Command(name string, args ...string) Parser {
+	return func(input []byte) (interface{}, error) {
+		cmd := exec.Command(name, args...)
+		cmd.Stdin = bytes.NewReader(input)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return nil, fmt.Errorf("%w: %s", err, msg)
+			}
+			return nil, err
+		}
+		var v interface{}
+		if err := json.Unmarshal(out, &v); err != nil {
+			return nil, fmt.Errorf("invalid JSON output: %w", err)
+		}
+		return v, nil
+	}
+}
+
+// Run checks p against cases and returns the ones it got wrong. A case
+// with an expected error passes if p fails, and, when p's error wraps
+// one of the errors of package piml, if it is of the expected kind.
+func Run(p Parser, cases []Case) []Failure {
+	var failures []Failure
+	for _, c := range cases {
+		if reason := check(p, c); reason != "" {
+			failures = append(failures, Failure{Case: c.Name, Reason: reason})
+		}
+	}
+	return failures
+}
+
+// check runs p on c and describes what went wrong, if anything.
+func check(p Parser, c Case) string {
+	got, err := parse(p, c.Input)
+	if c.Error != "" {
+		if err == nil {
+			return fmt.Sprintf("expected a %s error, got %s", c.Error, compact(got))
+		}
+		if kind := errorKind(err); kind != "" && kind != c.Error {
+			return fmt.Sprintf("expected a %s error, got a %s error: %v", c.Error, kind, err)
+		}
+		return ""
+	}
+	if err != nil {
+		return fmt.Sprintf("unexpected error: %v", err)
+	}
+	var want interface{}
+	if err := json.Unmarshal(c.Want, &want); err != nil {
+		return fmt.Sprintf("invalid %s.json: %v", c.Name, err)
+	}
+	if !reflect.DeepEqual(normalize(got), want) {
+		return fmt.Sprintf("expected %s, got %s", compact(want), compact(got))
+	}
+	return ""
+}
+
+// parse calls p, turning a panic into an error so that one case can't
+// stop the run.
+func parse(p Parser, input []byte) (v interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return p(input)
+}
+
+// errorKind returns the kind of err, or "" if it is none of the known
+// ones.
+func errorKind(err error) string {
+	for kind, target := range errorKinds {
+		if errors.Is(err, target) {
+			return kind
+		}
+	}
+	return ""
+}
+
+// normalize returns v as encoding/json reads it back, so that values of
+// other types with the same JSON compare equal.
+func normalize(v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return v
+	}
+	return out
+}
+
+// compact returns v as one line of JSON, for messages.
+func compact(v interface{}) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
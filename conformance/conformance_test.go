@@ -0,0 +1,67 @@
+package conformance
+
+import (
+	"errors"
+	"testing"
+	"testing/fstest"
+)
+
+func TestReference(t *testing.T) {
+	cases := Cases()
+	if len(cases) == 0 {
+		t.Fatal("Expected cases in the corpus")
+	}
+	for _, f := range Run(Reference, cases) {
+		t.Error(f)
+	}
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	cases := []Case{
+		{Name: "value", Input: []byte("(a) 1\n"), Want: []byte(`{"a": "1"}`)},
+		{Name: "invalid", Input: []byte("(a\n"), Error: "syntax"},
+	}
+	wrong := func(input []byte) (interface{}, error) {
+		if string(input) == "(a\n" {
+			return map[string]interface{}{}, nil
+		}
+		return map[string]interface{}{"a": 1}, nil
+	}
+	failures := Run(wrong, cases)
+	if len(failures) != 2 {
+		t.Fatalf("Expected 2 failures, got %v", failures)
+	}
+	if want := `value: expected {"a":"1"}, got {"a":1}`; failures[0].String() != want {
+		t.Errorf("Expected %q, got %q", want, failures[0])
+	}
+
+	panicky := func(input []byte) (interface{}, error) { panic("boom") }
+	if failures := Run(panicky, cases[:1]); len(failures) != 1 {
+		t.Errorf("Expected a panic to fail its case, got %v", failures)
+	}
+	failing := func(input []byte) (interface{}, error) { return nil, errors.New("bad input") }
+	if failures := Run(failing, cases[1:]); len(failures) != 0 {
+		t.Errorf("Expected any error to pass an error case, got %v", failures)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.piml":  {Data: []byte("(a) 1\n")},
+		"a.json":  {Data: []byte(`{"a": "1"}`)},
+		"b.piml":  {Data: []byte("(b\n")},
+		"b.error": {Data: []byte("syntax\n")},
+	}
+	cases, err := Load(fsys)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(cases) != 2 || cases[0].Name != "a" || cases[1].Error != "syntax" {
+		t.Fatalf("Unexpected cases: %+v", cases)
+	}
+
+	fsys["c.piml"] = &fstest.MapFile{Data: []byte("(c) 1\n")}
+	if _, err := Load(fsys); err == nil {
+		t.Error("Expected an error for a case without an expectation")
+	}
+}
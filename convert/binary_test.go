@@ -0,0 +1,106 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPIMLToCBOR(t *testing.T) {
+	out, err := PIMLToCBOR([]byte("(b)\n  > true\n  > nil\n(a) 1\n"))
+	if err != nil {
+		t.Fatalf("PIMLToCBOR() error = %v", err)
+	}
+	// Keys keep the order of the document.
+	if want := "a2616282f5f6616101"; hex.EncodeToString(out) != want {
+		t.Fatalf("Expected %s, got %x", want, out)
+	}
+}
+
+func TestCBORToPIML(t *testing.T) {
+	// {_ "name": "web", "ports": [80, 443], "ratio": 1.5 (half), 1: h'0102', "id": -500}
+	in, _ := hex.DecodeString("bf646e616d656377656265706f7274738218501901bb65726174696ff93e00014201026269643901f3ff")
+	out, err := CBORToPIML(in)
+	if err != nil {
+		t.Fatalf("CBORToPIML() error = %v", err)
+	}
+	want := "(name) web\n(ports)\n  > 80\n  > 443\n(ratio) 1.5\n(1) AQI=\n(id) -500\n"
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	for _, in := range []string{"", "a1", "8181f6", "f6f6", "5f41"} {
+		data, _ := hex.DecodeString(in)
+		if _, err := CBORToPIML(data); err == nil {
+			t.Errorf("CBORToPIML(%s): expected an error", in)
+		}
+	}
+}
+
+func TestMessagePack(t *testing.T) {
+	out, err := PIMLToMessagePack([]byte("(b)\n  > true\n  > nil\n(a) 1\n"))
+	if err != nil {
+		t.Fatalf("PIMLToMessagePack() error = %v", err)
+	}
+	if want := "82a16292c3c0a16101"; hex.EncodeToString(out) != want {
+		t.Fatalf("Expected %s, got %x", want, out)
+	}
+
+	// {"id": -500, "big": uint64 max, "neg": -1, "f": float32 0.5, "bin": bin8 01 02}
+	in, _ := hex.DecodeString("85a26964d1fe0ca3626967cfffffffffffffffffa36e6567ffa166ca3f000000a362696ec4020102")
+	out, err = MessagePackToPIML(in)
+	if err != nil {
+		t.Fatalf("MessagePackToPIML() error = %v", err)
+	}
+	want := "(id) -500\n(big) 18446744073709551615\n(neg) -1\n(f) 0.5\n(bin) AQI=\n"
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	if _, err := MessagePackToPIML([]byte{0xd4, 0x01, 0x00}); err == nil {
+		t.Fatal("Expected an error for an extension type")
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	doc := []byte(`(name) app
+(db)
+  (port) 5432
+  (host) localhost
+  (ratio) 0.75
+  (zip) 01234
+(enabled) false
+(owner) nil
+(servers)
+  > (item)
+      (name) web
+(tags)
+  > a
+  > b
+`)
+	for name, conv := range map[string][2]func([]byte) ([]byte, error){
+		"CBOR":        {PIMLToCBOR, CBORToPIML},
+		"MessagePack": {PIMLToMessagePack, MessagePackToPIML},
+	} {
+		bin, err := conv[0](doc)
+		if err != nil {
+			t.Fatalf("%s: encode error = %v", name, err)
+		}
+		back, err := conv[1](bin)
+		if err != nil || !bytes.Equal(back, doc) {
+			t.Errorf("%s: expected:\n%s\nGot:\n%s\nError: %v", name, doc, back, err)
+		}
+	}
+}
+
+func TestBinaryDuplicateKeys(t *testing.T) {
+	// {"a": 1, "b": 2, "a": 3} keeps a first, with its last value.
+	in, _ := hex.DecodeString("a3616101616202616103")
+	out, err := CBORToPIML(in)
+	if err != nil {
+		t.Fatalf("CBORToPIML() error = %v", err)
+	}
+	if want := "(a) 3\n(b) 2\n"; string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+}
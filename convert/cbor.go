@@ -0,0 +1,341 @@
+package convert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// PIMLToCBOR converts a PIML document into CBOR (RFC 8949), so it can
+// feed a binary config pipeline. Objects become maps with text keys, in
+// the order of the document, lists and sets arrays, and nil null. Scalars are typed: true
+// and false become booleans, numbers without leading zeros integers or
+// floats, and everything else text.
+func PIMLToCBOR(data []byte) ([]byte, error) {
+	n, err := piml.ParseNode(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendCBOR(nil, n)
+}
+
+// CBORToPIML converts a CBOR document into PIML, the reverse of
+// PIMLToCBOR. Keys are written in the order of the map, and a key seen
+// twice keeps its first place with its last value. Map keys that are numbers or booleans are written out as
+// text, byte strings are written in base64, and tags are dropped,
+// keeping their content. Arrays of arrays can't be written in PIML, and
+// are an error.
+func CBORToPIML(data []byte) ([]byte, error) {
+	d := &cborDecoder{data: data}
+	n, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("convert: %d bytes of CBOR after the document", len(data)-d.pos)
+	}
+	return piml.Marshal(n)
+}
+
+// CBOR major types.
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// appendCBORHead appends the head of a data item: its major type and
+// argument, in the shortest form.
+func appendCBORHead(b []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(b, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(b, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64(append(b, major|27), arg)
+}
+
+// appendCBOR appends the encoding of the node n.
+func appendCBOR(b []byte, n *piml.Node) ([]byte, error) {
+	switch n.Kind {
+	case piml.NilNode:
+		return append(b, 0xf6), nil
+	case piml.ArrayNode, piml.SetNode:
+		b = appendCBORHead(b, cborArray, uint64(len(n.Children)))
+		for _, item := range n.Children {
+			var err error
+			if b, err = appendCBOR(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case piml.ObjectNode:
+		b = appendCBORHead(b, cborMap, uint64(len(n.Children)))
+		for _, c := range n.Children {
+			b = append(appendCBORHead(b, cborText, uint64(len(c.Key))), c.Key...)
+			var err error
+			if b, err = appendCBOR(b, c); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	switch v := typeScalar(n.Value).(type) {
+	case bool:
+		if v {
+			return append(b, 0xf5), nil
+		}
+		return append(b, 0xf4), nil
+	case int64:
+		if v < 0 {
+			return appendCBORHead(b, cborNegInt, uint64(-(v + 1))), nil
+		}
+		return appendCBORHead(b, cborUint, uint64(v)), nil
+	case uint64:
+		return appendCBORHead(b, cborUint, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xfb), math.Float64bits(v)), nil
+	}
+	return append(appendCBORHead(b, cborText, uint64(len(n.Value))), n.Value...), nil
+}
+
+// maxCBORDepth bounds the nesting of decoded CBOR, so hostile input
+// can't exhaust the stack.
+const maxCBORDepth = 1000
+
+var errCBOREnd = errors.New("convert: unexpected end of CBOR data")
+
+// cborDecoder decodes CBOR data items into nodes.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes.
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOREnd
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads the head of a data item. For indefinite lengths, arg is 0
+// and indefinite is true.
+func (d *cborDecoder) head() (major, info byte, arg uint64, indefinite bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), false, nil
+	case info == 31:
+		return major, info, 0, true, nil
+	case info > 27:
+		return 0, 0, 0, false, fmt.Errorf("convert: malformed CBOR at byte %d", d.pos-1)
+	}
+	b, err = d.next(1 << (info - 24))
+	if err != nil {
+		return 0, 0, 0, false, err
+	}
+	switch len(b) {
+	case 1:
+		arg = uint64(b[0])
+	case 2:
+		arg = uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		arg = uint64(binary.BigEndian.Uint32(b))
+	default:
+		arg = binary.BigEndian.Uint64(b)
+	}
+	return major, info, arg, false, nil
+}
+
+// atBreak consumes the break that ends an indefinite-length item, if
+// it comes next.
+func (d *cborDecoder) atBreak() (bool, error) {
+	if d.pos >= len(d.data) {
+		return false, errCBOREnd
+	}
+	if d.data[d.pos] == 0xff {
+		d.pos++
+		return true, nil
+	}
+	return false, nil
+}
+
+// value decodes the next data item into a node.
+func (d *cborDecoder) value(depth int) (*piml.Node, error) {
+	v, err := d.item(depth)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := v.(*piml.Node); ok {
+		return n, nil
+	}
+	return scalarNode(v)
+}
+
+// item decodes the next data item: a *piml.Node for arrays and maps,
+// and the Go value of scalars, which map keys need the type of.
+func (d *cborDecoder) item(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("convert: CBOR nested too deeply")
+	}
+	start := d.pos
+	major, info, arg, indefinite, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if indefinite && (major == cborUint || major == cborNegInt || major == cborTag) {
+		return nil, fmt.Errorf("convert: malformed CBOR at byte %d", start)
+	}
+
+	switch major {
+	case cborUint:
+		if arg <= math.MaxInt64 {
+			return int64(arg), nil
+		}
+		return arg, nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, fmt.Errorf("convert: CBOR integer at byte %d is too small", start)
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		var s []byte
+		if indefinite {
+			// Chunks of the same major type, up to a break.
+			for {
+				end, err := d.atBreak()
+				if err != nil {
+					return nil, err
+				}
+				if end {
+					break
+				}
+				m, _, n, indef, err := d.head()
+				if err != nil {
+					return nil, err
+				}
+				if m != major || indef {
+					return nil, fmt.Errorf("convert: malformed CBOR string at byte %d", start)
+				}
+				chunk, err := d.next(n)
+				if err != nil {
+					return nil, err
+				}
+				s = append(s, chunk...)
+			}
+		} else if s, err = d.next(arg); err != nil {
+			return nil, err
+		}
+		if major == cborBytes {
+			return append([]byte(nil), s...), nil
+		}
+		if !utf8.Valid(s) {
+			return nil, fmt.Errorf("convert: invalid UTF-8 in CBOR text at byte %d", start)
+		}
+		return string(s), nil
+	case cborArray:
+		list := &piml.Node{Kind: piml.ArrayNode}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if end, err := d.atBreak(); err != nil || end {
+					return list, err
+				}
+			}
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list.Children = append(list.Children, item)
+		}
+		return list, nil
+	case cborMap:
+		m := &piml.Node{Kind: piml.ObjectNode}
+		for i := uint64(0); indefinite || i < arg; i++ {
+			if indefinite {
+				if end, err := d.atBreak(); err != nil || end {
+					return m, err
+				}
+			}
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			key, err := mapKey(k)
+			if err != nil {
+				return nil, err
+			}
+			c, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			c.Key = key
+			setField(m, c)
+		}
+		return m, nil
+	case cborTag:
+		return d.item(depth + 1)
+	}
+
+	// Major type 7: simple values and floats.
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil // null and undefined
+	case 25:
+		return halfToFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("convert: unsupported CBOR simple value %d at byte %d", arg, start)
+}
+
+// halfToFloat converts an IEEE 754 half-precision float.
+func halfToFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
@@ -0,0 +1,61 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// flatten lists the scalars of a PIML document in order, with their
+// paths, as piml.Flatten does. A document that is a single scalar has
+// no keys to write it under, so it is an error.
+func flatten(data []byte) ([]piml.FlatEntry, error) {
+	entries, err := piml.Flatten(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 1 && len(entries[0].Path) == 0 {
+		return nil, errors.New("convert: a single value can't be flattened")
+	}
+	return entries, nil
+}
+
+// unflatten writes entries back as a PIML document, as piml.Unflatten
+// does.
+func unflatten(entries []piml.FlatEntry) ([]byte, error) {
+	data, err := piml.Unflatten(entries)
+	if err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	return data, nil
+}
+
+// text returns the value of e as PIML writes it, so nil is "nil".
+func text(e piml.FlatEntry) string {
+	if e.Value == nil {
+		return "nil"
+	}
+	return *e.Value
+}
+
+// entry returns the flat entry of a value read from a flat file, where
+// "nil" stands for nil as text writes it.
+func entry(path []string, value string) piml.FlatEntry {
+	if value == "nil" {
+		return piml.FlatEntry{Path: path}
+	}
+	return piml.FlatEntry{Path: path, Value: &value}
+}
+
+// splitPath splits a dotted key into its segments, as
+// piml.SplitFlatKey does, so escaped dots stay in their segment.
+func splitPath(key string) ([]string, error) {
+	path := piml.SplitFlatKey(key)
+	for _, seg := range path {
+		if seg == "" {
+			return nil, fmt.Errorf("empty segment in key %q", key)
+		}
+	}
+	return path, nil
+}
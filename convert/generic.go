@@ -0,0 +1,86 @@
+package convert
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// The binary formats go through piml.Node, the generic value of package
+// piml, so objects keep the order of their keys both ways. PIML scalars
+// are plain text, so they are typed on the way to binary: true and
+// false become booleans, numbers integers or floats as the decoder reads
+// them with piml.NumberInt64, and the rest strings.
+
+// typeScalar returns s as a bool, int64, uint64 or float64 if it spells
+// one, and as is otherwise. Numbers with leading zeros, like postal
+// codes, stay strings.
+func typeScalar(s string) interface{} {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	return piml.NumberInt64.Value(s)
+}
+
+// scalarNode returns the node of a decoded scalar, or of nil.
+func scalarNode(v interface{}) (*piml.Node, error) {
+	if v == nil {
+		return &piml.Node{Kind: piml.NilNode}, nil
+	}
+	s, err := scalarText(v)
+	if err != nil {
+		return nil, err
+	}
+	return &piml.Node{Kind: piml.ScalarNode, Value: s}, nil
+}
+
+// setField sets the field of the object n with the key of c to c. A key
+// seen before keeps its place, with the last value.
+func setField(n, c *piml.Node) {
+	for i, f := range n.Children {
+		if f.Key == c.Key {
+			n.Children[i] = c
+			return
+		}
+	}
+	n.Children = append(n.Children, c)
+}
+
+// scalarText returns the PIML text of a decoded scalar. Byte strings
+// are written in base64, as encoding/json does.
+func scalarText(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return "", fmt.Errorf("convert: unsupported value %v", v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v), nil
+	}
+	return "", fmt.Errorf("convert: unsupported value of type %T", v)
+}
+
+// mapKey returns the text of a decoded map key, which PIML needs to be
+// a string; numbers and booleans are written out.
+func mapKey(k interface{}) (string, error) {
+	switch k.(type) {
+	case string, bool, int64, uint64:
+		return scalarText(k)
+	}
+	return "", fmt.Errorf("convert: unsupported map key of type %T", k)
+}
@@ -0,0 +1,117 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// PIMLToINI converts a PIML document into an INI file. Values at the root
+// come first, then a [section] for each root key holding an object or a
+// list, whose values are written with dotted keys as in
+// PIMLToProperties:
+//
+//	(debug) true         debug = true
+//	(db)
+//	  (host) localhost   [db]
+//	  (pool)             host = localhost
+//	    (size) 10        pool.size = 10
+//
+// Dots inside keys and section names are escaped with a backslash, as
+// piml.JoinFlatKey escapes them. Values with leading or trailing spaces,
+// quotes, comment characters or line breaks are quoted, with backslash
+// escapes.
+func PIMLToINI(data []byte) ([]byte, error) {
+	entries, err := flatten(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, e := range entries {
+		if len(e.Path) == 1 {
+			fmt.Fprintf(&b, "%s = %s\n", piml.JoinFlatKey(e.Path), quoteINI(text(e)))
+		}
+	}
+	section := ""
+	for _, e := range entries {
+		if len(e.Path) == 1 {
+			continue
+		}
+		if e.Path[0] != section {
+			if b.Len() > 0 {
+				b.WriteString("\n")
+			}
+			section = e.Path[0]
+			fmt.Fprintf(&b, "[%s]\n", piml.JoinFlatKey(e.Path[:1]))
+		}
+		fmt.Fprintf(&b, "%s = %s\n", piml.JoinFlatKey(e.Path[1:]), quoteINI(text(e)))
+	}
+	return b.Bytes(), nil
+}
+
+// quoteINI quotes value if it would not read back as is.
+func quoteINI(value string) string {
+	if value == strings.TrimSpace(value) && !strings.ContainsAny(value, "\"';#\n\r\\") {
+		return value
+	}
+	return strconv.Quote(value)
+}
+
+// INIToPIML converts an INI file into PIML, the reverse of PIMLToINI.
+// Keys before the first section go at the root, and the keys of a
+// section under it; dotted section names and keys nest, unless the dot
+// is escaped with a backslash, and keys numbered from 0 become lists. Both '=' and ':' separate keys from
+// values, lines starting with ';' or '#' are comments, and quoted values
+// are unquoted.
+func INIToPIML(data []byte) ([]byte, error) {
+	var entries []piml.FlatEntry
+	var section []string
+	s := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+		if line[0] == '[' {
+			name, ok := strings.CutSuffix(line[1:], "]")
+			if !ok {
+				return nil, fmt.Errorf("convert: line %d: unclosed section name", lineNum)
+			}
+			path, err := splitPath(strings.TrimSpace(name))
+			if err != nil {
+				return nil, fmt.Errorf("convert: line %d: %w", lineNum, err)
+			}
+			section = path
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("convert: line %d: expected key = value", lineNum)
+		}
+		path, err := splitPath(strings.TrimSpace(line[:i]))
+		if err != nil {
+			return nil, fmt.Errorf("convert: line %d: %w", lineNum, err)
+		}
+		value := strings.TrimSpace(line[i+1:])
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("convert: line %d: malformed quoted value", lineNum)
+			}
+		} else if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+			value = value[1 : len(value)-1]
+		}
+		full := append(section[:len(section):len(section)], path...)
+		entries = append(entries, entry(full, value))
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	return unflatten(entries)
+}
@@ -0,0 +1,310 @@
+package convert
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"unicode/utf8"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// PIMLToMessagePack converts a PIML document into MessagePack, typing
+// its values as PIMLToCBOR does. Integers are written in the smallest
+// form that holds them, and keys in the order of the document.
+func PIMLToMessagePack(data []byte) ([]byte, error) {
+	n, err := piml.ParseNode(data)
+	if err != nil {
+		return nil, err
+	}
+	return appendMsgpack(nil, n)
+}
+
+// MessagePackToPIML converts a MessagePack document into PIML, the
+// reverse of PIMLToMessagePack. Keys are written in the order of the
+// map, as CBORToPIML writes them. Map keys that are numbers or booleans
+// are written out as text, and binary data is written in base64.
+// Extension types, timestamps included, are an error, and so are arrays
+// of arrays, which can't be written in PIML.
+func MessagePackToPIML(data []byte) ([]byte, error) {
+	d := &msgpackDecoder{data: data}
+	n, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("convert: %d bytes of MessagePack after the document", len(data)-d.pos)
+	}
+	return piml.Marshal(n)
+}
+
+// appendMsgpackLen appends the header of a string, binary, array or
+// map of length n: a fix form if there is one (fix >= 0) and n fits in
+// it, or one of the 8, 16 and 32-bit forms (code8 of 0 has no 8-bit
+// form).
+func appendMsgpackLen(b []byte, n int, fix, fixMax int, code8, code16, code32 byte) []byte {
+	switch {
+	case fix >= 0 && n <= fixMax:
+		return append(b, byte(fix|n))
+	case code8 != 0 && n <= math.MaxUint8:
+		return append(b, code8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, code16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, code32), uint32(n))
+}
+
+// appendMsgpackUint appends the smallest encoding of u.
+func appendMsgpackUint(b []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(b, byte(u))
+	case u <= math.MaxUint8:
+		return append(b, 0xcc, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+}
+
+// appendMsgpack appends the encoding of the node n.
+func appendMsgpack(b []byte, n *piml.Node) ([]byte, error) {
+	switch n.Kind {
+	case piml.NilNode:
+		return append(b, 0xc0), nil
+	case piml.ArrayNode, piml.SetNode:
+		b = appendMsgpackLen(b, len(n.Children), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range n.Children {
+			var err error
+			if b, err = appendMsgpack(b, item); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	case piml.ObjectNode:
+		b = appendMsgpackLen(b, len(n.Children), 0x80, 15, 0, 0xde, 0xdf)
+		for _, c := range n.Children {
+			b = append(appendMsgpackLen(b, len(c.Key), 0xa0, 31, 0xd9, 0xda, 0xdb), c.Key...)
+			var err error
+			if b, err = appendMsgpack(b, c); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	switch v := typeScalar(n.Value).(type) {
+	case bool:
+		if v {
+			return append(b, 0xc3), nil
+		}
+		return append(b, 0xc2), nil
+	case int64:
+		switch {
+		case v >= 0:
+			return appendMsgpackUint(b, uint64(v)), nil
+		case v >= -32:
+			return append(b, byte(v)), nil
+		case v >= math.MinInt8:
+			return append(b, 0xd0, byte(v)), nil
+		case v >= math.MinInt16:
+			return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v)), nil
+		case v >= math.MinInt32:
+			return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v)), nil
+		}
+		return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v)), nil
+	case uint64:
+		return appendMsgpackUint(b, v), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v)), nil
+	}
+	return append(appendMsgpackLen(b, len(n.Value), 0xa0, 31, 0xd9, 0xda, 0xdb), n.Value...), nil
+}
+
+// maxMsgpackDepth bounds the nesting of decoded MessagePack, so hostile
+// input can't exhaust the stack.
+const maxMsgpackDepth = 1000
+
+var errMsgpackEnd = errors.New("convert: unexpected end of MessagePack data")
+
+// msgpackDecoder decodes MessagePack into nodes.
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next returns the next n bytes.
+func (d *msgpackDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errMsgpackEnd
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes.
+func (d *msgpackDecoder) uint(size int) (uint64, error) {
+	b, err := d.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return uint64(b[0]), nil
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b)), nil
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// value decodes the next value into a node.
+func (d *msgpackDecoder) value(depth int) (*piml.Node, error) {
+	v, err := d.item(depth)
+	if err != nil {
+		return nil, err
+	}
+	if n, ok := v.(*piml.Node); ok {
+		return n, nil
+	}
+	return scalarNode(v)
+}
+
+// item decodes the next value: a *piml.Node for arrays and maps, and
+// the Go value of scalars, which map keys need the type of.
+func (d *msgpackDecoder) item(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("convert: MessagePack nested too deeply")
+	}
+	start := d.pos
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(uint64(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(uint64(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(uint64(c&0x1f), start)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8, 16, 32
+		n, err := d.uint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		s, err := d.next(n)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), s...), nil
+	case 0xca:
+		u, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := d.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8, 16, 32, 64
+		u, err := d.uint(1 << (c - 0xcc))
+		if err != nil || u > math.MaxInt64 {
+			return u, err
+		}
+		return int64(u), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8, 16, 32, 64
+		size := 1 << (c - 0xd0)
+		u, err := d.uint(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(u<<shift) >> shift, nil
+	case 0xd9, 0xda, 0xdb: // str 8, 16, 32
+		n, err := d.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n, start)
+	case 0xdc, 0xdd: // array 16, 32
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf: // map 16, 32
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	case 0xc7, 0xc8, 0xc9, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return nil, fmt.Errorf("convert: unsupported MessagePack extension at byte %d", start)
+	}
+	return nil, fmt.Errorf("convert: malformed MessagePack at byte %d", start)
+}
+
+// str reads a string of n bytes, which started at byte start.
+func (d *msgpackDecoder) str(n uint64, start int) (interface{}, error) {
+	s, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(s) {
+		return nil, fmt.Errorf("convert: invalid UTF-8 in MessagePack string at byte %d", start)
+	}
+	return string(s), nil
+}
+
+// arrayOf reads the n items of an array.
+func (d *msgpackDecoder) arrayOf(n uint64, depth int) (interface{}, error) {
+	list := &piml.Node{Kind: piml.ArrayNode}
+	for i := uint64(0); i < n; i++ {
+		item, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list.Children = append(list.Children, item)
+	}
+	return list, nil
+}
+
+// mapOf reads the n entries of a map.
+func (d *msgpackDecoder) mapOf(n uint64, depth int) (interface{}, error) {
+	m := &piml.Node{Kind: piml.ObjectNode}
+	for i := uint64(0); i < n; i++ {
+		k, err := d.item(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, err := mapKey(k)
+		if err != nil {
+			return nil, err
+		}
+		c, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		c.Key = key
+		setField(m, c)
+	}
+	return m, nil
+}
@@ -0,0 +1,188 @@
+package convert
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// PIMLToProperties converts a PIML document into a Java .properties
+// file, with one line per value and dotted keys for nesting:
+//
+//	(db)                 db.host=localhost
+//	  (host) localhost   db.pool.size=10
+//	  (pool)             tags.0=a
+//	    (size) 10
+//	(tags)
+//	  > a
+//
+// List items are numbered from 0. Values are written as in PIML, so nil
+// is "nil", and empty lists and objects, having no values, are left
+// out. Dots inside keys are escaped as piml.JoinFlatKey escapes them,
+// so the key app.name holding (id) is app\\.name.id once the
+// backslash is itself escaped for the file. Characters with a special
+// meaning are escaped with backslashes, and the file is written in
+// UTF-8.
+func PIMLToProperties(data []byte) ([]byte, error) {
+	entries, err := flatten(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, e := range entries {
+		b.WriteString(escapeProperty(piml.JoinFlatKey(e.Path), true))
+		b.WriteString("=")
+		b.WriteString(escapeProperty(text(e), false))
+		b.WriteString("\n")
+	}
+	return b.Bytes(), nil
+}
+
+// PropertiesToPIML converts a Java .properties file into PIML, the
+// reverse of PIMLToProperties: dotted keys become nested keys, and keys
+// numbered from 0 become lists. A dot escaped with a backslash is part
+// of its key. Keys keep the order they first appear in. Comments, continuation lines and escapes, \uXXXX included, are
+// handled as by java.util.Properties, except that the file is read as
+// UTF-8.
+func PropertiesToPIML(data []byte) ([]byte, error) {
+	var entries []piml.FlatEntry
+	s := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for s.Scan() {
+		lineNum++
+		start := lineNum
+		line := strings.TrimLeft(s.Text(), " \t\f")
+		if line == "" || line[0] == '#' || line[0] == '!' {
+			continue
+		}
+		// A line ending in an odd number of backslashes goes on.
+		for continues(line) && s.Scan() {
+			lineNum++
+			line = line[:len(line)-1] + strings.TrimLeft(s.Text(), " \t\f")
+		}
+
+		rawKey, rawValue := splitProperty(line)
+		key, err := unescapeProperty(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("convert: line %d: %w", start, err)
+		}
+		value, err := unescapeProperty(rawValue)
+		if err != nil {
+			return nil, fmt.Errorf("convert: line %d: %w", start, err)
+		}
+		path, err := splitPath(key)
+		if err != nil {
+			return nil, fmt.Errorf("convert: line %d: %w", start, err)
+		}
+		entries = append(entries, entry(path, value))
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("convert: %w", err)
+	}
+	return unflatten(entries)
+}
+
+// continues reports whether line ends in an escaping backslash.
+func continues(line string) bool {
+	n := 0
+	for n < len(line) && line[len(line)-1-n] == '\\' {
+		n++
+	}
+	return n%2 == 1
+}
+
+// splitProperty splits a logical line into its key and value, which
+// are separated by an unescaped '=', ':' or whitespace.
+func splitProperty(line string) (string, string) {
+	i := 0
+	for i < len(line) {
+		c := line[i]
+		if c == '\\' {
+			i += 2
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			break
+		}
+		i++
+	}
+	if i >= len(line) {
+		return line, ""
+	}
+	key, rest := line[:i], strings.TrimLeft(line[i:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+// unescapeProperty replaces the escapes of a key or value.
+func unescapeProperty(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch c := s[i]; c {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("malformed \\u escape in %q", s)
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// escapeProperty escapes s to be written as a key, or as a value.
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', ' ', '#', '!':
+			// Spaces only matter at the start of values, and the rest
+			// at the start of lines.
+			if key || (i == 0 && r == ' ') {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
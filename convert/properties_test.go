@@ -0,0 +1,160 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPIMLToProperties(t *testing.T) {
+	doc := []byte(`(name) web api
+(db)
+  (host) localhost
+  (pool)
+    (size) 10
+(tags)
+  > a
+  > b
+(admins)
+  > (item)
+      (id) 1
+(notes)
+  line 1
+  line 2
+(owner) nil
+(url) http://example.com
+`)
+	out, err := PIMLToProperties(doc)
+	if err != nil {
+		t.Fatalf("PIMLToProperties() error = %v", err)
+	}
+	want := `name=web api
+db.host=localhost
+db.pool.size=10
+tags.0=a
+tags.1=b
+admins.0.id=1
+notes=line 1\nline 2
+owner=nil
+url=http://example.com
+`
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	back, err := PropertiesToPIML(out)
+	if err != nil || string(back) != string(doc) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", doc, back, err)
+	}
+}
+
+func TestPropertiesToPIML(t *testing.T) {
+	in := []byte(`# Legacy settings
+! also a comment
+app.name = My \
+           App
+app.greeting:café
+app.key\ with\ spaces   value
+servers.1.host=b
+servers.0.host=a
+ports.0=80
+empty=
+`)
+	out, err := PropertiesToPIML(in)
+	if err != nil {
+		t.Fatalf("PropertiesToPIML() error = %v", err)
+	}
+	want := `(app)
+  (name) My App
+  (greeting) café
+  (key with spaces) value
+(servers)
+  > (item)
+      (host) a
+  > (item)
+      (host) b
+(ports)
+  > 80
+(empty) 
+`
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	for _, in := range []string{"a=1\na.b=2\n", "a.b=1\na=2\n", "a..b=1\n"} {
+		if _, err := PropertiesToPIML([]byte(in)); err == nil || !strings.HasPrefix(err.Error(), "convert: ") {
+			t.Errorf("PropertiesToPIML(%q): expected an error, got %v", in, err)
+		}
+	}
+}
+
+func TestINI(t *testing.T) {
+	doc := []byte(`(debug) true
+(motd)  hi; there
+(db)
+  (host) localhost
+  (pool)
+    (size) 10
+(tags)
+  > a
+`)
+	out, err := PIMLToINI(doc)
+	if err != nil {
+		t.Fatalf("PIMLToINI() error = %v", err)
+	}
+	want := `debug = true
+motd = "hi; there"
+
+[db]
+host = localhost
+pool.size = 10
+
+[tags]
+0 = a
+`
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	back, err := INIToPIML(out)
+	if err != nil || string(back) != strings.Replace(string(doc), "  hi", " hi", 1) {
+		t.Fatalf("Unexpected round trip:\n%s\nError: %v", back, err)
+	}
+
+	in := []byte(`; Legacy settings
+[server.http]
+port: 8080
+name = 'web'
+`)
+	out, err = INIToPIML(in)
+	want = "(server)\n  (http)\n    (port) 8080\n    (name) web\n"
+	if err != nil || string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", want, out, err)
+	}
+	if _, err := INIToPIML([]byte("[db\n")); err == nil {
+		t.Fatal("Expected an error for an unclosed section")
+	}
+}
+
+func TestFlatKeyDots(t *testing.T) {
+	doc := []byte("(labels)\n  (app.kubernetes.io) web\n  (tier) api\n")
+
+	props, err := PIMLToProperties(doc)
+	want := "labels.app\\\\.kubernetes\\\\.io=web\nlabels.tier=api\n"
+	if err != nil || string(props) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", want, props, err)
+	}
+	back, err := PropertiesToPIML(props)
+	if err != nil || string(back) != string(doc) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", doc, back, err)
+	}
+
+	ini, err := PIMLToINI(doc)
+	want = "[labels]\napp\\.kubernetes\\.io = web\ntier = api\n"
+	if err != nil || string(ini) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", want, ini, err)
+	}
+	back, err = INIToPIML(ini)
+	if err != nil || string(back) != string(doc) {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", doc, back, err)
+	}
+}
@@ -0,0 +1,309 @@
+// Package convert translates PIML documents to and from other formats,
+// for working with systems that only speak those.
+package convert
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// AttributePolicy says how XML attributes map to PIML keys.
+type AttributePolicy int
+
+const (
+	// AttributesAsKeys turns attributes into keys like child elements,
+	// so <db port="5432"> and <db><port>5432</port></db> read the same.
+	// PIML keys are always written back as elements. This is the
+	// default.
+	AttributesAsKeys AttributePolicy = iota
+
+	// AttributesPrefixed turns attributes into keys starting with '@',
+	// such as (@port), and writes such keys back as attributes, so
+	// documents round trip.
+	AttributesPrefixed
+
+	// AttributesIgnored drops attributes.
+	AttributesIgnored
+)
+
+// XMLOptions controls the conversion between PIML and XML.
+type XMLOptions struct {
+	Root       string          // Name of the root element, "piml" if empty
+	Item       string          // Name of list item elements, "item" if empty
+	TextKey    string          // Key for the text of elements with attributes or children, "text" if empty
+	Attributes AttributePolicy // How attributes map to keys
+}
+
+func (o XMLOptions) root() string {
+	if o.Root == "" {
+		return "piml"
+	}
+	return o.Root
+}
+
+func (o XMLOptions) item() string {
+	if o.Item == "" {
+		return "item"
+	}
+	return o.Item
+}
+
+func (o XMLOptions) textKey() string {
+	if o.TextKey == "" {
+		return "text"
+	}
+	return o.TextKey
+}
+
+// PIMLToXML converts a PIML document into XML. The document becomes the
+// root element, each key an element holding its value, and the items of
+// lists and sets item elements:
+//
+//	(db)                  <piml>
+//	  (host) localhost      <db>
+//	(tags)                    <host>localhost</host>
+//	  > a                   </db>
+//	                        <tags>
+//	                          <item>a</item>
+//	                        </tags>
+//	                      </piml>
+//
+// Nil values become empty elements. Keys must be valid XML names.
+func PIMLToXML(data []byte, opts XMLOptions) ([]byte, error) {
+	n, err := piml.ParseNode(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	enc := xml.NewEncoder(&b)
+	enc.Indent("", "  ")
+	if err := writeXML(enc, opts.root(), n, opts); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	b.WriteString("\n")
+	return b.Bytes(), nil
+}
+
+// writeXML writes the node n as the element name.
+func writeXML(enc *xml.Encoder, name string, n *piml.Node, opts XMLOptions) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	switch n.Kind {
+	case piml.ScalarNode:
+		return enc.EncodeElement(n.Value, start)
+	case piml.NilNode:
+		return enc.EncodeElement("", start)
+	}
+
+	var children []*piml.Node
+	for _, c := range n.Children {
+		if attr, ok := strings.CutPrefix(c.Key, "@"); ok && opts.Attributes == AttributesPrefixed &&
+			(c.Kind == piml.ScalarNode || c.Kind == piml.NilNode) {
+			if !isXMLName(attr) {
+				return fmt.Errorf("convert: key %q is not a valid XML name", c.Key)
+			}
+			start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: attr}, Value: c.Value})
+			continue
+		}
+		children = append(children, c)
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, c := range children {
+		name := opts.item()
+		if n.Kind == piml.ObjectNode {
+			if name = c.Key; !isXMLName(name) {
+				return fmt.Errorf("convert: key %q is not a valid XML name", name)
+			}
+		}
+		if err := writeXML(enc, name, c, opts); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// isXMLName reports whether s can be used as an element or attribute
+// name. It accepts a practical subset of the names XML allows.
+func isXMLName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 0x7f:
+		case i > 0 && (r == '-' || r == '.' || r >= '0' && r <= '9'):
+		default:
+			return false
+		}
+	}
+	return s != "" && !strings.HasPrefix(strings.ToLower(s), "xml")
+}
+
+// XMLToPIML converts an XML document into PIML, the reverse of
+// PIMLToXML. The content of the root element becomes the document:
+//
+//   - An element with only text becomes a key with that value, or nil
+//     if it is empty.
+//   - An element whose children are all item elements becomes a list.
+//   - Any other element becomes an object of its attributes, as set by
+//     opts.Attributes, and children. Children sharing a name are
+//     gathered into a list under that key, and text next to children or
+//     attributes is kept under opts.TextKey.
+//
+// Namespaces are dropped, and so are comments and processing
+// instructions.
+func XMLToPIML(data []byte, opts XMLOptions) ([]byte, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var root *piml.Node
+	var stack []*piml.Node
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("convert: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			el := &piml.Node{Kind: piml.ObjectNode, Key: tok.Name.Local}
+			for _, a := range tok.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" || opts.Attributes == AttributesIgnored {
+					continue
+				}
+				key := a.Name.Local
+				if opts.Attributes == AttributesPrefixed {
+					key = "@" + key
+				}
+				el.Children = append(el.Children, &piml.Node{Kind: piml.ScalarNode, Key: key, Value: a.Value})
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, el)
+			} else if root == nil {
+				root = el
+			}
+			stack = append(stack, el)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].Value += string(tok)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("convert: no root element")
+	}
+	return piml.Marshal(shapeXML(root, opts, true))
+}
+
+// XML elements are read into nodes as they are written, then shaped
+// into the nodes of the document. As read, an element is an object node
+// holding its text as its Value, its attributes, named as set by
+// XMLOptions.Attributes, as scalar children, and its child elements as
+// object children.
+
+// xmlParts splits the children of the element el into its attributes
+// and its child elements.
+func xmlParts(el *piml.Node) (attrs, children []*piml.Node) {
+	for _, c := range el.Children {
+		if c.Kind == piml.ScalarNode {
+			attrs = append(attrs, c)
+		} else {
+			children = append(children, c)
+		}
+	}
+	return attrs, children
+}
+
+// shapeXML returns the value of the element el, after its key, or as
+// the whole document.
+func shapeXML(el *piml.Node, opts XMLOptions, root bool) *piml.Node {
+	text := strings.TrimSpace(el.Value)
+	attrs, children := xmlParts(el)
+
+	switch {
+	case len(children) == 0 && len(attrs) == 0:
+		if text == "" {
+			if root {
+				return &piml.Node{Kind: piml.ObjectNode} // An empty document
+			}
+			return &piml.Node{Kind: piml.NilNode}
+		}
+		return &piml.Node{Kind: piml.ScalarNode, Value: text}
+	case len(attrs) == 0 && text == "" && isXMLList(children, opts):
+		list := &piml.Node{Kind: piml.ArrayNode}
+		for _, item := range children {
+			list.Children = append(list.Children, shapeXMLItem(item, opts))
+		}
+		return list
+	}
+	return shapeXMLFields(el, opts)
+}
+
+// shapeXMLItem returns the element el as a list item: a scalar, or an
+// object of its attributes and children.
+func shapeXMLItem(el *piml.Node, opts XMLOptions) *piml.Node {
+	if len(el.Children) == 0 {
+		if s := strings.TrimSpace(el.Value); s != "" {
+			return &piml.Node{Kind: piml.ScalarNode, Value: s}
+		}
+		return &piml.Node{Kind: piml.NilNode}
+	}
+	return shapeXMLFields(el, opts)
+}
+
+// isXMLList reports whether all the children are items.
+func isXMLList(children []*piml.Node, opts XMLOptions) bool {
+	for _, c := range children {
+		if c.Key != opts.item() {
+			return false
+		}
+	}
+	return true
+}
+
+// shapeXMLFields returns the attributes and children of el as the
+// fields of an object.
+func shapeXMLFields(el *piml.Node, opts XMLOptions) *piml.Node {
+	attrs, children := xmlParts(el)
+	obj := &piml.Node{Kind: piml.ObjectNode, Children: attrs}
+	if text := strings.TrimSpace(el.Value); text != "" {
+		obj.Children = append(obj.Children, &piml.Node{Kind: piml.ScalarNode, Key: opts.textKey(), Value: text})
+	}
+
+	// Children sharing a name are listed together, where the first is.
+	count := map[string]int{}
+	for _, c := range children {
+		count[c.Key]++
+	}
+	done := map[string]bool{}
+	for _, c := range children {
+		if done[c.Key] {
+			continue
+		}
+		if count[c.Key] == 1 {
+			v := shapeXML(c, opts, false)
+			v.Key = c.Key
+			obj.Children = append(obj.Children, v)
+			continue
+		}
+		done[c.Key] = true
+		list := &piml.Node{Kind: piml.ArrayNode, Key: c.Key}
+		for _, item := range children {
+			if item.Key == c.Key {
+				list.Children = append(list.Children, shapeXMLItem(item, opts))
+			}
+		}
+		obj.Children = append(obj.Children, list)
+	}
+	return obj
+}
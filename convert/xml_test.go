@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPIMLToXML(t *testing.T) {
+	doc := []byte(`(name) web & api
+(db)
+  (host) localhost
+  (port) 5432
+(tags)
+  > a
+  > b
+(admins)
+  > (item)
+      (id) 1
+(notes)
+  line 1
+  line 2
+(owner) nil
+`)
+	out, err := PIMLToXML(doc, XMLOptions{Root: "config"})
+	if err != nil {
+		t.Fatalf("PIMLToXML() error = %v", err)
+	}
+	want := `<config>
+  <name>web &amp; api</name>
+  <db>
+    <host>localhost</host>
+    <port>5432</port>
+  </db>
+  <tags>
+    <item>a</item>
+    <item>b</item>
+  </tags>
+  <admins>
+    <item>
+      <id>1</id>
+    </item>
+  </admins>
+  <notes>line 1&#xA;line 2</notes>
+  <owner></owner>
+</config>
+`
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	if _, err := PIMLToXML([]byte("(size (bytes\\)) 1\n"), XMLOptions{}); err == nil || !strings.Contains(err.Error(), "not a valid XML name") {
+		t.Fatalf("Expected an invalid name error, got %v", err)
+	}
+}
+
+func TestXMLToPIML(t *testing.T) {
+	in := []byte(`<?xml version="1.0"?>
+<!-- Legacy config -->
+<config xmlns="urn:example" version="2">
+  <server name="web" port="80">
+    <alias>www</alias>
+    <alias>w3</alias>
+  </server>
+  <server name="api">primary</server>
+  <tags><item>a</item><item>b</item></tags>
+  <owner/>
+</config>`)
+
+	out, err := XMLToPIML(in, XMLOptions{})
+	if err != nil {
+		t.Fatalf("XMLToPIML() error = %v", err)
+	}
+	want := `(version) 2
+(server)
+  > (item)
+      (name) web
+      (port) 80
+      (alias)
+        > www
+        > w3
+  > (item)
+      (name) api
+      (text) primary
+(tags)
+  > a
+  > b
+(owner) nil
+`
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	out, err = XMLToPIML([]byte(`<r><db port="1"/></r>`), XMLOptions{Attributes: AttributesIgnored})
+	if err != nil || string(out) != "(db) nil\n" {
+		t.Fatalf("Unexpected output %q, error %v", out, err)
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	opts := XMLOptions{Attributes: AttributesPrefixed}
+	doc := "(db)\n  (@port) 5432\n  (host) localhost\n(tags)\n  > a\n"
+	x, err := PIMLToXML([]byte(doc), opts)
+	if err != nil {
+		t.Fatalf("PIMLToXML() error = %v", err)
+	}
+	if !strings.Contains(string(x), `<db port="5432">`) {
+		t.Fatalf("Expected port as an attribute, got:\n%s", x)
+	}
+	back, err := XMLToPIML(x, opts)
+	if err != nil || string(back) != doc {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", doc, back, err)
+	}
+}
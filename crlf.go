@@ -0,0 +1,43 @@
+package piml
+
+import (
+	"bytes"
+	"io"
+)
+
+// SetCRLF makes the encoder end lines with "\r\n" instead of "\n",
+// for files meant to be edited on Windows. The Decoder reads both.
+func (e *Encoder) SetCRLF(on bool) {
+	e.crlf = on
+}
+
+// write runs fn with the encoder's output converted to \r\n line
+// endings, if SetCRLF is on, and reports the path to the value fn
+// failed on, if any. A panic is reported as an error wrapping
+// ErrInternal.
+func (e *Encoder) write(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = e.pathError(panicError(r))
+		}
+	}()
+	if e.crlf {
+		w := e.w
+		e.w = &crlfWriter{w: w}
+		defer func() { e.w = w }()
+	}
+	e.path = e.path[:0]
+	return e.pathError(fn())
+}
+
+// crlfWriter replaces every "\n" written to it with "\r\n".
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c *crlfWriter) Write(p []byte) (int, error) {
+	if _, err := c.w.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
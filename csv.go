@@ -0,0 +1,333 @@
+package piml
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// MarshalCSV writes v, a slice of flat structs or of pointers to them,
+// as CSV, so a table kept in a config can be edited in a spreadsheet.
+// The header row holds the keys of the struct's fields, in declaration
+// order, and each item is a row holding their values as PIML writes
+// them. Nil values, nil items included, are written as empty cells.
+//
+// A struct is flat if all its fields are scalars, or pointers to them;
+// nested structs, lists and maps are reported as errors.
+func MarshalCSV(v interface{}) ([]byte, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, fmt.Errorf("%w: MarshalCSV needs a slice of structs, got %s", ErrUnsupportedType, rv.Kind())
+	}
+	fields, err := csvFields(rv.Type().Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	row := make([]string, len(fields))
+	for i, f := range fields {
+		row[i] = f.name
+	}
+	if err := w.Write(row); err != nil {
+		return nil, err
+	}
+	for i := 0; i < rv.Len(); i++ {
+		item := indirect(rv.Index(i), false)
+		for j, f := range fields {
+			row[j] = ""
+			if item.Kind() == reflect.Ptr {
+				continue // Nil item
+			}
+			fv, ok := fieldByIndex(item, f.index, false)
+			if !ok {
+				continue
+			}
+			if row[j], err = csvCell(fv); err != nil {
+				return nil, &MarshalerError{Path: fmt.Sprintf("[%d].%s", i, f.name), Err: err}
+			}
+		}
+		if err := w.Write(row); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
+
+// csvCell returns the text of the scalar fv, or "" if it is nil.
+func csvCell(fv reflect.Value) (string, error) {
+	fv = indirect(fv, false)
+	if isNilOrEmpty(fv) {
+		return "", nil
+	}
+	if i, ok := nullableValueField(fv.Type()); ok {
+		fv = fv.Field(i)
+	}
+	return formatPrimitive(fv)
+}
+
+// csvFields returns the fields of t, a flat struct or a pointer to one,
+// which make up the columns of a CSV table.
+func csvFields(t reflect.Type) ([]field, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || !isObjectType(t) {
+		return nil, fmt.Errorf("%w: CSV rows must be structs, got %s", ErrUnsupportedType, t)
+	}
+	fields := cachedFields(t).fields
+	for _, f := range fields {
+		ft := t.FieldByIndex(f.index).Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if isScalarType(ft) {
+			continue
+		}
+		switch ft.Kind() {
+		case reflect.Struct, reflect.Slice, reflect.Array, reflect.Map, reflect.Interface:
+			if _, ok := nullableValueField(ft); !ok {
+				return nil, fmt.Errorf("%w: field %q of %s is not a scalar", ErrUnsupportedType, f.name, t)
+			}
+		}
+	}
+	return fields, nil
+}
+
+// UnmarshalCSV reads a CSV table written by MarshalCSV, or by a
+// spreadsheet, into v, a pointer to a slice of flat structs or of
+// pointers to them. Columns are matched to fields by the keys in the
+// header row; unknown columns are ignored, and empty cells leave their
+// field at its zero value. Cells are decoded like PIML values, so enums,
+// times and the other scalar types work as in a document, except that
+// a "nil" cell is the text nil in fields that can't be nil, such as
+// strings.
+func UnmarshalCSV(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidUnmarshal
+	}
+	slice := rv.Elem()
+	if slice.Kind() != reflect.Slice {
+		return fmt.Errorf("piml: UnmarshalCSV needs a pointer to a slice, got %s", rv.Type())
+	}
+	if _, err := csvFields(slice.Type().Elem()); err != nil {
+		return err
+	}
+	elemType := slice.Type().Elem()
+	structType := elemType
+	for structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+	info := cachedFields(structType)
+
+	r := csv.NewReader(bytes.NewReader(data))
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		slice.Set(reflect.Zero(slice.Type()))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("piml: %w", err)
+	}
+	columns := make([]int, len(header)) // Index in info.fields, or -1
+	for i, key := range header {
+		if j, ok := info.byName[key]; ok {
+			columns[i] = j
+		} else {
+			columns[i] = -1
+		}
+	}
+
+	d := NewDecoder(nil)
+	items := reflect.MakeSlice(slice.Type(), 0, 0)
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("piml: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		item := reflect.New(structType).Elem()
+		for i, cell := range record {
+			if i >= len(columns) || columns[i] < 0 || cell == "" {
+				continue
+			}
+			fv, _ := fieldByIndex(item, info.fields[columns[i]].index, true)
+			var err error
+			if cell == "nil" && !csvNillable(fv.Type()) {
+				err = d.setLiteral(fv, cell)
+			} else {
+				err = d.setPrimitive(fv, cell)
+			}
+			if err != nil {
+				return fmt.Errorf("piml: line %d: column %q: %w", line, header[i], err)
+			}
+		}
+		if elemType.Kind() == reflect.Ptr {
+			item = item.Addr()
+		}
+		items = reflect.Append(items, item)
+	}
+	slice.Set(items)
+	return nil
+}
+
+// csvNillable reports whether a field of type t can be nil, so that a
+// "nil" cell reads as nil rather than as the text nil.
+func csvNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+		return true
+	}
+	_, ok := nullableValueField(t)
+	return ok || zeroIsNil(t)
+}
+
+// CSVToPIML converts a CSV table into a PIML list of objects under key,
+// one "> (item)" object per row with the header's keys, so a table edited
+// in a spreadsheet can be pasted back into a document. Empty cells are
+// left out. An empty key writes the list at the root of the document.
+func CSVToPIML(data []byte, key string) ([]byte, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("piml: %w", err)
+	}
+
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	tokens := []Token{}
+	if key != "" {
+		tokens = append(tokens, Key(key))
+	}
+	tokens = append(tokens, ArrayStart)
+	if len(records) > 0 {
+		header := records[0]
+		for _, record := range records[1:] {
+			tokens = append(tokens, ObjectStart)
+			for i, cell := range record {
+				if i < len(header) && cell != "" {
+					tokens = append(tokens, Key(header[i]), cell)
+				}
+			}
+			tokens = append(tokens, ObjectEnd)
+		}
+	}
+	tokens = append(tokens, ArrayEnd)
+	for _, t := range tokens {
+		if err := e.EncodeToken(t); err != nil {
+			return nil, err
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// PIMLToCSV converts the list of flat objects under key at the root of
+// a PIML document, or the root list if key is empty, into a CSV table,
+// the reverse of CSVToPIML. The columns are the keys of the objects in
+// the order they first appear, and nil values are written as empty
+// cells.
+func PIMLToCSV(data []byte, key string) ([]byte, error) {
+	var header []string
+	var rows []map[string]string
+	column := map[string]bool{}
+
+	var depth int     // Blocks open
+	var listDepth int // Depth of the list once found, -1 after it
+	var field string  // Key waiting for its value in a row
+	var atKey bool    // Whether the last root key was key
+
+	block := func(list bool) func(Position) error {
+		return func(pos Position) error {
+			depth++
+			switch {
+			case list && listDepth == 0 && ((key == "" && depth == 1) || (atKey && depth == 2)):
+				listDepth = depth
+			case listDepth > 0 && depth == listDepth+1 && !list:
+				rows = append(rows, map[string]string{})
+			case listDepth > 0 && depth > listDepth:
+				return fmt.Errorf("piml: %s: the rows of a CSV table must be flat objects", pos)
+			}
+			return nil
+		}
+	}
+	end := func(Position) error {
+		if depth == listDepth {
+			listDepth = -1 // Done, ignore the rest
+		}
+		depth--
+		return nil
+	}
+	// scalar adds value, or an empty cell for nil, to the current row.
+	scalar := func(value *string, pos Position) error {
+		switch {
+		case listDepth <= 0 || depth < listDepth:
+			return nil
+		case depth == listDepth:
+			return fmt.Errorf("piml: %s: the rows of a CSV table must be objects", pos)
+		}
+		if !column[field] {
+			column[field] = true
+			header = append(header, field)
+		}
+		if value != nil {
+			rows[len(rows)-1][field] = *value
+		}
+		return nil
+	}
+	err := Walk(data, Handler{
+		OnKey: func(k string, _ Position) error {
+			if depth == 1 && listDepth == 0 {
+				atKey = k == key
+			}
+			field = k
+			return nil
+		},
+		OnScalar: func(value string, pos Position) error {
+			return scalar(&value, pos)
+		},
+		OnObjectStart: block(false),
+		OnObjectEnd:   end,
+		OnArrayStart:  block(true),
+		OnArrayEnd:    end,
+		OnSetStart:    block(false),
+		OnSetEnd:      end,
+		onNil: func(pos Position) error {
+			return scalar(nil, pos)
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if listDepth == 0 {
+		if key == "" {
+			return nil, errors.New("piml: the document is not a list")
+		}
+		return nil, fmt.Errorf("piml: no list under key %q", key)
+	}
+
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	if len(header) > 0 {
+		w.Write(header)
+	}
+	record := make([]string, len(header))
+	for _, row := range rows {
+		for i, k := range header {
+			record[i] = row[k]
+		}
+		w.Write(record)
+	}
+	w.Flush()
+	return b.Bytes(), w.Error()
+}
@@ -0,0 +1,29 @@
+package piml
+
+import "fmt"
+
+// SetRejectDanglingKeys makes the decoder report an error wrapping
+// ErrDanglingKey for a `(key)` line with nothing indented beneath it,
+// which otherwise leaves its value as it is. Such a key is usually the
+// last line of a truncated file. Write `(key) nil` for a key that
+// deliberately has no value.
+func (d *Decoder) SetRejectDanglingKeys(on bool) {
+	d.rejectDangling = on
+}
+
+// checkDangling checks that a value is indented beneath line, a (key)
+// line at the front of the input, and leaves the input as it was.
+func (d *Decoder) checkDangling(line *lineInfo) error {
+	d.consume()
+	child, err := d.peekChild(line.indent)
+	if err != nil {
+		return err
+	}
+	if child == nil {
+		return fmt.Errorf("%w: line %d: (%s) has nothing beneath it", ErrDanglingKey, line.line, line.key)
+	}
+	// Put the key back in front of its value.
+	d.replay = append([]*lineInfo{child}, d.replay...)
+	d.peekBuf = line
+	return nil
+}
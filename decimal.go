@@ -0,0 +1,102 @@
+package piml
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// Decimal is a decimal number kept as the exact text it was written
+// with, such as "19.99" or "0.10", so currency amounts and other
+// settings that must not be rounded survive a decode and encode
+// unchanged. Unlike float64, "0.10" stays "0.10" and "0.1" + "0.2" can
+// be added exactly with Rat.
+//
+// The text is a sign, digits, an optional fraction and an optional
+// exponent, as in "-1.5e3". The zero Decimal is written as "0".
+type Decimal string
+
+// ParseDecimal checks that s is a decimal number and returns it as a
+// Decimal with the same text.
+func ParseDecimal(s string) (Decimal, error) {
+	i := 0
+	digits := func() bool {
+		start := i
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		return i > start
+	}
+	sign := func() {
+		if i < len(s) && (s[i] == '+' || s[i] == '-') {
+			i++
+		}
+	}
+
+	sign()
+	ok := digits()
+	if ok && i < len(s) && s[i] == '.' {
+		i++
+		ok = digits()
+	}
+	if ok && i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		i++
+		sign()
+		ok = digits()
+	}
+	if !ok || i != len(s) {
+		return "", fmt.Errorf("invalid decimal %q", s)
+	}
+	return Decimal(s), nil
+}
+
+// String returns the text of d.
+func (d Decimal) String() string {
+	if d == "" {
+		return "0"
+	}
+	return string(d)
+}
+
+// Rat returns the exact value of d, or nil if d is not a valid decimal.
+func (d Decimal) Rat() *big.Rat {
+	r, ok := new(big.Rat).SetString(d.String())
+	if !ok {
+		return nil
+	}
+	return r
+}
+
+// Float64 returns the float64 nearest to d, for when exactness no
+// longer matters.
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(d.String(), 64)
+}
+
+// Cmp compares d and other by value, returning -1, 0 or +1, so "1.50"
+// and "1.5" are equal. Both must be valid decimals.
+func (d Decimal) Cmp(other Decimal) int {
+	return d.Rat().Cmp(other.Rat())
+}
+
+// IsZero reports whether the value of d is zero, whatever its text,
+// such as "0.00". This makes `piml:",omitzero"` leave out zero amounts.
+func (d Decimal) IsZero() bool {
+	r := d.Rat()
+	return r != nil && r.Sign() == 0
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Decimal) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Decimal) UnmarshalText(text []byte) error {
+	parsed, err := ParseDecimal(string(text))
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
@@ -0,0 +1,193 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// A Doc builds a PIML document step by step, for programs that generate
+// configs without defining throwaway structs or filling in templates:
+//
+//	doc := piml.NewDoc().
+//		Set("server.port", 8080).
+//		Set("server.host", "localhost").
+//		AppendArray("admins", Admin{Name: "ada"}, Admin{Name: "bob"})
+//	data, err := doc.Bytes()
+//
+// Paths are those of Node.Lookup. Values are converted with NewNode, so
+// they can be anything Marshal writes, nodes included. The methods
+// return the Doc so calls can be chained; the first error stops the
+// build and is returned by Bytes and Err.
+type Doc struct {
+	root *Node
+	err  error
+}
+
+// NewDoc returns a Doc holding an empty document.
+func NewDoc() *Doc {
+	return &Doc{root: &Node{Kind: ObjectNode}}
+}
+
+// Set sets the value at path to value, adding the keys on the way that
+// are missing. List items on the way must exist already.
+func (d *Doc) Set(path string, value interface{}) *Doc {
+	if d.err != nil {
+		return d
+	}
+	n, err := NewNode(value)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	target, err := d.at(path)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	key := target.Key
+	*target = *n
+	target.Key = key
+	return d
+}
+
+// AppendArray appends items to the list at path, which is made if it is
+// missing or nil. An item that is a list itself is an error, as PIML
+// lists can't hold lists.
+func (d *Doc) AppendArray(path string, items ...interface{}) *Doc {
+	if d.err != nil {
+		return d
+	}
+	target, err := d.at(path)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	switch target.Kind {
+	case NilNode:
+		target.Kind = ArrayNode
+	case ObjectNode:
+		if len(target.Children) > 0 {
+			d.err = fmt.Errorf("piml: %s is an object, not a list", path)
+			return d
+		}
+		target.Kind = ArrayNode // Made by at
+	case ArrayNode:
+	default:
+		d.err = fmt.Errorf("piml: %s is a %v, not a list", path, target.Kind)
+		return d
+	}
+	for _, item := range items {
+		n, err := NewNode(item)
+		if err != nil {
+			d.err = err
+			return d
+		}
+		if n.Kind == ArrayNode || n.Kind == SetNode {
+			d.err = fmt.Errorf("piml: %s: lists can't hold lists", path)
+			return d
+		}
+		n.Key = ""
+		target.Children = append(target.Children, n)
+	}
+	return d
+}
+
+// Delete removes the value at path, with its key. Deleting a value that
+// doesn't exist is an error.
+func (d *Doc) Delete(path string) *Doc {
+	if d.err != nil {
+		return d
+	}
+	steps, err := parseNodePath(path)
+	if err != nil {
+		d.err = err
+		return d
+	}
+	if len(steps) == 0 {
+		d.err = errors.New("piml: can't delete the whole document")
+		return d
+	}
+	parent := d.root
+	for _, s := range steps[:len(steps)-1] {
+		if parent = parent.child(s); parent == nil {
+			break
+		}
+	}
+	var target *Node
+	if parent != nil {
+		target = parent.child(steps[len(steps)-1])
+	}
+	if target == nil {
+		d.err = fmt.Errorf("piml: no %s in the document", path)
+		return d
+	}
+	for i, c := range parent.Children {
+		if c == target {
+			parent.Children = append(parent.Children[:i], parent.Children[i+1:]...)
+			break
+		}
+	}
+	return d
+}
+
+// at returns the node at path, adding empty objects for the keys that
+// are missing, and turning nil values on the way into objects.
+func (d *Doc) at(path string) (*Node, error) {
+	steps, err := parseNodePath(path)
+	if err != nil {
+		return nil, err
+	}
+	n := d.root
+	for i, s := range steps {
+		if n.Kind == NilNode && s.index < 0 {
+			n.Kind = ObjectNode
+		}
+		c := n.child(s)
+		if c == nil {
+			if s.index >= 0 || n.Kind != ObjectNode {
+				return nil, fmt.Errorf("piml: no %s in %s", s, nodePath(steps[:i]))
+			}
+			c = &Node{Kind: ObjectNode, Key: s.key}
+			n.Children = append(n.Children, c)
+		}
+		n = c
+	}
+	return n, nil
+}
+
+// nodePath writes steps back as a path, or "the document" if there are
+// none.
+func nodePath(steps []nodeStep) string {
+	if len(steps) == 0 {
+		return "the document"
+	}
+	var b bytes.Buffer
+	for i, s := range steps {
+		if i > 0 && s.index < 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(s.String())
+	}
+	return b.String()
+}
+
+// Node returns the document as a tree of nodes. Changes to the tree
+// change the Doc.
+func (d *Doc) Node() *Node {
+	return d.root
+}
+
+// Err returns the first error met while building the document.
+func (d *Doc) Err() error {
+	return d.err
+}
+
+// Bytes returns the PIML encoding of the document, or the first error
+// met while building it.
+func (d *Doc) Bytes() ([]byte, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	return Marshal(d.root)
+}
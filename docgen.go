@@ -0,0 +1,197 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"html"
+	"reflect"
+	"strings"
+)
+
+// DocFormat is the output format of GenerateDocs.
+type DocFormat int
+
+const (
+	// DocMarkdown writes a Markdown table. This is the default.
+	DocMarkdown DocFormat = iota
+
+	// DocHTML writes an HTML table.
+	DocHTML
+)
+
+// GenerateDocs writes a reference of every key of the struct type of v,
+// which may be a struct or a pointer to one, so the documentation of a
+// config file is generated from the code that reads it. Besides the key
+// and its type, each row shows what these tags say about the field:
+//
+//	type Config struct {
+//		Port int    `piml:"port,required" pimlcomment:"Port to listen on"`
+//		Host string `piml:"host" pimldefault:"localhost"`
+//	}
+//
+// The `required` option and the pimldefault tag are documentation for
+// readers and for ExampleFor; the decoder doesn't enforce or apply them.
+//
+// Keys of nested structs are listed after their parent, with dotted
+// paths such as database.host. The items of a list of structs are
+// written as admins[].name, and the values of a map of structs as
+// servers.*.host.
+func GenerateDocs(v interface{}, format DocFormat) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || isScalarType(t) {
+		return nil, errors.New("piml: GenerateDocs needs a struct")
+	}
+
+	var rows []docRow
+	collectDocs(t, "", map[reflect.Type]bool{}, &rows)
+
+	var b bytes.Buffer
+	if format == DocHTML {
+		writeHTMLDocs(&b, rows)
+	} else {
+		writeMarkdownDocs(&b, rows)
+	}
+	return b.Bytes(), nil
+}
+
+// docRow is the documentation of a key.
+type docRow struct {
+	key      string
+	typ      string
+	def      string // Default value, if hasDef
+	hasDef   bool
+	required bool
+	comment  string
+}
+
+// fieldDoc returns the documentation of struct field sf, whose piml tag
+// options are opts, from its tags.
+func fieldDoc(sf reflect.StructField, opts tagOptions) docRow {
+	def, hasDef := sf.Tag.Lookup("pimldefault")
+	return docRow{
+		def:      def,
+		hasDef:   hasDef,
+		required: opts.Contains("required"),
+		comment:  sf.Tag.Get("pimlcomment"),
+	}
+}
+
+// collectDocs appends a row for every key of struct type t to rows, and
+// then the rows of the structs nested in it. Keys are prefixed with
+// prefix; seen holds the types being listed, to stop at cycles.
+func collectDocs(t reflect.Type, prefix string, seen map[reflect.Type]bool, rows *[]docRow) {
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, f := range cachedFields(t).fields {
+		sf := t.FieldByIndex(f.index)
+		row := fieldDoc(sf, f.opts)
+		row.key = prefix + f.name
+		row.typ = docType(sf.Type)
+		if allowed, ok := f.opts.Get("enum"); ok {
+			row.typ = "one of " + strings.ReplaceAll(allowed, "|", ", ")
+		}
+		*rows = append(*rows, row)
+
+		// Document the fields of nested structs, by the path to them.
+		ft, suffix := docElem(sf.Type)
+		if ft != nil && !seen[ft] {
+			collectDocs(ft, row.key+suffix, seen, rows)
+		}
+	}
+}
+
+// docElem returns the struct type whose fields are nested under a key
+// of type t, if any, and what to add to the key to reach them.
+func docElem(t reflect.Type) (reflect.Type, string) {
+	suffix := "."
+	for {
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		switch {
+		case t.Kind() == reflect.Struct && !isScalarType(t):
+			if _, ok := nullableValueField(t); ok {
+				return nil, ""
+			}
+			return t, suffix
+		case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+			t, suffix = t.Elem(), strings.TrimSuffix(suffix, ".")+"[]."
+		case t.Kind() == reflect.Map && !isSetType(t):
+			t, suffix = t.Elem(), suffix+"*."
+		default:
+			return nil, ""
+		}
+	}
+}
+
+// docType describes the values of type t in the terms of a PIML file.
+func docType(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if i, ok := nullableValueField(t); ok {
+		return docType(t.Field(i).Type)
+	}
+	if isScalarType(t) {
+		return t.String()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		return "object"
+	case reflect.Slice, reflect.Array:
+		return "list of " + docType(t.Elem())
+	case reflect.Map:
+		if isSetType(t) {
+			return "set of " + docType(t.Key())
+		}
+		return "map of " + docType(t.Elem())
+	case reflect.Interface:
+		return "any"
+	}
+	return t.String()
+}
+
+// docHeaders are the column titles of the generated tables.
+var docHeaders = []string{"Key", "Type", "Default", "Required", "Description"}
+
+// writeMarkdownDocs writes rows as a Markdown table.
+func writeMarkdownDocs(b *bytes.Buffer, rows []docRow) {
+	b.WriteString("| " + strings.Join(docHeaders, " | ") + " |\n")
+	b.WriteString(strings.Repeat("| --- ", len(docHeaders)) + "|\n")
+	cell := strings.NewReplacer("|", `\|`, "\n", "<br>").Replace
+	for _, r := range rows {
+		def, required := "", ""
+		if r.hasDef {
+			def = "`" + cell(r.def) + "`"
+		}
+		if r.required {
+			required = "yes"
+		}
+		b.WriteString("| `" + cell(r.key) + "` | " + cell(r.typ) + " | " + def + " | " + required + " | " + cell(r.comment) + " |\n")
+	}
+}
+
+// writeHTMLDocs writes rows as an HTML table.
+func writeHTMLDocs(b *bytes.Buffer, rows []docRow) {
+	b.WriteString("<table>\n  <thead>\n    <tr>")
+	for _, h := range docHeaders {
+		b.WriteString("<th>" + h + "</th>")
+	}
+	b.WriteString("</tr>\n  </thead>\n  <tbody>\n")
+	for _, r := range rows {
+		def, required := "", ""
+		if r.hasDef {
+			def = "<code>" + html.EscapeString(r.def) + "</code>"
+		}
+		if r.required {
+			required = "yes"
+		}
+		b.WriteString("    <tr><td><code>" + html.EscapeString(r.key) + "</code></td><td>" + html.EscapeString(r.typ) +
+			"</td><td>" + def + "</td><td>" + required + "</td><td>" + html.EscapeString(r.comment) + "</td></tr>\n")
+	}
+	b.WriteString("  </tbody>\n</table>\n")
+}
@@ -0,0 +1,507 @@
+package piml
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An Editor changes a PIML document in place, for tools like a
+// "piml set" command working on files that people maintain by hand.
+// Only the lines of the values that are set or deleted change: other
+// lines, comments, blank lines and the order of keys are kept byte for
+// byte.
+//
+//	ed, err := piml.OpenEditor("config.piml")
+//	if err != nil {
+//		return err
+//	}
+//	if err := ed.Set("server.port", 9090); err != nil {
+//		return err
+//	}
+//	if err := ed.Delete("legacy"); err != nil {
+//		return err
+//	}
+//	return ed.Save()
+//
+// Paths are those of Node.Lookup. New lines are indented like their
+// siblings, and end in \r\n if the document's lines do.
+type Editor struct {
+	name           string   // File to save to, if opened from one
+	lines          []string // Lines of the document, with their line endings
+	deleteComments bool
+}
+
+// NewEditor returns an editor for the document data, which must be
+// valid PIML.
+func NewEditor(data []byte) (*Editor, error) {
+	ed := &Editor{lines: splitLines(string(data))}
+	if _, err := ed.spans(); err != nil {
+		return nil, err
+	}
+	return ed, nil
+}
+
+// OpenEditor returns an editor for the document in the named file,
+// which Save writes back to.
+func OpenEditor(name string) (*Editor, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := NewEditor(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	ed.name = name
+	return ed, nil
+}
+
+// Delete removes the value at path from the document data, as
+// Editor.Delete does, and returns the document left.
+//
+//	data, err = piml.Delete(data, "features[2]")
+func Delete(data []byte, path string) ([]byte, error) {
+	ed, err := NewEditor(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := ed.Delete(path); err != nil {
+		return nil, err
+	}
+	return ed.Bytes(), nil
+}
+
+// SetDeleteComments sets whether Delete also removes the comments just
+// above a value, up to the first blank line, which usually describe it.
+// Comments are kept by default.
+func (ed *Editor) SetDeleteComments(on bool) {
+	ed.deleteComments = on
+}
+
+// Bytes returns the document, with the changes made so far.
+func (ed *Editor) Bytes() []byte {
+	return []byte(strings.Join(ed.lines, ""))
+}
+
+// Save writes the document back to the file it was opened from,
+// keeping the file's permissions. The file is replaced atomically, as
+// WriteFile replaces it.
+func (ed *Editor) Save() error {
+	if ed.name == "" {
+		return errors.New("piml: the editor wasn't opened from a file")
+	}
+	info, err := os.Stat(ed.name)
+	if err != nil {
+		return err
+	}
+	return writeAtomic(ed.name, ed.Bytes(), info.Mode().Perm())
+}
+
+// Lookup returns the node at path in the document as it is now, or nil
+// if there is none.
+func (ed *Editor) Lookup(path string) *Node {
+	n, err := ParseNode(ed.Bytes())
+	if err != nil {
+		return nil
+	}
+	return n.Lookup(path)
+}
+
+// Set sets the value at path, written as Marshal writes it. The lines of
+// an existing value are replaced, keeping its key as written. Missing
+// keys are added after the last field of the nearest object on the
+// path, or in place of a nil value; list items must exist already.
+func (ed *Editor) Set(path string, value interface{}) error {
+	steps, err := parseNodePath(path)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return errors.New("piml: can't set the whole document")
+	}
+	n, err := NewNode(value)
+	if err != nil {
+		return err
+	}
+	spans, err := ed.spans()
+	if err != nil {
+		return err
+	}
+	if s, ok := spans[nodePathKey(steps)]; ok {
+		return ed.replace(s, n)
+	}
+
+	// Find the nearest value on the path that exists.
+	depth := len(steps) - 1
+	for depth > 0 && spans[nodePathKey(steps[:depth])] == nil {
+		depth--
+	}
+	var keys []string
+	for _, s := range steps[depth:] {
+		if s.index >= 0 {
+			return fmt.Errorf("piml: no %s in %s", s, nodePath(steps[:depth]))
+		}
+		keys = append(keys, s.key)
+	}
+	parent := spans[nodePathKey(steps[:depth])]
+	switch parent.kind {
+	case ObjectNode:
+		return ed.insertField(parent, keys[0], nestNode(n, keys[1:]))
+	case NilNode:
+		return ed.replace(parent, nestNode(n, keys))
+	}
+	return fmt.Errorf("piml: %s is a %v, not an object", nodePath(steps[:depth]), parent.kind)
+}
+
+// nestNode returns n inside objects with the keys, outermost first.
+func nestNode(n *Node, keys []string) *Node {
+	for i := len(keys) - 1; i >= 0; i-- {
+		n = &Node{Kind: ObjectNode, Children: []*Node{withKey(n, keys[i])}}
+	}
+	return n
+}
+
+// withKey returns n with its key set to key.
+func withKey(n *Node, key string) *Node {
+	c := *n
+	c.Key = key
+	return &c
+}
+
+// Delete removes the value at path, with its key, and the lines below
+// it. Deleting the last item of a list leaves the list nil. A blank line
+// left next to another, or at the start or end of the document, is
+// removed too.
+func (ed *Editor) Delete(path string) error {
+	steps, err := parseNodePath(path)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return errors.New("piml: can't delete the whole document")
+	}
+	spans, err := ed.spans()
+	if err != nil {
+		return err
+	}
+	s, ok := spans[nodePathKey(steps)]
+	if !ok {
+		return fmt.Errorf("piml: no %s in the document", path)
+	}
+	parent := spans[nodePathKey(steps[:len(steps)-1])]
+	from, to := s.line, s.end+1
+	if ed.deleteComments {
+		for from > 0 && isComment(ed.lines[from-1]) {
+			from--
+		}
+	}
+	if isBlank(ed.lines, from-1) && (isBlank(ed.lines, to) || to == len(ed.lines)) || from == 0 && isBlank(ed.lines, to) {
+		if from > 0 {
+			from--
+		} else {
+			to++
+		}
+	}
+	ed.splice(from, to, nil)
+	if (parent.kind == ArrayNode || parent.kind == SetNode) && parent.items == 1 && parent.line >= 0 {
+		// (key) alone would be an empty object.
+		ed.lines[parent.line] = ed.keyPrefix(parent.line) + " nil" + lineEnding(ed.lines[parent.line])
+	}
+	return nil
+}
+
+// editSpan is where a value of the document is: its first line, which
+// holds its key or "> ", and the last line of its content.
+type editSpan struct {
+	line   int // Index in lines, or -1 for the root
+	end    int // Index of the last line, for values on several lines
+	kind   NodeKind
+	item   bool // Whether it is a list item
+	items  int  // Number of children, for objects, arrays and sets
+	indent string
+}
+
+// spans finds the values of the document, by path.
+func (ed *Editor) spans() (map[string]*editSpan, error) {
+	type frame struct {
+		path string
+		span *editSpan
+	}
+	spans := map[string]*editSpan{}
+	var stack []frame
+	var key string
+	keyLine := -1
+
+	// add records the value whose first line is at pos, which is the
+	// key's line for the values of keys.
+	add := func(kind NodeKind, pos Position) frame {
+		s := &editSpan{line: -1, end: ed.lastContent(), kind: kind}
+		if len(stack) == 0 {
+			spans[""] = s
+			return frame{span: s}
+		}
+		parent := stack[len(stack)-1]
+		var path string
+		if parent.span.kind == ObjectNode {
+			path = key
+			if parent.path != "" {
+				path = parent.path + "." + key
+			}
+			s.line = keyLine
+		} else {
+			path = parent.path + "[" + strconv.Itoa(parent.span.items) + "]"
+			s.line = pos.Line - 1
+			s.item = true
+		}
+		parent.span.items++
+		s.indent = leadingSpace(ed.lines[s.line])
+		s.end = ed.blockEnd(s.line)
+		spans[path] = s
+		return frame{path: path, span: s}
+	}
+	open := func(kind NodeKind) func(Position) error {
+		return func(pos Position) error {
+			stack = append(stack, add(kind, pos))
+			return nil
+		}
+	}
+	end := func(Position) error {
+		stack = stack[:len(stack)-1]
+		return nil
+	}
+	err := Walk(ed.Bytes(), Handler{
+		OnKey: func(k string, pos Position) error {
+			key, keyLine = k, pos.Line-1
+			return nil
+		},
+		OnScalar: func(_ string, pos Position) error {
+			add(ScalarNode, pos)
+			return nil
+		},
+		OnObjectStart: open(ObjectNode),
+		OnObjectEnd:   end,
+		OnArrayStart:  open(ArrayNode),
+		OnArrayEnd:    end,
+		OnSetStart:    open(SetNode),
+		OnSetEnd:      end,
+		onNil: func(pos Position) error {
+			add(NilNode, pos)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if spans[""] == nil {
+		spans[""] = &editSpan{line: -1, end: -1, kind: ObjectNode} // An empty document
+	}
+	return spans, nil
+}
+
+// lastContent returns the index of the last line that isn't blank or a
+// comment, or -1 if there is none.
+func (ed *Editor) lastContent() int {
+	for j := len(ed.lines) - 1; j >= 0; j-- {
+		if isContent(ed.lines[j]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// isComment reports whether line is a comment.
+func isComment(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// isBlank reports whether lines[i] exists and is blank.
+func isBlank(lines []string, i int) bool {
+	return i >= 0 && i < len(lines) && strings.TrimSpace(lines[i]) == ""
+}
+
+// isContent reports whether line is neither blank nor a comment.
+func isContent(line string) bool {
+	text := strings.TrimSpace(line)
+	return text != "" && !strings.HasPrefix(text, "#")
+}
+
+// blockEnd returns the index of the last line of the value starting at
+// line i: the last line with content indented deeper than line i,
+// before the next one that isn't.
+func (ed *Editor) blockEnd(i int) int {
+	indent := len(leadingSpace(ed.lines[i]))
+	end := i
+	for j := i + 1; j < len(ed.lines); j++ {
+		if !isContent(ed.lines[j]) {
+			continue
+		}
+		if len(leadingSpace(ed.lines[j])) <= indent {
+			break
+		}
+		end = j
+	}
+	return end
+}
+
+// insertField adds key with the value n as the last field of the object
+// at s.
+func (ed *Editor) insertField(s *editSpan, key string, n *Node) error {
+	lines, err := renderNode(&Node{Kind: ObjectNode, Children: []*Node{withKey(n, key)}})
+	if err != nil {
+		return err
+	}
+	// Indent new fields like the others, or one level deeper than the
+	// object, which is two for the fields of list items.
+	indent := ""
+	switch unit := ed.indentUnit(); {
+	case s.items > 0:
+		indent = leadingSpace(ed.lines[ed.firstChild(s)])
+	case s.line >= 0 && s.item:
+		indent = s.indent + unit + unit
+	case s.line >= 0:
+		indent = s.indent + unit
+	}
+	at := s.end + 1
+	ed.splice(at, at, ed.indentLines(lines, indent))
+	return nil
+}
+
+// firstChild returns the index of the first line with content below
+// line s.line, or of the document for the root.
+func (ed *Editor) firstChild(s *editSpan) int {
+	for j := s.line + 1; j <= s.end; j++ {
+		if isContent(ed.lines[j]) {
+			return j
+		}
+	}
+	return s.line
+}
+
+// replace writes n in place of the value at s, keeping its key as
+// written.
+func (ed *Editor) replace(s *editSpan, n *Node) error {
+	var lines []string
+	var err error
+	if s.item {
+		lines, err = renderNode(&Node{Kind: ArrayNode, Children: []*Node{withKey(n, "")}})
+	} else {
+		lines, err = renderNode(&Node{Kind: ObjectNode, Children: []*Node{withKey(n, "k")}})
+	}
+	if err != nil {
+		return err
+	}
+	lines = ed.indentLines(lines, s.indent)
+	if !s.item {
+		// Keep the key as written, with what follows it rendered anew.
+		_, after, _ := cutKey(strings.TrimLeft(lines[0], " \t")[1:])
+		lines[0] = ed.keyPrefix(s.line) + after
+	}
+	ed.splice(s.line, s.end+1, lines)
+	return nil
+}
+
+// keyPrefix returns line i up to the end of its key: its indentation
+// and (key).
+func (ed *Editor) keyPrefix(i int) string {
+	line := strings.TrimRight(ed.lines[i], "\r\n")
+	text := strings.TrimLeft(line, " \t")
+	_, rest, _ := cutKey(text[1:])
+	return line[:len(line)-len(rest)]
+}
+
+// indentLines indents rendered lines by indent, and by the document's
+// indentation unit for each level of their own, and gives them the
+// document's line ending.
+func (ed *Editor) indentLines(lines []string, indent string) []string {
+	eol := "\n"
+	if len(ed.lines) > 0 && strings.HasSuffix(ed.lines[0], "\r\n") {
+		eol = "\r\n"
+	}
+	unit := ed.indentUnit()
+	out := make([]string, len(lines))
+	for i, line := range lines {
+		n := len(leadingSpace(line))
+		out[i] = indent + strings.Repeat(unit, n/len(indentString(1))) + line[n:] + eol
+	}
+	return out
+}
+
+// indentUnit returns the indentation of the document's first nested
+// field, relative to its key, or that of Marshal if there is none.
+func (ed *Editor) indentUnit() string {
+	for i, line := range ed.lines {
+		text := strings.TrimSpace(line)
+		if !strings.HasPrefix(text, "(") {
+			continue
+		}
+		if _, rest, _ := cutKey(text[1:]); strings.TrimSpace(rest) != "" {
+			continue // (key) value
+		}
+		for _, next := range ed.lines[i+1:] {
+			if !isContent(next) {
+				continue
+			}
+			indent, child := leadingSpace(line), leadingSpace(next)
+			if strings.HasPrefix(strings.TrimSpace(next), "(") && len(child) > len(indent) && strings.HasPrefix(child, indent) {
+				return child[len(indent):]
+			}
+			break
+		}
+	}
+	return indentString(1)
+}
+
+// splice replaces lines[from:to] with repl.
+func (ed *Editor) splice(from, to int, repl []string) {
+	if from > 0 && from == len(ed.lines) && len(repl) > 0 && lineEnding(ed.lines[from-1]) == "" {
+		ed.lines[from-1] += lineEnding(repl[0]) // The last line had none
+	}
+	lines := append([]string(nil), ed.lines[:from]...)
+	lines = append(lines, repl...)
+	ed.lines = append(lines, ed.lines[to:]...)
+}
+
+// renderNode returns the lines of n as Marshal writes them.
+func renderNode(n *Node) ([]string, error) {
+	data, err := Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"), nil
+}
+
+// nodePathKey returns the path of steps as spans are keyed.
+func nodePathKey(steps []nodeStep) string {
+	if len(steps) == 0 {
+		return ""
+	}
+	return nodePath(steps)
+}
+
+// splitLines splits s into lines, keeping their line endings.
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// leadingSpace returns the indentation of line.
+func leadingSpace(line string) string {
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// lineEnding returns the line ending of line, if it has one.
+func lineEnding(line string) string {
+	return line[len(strings.TrimRight(line, "\r\n")):]
+}
@@ -0,0 +1,157 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// A RawMessage is a PIML document held as a value of another one, for
+// plugin configs and the like that a host application stores without
+// knowing their types. It is written as a multi-line string with each
+// line of the document behind "| ", and read back as the document, to
+// be decoded later with Unmarshal:
+//
+//	type Plugin struct {
+//		Name   string          `piml:"name"`
+//		Config piml.RawMessage `piml:"config"`
+//	}
+//
+// is written as
+//
+//	(name) cache
+//	(config)
+//	  | (size) 64
+//	  | (ttl) 5m
+//
+// Fields of other types tagged `piml:",pimlstring"` are written the same
+// way, and read straight into their type, where they may also be
+// written as a plain nested value.
+type RawMessage []byte
+
+var rawMessageType = reflect.TypeOf(RawMessage(nil))
+
+// isRawMessage reports whether v is a RawMessage, or points to one.
+func isRawMessage(v reflect.Value) bool {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t == rawMessageType
+}
+
+// nestedEncoder returns an encoder writing to w with e's settings, for
+// documents written inside the one e is writing, as
+// Decoder.cloneSettings does for reading them. Settings that apply to
+// e's output as a whole, like line endings, flat keys, checksums and
+// signatures, are not copied.
+func (e *Encoder) nestedEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:              w,
+		redact:         e.redact,
+		mask:           e.mask,
+		order:          e.order,
+		spacing:        e.spacing,
+		inlineComments: e.inlineComments,
+		comments:       e.comments,
+		keyNormalizer:  e.keyNormalizer,
+		tagKeys:        e.tagKeys,
+		encryptor:      e.encryptor,
+	}
+}
+
+// encodePIMLString writes the value v of a pimlstring field as a
+// document, like a RawMessage.
+func (e *Encoder) encodePIMLString(v reflect.Value, indent int) error {
+	var b bytes.Buffer
+	nested := e.nestedEncoder(&b)
+	if err := nested.write(func() error { return nested.encodeValue(v, -1, false) }); err != nil {
+		return err
+	}
+	return e.encodeDocument(b.Bytes(), indent, false)
+}
+
+// encodeDocument writes doc as the value of the key just written, with
+// each of its lines behind "| ".
+func (e *Encoder) encodeDocument(doc []byte, indent int, inArray bool) error {
+	if inArray {
+		return errors.New("piml: list items can't hold documents")
+	}
+	text := strings.TrimRight(strings.ReplaceAll(string(doc), "\r\n", "\n"), "\n")
+	if indent > -1 {
+		if err := e.writeString("\n"); err != nil {
+			return err
+		}
+	}
+	if text == "" {
+		return nil // (key) alone
+	}
+	indentStr := indentString(indent + 1)
+	for _, line := range strings.Split(text, "\n") {
+		var err error
+		if line == "" {
+			err = e.writeString(indentStr, "|\n")
+		} else {
+			err = e.writeString(indentStr, "| ", line, "\n")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeDocument reads a document written by encodeDocument into v, a
+// RawMessage or the target of a pimlstring field.
+func (d *Decoder) decodeDocument(v reflect.Value, currentIndent int) error {
+	line, err := d.peekChild(currentIndent)
+	if err != nil || line == nil {
+		return err // (key) alone, an empty document
+	}
+	raw := isRawMessage(v)
+	if line.lineType != lineMultiLine {
+		if raw {
+			return fmt.Errorf("%w: line %d: expected the lines of a document behind |", ErrSyntax, line.line)
+		}
+		return d.decodeValue(v, currentIndent) // Written in place
+	}
+
+	first := line.line
+	var s string
+	if err := d.decodeMultiLineString(reflect.ValueOf(&s), currentIndent); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for i, text := range strings.Split(s, "\n") {
+		switch {
+		case text == "|":
+		case strings.HasPrefix(text, "| "):
+			b.WriteString(text[2:])
+		case strings.HasPrefix(text, "|"):
+			b.WriteString(text[1:])
+		default:
+			return fmt.Errorf("%w: line %d: expected a line of a document behind |", ErrSyntax, first+i)
+		}
+		b.WriteByte('\n')
+	}
+	if raw {
+		indirect(v, true).SetBytes(b.Bytes())
+		return nil
+	}
+
+	target := indirect(v, true)
+	if !target.CanAddr() {
+		return fmt.Errorf("piml: cannot unmarshal a document into %s", v.Type())
+	}
+	sub := d.cloneSettings(b.Bytes())
+	err = sub.Decode(target.Addr().Interface())
+	d.warned = append(d.warned, sub.warned...)
+	d.decoded = append(d.decoded, sub.decoded...)
+	if err != nil {
+		return fmt.Errorf("piml: in the document at line %d: %w", first, err)
+	}
+	return nil
+}
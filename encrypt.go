@@ -0,0 +1,77 @@
+package piml
+
+import (
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// encPrefix starts an encrypted scalar, followed by the version of its
+// format and the ciphertext in base64, e.g. "enc:v1:q83vEjRWeJA=".
+const (
+	encPrefix   = "enc:"
+	encVersion1 = "v1:"
+)
+
+// An Encryptor encrypts the values of secret fields, e.g. with a key
+// held by a KMS. The ciphertext should name the key it was made with,
+// if there are several, for the Decryptor to find it.
+type Encryptor interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+}
+
+// A Decryptor decrypts what an Encryptor encrypted.
+type Decryptor interface {
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// SetEncryptor makes the encoder write the value of every field tagged
+// `piml:",secret"` encrypted with enc, so secrets in committed config
+// files stay encrypted at rest:
+//
+//	(password) enc:v1:q83vEjRWeJA=
+//
+// Secrets must be scalars. Redact takes precedence, and nil and empty
+// secrets are still written as `nil`.
+func (e *Encoder) SetEncryptor(enc Encryptor) {
+	e.encryptor = enc
+}
+
+// SetDecryptor makes the decoder decrypt "enc:v1:" scalars with dec,
+// wherever they are, before setting them. Without a decryptor they are
+// kept as they are, like unresolved references.
+func (d *Decoder) SetDecryptor(dec Decryptor) {
+	d.decryptor = dec
+}
+
+// encrypt returns the encrypted text of the scalar v.
+func (e *Encoder) encrypt(v reflect.Value) (string, error) {
+	s, err := formatPrimitive(indirect(v, false))
+	if err != nil {
+		return "", fmt.Errorf("piml: only scalars can be encrypted: %w", err)
+	}
+	ciphertext, err := e.encryptor.Encrypt([]byte(s))
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot encrypt: %w", err)
+	}
+	return encPrefix + encVersion1 + base64.StdEncoding.EncodeToString(ciphertext), nil
+}
+
+// decrypt returns the plaintext of an "enc:" scalar.
+func (d *Decoder) decrypt(valueStr string) (string, error) {
+	data, ok := strings.CutPrefix(strings.TrimPrefix(valueStr, encPrefix), encVersion1)
+	if !ok {
+		version, _, _ := strings.Cut(strings.TrimPrefix(valueStr, encPrefix), ":")
+		return "", fmt.Errorf("piml: unknown encrypted value version %q", version)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot decrypt: %w", err)
+	}
+	plaintext, err := d.decryptor.Decrypt(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot decrypt: %w", err)
+	}
+	return string(plaintext), nil
+}
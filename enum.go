@@ -0,0 +1,135 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Integer is the set of types that can be registered as enums.
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr
+}
+
+// enumType holds the names registered for an integer enum type.
+type enumType struct {
+	names  map[int64]string
+	values map[string]int64
+	valid  string // Names in value order, for error messages
+}
+
+// namedType is a registered integer type whose values are written as names.
+type namedType interface {
+	format(v reflect.Value) (string, error)
+	parse(v reflect.Value, s string) error
+}
+
+// namedTypes maps a reflect.Type to its namedType: an *enumType,
+// a *flagsType or a *scalarType.
+var namedTypes sync.Map
+
+// RegisterEnum registers the names of the integer enum type T, so its
+// values are written as names and only those names are accepted when
+// decoding:
+//
+//	type Level int
+//
+//	const (
+//		Debug Level = iota
+//		Info
+//	)
+//
+//	piml.RegisterEnum(map[string]Level{"debug": Debug, "info": Info})
+//
+// Names can share a value, as aliases: all of them are accepted, and
+// the first in alphabetical order is written. Registering T again
+// replaces its names, as well as anything
+// registered for it with RegisterFlags or RegisterScalar.
+func RegisterEnum[T Integer](names map[string]T) {
+	e := &enumType{
+		names:  make(map[int64]string, len(names)),
+		values: make(map[string]int64, len(names)),
+	}
+	for name, value := range names {
+		e.values[name] = int64(value)
+	}
+	order := make([]string, 0, len(names))
+	for name := range names {
+		order = append(order, name)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		vi, vj := e.values[order[i]], e.values[order[j]]
+		if vi != vj {
+			return vi < vj
+		}
+		return order[i] < order[j]
+	})
+	for _, name := range order {
+		if _, ok := e.names[e.values[name]]; !ok {
+			e.names[e.values[name]] = name
+		}
+	}
+	e.valid = strings.Join(order, ", ")
+	namedTypes.Store(reflect.TypeFor[T](), e)
+}
+
+// lookupNamed returns the enum, flags or scalar registered for t, if any.
+func lookupNamed(t reflect.Type) (namedType, bool) {
+	n, ok := namedTypes.Load(t)
+	if !ok {
+		return nil, false
+	}
+	return n.(namedType), true
+}
+
+// format returns the name of the enum value v.
+func (e *enumType) format(v reflect.Value) (string, error) {
+	n := intValue(v)
+	name, ok := e.names[n]
+	if !ok {
+		return "", fmt.Errorf("piml: invalid %s value %d, must be one of %s", v.Type(), n, e.valid)
+	}
+	return name, nil
+}
+
+// parse sets v to the value named by s.
+func (e *enumType) parse(v reflect.Value, s string) error {
+	n, ok := e.values[s]
+	if !ok {
+		return fmt.Errorf("piml: invalid %s value %q, must be one of %s", v.Type(), s, e.valid)
+	}
+	setIntValue(v, n)
+	return nil
+}
+
+// checkEnumTag validates s against the options of an `enum=a|b|c` tag.
+func checkEnumTag(allowed, s string) error {
+	for _, name := range strings.Split(allowed, "|") {
+		if s == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("piml: invalid value %q, must be one of %s", s, strings.ReplaceAll(allowed, "|", ", "))
+}
+
+// intValue returns the value of an integer kind as an int64.
+func intValue(v reflect.Value) int64 {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return int64(v.Uint())
+	}
+	return v.Int()
+}
+
+// setIntValue sets an integer kind from an int64.
+func setIntValue(v reflect.Value, n int64) {
+	switch v.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(uint64(n))
+	default:
+		v.SetInt(n)
+	}
+}
@@ -0,0 +1,113 @@
+package piml
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ToEnv flattens a document into environment variables, one for each
+// value, so a PIML config can feed a 12-factor container. The path of
+// keys to a value makes up the name, upper-cased and joined with
+// underscores after prefix, and list items are numbered from 0:
+//
+//	(database)                  APP_DATABASE_HOST=localhost
+//	  (host) localhost          APP_DATABASE_PORT=5432
+//	  (port) 5432               APP_TAGS_0=web
+//	(tags)
+//	  > web
+//
+// Characters other than ASCII letters and digits become underscores.
+// Nil values are left out, as an unset variable, and so are empty lists
+// and objects. An empty prefix leaves the names unprefixed. Two values
+// whose paths make the same name, such as (a-b) and (a) holding (b),
+// are an error, as one variable can't hold both.
+//
+// FromEnv reads the variables back, but not every document survives
+// the trip: a key holding other characters, such as max-conns or
+// app.name, comes back split into nested keys at each of them.
+// Multi-line values are written with their line breaks, which os/exec
+// passes on as they are but .env files and shells generally can't hold.
+func ToEnv(data []byte, prefix string) ([]string, error) {
+	entries, err := Flatten(data)
+	if err != nil {
+		return nil, err
+	}
+	var env []string
+	paths := map[string][]string{} // The path each name was made from
+	for _, e := range entries {
+		if len(e.Path) == 0 {
+			return nil, errors.New("piml: a single value can't be flattened")
+		}
+		if e.Value == nil {
+			continue
+		}
+		name := envName(strings.Join(e.Path, "_"))
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+		if other, ok := paths[name]; ok {
+			return nil, fmt.Errorf("piml: %q and %q both make the variable %s", JoinFlatKey(other), JoinFlatKey(e.Path), name)
+		}
+		paths[name] = e.Path
+		env = append(env, name+"="+*e.Value)
+	}
+	return env, nil
+}
+
+// envName upper-cases s and replaces the characters that don't belong
+// in a variable name with underscores.
+func envName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// FromEnv builds a document from the variables in env, given as
+// "NAME=value" like os.Environ returns them, whose names start with
+// prefix and an underscore; the reverse of ToEnv. The rest of each name
+// is lower-cased and split at underscores into nested keys, and keys
+// numbered from 0 become lists:
+//
+//	data, err := piml.FromEnv(os.Environ(), "APP")
+//
+// As underscores separate keys, a key like max_conns can't come back
+// from the environment, nor can keys ToEnv wrote with dots or dashes;
+// each is read as (max) holding (conns). A value holding line breaks
+// is written as a multi-line string. Keys are written in the order of
+// their names. A variable that is both a value and the start of others,
+// such as APP_DB and APP_DB_HOST, is an error.
+func FromEnv(env []string, prefix string) ([]byte, error) {
+	if prefix != "" {
+		prefix += "_"
+	}
+	var entries []FlatEntry
+	for _, kv := range env {
+		name, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok || rest == "" {
+			continue
+		}
+		path := strings.Split(strings.ToLower(rest), "_")
+		for _, seg := range path {
+			if seg == "" {
+				return nil, fmt.Errorf("piml: empty key in variable %s", name)
+			}
+		}
+		entries = append(entries, FlatEntry{Path: path, Value: &value})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return strings.Join(entries[i].Path, "_") < strings.Join(entries[j].Path, "_")
+	})
+	return Unflatten(entries)
+}
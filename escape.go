@@ -0,0 +1,191 @@
+package piml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// escapeHash escapes a value or line that would otherwise read as a
+// comment. Text made of any number of backslashes followed by '#' gets
+// one more backslash, so "#" is written as `\#` and `\#` as `\\#`.
+// Leading spaces are kept in front of the escape.
+func escapeHash(s string) string {
+	rest := strings.TrimLeft(s, " ")
+	if !isHashEscape(rest) {
+		return s
+	}
+	return s[:len(s)-len(rest)] + `\` + rest
+}
+
+// unescapeHash reverses escapeHash: it removes one backslash from text
+// made of one or more backslashes followed by '#', after leading spaces.
+func unescapeHash(s string) string {
+	rest := strings.TrimLeft(s, " ")
+	if !strings.HasPrefix(rest, `\`) || !isHashEscape(rest) {
+		return s
+	}
+	return s[:len(s)-len(rest)] + rest[1:]
+}
+
+// escapeInlineHashes escapes every '#' that would start an inline
+// comment: one at the start of s or after a space, along with any
+// backslashes right before it.
+func escapeInlineHashes(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if (i == 0 || s[i-1] == ' ') && isHashEscape(s[i:]) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// stripInlineComment removes an inline comment from a value: everything
+// from the first '#' at the start of s or after a space. Hashes escaped
+// by escapeInlineHashes lose one backslash instead.
+func stripInlineComment(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if i == 0 || s[i-1] == ' ' {
+			if s[i] == '#' {
+				return strings.TrimRight(b.String(), " ")
+			}
+			if s[i] == '\\' && isHashEscape(s[i:]) {
+				continue // Drop one backslash
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isHashEscape reports whether s starts with any number of
+// backslashes followed by '#'.
+func isHashEscape(s string) bool {
+	return strings.HasPrefix(strings.TrimLeft(s, `\`), "#")
+}
+
+// escapeScalar escapes a single-line list item or scalar alone at the
+// root: text that would read as a key, an item or nil, after any
+// backslashes and spaces, gets one more backslash, so "(a) b" is
+// written as `\(a) b`, ">" as `\>` and "nil" as `\nil`. Hashes are
+// escaped as escapeHash escapes them.
+func escapeScalar(s string) string {
+	if isScalarEscape(s) {
+		return `\` + s
+	}
+	return escapeHash(s)
+}
+
+// escapeRootScalar escapes a single-line scalar alone at the root as
+// escapeScalar does, and "---", which would end the document, as `\---`.
+func escapeRootScalar(s string) string {
+	if isLineEscape(s) {
+		return `\` + s
+	}
+	return escapeScalar(s)
+}
+
+// unescapeScalar reverses escapeScalar, once hashes are unescaped,
+// reporting whether s was escaped.
+func unescapeScalar(s string) (string, bool) {
+	if strings.HasPrefix(s, `\`) && isScalarEscape(s) {
+		return s[1:], true
+	}
+	return s, false
+}
+
+// isScalarEscape reports whether s, after any backslashes and spaces,
+// would read as a key, an item or nil.
+func isScalarEscape(s string) bool {
+	rest := strings.TrimLeft(strings.TrimLeft(s, `\`), " ")
+	return strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, ">") || rest == "nil"
+}
+
+// unescapeNil reverses the escaping of a value after a key or of a set
+// item, where only nil is escaped, once hashes are unescaped, reporting
+// whether s was escaped. Text such as `\(a)` is left as it is.
+func unescapeNil(s string) (string, bool) {
+	if strings.HasPrefix(s, `\`) && isNilEscape(s) {
+		return s[1:], true
+	}
+	return s, false
+}
+
+// isNilEscape reports whether s, after any backslashes, is nil.
+func isNilEscape(s string) bool {
+	return strings.TrimLeft(s, `\`) == "nil"
+}
+
+// escapeLine escapes a line of a multi-line string: text that would
+// read as a key, an item or a document separator, after leading spaces
+// and any backslashes, gets one more backslash, so "(a) b" is written
+// as `\(a) b` and "---" as `\---`. Leading spaces are kept in front of
+// the escape. Hashes are escaped as escapeHash escapes them.
+func escapeLine(s string) string {
+	rest := strings.TrimLeft(s, " ")
+	if !isLineEscape(rest) {
+		return escapeHash(s)
+	}
+	return s[:len(s)-len(rest)] + `\` + rest
+}
+
+// unescapeLine reverses escapeLine, once hashes are unescaped,
+// reporting whether s was escaped.
+func unescapeLine(s string) (string, bool) {
+	rest := strings.TrimLeft(s, " ")
+	if !strings.HasPrefix(rest, `\`) || !isLineEscape(rest) {
+		return s, false
+	}
+	return s[:len(s)-len(rest)] + rest[1:], true
+}
+
+// isLineEscape reports whether s, after any backslashes, would read as
+// a key, an item or a document separator.
+func isLineEscape(s string) bool {
+	rest := strings.TrimLeft(s, `\`)
+	return strings.HasPrefix(rest, "(") || strings.HasPrefix(rest, ">") || rest == documentSeparator
+}
+
+// keyEscaper escapes the characters that would end a key early.
+var keyEscaper = strings.NewReplacer(`\`, `\\`, `)`, `\)`)
+
+// escapeKey escapes a key for writing between parentheses:
+// "size (bytes)" is written as `(size (bytes\))`.
+func escapeKey(key string) string {
+	return keyEscaper.Replace(key)
+}
+
+// checkKey reports an error wrapping ErrInvalidKey if key could not be
+// read back once written, even with escaping. Keys of profile blocks
+// are only allowed if profiles is set, for documents that hold their
+// profile blocks as keys, such as a Node.
+func checkKey(key string, profiles bool) error {
+	if strings.ContainsAny(key, "\r\n") {
+		return fmt.Errorf("%w %q: keys cannot contain line breaks", ErrInvalidKey, key)
+	}
+	if !profiles && strings.HasPrefix(key, profilePrefix) {
+		return fmt.Errorf("%w %q: keys starting with %q are profile blocks", ErrInvalidKey, key, profilePrefix)
+	}
+	return nil
+}
+
+// cutKey reads an escaped key up to its closing ')', returning the
+// unescaped key and the text after it. ok is false if the key is not
+// closed. A backslash escapes only ')' and '\'; others are literal.
+func cutKey(s string) (key, rest string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case ')':
+			return b.String(), s[i+1:], true
+		case '\\':
+			if i+1 < len(s) && (s[i+1] == ')' || s[i+1] == '\\') {
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return "", "", false
+}
@@ -0,0 +1,130 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ExampleFor returns a sample config file for the struct v, which may
+// be a struct or a pointer to one, so the example shipped with a
+// program is generated from its config type instead of drifting from
+// it. Every field is written with its pimlcomment tag as a comment, as
+// with Encoder.SetComments, and zero fields with a pimldefault tag hold
+// that default:
+//
+//	type Config struct {
+//		Host string `piml:"host" pimldefault:"localhost" pimlcomment:"Address to bind"`
+//		Port int    `piml:"port,required" pimldefault:"8080"`
+//	}
+//
+// gives
+//
+//	# Address to bind
+//	(host) localhost
+//	# (required)
+//	(port) 8080
+//
+// Values already set in v are kept. Nil pointers to structs are filled
+// in and empty lists of structs get one item, so the keys nested in
+// them are shown too. Defaults are scalars, decoded like a value in a
+// document; v itself is never modified.
+func ExampleFor(v interface{}) ([]byte, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct || isScalarType(t) {
+		return nil, errors.New("piml: ExampleFor needs a struct")
+	}
+
+	// Work on a deep copy, made by a round trip, so v is left alone.
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	example := reflect.New(t)
+	if err := Unmarshal(data, example.Interface()); err != nil {
+		return nil, err
+	}
+	if err := applyDefaults(example.Elem(), map[reflect.Type]bool{}); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.SetComments(true)
+	if err := e.Encode(example.Interface()); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// applyDefaults sets the zero fields of struct v that have a
+// pimldefault tag to their default, and fills in the structs nested in
+// v. seen holds the types being filled in, to stop at cycles.
+func applyDefaults(v reflect.Value, seen map[reflect.Type]bool) error {
+	t := v.Type()
+	seen[t] = true
+	defer delete(seen, t)
+
+	for _, f := range cachedFields(t).fields {
+		fv, _ := fieldByIndex(v, f.index, true)
+		if def, ok := t.FieldByIndex(f.index).Tag.Lookup("pimldefault"); ok {
+			if isZero(fv) {
+				if err := NewDecoder([]byte(def)).Decode(fv.Addr().Interface()); err != nil {
+					return fmt.Errorf("piml: invalid default for %s: %w", f.name, err)
+				}
+			}
+			continue
+		}
+		if err := fillExample(fv, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillExample makes the structs under v, if any, show their keys.
+func fillExample(v reflect.Value, seen map[reflect.Type]bool) error {
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Ptr:
+		if !isObjectType(t.Elem()) || t.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		if v.IsNil() {
+			if seen[t.Elem()] {
+				return nil // Keep recursive types finite
+			}
+			v.Set(reflect.New(t.Elem()))
+		}
+		return applyDefaults(v.Elem(), seen)
+	case reflect.Struct:
+		if !isObjectType(t) {
+			return nil
+		}
+		return applyDefaults(v, seen)
+	case reflect.Slice:
+		elem := t.Elem()
+		if elem.Kind() == reflect.Ptr {
+			elem = elem.Elem()
+		}
+		if elem.Kind() != reflect.Struct || !isObjectType(elem) {
+			return nil
+		}
+		if v.Len() == 0 {
+			if seen[elem] {
+				return nil
+			}
+			v.Set(reflect.MakeSlice(t, 1, 1))
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := fillExample(v.Index(i), seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The most common container types, map[string]string,
+// map[string]interface{}, []string and []int, skip reflection for their
+// entries. Their output, and their errors, are the same as on the
+// reflective paths.
+
+// encodeFastMap writes the entries of a map[string]string or
+// map[string]interface{}, reporting whether v was one.
+func (e *Encoder) encodeFastMap(v reflect.Value, indent int) (bool, error) {
+	if !v.CanInterface() {
+		return false, nil
+	}
+	switch m := v.Interface().(type) {
+	case map[string]string:
+		return true, encodeMapEntries(e, m, indent, func(s string, indent int) error {
+			return e.encodeString(s, indent, false)
+		})
+	case map[string]interface{}:
+		return true, encodeMapEntries(e, m, indent, func(val interface{}, indent int) error {
+			return e.encodeValue(reflect.ValueOf(val), indent, false)
+		})
+	}
+	return false, nil
+}
+
+// encodeMapEntries writes the entries of m in sorted key order, like
+// encodeMap, with encode writing each value.
+func encodeMapEntries[V any](e *Encoder, m map[string]V, indent int, encode func(V, int) error) error {
+	fieldIndent := indent + 1
+	indentStr := indentString(fieldIndent)
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for i, key := range keys {
+		if i > 0 && e.spacing == SpacingSections && indent == -1 {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
+		}
+		e.pushKey(key)
+		if err := e.writeKey(indentStr, key); err != nil {
+			return err
+		}
+		if err := encode(m[key], fieldIndent); err != nil {
+			return err
+		}
+		e.pop()
+	}
+	return nil
+}
+
+// encodeFastSlice writes the items of a []string or []int, reporting
+// whether v was one.
+func (e *Encoder) encodeFastSlice(v reflect.Value, indent int) (bool, error) {
+	if !v.CanInterface() {
+		return false, nil
+	}
+	indentStr := indentString(indent)
+	switch s := v.Interface().(type) {
+	case []string:
+		for _, item := range s {
+			if strings.Contains(item, "\n") {
+				if err := e.encodeString(item, indent, true); err != nil {
+					return true, err
+				}
+				continue
+			}
+			if err := e.writeString(indentStr, "> ", e.escapeItem(item), "\n"); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	case []int:
+		for _, item := range s {
+			if err := e.writeString(indentStr, "> ", strconv.Itoa(item), "\n"); err != nil {
+				return true, err
+			}
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// decodeFastSlice decodes the items of a []string or []int, reporting
+// whether v was one.
+func (d *Decoder) decodeFastSlice(v reflect.Value, currentIndent int) (bool, error) {
+	var items interface{}
+	var err error
+	switch v.Interface().(type) {
+	case []string:
+		items, err = decodeItems(d, currentIndent, func(s string) (string, error) {
+			return s, nil
+		})
+	case []int:
+		if d.lenient {
+			return false, nil // See coerce
+		}
+		items, err = decodeItems(d, currentIndent, parseInt)
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, err
+	}
+	if d.appendSlices {
+		v.Set(reflect.AppendSlice(v, reflect.ValueOf(items)))
+	} else {
+		v.Set(reflect.ValueOf(items))
+	}
+	return true, nil
+}
+
+// decodeItems decodes array items like decodeSlice, with parse turning
+// a resolved scalar into an item.
+func decodeItems[T any](d *Decoder, currentIndent int, parse func(string) (T, error)) ([]T, error) {
+	items := make([]T, 0)
+	var first *lineInfo // First item, for SetIndentUnit
+
+	for {
+		// Blank lines between array items are skipped
+		line, err := d.peekChild(currentIndent)
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			break // End of array
+		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return nil, err
+		}
+
+		var item T
+		switch {
+		case line.lineType == lineArrayObject:
+			// > (item), which is an error unless it is empty.
+			d.consume()
+			if err := d.decodeValue(reflect.ValueOf(&item), line.indent); err != nil {
+				return nil, fmt.Errorf("piml: error decoding item [%d]: %w", len(items), err)
+			}
+		case line.lineType != lineArrayItem:
+			// This line is not an array item, so we're done.
+			return items, nil
+		case line.isNil():
+			// nil can't be assigned to these types.
+			d.consume()
+			if err := d.setPrimitive(reflect.ValueOf(&item), line.value); err != nil {
+				return nil, fmt.Errorf("piml: line %d: error setting item [%d]: %w", line.line, len(items), err)
+			}
+		default:
+			d.consume()
+			var s string
+			multi, err := d.multiLineItem(line)
+			if multi {
+				err = d.decodeMultiLineString(reflect.ValueOf(&s), line.indent)
+			} else if line.literal {
+				s = line.value
+			} else if err == nil {
+				s, err = d.resolve(line.value)
+			}
+			if err == nil {
+				item, err = parse(s)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("piml: line %d: error setting item [%d]: %w", line.line, len(items), err)
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseInt parses an int the way setScalar does.
+func parseInt(s string) (int, error) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("piml: invalid integer value: %w", err)
+	}
+	if int64(int(i)) != i {
+		return 0, fmt.Errorf("piml: integer overflow: %s", s)
+	}
+	return int(i), nil
+}
+
+// fastMaps returns the map behind v if it is a map[string]string or a
+// map[string]interface{}, whose scalar entries setFastMapEntry sets.
+func fastMaps(v reflect.Value) (map[string]string, map[string]interface{}) {
+	switch m := v.Interface().(type) {
+	case map[string]string:
+		return m, nil
+	case map[string]interface{}:
+		return nil, m
+	}
+	return nil, nil
+}
+
+// setFastMapEntry sets key to the scalar of line in whichever of
+// strMap and anyMap is not nil, like setLineValue would.
+func (d *Decoder) setFastMapEntry(strMap map[string]string, anyMap map[string]interface{}, key string, line *lineInfo) error {
+	if line.isNil() {
+		if strMap != nil {
+			var s string
+			return d.setPrimitive(reflect.ValueOf(&s), line.value)
+		}
+		anyMap[key] = nil
+		return nil
+	}
+	s := line.value
+	if !line.literal {
+		var err error
+		if s, err = d.resolve(s); err != nil {
+			return err
+		}
+	}
+	if strMap != nil {
+		strMap[key] = s
+	} else {
+		anyMap[key] = d.genericScalar(s)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package piml
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// A field is a struct field that is read and written as a key. Fields
+// of untagged embedded structs are promoted, as if they were fields of
+// the outer struct.
+type field struct {
+	name    string     // Key
+	opts    tagOptions // Tag options
+	index   []int      // Field indexes leading to the field, see fieldByIndex
+	tagged  bool       // Whether the key comes from a tag
+	goName  string     // Name of the Go field, see SetKeyCases
+	comment string     // From the pimlcomment tag
+}
+
+// structInfo is the list of fields of a struct type.
+type structInfo struct {
+	fields    []field         // In declaration order
+	byName    map[string]int  // Index in fields, by key
+	ambiguous []string        // Keys of fields left out as ambiguous
+	groups    map[string]bool // Keys leading to dotted keys, see typeFields
+}
+
+// fieldCache holds the structInfo of every struct type seen so far,
+// for every list of tag keys it was read with.
+var fieldCache sync.Map // map[fieldCacheKey]*structInfo
+
+// fieldCacheKey identifies a struct type and the tag keys of its fields.
+type fieldCacheKey struct {
+	t    reflect.Type
+	tags string // See joinTagKeys
+}
+
+// cachedFields returns the fields of struct type t, with their tags read
+// under tagKeys, see SetTagKeys, working them out only the first time t
+// is seen with those keys.
+func cachedFields(t reflect.Type, tagKeys ...string) *structInfo {
+	key := fieldCacheKey{t, joinTagKeys(tagKeys)}
+	if info, ok := fieldCache.Load(key); ok {
+		return info.(*structInfo)
+	}
+	info, _ := fieldCache.LoadOrStore(key, typeFields(t, tagKeys))
+	return info.(*structInfo)
+}
+
+// typeFields works out the fields of struct type t.
+//
+// When several fields have the same key, the rules of encoding/json
+// decide which one is used: a promoted field is shadowed by any field
+// that is less deeply nested, so the fields of the outer struct always
+// win over those of embedded ones. Among the fields that are equally
+// deep, a tagged field wins over untagged ones. If that still leaves
+// more than one, the key is ambiguous and none of them are used.
+//
+// A tag with dots, like `piml:"db.pool.size"`, is a path: the field is
+// read and written as (size) inside (pool) inside (db), next to the
+// other fields under those keys. A field keyed db or db.pool shadows it.
+func typeFields(t reflect.Type, tagKeys []string) *structInfo {
+	type candidate struct {
+		field
+		depth int
+	}
+	var all []candidate
+
+	// Visit fields depth first, so promoted fields take the place of
+	// their embedded struct in the declaration order.
+	var visit func(t reflect.Type, index []int, seen map[reflect.Type]bool)
+	visit = func(t reflect.Type, index []int, seen map[reflect.Type]bool) {
+		seen[t] = true
+		defer delete(seen, t)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			tag, opts := parseTag(lookupTag(sf, tagKeys))
+			if tag == "-" {
+				continue // Skip this field
+			}
+			fieldIndex := append(index[:len(index):len(index)], i)
+
+			// Tagged embedded structs are nested instead. This includes
+			// unexported types, whose exported fields are usable, but
+			// not pointers to them, which can't be followed.
+			if sf.Anonymous && tag == "" {
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					if !sf.IsExported() {
+						continue
+					}
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !isScalarType(ft) {
+					if !seen[ft] {
+						visit(ft, fieldIndex, seen)
+					}
+					continue
+				}
+			}
+
+			// Unexported fields can't be read or set.
+			if !sf.IsExported() {
+				continue
+			}
+			tagged := tag != ""
+			if !tagged {
+				tag = strings.ToLower(sf.Name)
+			}
+			f := field{name: tag, opts: opts, index: fieldIndex, tagged: tagged, goName: sf.Name, comment: sf.Tag.Get("pimlcomment")}
+			all = append(all, candidate{f, len(index)})
+		}
+	}
+	visit(t, nil, map[reflect.Type]bool{})
+
+	// Keep the dominant field for each key, or -1 if it is ambiguous.
+	byName := make(map[string][]int, len(all))
+	for i, c := range all {
+		byName[c.name] = append(byName[c.name], i)
+	}
+	winner := make(map[string]int, len(byName))
+	for name, candidates := range byName {
+		var shallowest, tagged []int
+		for _, i := range candidates {
+			if len(shallowest) > 0 && all[i].depth > all[shallowest[0]].depth {
+				continue
+			}
+			if len(shallowest) > 0 && all[i].depth < all[shallowest[0]].depth {
+				shallowest, tagged = nil, nil
+			}
+			shallowest = append(shallowest, i)
+			if all[i].tagged {
+				tagged = append(tagged, i)
+			}
+		}
+		switch {
+		case len(shallowest) == 1:
+			winner[name] = shallowest[0]
+		case len(tagged) == 1:
+			winner[name] = tagged[0]
+		default:
+			winner[name] = -1
+		}
+	}
+
+	info := &structInfo{byName: make(map[string]int, len(winner))}
+	for i, c := range all {
+		switch winner[c.name] {
+		case i:
+			groups := groupKeys(c.name)
+			if shadowed(groups, winner) {
+				continue
+			}
+			for _, g := range groups {
+				if info.groups == nil {
+					info.groups = make(map[string]bool)
+				}
+				info.groups[g] = true
+			}
+			info.byName[c.name] = len(info.fields)
+			info.fields = append(info.fields, c.field)
+		case -1:
+			info.ambiguous = append(info.ambiguous, c.name)
+			winner[c.name] = -2 // Listed once
+		}
+	}
+	return info
+}
+
+// groupKeys returns the keys leading to a dotted key, such as db and
+// db.pool for db.pool.size.
+func groupKeys(name string) []string {
+	var keys []string
+	for i := 0; i < len(name); i++ {
+		if name[i] == '.' {
+			keys = append(keys, name[:i])
+		}
+	}
+	return keys
+}
+
+// shadowed reports whether a field is keyed by one of groups.
+func shadowed(groups []string, fields map[string]int) bool {
+	for _, g := range groups {
+		if _, ok := fields[g]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isFieldGroup reports whether key, a dotted path from the top of
+// struct type t, leads to fields with longer dotted keys. Keys are
+// compared with match, if it is set, see Decoder.keyMatcher.
+func isFieldGroup(t reflect.Type, key string, match MatchKeyFunc, tagKeys []string) bool {
+	info := cachedFields(t, tagKeys...)
+	if match == nil {
+		return info.groups[key]
+	}
+	for g := range info.groups {
+		if match(key, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetStrictFields makes the decoder report an error wrapping
+// ErrAmbiguousField for a key shared by several promoted fields, none of
+// which shadows the others, instead of ignoring it like an unknown key.
+// Such fields are never written by the encoder.
+func (d *Decoder) SetStrictFields(on bool) {
+	d.strictFields = on
+}
+
+// fieldByIndex returns the field of struct v at index. Embedded
+// pointers on the way that are nil are allocated if alloc is set;
+// otherwise the field is reported as missing.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,65 @@
+package piml
+
+import "strings"
+
+// SetOnlyPrefixes makes the decoder decode only the keys whose path
+// starts with one of prefixes, skipping the rest of the document
+// without decoding it, so a service can read just its own section of a
+// large shared config:
+//
+//	d := piml.NewDecoder(data)
+//	d.SetOnlyPrefixes("database.")
+//	err := d.Decode(&cfg) // Only (database) and what it holds
+//
+// The path of a key is made of the keys leading to it, joined with
+// dots, such as database.pool.size; list items add nothing to it.
+// Prefixes are compared as strings, so end a prefix with a dot to
+// select a whole section: "database" matches databases too. With no
+// prefixes, which is the default, every key is decoded.
+func (d *Decoder) SetOnlyPrefixes(prefixes ...string) {
+	d.onlyPrefixes = prefixes
+}
+
+// SetSkipPrefixes makes the decoder skip the keys whose path starts
+// with one of prefixes, as if they weren't in the document. Paths and
+// prefixes work as in SetOnlyPrefixes, and skipped keys win over the
+// keys it selects.
+func (d *Decoder) SetSkipPrefixes(prefixes ...string) {
+	d.skipPrefixes = prefixes
+}
+
+// What to do with a key, see filterKey.
+const (
+	filterKeep    = iota // Decode the key and everything in it
+	filterSkip           // Leave the key out
+	filterDescend        // Decode the key, checking the keys in it
+)
+
+// filterKey tells what to do with the key at path, for SetOnlyPrefixes
+// and SetSkipPrefixes. A key is matched with a dot after it, so that
+// the prefix "database." matches the key database itself.
+func (d *Decoder) filterKey(path string) int {
+	path += "."
+	for _, prefix := range d.skipPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return filterSkip
+		}
+	}
+	if len(d.onlyPrefixes) == 0 {
+		return filterDescend
+	}
+	for _, prefix := range d.onlyPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			if len(d.skipPrefixes) > 0 {
+				return filterDescend // Skipped keys may be deeper
+			}
+			return filterKeep
+		}
+	}
+	for _, prefix := range d.onlyPrefixes {
+		if strings.HasPrefix(prefix, path) {
+			return filterDescend // The selected keys are deeper
+		}
+	}
+	return filterSkip
+}
@@ -0,0 +1,93 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// flagsType holds the flags registered for an integer bitmask type.
+type flagsType struct {
+	names  []string // Sorted by value
+	values map[string]int64
+}
+
+// RegisterFlags registers the flags of the integer bitmask type T, so its
+// values are written as comma-separated lists of flag names:
+//
+//	type Perm uint8
+//
+//	const (
+//		Read Perm = 1 << iota
+//		Write
+//		Exec
+//	)
+//
+//	piml.RegisterFlags(map[string]Perm{"read": Read, "write": Write, "exec": Exec})
+//
+// A value of Read|Write is then written as "read,write". A value with no
+// flags set is written as "0", unless a flag with the value 0 is registered.
+//
+// Registering T again replaces its flags, as well as anything
+// registered for it with RegisterEnum or RegisterScalar.
+func RegisterFlags[T Integer](flags map[string]T) {
+	f := &flagsType{values: make(map[string]int64, len(flags))}
+	for name, value := range flags {
+		f.names = append(f.names, name)
+		f.values[name] = int64(value)
+	}
+	sort.Slice(f.names, func(i, j int) bool {
+		vi, vj := f.values[f.names[i]], f.values[f.names[j]]
+		if vi != vj {
+			return uint64(vi) < uint64(vj)
+		}
+		return f.names[i] < f.names[j]
+	})
+	namedTypes.Store(reflect.TypeFor[T](), f)
+}
+
+// format returns the comma-separated flags set in v.
+func (f *flagsType) format(v reflect.Value) (string, error) {
+	n := intValue(v)
+	if n == 0 {
+		for _, name := range f.names {
+			if f.values[name] == 0 {
+				return name, nil
+			}
+		}
+		return "0", nil
+	}
+
+	var set []string
+	rest := n
+	for _, name := range f.names {
+		flag := f.values[name]
+		// Include a flag if all of its bits are set and it adds new ones.
+		if flag != 0 && n&flag == flag && rest&flag != 0 {
+			set = append(set, name)
+			rest &^= flag
+		}
+	}
+	if rest != 0 {
+		return "", fmt.Errorf("piml: invalid %s value %#x, unknown flags %#x", v.Type(), uint64(n), uint64(rest))
+	}
+	return strings.Join(set, ","), nil
+}
+
+// parse sets v to the union of the comma-separated flags in s.
+func (f *flagsType) parse(v reflect.Value, s string) error {
+	var n int64
+	if s != "0" {
+		for _, name := range strings.Split(s, ",") {
+			name = strings.TrimSpace(name)
+			flag, ok := f.values[name]
+			if !ok {
+				return fmt.Errorf("piml: invalid %s flag %q, must be one of %s", v.Type(), name, strings.Join(f.names, ", "))
+			}
+			n |= flag
+		}
+	}
+	setIntValue(v, n)
+	return nil
+}
@@ -0,0 +1,336 @@
+package piml
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SetFlat makes the encoder write the whole document as scalars under
+// dotted keys, one per line, numbering list items from 0:
+//
+//	(db.host) localhost
+//	(db.port) 5432
+//	(tags.0) web
+//
+// Flat documents diff line by line, and suit systems that only know
+// flat key/value stores. Comments and blank lines are not written, and
+// neither are empty lists and objects. Dots and backslashes in the keys
+// themselves, such as map keys, are escaped with a backslash, so the
+// map key a.b is written as a\.b.
+func (e *Encoder) SetFlat(on bool) {
+	e.flat = on
+}
+
+// encodeFlat writes v like Encode, with dotted keys for SetFlat.
+func (e *Encoder) encodeFlat(v reflect.Value) error {
+	var b bytes.Buffer
+	nested := e.nestedEncoder(&b)
+	if err := nested.write(func() error { return nested.encodeValue(v, -1, false) }); err != nil {
+		return err
+	}
+	entries, err := flatten(NewDecoder(b.Bytes()))
+	if err != nil {
+		return err
+	}
+	return e.write(func() error {
+		for _, entry := range entries {
+			indent := 0
+			if len(entry.Path) == 0 {
+				indent = -1 // A single scalar
+			} else if err := e.writeString("(", escapeKey(JoinFlatKey(entry.Path)), ")"); err != nil {
+				return err
+			}
+			var err error
+			if entry.Value == nil {
+				err = e.writeNil(indent)
+			} else {
+				err = e.encodeString(*entry.Value, indent, false)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetFlat makes the decoder read documents written with dotted keys,
+// as Encoder.SetFlat writes them: every key is split at its dots into
+// nested keys, and keys numbered from 0 make lists. A dot escaped with
+// a backslash, as in a\.b, is part of its key, and so is a backslash
+// escaped with another. Flat and nested keys can be mixed, so (db.host)
+// and (db) holding (port) both end up under db. Line numbers in errors
+// count the lines of the nested document.
+func (d *Decoder) SetFlat(on bool) {
+	d.flat = on
+}
+
+// expand rewrites the decoder's input with nested keys, for SetFlat.
+func (d *Decoder) expand() error {
+	d.expanded = true
+	flat := NewDecoder(d.src)
+	flat.inlineComments = d.inlineComments
+	entries, err := flatten(flat)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 1 && entries[0].Path == nil {
+		return nil // A single scalar
+	}
+	for i, entry := range entries {
+		var path []string
+		for _, key := range entry.Path {
+			path = append(path, SplitFlatKey(key)...)
+		}
+		entries[i].Path = path
+	}
+	nested, err := Unflatten(entries)
+	if err != nil {
+		return err
+	}
+	d.setInput(nested)
+	d.lineMap = nil
+	return nil
+}
+
+// flatKeyEscaper escapes the dots of a key, and the backslashes that
+// would read as escapes, for a segment of a flat key.
+var flatKeyEscaper = strings.NewReplacer(`\`, `\\`, `.`, `\.`)
+
+// JoinFlatKey joins the segments of path into a flat key, escaping the
+// dots and backslashes they hold.
+func JoinFlatKey(path []string) string {
+	segs := make([]string, len(path))
+	for i, seg := range path {
+		segs[i] = flatKeyEscaper.Replace(seg)
+	}
+	return strings.Join(segs, ".")
+}
+
+// SplitFlatKey splits a flat key at its dots into segments, reversing
+// JoinFlatKey. A backslash that escapes nothing is kept.
+func SplitFlatKey(key string) []string {
+	var segs []string
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; {
+		case c == '\\' && i+1 < len(key) && (key[i+1] == '.' || key[i+1] == '\\'):
+			i++
+			b.WriteByte(key[i])
+		case c == '.':
+			segs = append(segs, b.String())
+			b.Reset()
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return append(segs, b.String())
+}
+
+// FlatEntry is a scalar of a document, with the path of keys leading
+// to it. List items are numbered from 0, so the first tag is tags.0.
+type FlatEntry struct {
+	Path  []string
+	Value *string // Nil for nil
+}
+
+// Flatten lists the scalars of a document in order, with their paths;
+// ToEnv and the properties and INI converters of the convert package
+// are built on it. Empty lists and objects have no scalars, so they are
+// dropped. A document that is a single scalar has an empty path.
+func Flatten(data []byte) ([]FlatEntry, error) {
+	return flatten(NewDecoder(data))
+}
+
+// Unflatten writes entries back as a document, the reverse of Flatten.
+// Keys that are exactly 0, 1, ... make lists, unless an item is a list
+// itself, which PIML lists can't hold. Paths that clash, such as a key
+// holding both a value and nested keys, are an error.
+func Unflatten(entries []FlatEntry) ([]byte, error) {
+	root, err := unflatten(entries)
+	if err != nil {
+		return nil, err
+	}
+	return writePIML(root)
+}
+
+// flatten lists the scalars of the document d reads, for Flatten.
+func flatten(d *Decoder) ([]FlatEntry, error) {
+	type frame struct {
+		list bool
+		n    int // Items so far, in a list
+	}
+	var entries []FlatEntry
+	var path []string
+	var stack []*frame
+	var key string
+
+	// segment returns the path segment of the next value.
+	segment := func() string {
+		f := stack[len(stack)-1]
+		if !f.list {
+			return key
+		}
+		f.n++
+		return strconv.Itoa(f.n - 1)
+	}
+	open := func(list bool) func(Position) error {
+		return func(Position) error {
+			if len(stack) > 0 {
+				path = append(path, segment())
+			}
+			stack = append(stack, &frame{list: list})
+			return nil
+		}
+	}
+	end := func(Position) error {
+		stack = stack[:len(stack)-1]
+		if len(stack) > 0 {
+			path = path[:len(path)-1]
+		}
+		return nil
+	}
+
+	add := func(value *string) {
+		if len(stack) == 0 {
+			entries = append(entries, FlatEntry{Value: value})
+			return
+		}
+		p := append(path[:len(path):len(path)], segment())
+		entries = append(entries, FlatEntry{Path: p, Value: value})
+	}
+
+	err := d.Walk(Handler{
+		OnKey: func(k string, _ Position) error {
+			key = k
+			return nil
+		},
+		OnScalar: func(value string, _ Position) error {
+			add(&value)
+			return nil
+		},
+		OnObjectStart: open(false),
+		OnObjectEnd:   end,
+		OnArrayStart:  open(true),
+		OnArrayEnd:    end,
+		OnSetStart:    open(true),
+		OnSetEnd:      end,
+		onNil: func(Position) error {
+			add(nil)
+			return nil
+		},
+	})
+	return entries, err
+}
+
+// flatNode is an object, list or scalar rebuilt from flattened entries.
+type flatNode struct {
+	scalar   bool
+	value    *string // Nil for nil
+	keys     []string
+	children map[string]*flatNode
+}
+
+// unflatten rebuilds the tree of entries, reporting paths that clash,
+// such as a key holding both a value and nested keys.
+func unflatten(entries []FlatEntry) (*flatNode, error) {
+	root := &flatNode{children: map[string]*flatNode{}}
+	for _, e := range entries {
+		n := root
+		for i, seg := range e.Path {
+			if n.scalar {
+				return nil, fmt.Errorf("piml: %q has both a value and nested keys", JoinFlatKey(e.Path[:i]))
+			}
+			child, ok := n.children[seg]
+			if !ok {
+				child = &flatNode{children: map[string]*flatNode{}}
+				n.keys = append(n.keys, seg)
+				n.children[seg] = child
+			}
+			n = child
+		}
+		if len(n.keys) > 0 || n.scalar {
+			return nil, fmt.Errorf("piml: %q is set more than once, or has nested keys", JoinFlatKey(e.Path))
+		}
+		n.scalar, n.value = true, e.Value
+	}
+	return root, nil
+}
+
+// listItems returns the keys of n in item order if they are exactly
+// 0, 1, ... and none of the items is a list itself, so n can be
+// written as a list.
+func (n *flatNode) listItems() ([]string, bool) {
+	if n.scalar || len(n.keys) == 0 {
+		return nil, false
+	}
+	items := append([]string(nil), n.keys...)
+	index := make(map[string]int, len(items))
+	for _, k := range items {
+		i, err := strconv.Atoi(k)
+		if err != nil || i < 0 || i >= len(items) || strconv.Itoa(i) != k {
+			return nil, false
+		}
+		if _, ok := n.children[k].listItems(); ok {
+			return nil, false // PIML lists can't hold lists
+		}
+		index[k] = i
+	}
+	sort.Slice(items, func(a, b int) bool { return index[items[a]] < index[items[b]] })
+	return items, true
+}
+
+// writePIML writes the tree under root as a PIML document.
+func writePIML(root *flatNode) ([]byte, error) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	var err error
+	token := func(t Token) {
+		if err == nil {
+			err = enc.EncodeToken(t)
+		}
+	}
+
+	var value func(n *flatNode)
+	fields := func(n *flatNode) {
+		for _, k := range n.keys {
+			token(Key(k))
+			value(n.children[k])
+		}
+	}
+	value = func(n *flatNode) {
+		if n.scalar {
+			if n.value == nil {
+				token(nil)
+			} else {
+				token(*n.value)
+			}
+			return
+		}
+		if items, ok := n.listItems(); ok {
+			token(ArrayStart)
+			for _, k := range items {
+				value(n.children[k])
+			}
+			token(ArrayEnd)
+			return
+		}
+		token(ObjectStart)
+		fields(n)
+		token(ObjectEnd)
+	}
+
+	if _, ok := root.listItems(); ok {
+		value(root)
+	} else {
+		fields(root)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
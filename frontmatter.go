@@ -0,0 +1,57 @@
+package piml
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// FrontMatterDelimiter is the line that opens and closes a PIML
+// front matter block at the top of a document.
+const FrontMatterDelimiter = "---"
+
+// SplitFrontMatter extracts a PIML front matter block from the top of data,
+// decodes it into the value pointed to by v, and returns the remaining body.
+//
+// The block must start on the first line of data and is delimited by
+// FrontMatterDelimiter lines:
+//
+//	---
+//	(title) Hello World
+//	(tags)
+//	  > go
+//	---
+//	# Markdown body starts here
+//
+// If data does not start with a delimiter, v is left untouched and
+// data is returned unchanged.
+func SplitFrontMatter(data []byte, v interface{}) ([]byte, error) {
+	first, rest, _ := cutLine(bytes.TrimPrefix(data, []byte(byteOrderMark)))
+	if string(bytes.TrimRight(first, " \r")) != FrontMatterDelimiter {
+		return data, nil // No front matter
+	}
+
+	// Find the closing delimiter.
+	offset := 0
+	for {
+		line, next, more := cutLine(rest[offset:])
+		if string(bytes.TrimRight(line, " \r")) == FrontMatterDelimiter {
+			if err := Unmarshal(rest[:offset], v); err != nil {
+				return nil, err
+			}
+			return rest[len(rest)-len(next):], nil
+		}
+		if !more {
+			return nil, fmt.Errorf("%w: front matter is missing its closing %q", ErrSyntax, FrontMatterDelimiter)
+		}
+		offset = len(rest) - len(next)
+	}
+}
+
+// cutLine splits data around its first newline.
+// ok reports whether a newline was found.
+func cutLine(data []byte) (line, rest []byte, ok bool) {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i], data[i+1:], true
+	}
+	return data, nil, false
+}
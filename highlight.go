@@ -0,0 +1,129 @@
+package piml
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// HighlightOptions controls Highlight.
+type HighlightOptions struct {
+	LineNumbers    bool // Start each line with its number
+	NoColor        bool // Leave out the colors, e.g. when not writing to a terminal
+	InlineComments bool // Show " # ..." at the end of values as comments, as SetInlineComments reads them
+}
+
+// ANSI colors of Highlight.
+const (
+	colorKey     = "\x1b[36m" // Cyan
+	colorScalar  = "\x1b[32m" // Green
+	colorNil     = "\x1b[35m" // Magenta
+	colorMarker  = "\x1b[33m" // Yellow
+	colorComment = "\x1b[90m" // Gray
+	colorReset   = "\x1b[0m"
+)
+
+// Highlight writes data to w with ANSI colors for terminals, for
+// "piml cat" style output: keys, scalars, nil, comments and the "> "
+// and ">| " markers of list items each have their own color. Lines are
+// written as they are otherwise, so data doesn't need to be valid PIML
+// to be shown.
+func Highlight(w io.Writer, data []byte, opts HighlightOptions) error {
+	lines := splitLines(string(data))
+	width := len(strconv.Itoa(len(lines)))
+	bw := bufio.NewWriter(w)
+	h := highlighter{w: bw, opts: opts}
+	for n, line := range lines {
+		if opts.LineNumbers {
+			h.paint(colorComment, fmt.Sprintf("%*d | ", width, n+1))
+		}
+		h.line(strings.TrimRight(line, "\r\n"))
+		bw.WriteString(lineEnding(line))
+	}
+	return bw.Flush()
+}
+
+// highlighter colors the lines of Highlight.
+type highlighter struct {
+	w    *bufio.Writer
+	opts HighlightOptions
+}
+
+// paint writes s in color, unless it is empty.
+func (h *highlighter) paint(color, s string) {
+	if s == "" {
+		return
+	}
+	if h.opts.NoColor {
+		h.w.WriteString(s)
+		return
+	}
+	h.w.WriteString(color)
+	h.w.WriteString(s)
+	h.w.WriteString(colorReset)
+}
+
+// line writes a line, without its line ending.
+func (h *highlighter) line(line string) {
+	text := strings.TrimLeft(line, " \t")
+	h.w.WriteString(line[:len(line)-len(text)])
+	switch {
+	case text == "":
+	case strings.HasPrefix(text, "#"):
+		h.paint(colorComment, text)
+	case strings.HasPrefix(text, "> ("):
+		h.paint(colorMarker, ">")
+		h.w.WriteString(" ")
+		h.paint(colorKey, text[2:])
+	case strings.HasPrefix(text, ">|"):
+		h.paint(colorMarker, ">|")
+		h.value(text[2:])
+	case strings.HasPrefix(text, ">"):
+		h.paint(colorMarker, ">")
+		h.value(text[1:])
+	case strings.HasPrefix(text, "("):
+		_, rest, ok := cutKey(text[1:])
+		if !ok {
+			h.w.WriteString(text)
+			return
+		}
+		h.paint(colorKey, text[:len(text)-len(rest)])
+		h.value(rest)
+	default:
+		h.value(text) // A line of a multi-line string
+	}
+}
+
+// value writes the value at the end of a line, with the spaces before
+// it.
+func (h *highlighter) value(s string) {
+	text := strings.TrimLeft(s, " ")
+	h.w.WriteString(s[:len(s)-len(text)])
+	comment := ""
+	if h.opts.InlineComments {
+		if i := inlineCommentStart(text); i >= 0 {
+			text, comment = text[:i], text[i:]
+		}
+	}
+	value := strings.TrimRight(text, " ")
+	color := colorScalar
+	if value == "nil" {
+		color = colorNil
+	}
+	h.paint(color, value)
+	h.w.WriteString(text[len(value):])
+	h.paint(colorComment, comment)
+}
+
+// inlineCommentStart returns the index of the inline comment in s, as
+// stripInlineComment finds it, or -1 if there is none.
+func inlineCommentStart(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '#' && (i == 0 || s[i-1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,29 @@
+package piml
+
+import "fmt"
+
+// SetIndentUnit makes the decoder strict about indentation: every key
+// and list item must be indented by a multiple of unit spaces, and all
+// fields or items of a block must share the same indentation. A unit of
+// 0, the default, accepts any indentation deeper than the parent's.
+//
+// Multi-line string lines are not checked, as their indentation beyond
+// the block's is part of the value.
+func (d *Decoder) SetIndentUnit(unit int) {
+	d.indentUnit = unit
+}
+
+// checkIndent reports an error for a line in a block whose first line
+// was first, if its indentation breaks the rules of SetIndentUnit.
+func (d *Decoder) checkIndent(line, first *lineInfo) error {
+	if d.indentUnit <= 0 {
+		return nil
+	}
+	if line.indent%d.indentUnit != 0 {
+		return fmt.Errorf("%w: line %d: indented by %d spaces, which is not a multiple of %d", ErrSyntax, line.line, line.indent, d.indentUnit)
+	}
+	if line.indent != first.indent {
+		return fmt.Errorf("%w: line %d: indented by %d spaces, but line %d of the same block is indented by %d", ErrSyntax, line.line, line.indent, first.line, first.indent)
+	}
+	return nil
+}
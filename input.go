@@ -0,0 +1,35 @@
+package piml
+
+import (
+	"bytes"
+	"io"
+)
+
+// documentSeparator is the line that ends a document, so several can
+// share one input.
+const documentSeparator = "---"
+
+// InputOffset returns the number of bytes of input consumed so far.
+//
+// A document ends at the end of the input, or at a "---" line. After
+// Decode, the offset points at that line, so a caller embedding PIML in
+// a larger stream can carry on reading from there. Calling Decode again
+// reads the next document after the separator. With UseTemplate, the
+// offset is in the rendered input.
+func (d *Decoder) InputOffset() int64 {
+	return int64(d.offset)
+}
+
+// Buffered returns a reader of the input that has not been consumed
+// yet, starting at InputOffset.
+func (d *Decoder) Buffered() io.Reader {
+	return bytes.NewReader(d.src[d.offset:])
+}
+
+// nextDocument moves past the separator that ended the last document.
+func (d *Decoder) nextDocument() {
+	if d.sep != nil {
+		d.offset = d.sep.end
+		d.sep = nil
+	}
+}
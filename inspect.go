@@ -0,0 +1,82 @@
+package piml
+
+import "strconv"
+
+// An Inspection describes the size and make-up of a PIML document, as
+// returned by Inspect.
+type Inspection struct {
+	Bytes    int            // Size of the document
+	Lines    int            // Lines, including blank and comment lines
+	Comments int            // Comment lines
+	Keys     int            // Keys, at all depths
+	MaxDepth int            // Deepest nesting of values, 1 for a flat document
+	Lists    map[string]int // Number of items of each array and set, by path
+	Scalars  map[string]int // Scalars by the type they read as: int, float, bool, string or nil
+}
+
+// Inspect parses the PIML document data and reports its size, for
+// commands like "piml stat" and for services that keep the configs they
+// accept within a budget:
+//
+//	info, err := piml.Inspect(data)
+//	if err == nil && info.MaxDepth > 8 {
+//		return errors.New("config nested too deeply")
+//	}
+//
+// Paths in Lists are those of Node.Lookup, and scalars are typed as Tree
+// types them.
+func Inspect(data []byte) (Inspection, error) {
+	root, err := ParseNode(data)
+	if err != nil {
+		return Inspection{}, err
+	}
+	info := Inspection{
+		Bytes:   len(data),
+		Lists:   map[string]int{},
+		Scalars: map[string]int{},
+	}
+	for _, line := range splitLines(string(data)) {
+		info.Lines++
+		if isComment(line) {
+			info.Comments++
+		}
+	}
+	if root.Kind != ObjectNode || len(root.Children) > 0 {
+		info.inspect(root, "", 0) // An empty document has no values
+	}
+	return info, nil
+}
+
+// inspect counts n, which is at path and depth blocks deep.
+func (info *Inspection) inspect(n *Node, path string, depth int) {
+	switch n.Kind {
+	case ScalarNode, NilNode:
+		if depth == 0 {
+			depth = 1 // A root scalar
+		}
+		typ := "nil"
+		if n.Kind == ScalarNode {
+			typ = readType(n.Value)
+		}
+		info.Scalars[typ]++
+	case ObjectNode:
+		depth++
+		for _, c := range n.Children {
+			info.Keys++
+			p := c.Key
+			if path != "" {
+				p = path + "." + c.Key
+			}
+			info.inspect(c, p, depth)
+		}
+	case ArrayNode, SetNode:
+		depth++
+		info.Lists[path] = len(n.Children)
+		for i, c := range n.Children {
+			info.inspect(c, path+"["+strconv.Itoa(i)+"]", depth)
+		}
+	}
+	if depth > info.MaxDepth {
+		info.MaxDepth = depth
+	}
+}
@@ -0,0 +1,32 @@
+package piml
+
+import "strings"
+
+// keyCacheSize is the number of keys a decoder keeps for interning.
+const keyCacheSize = 256
+
+// keyCache holds recently seen keys, indexed by their hash. A slot is
+// replaced when a different key with the same hash comes along, so it
+// never grows, while the keys of a document, repeated in every object
+// of a long list, all stay in it.
+type keyCache [keyCacheSize]string
+
+// intern returns a copy of key that is shared with every other
+// occurrence of the same key. Keys are otherwise slices of the line they
+// were read from, so decoded maps would hold on to each line in full.
+func (d *Decoder) intern(key string) string {
+	if d.keys == nil {
+		d.keys = new(keyCache)
+	}
+	// FNV-1a
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	slot := &d.keys[h%keyCacheSize]
+	if *slot != key {
+		*slot = strings.Clone(key)
+	}
+	return *slot
+}
@@ -0,0 +1,92 @@
+package piml
+
+import (
+	"strings"
+	"unicode"
+)
+
+// KeyCase is a set of the ways a document may write the key of a struct
+// field without a tag, besides its name in lower case, see SetKeyCases.
+type KeyCase int
+
+const (
+	// KeyCaseExact matches the name of the Go field, e.g. MaxConns.
+	KeyCaseExact KeyCase = 1 << iota
+
+	// KeyCaseFold matches the name of the Go field in any case, e.g.
+	// maxConns or MAXCONNS.
+	KeyCaseFold
+
+	// KeyCaseSnake matches the words of the name joined by underscores,
+	// in any case, e.g. max_conns for MaxConns, or http_server for
+	// HTTPServer.
+	KeyCaseSnake
+
+	// KeyCaseKebab matches the words of the name joined by hyphens, in
+	// any case, e.g. max-conns.
+	KeyCaseKebab
+
+	// KeyCaseAll matches all of the above.
+	KeyCaseAll = KeyCaseExact | KeyCaseFold | KeyCaseSnake | KeyCaseKebab
+)
+
+// SetKeyCases makes the decoder match the keys of struct fields without
+// a tag in the ways in cases, as well as in lower case, so structs with
+// few tags still work with documents written in another convention:
+//
+//	d.SetKeyCases(piml.KeyCaseSnake | piml.KeyCaseKebab)
+//
+// Tagged fields match their tag only. A key written the way of several
+// fields sets the first of them.
+func (d *Decoder) SetKeyCases(cases KeyCase) {
+	d.keyCases = cases
+}
+
+// matchesCase reports whether key is the Go field name written in one
+// of the ways in cases.
+func matchesCase(key, name string, cases KeyCase) bool {
+	switch {
+	case cases&KeyCaseExact != 0 && key == name:
+		return true
+	case cases&KeyCaseFold != 0 && strings.EqualFold(key, name):
+		return true
+	}
+	if cases&(KeyCaseSnake|KeyCaseKebab) == 0 {
+		return false
+	}
+	words := splitWords(name)
+	return (cases&KeyCaseSnake != 0 && strings.EqualFold(key, strings.Join(words, "_"))) ||
+		(cases&KeyCaseKebab != 0 && strings.EqualFold(key, strings.Join(words, "-")))
+}
+
+// splitWords splits a Go name into its words, e.g. HTTPServerURL into
+// HTTP, Server and URL. Underscores separate words too.
+func splitWords(name string) []string {
+	var words []string
+	runes := []rune(name)
+	start := 0
+	for i := 1; i <= len(runes); i++ {
+		if i < len(runes) && runes[i] != '_' && runes[i-1] != '_' && !startsWord(runes, i) {
+			continue
+		}
+		if word := strings.Trim(string(runes[start:i]), "_"); word != "" {
+			words = append(words, word)
+		}
+		start = i
+	}
+	return words
+}
+
+// startsWord reports whether the rune at i starts a word: an upper case
+// letter after a lower case letter or digit, or the last upper case
+// letter of an acronym followed by a lower case letter.
+func startsWord(runes []rune, i int) bool {
+	if !unicode.IsUpper(runes[i]) {
+		return false
+	}
+	prev := runes[i-1]
+	if unicode.IsLower(prev) || unicode.IsDigit(prev) {
+		return true
+	}
+	return unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+}
@@ -0,0 +1,60 @@
+package piml
+
+// SetKeyNormalizer makes the decoder pass every key, and every struct tag
+// it is matched against, through normalize. This lets keys that look
+// the same match even when their bytes differ, e.g. with NFC
+// normalization from golang.org/x/text/unicode/norm:
+//
+//	d.SetKeyNormalizer(norm.NFC.String)
+func (d *Decoder) SetKeyNormalizer(normalize func(string) string) {
+	d.keyNormalizer = normalize
+}
+
+// normalizeKey applies the decoder's key normalizer, if any.
+func (d *Decoder) normalizeKey(key string) string {
+	if d.keyNormalizer == nil {
+		return key
+	}
+	return d.keyNormalizer(key)
+}
+
+// A MatchKeyFunc reports whether the key of a document matches the key of
+// a struct field.
+type MatchKeyFunc func(docKey, fieldKey string) bool
+
+// SetMatchKey makes the decoder match the keys of the document to the
+// keys of struct fields with match, instead of comparing them, for key
+// conventions that normalizing can't express, such as prefixes:
+//
+//	d.SetMatchKey(func(docKey, fieldKey string) bool {
+//		return strings.TrimPrefix(docKey, "app_") == fieldKey
+//	})
+//
+// Both keys are normalized first, if there is a key normalizer, and for
+// fields with dotted keys, both are the paths from the top of the
+// struct, such as db.pool.size. The first field in declaration order
+// that matches is used. Set match to nil to compare keys again.
+func (d *Decoder) SetMatchKey(match MatchKeyFunc) {
+	d.matchKey = match
+}
+
+// keyMatcher returns the function comparing the keys of the document to
+// the keys of fields, or nil if they are simply compared.
+func (d *Decoder) keyMatcher() MatchKeyFunc {
+	match, normalize := d.matchKey, d.keyNormalizer
+	switch {
+	case match != nil && normalize != nil:
+		return func(docKey, fieldKey string) bool { return match(docKey, normalize(fieldKey)) }
+	case match != nil:
+		return match
+	case normalize != nil:
+		return func(docKey, fieldKey string) bool { return docKey == normalize(fieldKey) }
+	}
+	return nil
+}
+
+// SetKeyNormalizer makes the encoder pass every key through normalize
+// before writing it.
+func (e *Encoder) SetKeyNormalizer(normalize func(string) string) {
+	e.keyNormalizer = normalize
+}
@@ -4,15 +4,36 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 )
 
 // An Encoder writes PIML values to an output stream.
 type Encoder struct {
-	w io.Writer
+	w       io.Writer
+	redact  bool       // Replace secret fields with mask
+	mask    string     // Replacement text for secret fields
+	order   FieldOrder // Order of struct fields
+	spacing Spacing    // Where to write blank lines between keys
+
+	inlineComments bool                // Escape hashes that would start inline comments
+	comments       bool                // Write pimlcomment tags above keys
+	crlf           bool                // End lines with \r\n
+	keyNormalizer  func(string) string // Applied to keys before writing
+	tagKeys        []string            // Struct tag keys, see SetTagKeys
+	flat           bool                // Write dotted keys, see SetFlat
+	checksum       bool                // Append a checksum trailer, see SetChecksum
+	signer         SignFunc            // Appends a signature trailer, see SetSigner
+	encryptor      Encryptor           // Encrypts secret fields, see SetEncryptor
+	profileKeys    bool                // Write keys starting with "profile:" as profile blocks
+
+	stream *arrayStream  // Array being streamed, see EncodeArrayHeader
+	path   []pathElem    // Path to the value being encoded, see pathError
+	tokens []*tokenFrame // Blocks open in EncodeToken, the root first
+	buf    []byte        // Line being written, see writeString
 }
 
 // NewEncoder returns a new encoder that writes to w.
@@ -20,15 +41,46 @@ func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{w: w}
 }
 
+// Redact makes the encoder replace the value of every field tagged
+// `piml:",secret"` with mask, so the output can be logged safely.
+// Nil and empty secrets are still written as `nil`.
+func (e *Encoder) Redact(mask string) {
+	e.redact = true
+	e.mask = mask
+}
+
 // Encode writes the PIML encoding of v to the stream.
 func (e *Encoder) Encode(v interface{}) error {
 	rv := reflect.ValueOf(v)
+	if e.checksum || e.signer != nil {
+		return e.encodeTrailed(rv)
+	}
+	return e.encodeRoot(rv)
+}
+
+// encodeRoot writes v as a document.
+func (e *Encoder) encodeRoot(v reflect.Value) error {
+	if e.flat {
+		return e.encodeFlat(v)
+	}
 	// Start with indent -1 to signify the root.
-	return e.encodeValue(rv, -1, false) // false = not in an array
+	return e.write(func() error {
+		return e.encodeValue(v, -1, false) // false = not in an array
+	})
 }
 
 // encodeValue is the main recursive marshalling function.
 func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
+	// Dereference pointers and interfaces
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	if v.IsValid() && v.Type() == nodeType {
+		n := v.Interface().(Node)
+		return e.encodeNode(&n, indent, inArray)
+	}
+
 	// Handle nil and empty values
 	if !v.IsValid() || isNilOrEmpty(v) {
 		if inArray {
@@ -37,33 +89,30 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 		}
 		// Not in an array, so it's a value for a key.
 		// The key itself is written by encodeStruct, here we just write 'nil'.
-		_, err := e.w.Write([]byte(" nil\n"))
-		return err
+		return e.writeNil(indent)
 	}
 
-	// This is only used for array items
-	var indentStr string
-	if indent > 0 {
-		indentStr = strings.Repeat("  ", indent)
+	if v.Type() == rawMessageType {
+		return e.encodeDocument(v.Bytes(), indent, inArray)
 	}
 
-	// Dereference pointers
-	for v.Kind() == reflect.Ptr {
-		v = v.Elem()
+	// This is only used for array items
+	indentStr := indentString(indent)
+
+	// Handle time.Time, big.Int, encoding.TextMarshaler, etc. as primitive strings
+	if s, ok, err := formatScalar(v); ok {
+		if err != nil {
+			return err
+		}
+		return e.writeScalar(s, indent, inArray)
 	}
 
 	// Dispatch based on type
 	switch v.Kind() {
 	case reflect.Struct:
-		// NEW CHECK: Handle time.Time as a primitive string
-		if v.Type() == reflect.TypeOf(time.Time{}) {
-			s := v.Interface().(time.Time).Format(time.RFC3339Nano)
-			if inArray {
-				_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-				return err
-			}
-			_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-			return err
+		// sql.NullString and friends are written as their value
+		if i, ok := nullableValueField(v.Type()); ok {
+			return e.encodeValue(v.Field(i), indent, inArray)
 		}
 
 		// If we're marshalling a struct inside an array, we must add the '>'
@@ -75,7 +124,7 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 			if itemName == "" {
 				itemName = "item"
 			}
-			if _, err := e.w.Write([]byte(fmt.Sprintf("%s> (%s)\n", indentStr, itemName))); err != nil {
+			if err := e.writeString(indentStr, "> (", itemName, ")\n"); err != nil {
 				return err
 			}
 			// Now encode the struct's fields, one level deeper
@@ -85,7 +134,7 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 			// The keys will be indented *by* encodeStruct.
 			// If we are nested (indent > -1), we need a newline first.
 			if indent > -1 {
-				if _, err := e.w.Write([]byte("\n")); err != nil {
+				if err := e.writeString("\n"); err != nil {
 					return err
 				}
 			}
@@ -93,9 +142,9 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 		}
 
 	case reflect.Slice, reflect.Array:
-		// We need a newline if we are not in an array
-		if !inArray {
-			if _, err := e.w.Write([]byte("\n")); err != nil {
+		// We need a newline if we are the value of a key
+		if !inArray && indent > -1 {
+			if err := e.writeString("\n"); err != nil {
 				return err
 			}
 		}
@@ -104,52 +153,43 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 	case reflect.Map:
 		// Per our spec, map keys are PIML keys.
 		// This is just like a struct.
-		if !inArray {
-			if _, err := e.w.Write([]byte("\n")); err != nil {
+		// map[T]struct{} is a set, written as >| items.
+		set := isSetType(v.Type())
+		if inArray {
+			// e.g., > (item)
+			if err := e.writeString(indentStr, "> (item)\n"); err != nil {
 				return err
 			}
+			if set {
+				return e.encodeSet(v, indent+2)
+			}
+			return e.encodeMap(v, indent+1)
+		}
+		if indent > -1 {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
+		}
+		if set {
+			return e.encodeSet(v, indent+1)
 		}
 		return e.encodeMap(v, indent)
 
 	case reflect.String:
-		return e.encodeString(v, indent, inArray)
+		return e.encodeString(v.String(), indent, inArray)
 
 	// Primitives
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		s := strconv.FormatInt(v.Int(), 10)
-		if inArray {
-			_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-			return err
-		}
-		_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-		return err
+		return e.writeScalar(strconv.FormatInt(v.Int(), 10), indent, inArray)
 
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		s := strconv.FormatUint(v.Uint(), 10)
-		if inArray {
-			_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-			return err
-		}
-		_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-		return err
+		return e.writeScalar(strconv.FormatUint(v.Uint(), 10), indent, inArray)
 
 	case reflect.Float32, reflect.Float64:
-		s := strconv.FormatFloat(v.Float(), 'f', -1, 64)
-		if inArray {
-			_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-			return err
-		}
-		_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-		return err
+		return e.writeScalar(strconv.FormatFloat(v.Float(), 'f', -1, 64), indent, inArray)
 
 	case reflect.Bool:
-		s := strconv.FormatBool(v.Bool())
-		if inArray {
-			_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-			return err
-		}
-		_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-		return err
+		return e.writeScalar(strconv.FormatBool(v.Bool()), indent, inArray)
 
 	default:
 		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Kind())
@@ -158,41 +198,183 @@ func (e *Encoder) encodeValue(v reflect.Value, indent int, inArray bool) error {
 
 // encodeStruct handles marshalling a Go struct to PIML.
 func (e *Encoder) encodeStruct(v reflect.Value, indent int) error {
-	t := v.Type()
+	fields, err := e.sortFields(structFields(v, e.tagKeys))
+	if err != nil {
+		return err
+	}
+	return e.encodeFields(nestFields(fields), indent)
+}
+
+// encodeFields writes fields as the keys of an object whose own key is
+// at indent.
+func (e *Encoder) encodeFields(fields []structField, indent int) error {
 	// The fields of a struct are indented one level deeper than the struct's key.
 	// For the root, indent = -1, so fieldIndent = 0.
 	// For a nested struct, indent = 0, so fieldIndent = 1.
 	fieldIndent := indent + 1
-	var indentStr string
-	if fieldIndent > 0 {
-		indentStr = strings.Repeat("  ", fieldIndent)
-	}
+	indentStr := indentString(fieldIndent)
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		fieldV := v.Field(i)
+	var prev *structField
+	for i := range fields {
+		f := &fields[i]
+		if f.omitted() {
+			continue // Skip zero values entirely, key included
+		}
 
-		tag := field.Tag.Get("piml")
-		if tag == "-" {
-			continue // Skip this field
+		if err := e.writeSpacing(prev, f, indent); err != nil {
+			return err
 		}
-		if tag == "" {
-			tag = strings.ToLower(field.Name)
+		prev = f
+
+		if e.comments {
+			if err := e.writeFieldComment(indentStr, f); err != nil {
+				return err
+			}
 		}
 
 		// Write the key
-		if _, err := e.w.Write([]byte(fmt.Sprintf("%s(%s)", indentStr, tag))); err != nil {
+		e.pushKey(f.name)
+		if err := e.writeKey(indentStr, f.name); err != nil {
 			return err
 		}
 
-		// Write the value
-		if err := e.encodeValue(fieldV, fieldIndent, false); err != nil {
+		// Fields with dotted keys are nested under the key they share.
+		if f.group != nil {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
+			if err := e.encodeFields(f.group, fieldIndent); err != nil {
+				return err
+			}
+			e.pop()
+			continue
+		}
+
+		// Secrets are masked, unless there is nothing to hide.
+		if e.redact && f.opts.Contains("secret") && !isNilOrEmpty(f.v) {
+			if err := e.writeString(" ", e.mask, "\n"); err != nil {
+				return err
+			}
+			e.pop()
+			continue
+		}
+		if e.encryptor != nil && f.opts.Contains("secret") && !isNilOrEmpty(f.v) {
+			s, err := e.encrypt(f.v)
+			if err != nil {
+				return err
+			}
+			if err := e.writeString(" ", s, "\n"); err != nil {
+				return err
+			}
+			e.pop()
+			continue
+		}
+
+		// Write the value, as a document for pimlstring fields
+		var err error
+		if f.opts.Contains("pimlstring") && !isNilOrEmpty(f.v) {
+			err = e.encodePIMLString(f.v, fieldIndent)
+		} else {
+			err = e.encodeValue(f.v, fieldIndent, false)
+		}
+		if err != nil {
 			return err
 		}
+		e.pop()
 	}
 	return nil
 }
 
+// structField is a struct field to be written as a key.
+type structField struct {
+	name    string
+	opts    tagOptions
+	comment string // From the pimlcomment tag
+	v       reflect.Value
+	group   []structField // Fields under this key, for dotted keys
+}
+
+// omitted reports whether f is left out for its readonly option or its
+// omitzero option, or, for a group, whether all its fields are.
+func (f *structField) omitted() bool {
+	if f.group == nil {
+		return f.opts.Contains("readonly") || (f.opts.Contains("omitzero") && isZero(f.v))
+	}
+	for i := range f.group {
+		if !f.group[i].omitted() {
+			return false
+		}
+	}
+	return true
+}
+
+// nestFields gathers the fields with dotted keys, like db.pool.size,
+// into groups under the first part of their key, recursively. A group
+// takes the place of its first field.
+func nestFields(fields []structField) []structField {
+	var nested []structField
+	var groups map[string]int // Index in nested, by key
+	for _, f := range fields {
+		head, rest, dotted := strings.Cut(f.name, ".")
+		if !dotted {
+			nested = append(nested, f)
+			continue
+		}
+		f.name = rest
+		i, ok := groups[head]
+		if !ok {
+			if groups == nil {
+				groups = make(map[string]int)
+			}
+			i = len(nested)
+			groups[head] = i
+			nested = append(nested, structField{name: head})
+		}
+		nested[i].group = append(nested[i].group, f)
+	}
+	for i := range nested {
+		if nested[i].group != nil {
+			nested[i].group = nestFields(nested[i].group)
+		}
+	}
+	return nested
+}
+
+// structFields returns the fields of struct v that are written, in
+// declaration order, with their values. The fields of untagged embedded
+// structs are promoted: they are listed as if they were fields of v,
+// unless they are behind a nil pointer. Tags are read under tagKeys, see
+// SetTagKeys.
+func structFields(v reflect.Value, tagKeys []string) []structField {
+	info := cachedFields(v.Type(), tagKeys...)
+	fields := make([]structField, 0, len(info.fields))
+	for _, f := range info.fields {
+		fieldV, ok := fieldByIndex(v, f.index, false)
+		if !ok {
+			continue // Nothing to promote
+		}
+		fields = append(fields, structField{name: f.name, opts: f.opts, comment: f.comment, v: fieldV})
+	}
+	return fields
+}
+
+// writeKey writes a key, escaped, after checking it can be read back.
+func (e *Encoder) writeKey(indentStr, key string) error {
+	return e.writeCheckedKey(indentStr, key, e.profileKeys)
+}
+
+// writeCheckedKey is like writeKey, writing the keys of profile blocks
+// too if profiles is set.
+func (e *Encoder) writeCheckedKey(indentStr, key string, profiles bool) error {
+	if e.keyNormalizer != nil {
+		key = e.keyNormalizer(key)
+	}
+	if err := checkKey(key, profiles); err != nil {
+		return err
+	}
+	return e.writeString(indentStr, "(", escapeKey(key), ")")
+}
+
 // encodeSlice handles marshalling a Go slice to PIML.
 func (e *Encoder) encodeSlice(v reflect.Value, indent int) error {
 	if v.Len() == 0 {
@@ -201,102 +383,180 @@ func (e *Encoder) encodeSlice(v reflect.Value, indent int) error {
 
 	// We'll peek at the first element
 	elemType := v.Type().Elem()
-	if elemType.Kind() == reflect.Ptr {
+	for elemType.Kind() == reflect.Ptr {
 		elemType = elemType.Elem()
 	}
 
-	switch elemType.Kind() {
-	case reflect.Struct:
+	isObject := isObjectType(elemType)
+
+	indentStr := indentString(indent)
+	if ok, err := e.encodeFastSlice(v, indent); ok {
+		return err
+	}
+
+	switch {
+	case isObject:
 		// List of Objects
 		for i := 0; i < v.Len(); i++ {
 			elemV := v.Index(i)
+			// Nil items are kept, so the other items stay in place.
+			if isNilOrEmpty(elemV) {
+				if err := e.writeString(indentStr, "> nil\n"); err != nil {
+					return err
+				}
+				continue
+			}
 			// Pass 'true' for inArray
+			e.pushIndex(i)
 			if err := e.encodeValue(elemV, indent, true); err != nil {
 				return err
 			}
+			e.pop()
+		}
+	case elemType.Kind() == reflect.Interface:
+		// The items of a []interface{}, as converters and templates
+		// make them, are written by their dynamic type.
+		for i := 0; i < v.Len(); i++ {
+			elemV := v.Index(i)
+			item := elemV
+			for (item.Kind() == reflect.Ptr || item.Kind() == reflect.Interface) && !item.IsNil() {
+				item = item.Elem()
+			}
+			e.pushIndex(i)
+			var err error
+			if item.IsValid() && isObjectType(item.Type()) && !isNilOrEmpty(item) {
+				err = e.encodeValue(item, indent, true)
+			} else {
+				err = e.writePrimitiveArrayItem(elemV, indent)
+			}
+			if err != nil {
+				return err
+			}
+			e.pop()
 		}
 	default:
 		// List of Primitives
-		var indentStr string
-		if indent > 0 {
-			indentStr = strings.Repeat("  ", indent)
-		}
 		for i := 0; i < v.Len(); i++ {
 			elemV := v.Index(i)
 			// Pass 'true' for inArray, but we re-implement the primitive
 			// logic here to write the '>'.
-			if err := e.writePrimitiveArrayItem(elemV, indentStr); err != nil {
+			e.pushIndex(i)
+			if err := e.writePrimitiveArrayItem(elemV, indent); err != nil {
 				return err
 			}
+			e.pop()
 		}
 	}
 	return nil
 }
 
+// isObjectType reports whether array items of type t are written as
+// "> (item)" blocks rather than "> value" lines. time.Time,
+// sql.NullString, etc. are listed like primitives.
+func isObjectType(t reflect.Type) bool {
+	_, nullable := nullableValueField(t)
+	return (t.Kind() == reflect.Struct && !nullable && !isScalarType(t)) ||
+		t.Kind() == reflect.Map
+}
+
 // encodeMap handles marshalling a Go map to PIML.
 // This is just like a struct.
 func (e *Encoder) encodeMap(v reflect.Value, indent int) error {
+	if ok, err := e.encodeFastMap(v, indent); ok {
+		return err
+	}
+
 	// Maps are encoded just like structs.
 	fieldIndent := indent + 1
-	var indentStr string
-	if fieldIndent > 0 {
-		indentStr = strings.Repeat("  ", fieldIndent)
+	indentStr := indentString(fieldIndent)
+
+	if v.Type().Key().Kind() != reflect.String {
+		return errors.New("piml: map keys must be strings")
 	}
 
-	// Note: Map iteration is not stable, so output may vary.
-	for _, key := range v.MapKeys() {
+	// Sort the keys, so the output is stable.
+	keys := v.MapKeys()
+	sortValues(keys)
+
+	for i, key := range keys {
 		val := v.MapIndex(key)
-		keyStr, ok := key.Interface().(string)
-		if !ok {
-			return errors.New("piml: map keys must be strings")
+		keyStr := key.String()
+
+		if i > 0 && e.spacing == SpacingSections && indent == -1 {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
 		}
 
 		// Write the key
-		if _, err := e.w.Write([]byte(fmt.Sprintf("%s(%s)", indentStr, keyStr))); err != nil {
+		e.pushKey(keyStr)
+		if err := e.writeKey(indentStr, keyStr); err != nil {
 			return err
 		}
 		// Write the value
 		if err := e.encodeValue(val, fieldIndent, false); err != nil {
 			return err
 		}
+		e.pop()
 	}
 	return nil
 }
 
+// encodeSet handles marshalling a map[T]struct{} to PIML as a set.
+// Items are written in sorted order.
+func (e *Encoder) encodeSet(v reflect.Value, indent int) error {
+	indentStr := indentString(indent)
+
+	keys := v.MapKeys()
+	sortValues(keys)
+	for _, key := range keys {
+		s, err := formatPrimitive(key)
+		if err != nil {
+			return err
+		}
+		if err := e.writeSetItem(indentStr, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSetItem writes s as a set item. Sets have no multi-line form, so
+// an item holding a line break is an error.
+func (e *Encoder) writeSetItem(indentStr, s string) error {
+	if strings.Contains(s, "\n") {
+		return fmt.Errorf("piml: set items can't span lines: %q", s)
+	}
+	return e.writeString(indentStr, ">| ", e.escapeValue(s), "\n")
+}
+
 // encodeString handles marshalling a string.
 // It detects multi-line strings.
-func (e *Encoder) encodeString(v reflect.Value, indent int, inArray bool) error {
-	s := v.String()
-	var indentStr string
-	if indent > 0 {
-		indentStr = strings.Repeat("  ", indent)
-	}
+func (e *Encoder) encodeString(s string, indent int, inArray bool) error {
+	indentStr := indentString(indent)
 
 	if strings.Contains(s, "\n") {
 		// --- Multi-line String ---
 		if inArray {
-			// This is tricky. A multi-line string in an array?
-			// Let's just use > for the first line
-			_, err := e.w.Write([]byte(fmt.Sprintf("%s> ... (multi-line not fully supported in array yet)\n", indentStr)))
-			return err
-		}
-		// Write key (already written by caller), then newline
-		if _, err := e.w.Write([]byte("\n")); err != nil {
-			return err
+			// An array item is a > alone, with the lines below it.
+			if err := e.writeString(indentStr, ">\n"); err != nil {
+				return err
+			}
+		} else if indent > -1 {
+			// Write key (already written by caller), then newline
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
 		}
 		// Write each line indented
 		lines := strings.Split(s, "\n")
 		lineIndent := indent + 1
-		var lineIndentStr string
-		if lineIndent > 0 {
-			lineIndentStr = strings.Repeat("  ", lineIndent)
-		}
+		lineIndentStr := indentString(lineIndent)
 		for _, line := range lines {
-			// Escape any line that starts with # to prevent it being parsed as a comment.
-			if strings.HasPrefix(line, "#") {
-				line = `\` + line
-			}
-			if _, err := e.w.Write([]byte(fmt.Sprintf("%s%s\n", lineIndentStr, line))); err != nil {
+			// Escape any line that would read as a comment, a key, an
+			// item or a document separator.
+			line = escapeLine(line)
+			if err := e.writeString(lineIndentStr, line, "\n"); err != nil {
 				return err
 			}
 		}
@@ -304,47 +564,202 @@ func (e *Encoder) encodeString(v reflect.Value, indent int, inArray bool) error
 	}
 
 	// --- Single-line String ---
+	return e.writeScalar(s, indent, inArray)
+}
+
+// writeScalar writes s as an array item, or as the value of the key
+// just written. A scalar at the root has no key, so no leading space.
+func (e *Encoder) writeScalar(s string, indent int, inArray bool) error {
 	if inArray {
-		_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-		return err
+		indentStr := indentString(indent)
+		return e.writeString(indentStr, "> ", e.escapeItem(s), "\n")
 	}
-	_, err := e.w.Write([]byte(fmt.Sprintf(" %s\n", s)))
-	return err
+	if indent == -1 {
+		// With no key before it, a value that would read as a comment, a
+		// key, an item, nil or a document separator is escaped.
+		return e.writeString(escapeRootScalar(s), "\n")
+	}
+	return e.writeString(" ", e.escapeValue(s), "\n")
+}
+
+// writeNil writes nil as the value of the key just written, or alone at
+// the root.
+func (e *Encoder) writeNil(indent int) error {
+	if indent == -1 {
+		return e.writeString("nil\n")
+	}
+	return e.writeString(" nil\n")
 }
 
 // writePrimitiveArrayItem is a helper for encodeSlice
-func (e *Encoder) writePrimitiveArrayItem(v reflect.Value, indentStr string) error {
-	for v.Kind() == reflect.Ptr {
+func (e *Encoder) writePrimitiveArrayItem(v reflect.Value, indent int) error {
+	for (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && !v.IsNil() {
 		v = v.Elem()
 	}
 
-	var s string
+	// Nil items are kept, so the other items stay in place.
+	if isNilOrEmpty(v) {
+		return e.writeString(indentString(indent), "> nil\n")
+	}
+	if i, ok := nullableValueField(v.Type()); ok {
+		v = v.Field(i)
+	}
+
+	s, err := formatPrimitive(v)
+	if err != nil {
+		return err
+	}
+	return e.encodeString(s, indent, true)
+}
+
+// writeString writes the concatenation of parts through the encoder's
+// line buffer, which saves the allocations of building lines with
+// fmt.Sprintf or +.
+func (e *Encoder) writeString(parts ...string) error {
+	e.buf = e.buf[:0]
+	for _, p := range parts {
+		e.buf = append(e.buf, p...)
+	}
+	_, err := e.w.Write(e.buf)
+	return err
+}
+
+// indentSpaces holds the indentation of the first levels, to be sliced
+// rather than built for every line.
+const indentSpaces = "                                                                "
+
+// indentString returns the spaces for the given indentation level.
+func indentString(indent int) string {
+	if indent <= 0 {
+		return ""
+	}
+	if 2*indent <= len(indentSpaces) {
+		return indentSpaces[:2*indent]
+	}
+	return strings.Repeat("  ", indent)
+}
+
+// escapeValue escapes a single-line value after a key, or a set item,
+// that would otherwise start a comment or read as nil. Values that
+// start like a key or an item read back as they are.
+func (e *Encoder) escapeValue(s string) string {
+	return e.escape(s, isNilEscape)
+}
+
+// escapeItem escapes a single-line list item that would otherwise
+// start a comment, or read as a key, an item or nil.
+func (e *Encoder) escapeItem(s string) string {
+	return e.escape(s, isScalarEscape)
+}
+
+// escape escapes the hashes of s that would start a comment, and s
+// itself if it is ambiguous.
+func (e *Encoder) escape(s string, ambiguous func(string) bool) string {
+	if !e.inlineComments {
+		if ambiguous(s) {
+			return `\` + s
+		}
+		return escapeHash(s)
+	}
+	if ambiguous(s) {
+		return `\` + escapeInlineHashes(s)
+	}
+	return escapeInlineHashes(s)
+}
+
+// formatPrimitive returns the text of a primitive array or set item.
+func formatPrimitive(v reflect.Value) (string, error) {
+	// Enums, encoding.TextMarshaler, etc. format themselves
+	if s, ok, err := formatScalar(v); ok {
+		return s, err
+	}
+
 	switch v.Kind() {
 	case reflect.String:
-		s = v.String()
+		return v.String(), nil
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-		s = strconv.FormatInt(v.Int(), 10)
+		return strconv.FormatInt(v.Int(), 10), nil
 	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		s = strconv.FormatUint(v.Uint(), 10)
+		return strconv.FormatUint(v.Uint(), 10), nil
 	case reflect.Float32, reflect.Float64:
-		s = strconv.FormatFloat(v.Float(), 'f', -1, 64)
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
 	case reflect.Bool:
-		s = strconv.FormatBool(v.Bool())
+		return strconv.FormatBool(v.Bool()), nil
 	default:
-		return fmt.Errorf("%w: %s", ErrUnsupportedType, v.Kind())
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, v.Kind())
 	}
+}
 
-	_, err := e.w.Write([]byte(fmt.Sprintf("%s> %s\n", indentStr, s)))
-	return err
+// isSetType reports whether t is a map[T]struct{}, which is written as a set.
+func isSetType(t reflect.Type) bool {
+	return t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && t.Elem().NumField() == 0
+}
+
+// sortValues sorts map keys in their natural order: numerically
+// for numbers, and by their string form otherwise.
+func sortValues(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		}
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	})
+}
+
+// isZeroer is implemented by types with their own notion of zero,
+// such as time.Time.
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf((*isZeroer)(nil)).Elem()
+
+// isZero reports whether v is the zero value of its type, using its
+// IsZero method if it has one. Nil pointers are always zero.
+func isZero(v reflect.Value) bool {
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return true
+	}
+	if v.Type().Implements(isZeroerType) {
+		return v.Interface().(isZeroer).IsZero()
+	}
+	if reflect.PointerTo(v.Type()).Implements(isZeroerType) {
+		return addressable(v).Addr().Interface().(isZeroer).IsZero()
+	}
+	return v.IsZero()
 }
 
 // isNilOrEmpty checks if a reflect.Value is nil, or an empty slice/map.
+// Pointers are followed, so a pointer to a nil pointer is nil too, as it
+// is written.
 func isNilOrEmpty(v reflect.Value) bool {
 	switch v.Kind() {
-	case reflect.Ptr, reflect.Interface:
+	case reflect.Ptr:
+		return v.IsNil() || isNilOrEmpty(v.Elem())
+	case reflect.Interface:
 		return v.IsNil()
 	case reflect.Slice, reflect.Map:
 		return v.IsNil() || v.Len() == 0
+	case reflect.Struct:
+		if _, ok := nullableValueField(v.Type()); ok {
+			return !v.FieldByName("Valid").Bool()
+		}
+		switch v.Type() {
+		case ipNetType:
+			// A net.IPNet with no address or mask is written as nil, not "<nil>"
+			n := v.Interface().(net.IPNet)
+			return n.IP == nil || n.Mask == nil
+		case netipAddrType, netipPrefixType, netipAddrPortType:
+			return v.IsZero() // Rather than as empty text
+		}
 	}
 	return false
 }
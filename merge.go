@@ -0,0 +1,31 @@
+package piml
+
+// SetKeepOnNil makes `(key) nil` leave the value of key as it is, rather
+// than clear it, when decoding over values that are already set.
+//
+// Decoding into a populated struct or map is a merge: keys present in
+// the document overwrite their fields and entries, nested objects are
+// merged into the same way, and keys absent from it leave theirs
+// untouched. That makes Decode usable for laying a partial config over
+// a full one:
+//
+//	cfg := defaults()
+//	d := piml.NewDecoder(overrides)
+//	d.SetKeepOnNil(true)
+//	err := d.Decode(&cfg)
+//
+// By default nil clears a field, and is an error for fields that can't
+// be nil. With SetKeepOnNil, overrides can write nil for "no override"
+// instead, and no map entries are added for it.
+func (d *Decoder) SetKeepOnNil(on bool) {
+	d.keepOnNil = on
+}
+
+// SetZeroTarget makes Decode zero the value it decodes into before
+// decoding, maps and slices included, so the result holds only what the
+// document says, and a struct reused across reloads of a file never
+// keeps values from an earlier version of it. The value is zeroed once
+// the input has been verified, even if decoding then fails.
+func (d *Decoder) SetZeroTarget(on bool) {
+	d.zeroTarget = on
+}
@@ -0,0 +1,210 @@
+package piml
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// A MigrationFunc upgrades a document from one schema version to the next
+// by modifying it in place. The document is in its generic form: objects
+// are map[string]interface{}, arrays are []interface{}, scalars are strings.
+// Keys keep the order they had, and new keys come after them.
+type MigrationFunc func(doc map[string]interface{}) error
+
+// A NodeMigrationFunc upgrades a document from one schema version to the
+// next by changing its root node, an object, in place.
+type NodeMigrationFunc func(doc *Node) error
+
+// Migrations is a registry of schema upgrades for a config format whose
+// version is stored under a top-level key.
+type Migrations struct {
+	versionKey string
+	current    int
+	steps      map[int]NodeMigrationFunc
+}
+
+// NewMigrations returns an empty registry for documents that store their
+// schema version under versionKey and are currently at version current.
+func NewMigrations(versionKey string, current int) *Migrations {
+	return &Migrations{
+		versionKey: versionKey,
+		current:    current,
+		steps:      make(map[int]NodeMigrationFunc),
+	}
+}
+
+// Register adds the migration that upgrades documents
+// from version from to version from+1.
+func (m *Migrations) Register(from int, fn MigrationFunc) {
+	m.steps[from] = func(doc *Node) error {
+		generic, _ := doc.Interface().(map[string]interface{})
+		if err := fn(generic); err != nil {
+			return err
+		}
+		n, err := genericDocNode(generic)
+		if err != nil {
+			return err
+		}
+		keepShape(n, doc)
+		*doc = *n
+		return nil
+	}
+}
+
+// genericDocNode returns the node of doc, the generic form of a
+// document, whose profile blocks are held as keys.
+func genericDocNode(doc map[string]interface{}) (*Node, error) {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.profileKeys = true
+	if err := e.Encode(doc); err != nil {
+		return nil, err
+	}
+	return ParseNode(b.Bytes())
+}
+
+// RegisterNode is like Register, for a migration that changes the
+// document's node.
+func (m *Migrations) RegisterNode(from int, fn NodeMigrationFunc) {
+	m.steps[from] = fn
+}
+
+// LoadWithMigrations decodes data into v like Unmarshal, upgrading it to
+// the current schema version first.
+//
+// The version is read from the migrations' version key; a document without
+// it is treated as version 0. Each registered step is applied in turn and
+// the version key is updated after each one, so a missing step or a
+// document newer than the current version is an error. The steps run on
+// the document's Node, which is decoded into v once they are done, so
+// values the steps leave alone are decoded as they were written.
+// Profile blocks are kept, and apply once the document is decoded.
+func LoadWithMigrations(data []byte, v interface{}, m *Migrations) error {
+	return LoadWithMigrationsOptions(data, v, m, DecoderOptions{})
+}
+
+// LoadWithMigrationsOptions is like LoadWithMigrations, but decodes with
+// the settings in opts. Settings that apply to the input as written,
+// such as checksums, templates and flat keys, apply before the steps
+// run; the rest apply when the upgraded document is decoded into v.
+func LoadWithMigrationsOptions(data []byte, v interface{}, m *Migrations, opts DecoderOptions) error {
+	input, rest := splitInputOptions(opts)
+	var doc Node
+	if err := NewDecoderWithOptions(data, input).Decode(&doc); err != nil {
+		return err
+	}
+	if doc.Kind != ObjectNode {
+		return fmt.Errorf("piml: the root of a migrated document must be an object, not %v", doc.Kind)
+	}
+
+	version := 0
+	if raw := doc.child(nodeStep{key: m.versionKey, index: -1}); raw != nil {
+		var err error
+		if version, err = strconv.Atoi(raw.Value); err != nil {
+			return fmt.Errorf("piml: invalid schema version %q: %w", raw.Value, err)
+		}
+	}
+	if version > m.current {
+		return fmt.Errorf("piml: schema version %d is newer than supported version %d", version, m.current)
+	}
+	if version == m.current {
+		return UnmarshalWithOptions(data, v, opts) // Nothing to upgrade
+	}
+
+	for ; version < m.current; version++ {
+		step, ok := m.steps[version]
+		if !ok {
+			return fmt.Errorf("piml: no migration registered from schema version %d", version)
+		}
+		if err := step(&doc); err != nil {
+			return fmt.Errorf("piml: migrating from schema version %d: %w", version, err)
+		}
+		if doc.Kind != ObjectNode {
+			return fmt.Errorf("piml: migrating from schema version %d: the root must stay an object, not %v", version, doc.Kind)
+		}
+		setVersion(&doc, m.versionKey, version+1)
+	}
+
+	upgraded, err := Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	return UnmarshalWithOptions(upgraded, v, rest)
+}
+
+// splitInputOptions splits opts into the settings that apply to the
+// input as written, and the rest, for decoding the document Marshal
+// writes once it is upgraded.
+func splitInputOptions(opts DecoderOptions) (input, rest DecoderOptions) {
+	input = DecoderOptions{
+		InlineComments: opts.InlineComments,
+		IndentUnit:     opts.IndentUnit,
+		Flat:           opts.Flat,
+		Checksum:       opts.Checksum,
+		Verifier:       opts.Verifier,
+		Signature:      opts.Signature,
+		Template:       opts.Template,
+		TemplateData:   opts.TemplateData,
+		TemplateFuncs:  opts.TemplateFuncs,
+	}
+	rest = opts
+	rest.InlineComments = false
+	rest.IndentUnit = 0
+	rest.Flat = false
+	rest.Checksum = false
+	rest.Verifier = nil
+	rest.Signature = nil
+	rest.Template = false
+	rest.TemplateData = nil
+	rest.TemplateFuncs = nil
+	return input, rest
+}
+
+// setVersion sets the version key of doc to version, adding it first if
+// it is missing.
+func setVersion(doc *Node, key string, version int) {
+	n := &Node{Kind: ScalarNode, Key: key, Value: strconv.Itoa(version)}
+	for i, c := range doc.Children {
+		if c.Key == key {
+			doc.Children[i] = n
+			return
+		}
+	}
+	doc.Children = append([]*Node{n}, doc.Children...)
+}
+
+// keepShape gives n, rebuilt from the generic values of old, what those
+// values lost: its keys go back in the order they had in old, with new
+// keys after them, and arrays that were sets are sets again.
+func keepShape(n, old *Node) {
+	switch {
+	case n.Kind == ObjectNode && old.Kind == ObjectNode:
+		pos := make(map[string]int, len(old.Children))
+		for i, c := range old.Children {
+			pos[c.Key] = i
+		}
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			pi, oki := pos[n.Children[i].Key]
+			pj, okj := pos[n.Children[j].Key]
+			if oki && okj {
+				return pi < pj
+			}
+			return oki && !okj
+		})
+		for _, c := range n.Children {
+			if o := old.child(nodeStep{key: c.Key, index: -1}); o != nil {
+				keepShape(c, o)
+			}
+		}
+	case n.Kind == ArrayNode && old.Kind == SetNode:
+		n.Kind = SetNode
+	case n.Kind == ArrayNode && old.Kind == ArrayNode:
+		for i, c := range n.Children {
+			if i < len(old.Children) {
+				keepShape(c, old.Children[i])
+			}
+		}
+	}
+}
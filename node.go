@@ -0,0 +1,401 @@
+package piml
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NodeKind is the kind of value held by a Node.
+type NodeKind int
+
+const (
+	ScalarNode NodeKind = iota // A value like 8080 or localhost
+	NilNode                    // nil
+	ObjectNode                 // Keys and their values
+	ArrayNode                  // "> item" items
+	SetNode                    // ">| item" items
+)
+
+func (k NodeKind) String() string {
+	switch k {
+	case ScalarNode:
+		return "scalar"
+	case NilNode:
+		return "nil"
+	case ObjectNode:
+		return "object"
+	case ArrayNode:
+		return "array"
+	case SetNode:
+		return "set"
+	}
+	return fmt.Sprintf("NodeKind(%d)", int(k))
+}
+
+// A Node is a value of a PIML document held as a tree, for programs
+// that read, build or change documents without Go types for them.
+// Marshal writes a Node, or a struct field holding one, as the value it
+// holds, and Unmarshal reads any value into one, keeping keys in
+// document order:
+//
+//	var n piml.Node
+//	err := piml.Unmarshal(data, &n)
+//	port := n.Lookup("server.port") // nil if there is none
+//
+// Node is the generic value of PIML: a kind, the text of a scalar and
+// ordered children, which Tree, the Editor and the properties and INI
+// converters share. SetGenericNodes makes interface{} values decode as
+// nodes, and Interface turns a node into the map[string]interface{}
+// values Unmarshal otherwise makes.
+type Node struct {
+	Kind     NodeKind
+	Key      string  // Key of the node in an object, or label of an object in a list
+	Value    string  // Text of a scalar
+	Children []*Node // Fields of an object, or items of an array or set
+}
+
+var nodeType = reflect.TypeOf(Node{})
+
+// ParseNode parses a PIML document into a tree of nodes. An empty
+// document is an empty object.
+func ParseNode(data []byte) (*Node, error) {
+	var n Node
+	if err := Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// NewNode returns the node of v, which is written as Marshal writes it.
+// Nodes are returned as they are.
+func NewNode(v interface{}) (*Node, error) {
+	switch v := v.(type) {
+	case *Node:
+		return v, nil
+	case Node:
+		return &v, nil
+	case string:
+		return &Node{Kind: ScalarNode, Value: v}, nil
+	}
+	data, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return ParseNode(data)
+}
+
+// Interface returns the value of n as generic values, as Unmarshal
+// reads it into an interface{}: objects are map[string]interface{},
+// arrays and sets []interface{}, in document order, scalars strings,
+// and nil is nil. The order of keys is lost.
+func (n *Node) Interface() interface{} {
+	switch n.Kind {
+	case ScalarNode:
+		return n.Value
+	case ObjectNode:
+		m := make(map[string]interface{}, len(n.Children))
+		for _, c := range n.Children {
+			m[c.Key] = c.Interface()
+		}
+		return m
+	case ArrayNode, SetNode:
+		list := make([]interface{}, len(n.Children))
+		for i, c := range n.Children {
+			list[i] = c.Interface()
+		}
+		return list
+	}
+	return nil
+}
+
+// SetGenericNodes makes the decoder read values into interface{} as
+// *Node, keeping the order of keys, instead of as map[string]interface{},
+// []interface{} and string values. The values of a
+// map[string]interface{} are then nodes too.
+func (d *Decoder) SetGenericNodes(on bool) {
+	d.genericNodes = on
+}
+
+// genericNode returns a new Node, set as the value of v, if v is an
+// interface{} read as a node, see SetGenericNodes.
+func (d *Decoder) genericNode(v reflect.Value) (*Node, bool) {
+	if !d.genericNodes {
+		return nil, false
+	}
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Interface || t.NumMethod() != 0 {
+		return nil, false
+	}
+	v = indirect(v, true)
+	if !v.CanSet() {
+		return nil, false
+	}
+	n := &Node{}
+	v.Set(reflect.ValueOf(n))
+	return n, true
+}
+
+// Lookup returns the node at path below n, or nil if there is none.
+// A path is made of keys joined with dots, with the index of list
+// items in brackets, such as servers[0].host; the empty path is n
+// itself.
+func (n *Node) Lookup(path string) *Node {
+	steps, err := parseNodePath(path)
+	if err != nil {
+		return nil
+	}
+	for _, s := range steps {
+		if n = n.child(s); n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// child returns the child of n at step s, or nil if there is none.
+func (n *Node) child(s nodeStep) *Node {
+	if s.index < 0 {
+		if n.Kind != ObjectNode {
+			return nil
+		}
+		for _, c := range n.Children {
+			if c.Key == s.key {
+				return c
+			}
+		}
+		return nil
+	}
+	if (n.Kind != ArrayNode && n.Kind != SetNode) || s.index >= len(n.Children) {
+		return nil
+	}
+	return n.Children[s.index]
+}
+
+// nodeStep is a step of a node path: a key, or the index of an item.
+type nodeStep struct {
+	key   string
+	index int // -1 for a key
+}
+
+func (s nodeStep) String() string {
+	if s.index < 0 {
+		return s.key
+	}
+	return "[" + strconv.Itoa(s.index) + "]"
+}
+
+// parseNodePath splits a path like servers[0].host into its steps.
+func parseNodePath(path string) ([]nodeStep, error) {
+	var steps []nodeStep
+	rest := path
+	for rest != "" {
+		if rest[0] == '[' {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("piml: unclosed index in path %q", path)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 {
+				return nil, fmt.Errorf("piml: invalid index %q in path %q", rest[1:end], path)
+			}
+			steps = append(steps, nodeStep{index: i})
+			rest = rest[end+1:]
+			switch {
+			case strings.HasPrefix(rest, ".") && len(rest) > 1:
+				rest = rest[1:]
+			case rest != "" && rest[0] != '[':
+				return nil, fmt.Errorf("piml: expected . or [ after index in path %q", path)
+			}
+			continue
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("piml: empty key in path %q", path)
+		}
+		steps = append(steps, nodeStep{key: rest[:end], index: -1})
+		rest = rest[end:]
+		if strings.HasPrefix(rest, ".") {
+			if rest = rest[1:]; rest == "" {
+				return nil, fmt.Errorf("piml: empty key in path %q", path)
+			}
+		}
+	}
+	return steps, nil
+}
+
+// encodeNode writes n like encodeValue writes a Go value.
+func (e *Encoder) encodeNode(n *Node, indent int, inArray bool) error {
+	indentStr := indentString(indent)
+	switch n.Kind {
+	case ScalarNode:
+		return e.encodeString(n.Value, indent, inArray)
+	case NilNode:
+		if inArray {
+			return e.writeString(indentStr, "> nil\n")
+		}
+		return e.writeNil(indent)
+	case ObjectNode:
+		if inArray {
+			label := n.Key
+			if label == "" {
+				label = "item"
+			}
+			if err := e.writeString(indentStr, "> (", escapeKey(label), ")\n"); err != nil {
+				return err
+			}
+			indent++
+		} else if indent > -1 {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
+		}
+		for _, c := range n.Children {
+			e.pushKey(c.Key)
+			if err := e.writeNodeKey(indentString(indent+1), c.Key); err != nil {
+				return err
+			}
+			if err := e.encodeNode(c, indent+1, false); err != nil {
+				return err
+			}
+			e.pop()
+		}
+		return nil
+	case ArrayNode, SetNode:
+		if inArray {
+			return errors.New("piml: lists can't hold lists")
+		}
+		if len(n.Children) == 0 {
+			return e.writeNil(indent)
+		}
+		if indent > -1 {
+			if err := e.writeString("\n"); err != nil {
+				return err
+			}
+		}
+		itemIndent := indent + 1
+		for i, c := range n.Children {
+			e.pushIndex(i)
+			var err error
+			if n.Kind == SetNode {
+				err = e.writeSetItem(indentString(itemIndent), c.Value)
+			} else {
+				err = e.encodeNode(c, itemIndent, true)
+			}
+			if err != nil {
+				return err
+			}
+			e.pop()
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: node kind %v", ErrUnsupportedType, n.Kind)
+}
+
+// decodeNode reads the value of a key at currentIndent, or of the root,
+// into n.
+func (d *Decoder) decodeNode(n *Node, currentIndent int) error {
+	key := n.Key
+	*n = Node{Key: key}
+	var stack []*Node
+	var pending string // Key of the next value
+	var label string   // Label of the next object in a list
+	add := func(kind NodeKind, value string) *Node {
+		if len(stack) == 0 {
+			n.Kind, n.Value = kind, value
+			return n
+		}
+		parent := stack[len(stack)-1]
+		c := &Node{Kind: kind, Value: value}
+		if parent.Kind == ObjectNode {
+			c.Key = pending
+		} else if kind == ObjectNode {
+			c.Key = label
+		}
+		parent.Children = append(parent.Children, c)
+		return c
+	}
+	open := func(kind NodeKind) func(Position) error {
+		return func(Position) error {
+			stack = append(stack, add(kind, ""))
+			return nil
+		}
+	}
+	end := func(Position) error {
+		stack = stack[:len(stack)-1]
+		return nil
+	}
+	h := Handler{
+		OnKey: func(k string, _ Position) error {
+			pending = k
+			return nil
+		},
+		OnScalar: func(value string, _ Position) error {
+			add(ScalarNode, value)
+			return nil
+		},
+		OnObjectStart: open(ObjectNode),
+		OnObjectEnd:   end,
+		OnArrayStart:  open(ArrayNode),
+		OnArrayEnd:    end,
+		OnSetStart:    open(SetNode),
+		OnSetEnd:      end,
+		onLabel: func(l string) {
+			label = l
+		},
+		onNil: func(Position) error {
+			add(NilNode, "")
+			return nil
+		},
+	}
+
+	line, err := d.peekChild(currentIndent)
+	if err != nil {
+		return err
+	}
+	if line == nil {
+		n.Kind = ObjectNode // An empty document, or (key) alone
+		return nil
+	}
+	return d.walkValue(h, currentIndent, linePosition(line))
+}
+
+// nodeTarget returns the Node that v is, or points to, allocating nil
+// pointers on the way.
+func nodeTarget(v reflect.Value) (*Node, bool) {
+	t := v.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t != nodeType {
+		return nil, false
+	}
+	v = indirect(v, true)
+	if !v.CanAddr() {
+		return nil, false
+	}
+	return v.Addr().Interface().(*Node), true
+}
+
+// writeNodeKey is like writeKey, but writes profile blocks too: a Node
+// holds them as keys, as ParseNode read them, so they are written back
+// as they were.
+func (e *Encoder) writeNodeKey(indentStr, key string) error {
+	return e.writeCheckedKey(indentStr, key, true)
+}
+
+// setNode sets n to the scalar of a "(key) value" line.
+func setNode(n *Node, valueStr string) {
+	*n = Node{Kind: ScalarNode, Key: n.Key, Value: valueStr}
+	if valueStr == "nil" {
+		n.Kind, n.Value = NilNode, ""
+	}
+}
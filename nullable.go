@@ -0,0 +1,40 @@
+package piml
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Nullable holds a value of type T that may be nil in a document,
+// like sql.Null[T]. A `nil` value decodes to Valid == false, and an
+// invalid Nullable marshals to `nil`.
+type Nullable[T any] struct {
+	V     T
+	Valid bool // Valid is true if V is not nil
+}
+
+// NullableOf returns a valid Nullable holding v.
+func NullableOf[T any](v T) Nullable[T] {
+	return Nullable[T]{V: v, Valid: true}
+}
+
+var nullablePkgPath = reflect.TypeOf(Nullable[int]{}).PkgPath()
+
+// nullableValueField reports whether t is a nullable wrapper: a Nullable[T],
+// or one of database/sql's NullString, NullInt64, ..., Null[T] types.
+// It returns the index of the field holding the value.
+func nullableValueField(t reflect.Type) (int, bool) {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return 0, false
+	}
+	switch {
+	case t.PkgPath() == "database/sql" && strings.HasPrefix(t.Name(), "Null"):
+	case t.PkgPath() == nullablePkgPath && strings.HasPrefix(t.Name(), "Nullable["):
+	default:
+		return 0, false
+	}
+	if valid := t.Field(1); valid.Name != "Valid" || valid.Type.Kind() != reflect.Bool {
+		return 0, false
+	}
+	return 0, true
+}
@@ -0,0 +1,91 @@
+package piml
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NumberMode is how the decoder reads numbers into interface{} values,
+// see SetNumberMode.
+type NumberMode int
+
+const (
+	// NumberString keeps numbers as strings, like any other scalar.
+	// This is the default.
+	NumberString NumberMode = iota
+
+	// NumberInt64 reads integers as int64 and other numbers as float64,
+	// so large integers such as IDs keep all their digits.
+	NumberInt64
+
+	// NumberFloat64 reads all numbers as float64, as encoding/json does.
+	NumberFloat64
+
+	// NumberType reads numbers as a Number, which keeps their text.
+	NumberType
+)
+
+// A Number is the text of a number read into an interface{} with
+// NumberType. It is written back as it was read.
+type Number string
+
+// String returns the text of the number.
+func (n Number) String() string {
+	return string(n)
+}
+
+// Int64 returns the number as an int64.
+func (n Number) Int64() (int64, error) {
+	return strconv.ParseInt(string(n), 10, 64)
+}
+
+// Float64 returns the number as a float64.
+func (n Number) Float64() (float64, error) {
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// SetNumberMode sets how the decoder reads numbers into interface{}
+// values, such as the values of a map[string]interface{}: as strings,
+// which is the default, as int64 or float64, or as a Number. Values such
+// as 007, whose leading zeros would be lost, stay strings in every mode.
+func (d *Decoder) SetNumberMode(mode NumberMode) {
+	d.numberMode = mode
+}
+
+// genericScalar returns the interface{} value of a resolved scalar.
+func (d *Decoder) genericScalar(s string) interface{} {
+	return d.numberMode.Value(s)
+}
+
+// Value returns the interface{} value of the scalar s in the mode m, as
+// the decoder reads it: a number typed by m, or s itself if it isn't a
+// number or has leading zeros. With NumberInt64, integers beyond the
+// range of int64 are read as uint64 if they fit. Tools that type the
+// text of Nodes use it to agree with the decoder:
+//
+//	v := piml.NumberInt64.Value(n.Value) // int64(8080) for "8080"
+func (m NumberMode) Value(s string) interface{} {
+	if m == NumberString {
+		return s
+	}
+	typ := readType(s)
+	if typ != "int" && typ != "float" {
+		return s
+	}
+	if digits := strings.TrimLeft(s, "+-"); len(digits) > 1 && digits[0] == '0' && digits[1] >= '0' && digits[1] <= '9' {
+		return s // Leading zeros, as in a zip code
+	}
+	switch {
+	case m == NumberType:
+		return Number(s)
+	case m == NumberInt64 && typ == "int":
+		i, _ := strconv.ParseInt(s, 10, 64)
+		return i
+	case m == NumberInt64 && !strings.HasPrefix(s, "-"):
+		if u, err := strconv.ParseUint(strings.TrimPrefix(s, "+"), 10, 64); err == nil {
+			return u
+		}
+	}
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
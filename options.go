@@ -0,0 +1,165 @@
+package piml
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"text/template"
+)
+
+// EncoderOptions holds the settings of an Encoder in one value, for
+// NewEncoderWithOptions and MarshalWithOptions. Each field does what
+// the Encoder method of the same name does, and its zero value is the
+// default, so settings added in later versions leave existing option
+// values unchanged.
+type EncoderOptions struct {
+	Redact         string              // Mask for secret fields, none if empty
+	FieldOrder     FieldOrder          // Order of struct fields
+	Spacing        Spacing             // Where to write blank lines between keys
+	InlineComments bool                // Escape hashes that would start inline comments
+	CRLF           bool                // End lines with \r\n
+	Comments       bool                // Write pimlcomment tags above keys
+	KeyNormalizer  func(string) string // Applied to keys before writing
+	TagKeys        []string            // Struct tag keys, "piml" if empty
+	Flat           bool                // Write dotted keys
+	Checksum       bool                // End documents with a checksum trailer
+	Signer         SignFunc            // Signs documents, see SetSigner
+	Encryptor      Encryptor           // Encrypts secret fields
+}
+
+// NewEncoderWithOptions returns a new encoder that writes to w with
+// the settings in opts.
+func NewEncoderWithOptions(w io.Writer, opts EncoderOptions) *Encoder {
+	e := NewEncoder(w)
+	if opts.Redact != "" {
+		e.Redact(opts.Redact)
+	}
+	e.SetFieldOrder(opts.FieldOrder)
+	e.SetSpacing(opts.Spacing)
+	e.SetInlineComments(opts.InlineComments)
+	e.SetCRLF(opts.CRLF)
+	e.SetComments(opts.Comments)
+	e.SetKeyNormalizer(opts.KeyNormalizer)
+	e.SetTagKeys(opts.TagKeys...)
+	e.SetFlat(opts.Flat)
+	e.SetChecksum(opts.Checksum)
+	e.SetSigner(opts.Signer)
+	e.SetEncryptor(opts.Encryptor)
+	return e
+}
+
+// MarshalWithOptions is like Marshal, but encodes with the settings in
+// opts:
+//
+//	data, err := piml.MarshalWithOptions(cfg, piml.EncoderOptions{
+//		FieldOrder: piml.OrderAlphabetical,
+//		Spacing:    piml.SpacingSections,
+//	})
+func MarshalWithOptions(v interface{}, opts EncoderOptions) ([]byte, error) {
+	var b bytes.Buffer
+	if err := NewEncoderWithOptions(&b, opts).Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// DecoderOptions holds the settings of a Decoder in one value, for
+// NewDecoderWithOptions and UnmarshalWithOptions. Each field does what
+// the Decoder method of the same name does, and its zero value is the
+// default.
+type DecoderOptions struct {
+	InlineComments bool                     // " #" starts a comment in values
+	KeyNormalizer  func(string) string      // Applied to keys and struct tags
+	TagKeys        []string                 // Struct tag keys, "piml" if empty
+	KeyCases       KeyCase                  // Other keys of untagged fields
+	IndentUnit     int                      // Required indentation step, 0 for any
+	StrictFields   bool                     // Report keys of ambiguous fields
+	TimeLayouts    []string                 // Accepted time.Time layouts, in order
+	Resolvers      map[string]Resolver      // Keyed by scheme, see RegisterResolver
+	RefFS          fs.FS                    // Source of $ref documents, see UseRefFS
+	Profile        string                   // Profile to merge, see UseProfile
+	StatsHook      func(DecodeStats, error) // Called at the end of Decode
+	Flat           bool                     // Split keys at dots
+	OnlyPrefixes   []string                 // Key paths to decode, see SetOnlyPrefixes
+	SkipPrefixes   []string                 // Key paths to skip, see SetSkipPrefixes
+	Lenient        bool                     // Tolerate sloppy numbers and booleans
+	NumberMode     NumberMode               // How numbers are read into interface{}
+	TypeHints      bool                     // Read "!!type " prefixes of scalars
+	GenericNodes   bool                     // Read interface{} values as *Node
+	Warnings       bool                     // Record warnings, see SetWarnings
+	WarningHandler func(Warning)            // Called with each warning
+	TraceHook      func(Assignment)         // Called for every scalar stored
+	Checksum       bool                     // Verify the checksum trailer
+	Verifier       VerifyFunc               // Authenticates the input, see SetVerifier
+	Signature      []byte                   // Detached signature, see SetSignature
+	Decryptor      Decryptor                // Decrypts "enc:" scalars
+	AppendSlices   bool                     // Append array items to slices
+	KeepOnNil      bool                     // Leave values as they are on nil
+	ZeroTarget     bool                     // Zero the value before decoding
+	RejectDangling bool                     // Reject (key) lines without values
+	Partial        bool                     // Carry on past values that fail
+
+	// MatchKey, if set, decides which document keys match which field
+	// keys, see SetMatchKey.
+	MatchKey MatchKeyFunc
+
+	// Template, if set, runs the input through text/template with
+	// TemplateData and TemplateFuncs, see UseTemplate.
+	Template      bool
+	TemplateData  interface{}
+	TemplateFuncs template.FuncMap
+}
+
+// NewDecoderWithOptions returns a new decoder that reads from data with
+// the settings in opts.
+func NewDecoderWithOptions(data []byte, opts DecoderOptions) *Decoder {
+	d := NewDecoder(data)
+	d.SetInlineComments(opts.InlineComments)
+	d.SetKeyNormalizer(opts.KeyNormalizer)
+	d.SetTagKeys(opts.TagKeys...)
+	d.SetKeyCases(opts.KeyCases)
+	d.SetMatchKey(opts.MatchKey)
+	d.SetIndentUnit(opts.IndentUnit)
+	d.SetStrictFields(opts.StrictFields)
+	d.SetTimeLayouts(opts.TimeLayouts...)
+	for scheme, r := range opts.Resolvers {
+		d.RegisterResolver(scheme, r)
+	}
+	d.UseRefFS(opts.RefFS)
+	d.UseProfile(opts.Profile)
+	d.SetStatsHook(opts.StatsHook)
+	d.SetFlat(opts.Flat)
+	d.SetOnlyPrefixes(opts.OnlyPrefixes...)
+	d.SetSkipPrefixes(opts.SkipPrefixes...)
+	d.SetLenient(opts.Lenient)
+	d.SetNumberMode(opts.NumberMode)
+	d.SetTypeHints(opts.TypeHints)
+	d.SetGenericNodes(opts.GenericNodes)
+	d.SetWarnings(opts.Warnings)
+	d.SetWarningHandler(opts.WarningHandler)
+	d.SetTraceHook(opts.TraceHook)
+	d.SetChecksum(opts.Checksum)
+	d.SetVerifier(opts.Verifier)
+	d.SetSignature(opts.Signature)
+	d.SetDecryptor(opts.Decryptor)
+	d.SetAppendSlices(opts.AppendSlices)
+	d.SetKeepOnNil(opts.KeepOnNil)
+	d.SetZeroTarget(opts.ZeroTarget)
+	d.SetRejectDanglingKeys(opts.RejectDangling)
+	d.SetPartial(opts.Partial)
+	if opts.Template {
+		d.UseTemplate(opts.TemplateData, opts.TemplateFuncs)
+	}
+	return d
+}
+
+// UnmarshalWithOptions is like Unmarshal, but decodes with the
+// settings in opts:
+//
+//	err := piml.UnmarshalWithOptions(data, &cfg, piml.DecoderOptions{
+//		IndentUnit: 2,
+//		Profile:    "production",
+//	})
+func UnmarshalWithOptions(data []byte, v interface{}, opts DecoderOptions) error {
+	return NewDecoderWithOptions(data, opts).Decode(v)
+}
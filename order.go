@@ -0,0 +1,62 @@
+package piml
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// FieldOrder controls the order in which an Encoder writes struct fields.
+type FieldOrder int
+
+const (
+	// OrderDeclared writes fields in the order they are declared.
+	// This is the default.
+	OrderDeclared FieldOrder = iota
+
+	// OrderAlphabetical writes fields sorted by key.
+	OrderAlphabetical
+
+	// OrderWeighted writes fields by the weight in their `order` tag
+	// option, lowest first, e.g. `piml:"name,order=1"`. Fields without
+	// a weight follow, in the order they are declared.
+	OrderWeighted
+)
+
+// SetFieldOrder sets the order in which struct fields are written.
+// Promoted fields of embedded structs are ordered along with the others.
+func (e *Encoder) SetFieldOrder(order FieldOrder) {
+	e.order = order
+}
+
+// sortFields sorts fields according to the encoder's FieldOrder.
+func (e *Encoder) sortFields(fields []structField) ([]structField, error) {
+	switch e.order {
+	case OrderAlphabetical:
+		sort.SliceStable(fields, func(i, j int) bool {
+			return fields[i].name < fields[j].name
+		})
+	case OrderWeighted:
+		weights := make(map[string]int, len(fields))
+		for _, f := range fields {
+			s, ok := f.opts.Get("order")
+			if !ok {
+				continue
+			}
+			w, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, fmt.Errorf("piml: invalid order %q for key %q", s, f.name)
+			}
+			weights[f.name] = w
+		}
+		sort.SliceStable(fields, func(i, j int) bool {
+			wi, oki := weights[fields[i].name]
+			wj, okj := weights[fields[j].name]
+			if oki != okj {
+				return oki // Weighted fields first
+			}
+			return wi < wj
+		})
+	}
+	return fields, nil
+}
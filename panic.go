@@ -0,0 +1,19 @@
+package piml
+
+import "fmt"
+
+// panicError returns the error reported in place of the panic r, which
+// is a bug, in PIML or in a method it called, such as an UnmarshalText,
+// rather than a reason to crash the program reading a file.
+func panicError(r interface{}) error {
+	return fmt.Errorf("%w: %v", ErrInternal, r)
+}
+
+// catchPanic turns a panic of the function deferring it into an error
+// wrapping ErrInternal, stored in *err. The public API defers it, so it
+// returns errors, not panics, whatever its input and types.
+func catchPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = panicError(r)
+	}
+}
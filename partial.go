@@ -0,0 +1,67 @@
+package piml
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SetPartial makes the decoder carry on past values it can't decode,
+// such as a number that doesn't fit its field, leaving them as they
+// are, so tooling can show what is readable in a damaged file. Decode
+// then returns the errors of all such values, each behind the path of
+// its value, joined with errors.Join, and what it could decode is in
+// the value it was given, on a best effort basis. Syntax errors still end the decoding, as what follows
+// them can't be trusted. DecodedPaths lists the values that were read.
+func (d *Decoder) SetPartial(on bool) {
+	d.partial = on
+}
+
+// DecodedPaths returns the paths of the values the last Decode read
+// without error, in the order of the document, as in db.port or
+// hosts[1]. A key is listed once everything beneath it was read, after
+// the keys inside it. Indexes count the items as written, failed ones
+// included. It is only recorded with SetPartial.
+func (d *Decoder) DecodedPaths() []string {
+	return d.decoded
+}
+
+// UnmarshalPartial is like Unmarshal with SetPartial: it decodes what it
+// can of data into v, and returns the paths it decoded along with the
+// errors of the values it couldn't.
+func UnmarshalPartial(data []byte, v interface{}) ([]string, error) {
+	d := NewDecoder(data)
+	d.SetPartial(true)
+	err := d.Decode(v)
+	return d.DecodedPaths(), err
+}
+
+// pushPath records that the value of key is being decoded, in place of
+// any value after the first depth steps of the path.
+func (d *Decoder) pushPath(depth int, key string) {
+	d.path = append(d.path[:depth], pathElem{key: key})
+}
+
+// pushPathIndex records that the array item at index is being decoded,
+// in place of any value after the first depth steps of the path.
+func (d *Decoder) pushPathIndex(depth, index int) {
+	d.path = append(d.path[:depth], pathElem{index: index})
+}
+
+// decodedPath records that the value at the current path was read.
+func (d *Decoder) decodedPath() {
+	d.decoded = append(d.decoded, formatPath(d.path))
+}
+
+// skipError reports whether decoding can carry on past err, a value's
+// error, in which case it is kept for Decode to return, with the path
+// of the value.
+func (d *Decoder) skipError(err error) bool {
+	if !d.partial || errors.Is(err, ErrSyntax) {
+		return false
+	}
+	if len(d.path) > 0 {
+		err = fmt.Errorf("piml: %s: %w", formatPath(d.path), err)
+	}
+	d.partialErrs = append(d.partialErrs, err)
+	return true
+}
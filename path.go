@@ -0,0 +1,71 @@
+package piml
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A MarshalerError is returned by the encoder when a value nested
+// inside the one being encoded can't be written. Path locates it from
+// the root, as in plugins[3].handler, so the error points at the field
+// to fix rather than just at its type.
+type MarshalerError struct {
+	Path string // Keys and array indexes leading to the value
+	Err  error  // What went wrong
+}
+
+func (e *MarshalerError) Error() string {
+	return "piml: cannot marshal " + e.Path + ": " + strings.TrimPrefix(e.Err.Error(), "piml: ")
+}
+
+func (e *MarshalerError) Unwrap() error {
+	return e.Err
+}
+
+// pathElem is a step of the path to the value being encoded or decoded:
+// a key, or an array index if key is empty.
+type pathElem struct {
+	key   string
+	index int
+}
+
+// pushKey records that the value of key is being encoded. The steps of
+// a value that fails are left in place, for pathError.
+func (e *Encoder) pushKey(key string) {
+	e.path = append(e.path, pathElem{key: key})
+}
+
+// pushIndex records that the array item at index is being encoded.
+func (e *Encoder) pushIndex(index int) {
+	e.path = append(e.path, pathElem{index: index})
+}
+
+// pop ends the last step of the path.
+func (e *Encoder) pop() {
+	e.path = e.path[:len(e.path)-1]
+}
+
+// pathError wraps err, returned while encoding, in a MarshalerError
+// with the path to the value that failed, if it is not the root.
+func (e *Encoder) pathError(err error) error {
+	if err == nil || len(e.path) == 0 {
+		return err
+	}
+	return &MarshalerError{Path: formatPath(e.path), Err: err}
+}
+
+// formatPath returns the text of a path, as in plugins[3].handler.
+func formatPath(path []pathElem) string {
+	var b strings.Builder
+	for i, p := range path {
+		switch {
+		case p.key == "":
+			b.WriteString("[" + strconv.Itoa(p.index) + "]")
+		case i > 0:
+			b.WriteString("." + p.key)
+		default:
+			b.WriteString(p.key)
+		}
+	}
+	return b.String()
+}
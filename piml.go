@@ -10,6 +10,13 @@ var (
 	ErrSyntax           = errors.New("piml: syntax error")
 	ErrInvalidUnmarshal = errors.New("piml: Unmarshal(nil) or Unmarshal(non-pointer)")
 	ErrUnsupportedType  = errors.New("piml: unsupported type for marshalling")
+	ErrInvalidKey       = errors.New("piml: invalid key")
+	ErrAmbiguousField   = errors.New("piml: ambiguous field")
+	ErrChecksum         = errors.New("piml: checksum mismatch")
+	ErrSignature        = errors.New("piml: invalid signature")
+	ErrDanglingKey      = errors.New("piml: key without a value")
+	ErrInternal         = errors.New("piml: internal error")
+	ErrNoBackup         = errors.New("piml: no backup")
 )
 
 //const SimpleTimeFormat = "2006-01-02 15:04:05"
@@ -20,6 +27,10 @@ var (
 //
 // Unmarshal uses reflection to map PIML keys to struct fields,
 // using the `piml:"..."` struct tag.
+//
+// Keys absent from the document leave the fields of v as they are, so
+// Unmarshal into a populated struct overlays the document on it. See
+// Decoder.SetKeepOnNil.
 func Unmarshal(data []byte, v interface{}) error {
 	d := NewDecoder(data)
 	return d.Decode(v)
@@ -31,7 +42,7 @@ func Unmarshal(data []byte, v interface{}) error {
 // using the `piml:"..."` struct tag.
 //
 // Per the PIML spec we defined:
-// - Go nil pointers will be marshalled to `nil`.
+// - Go nil pointers, at any depth, will be marshalled to `nil`.
 // - Go empty slices (`[]string{}`) will be marshalled to `nil`.
 // - Go empty maps (`map[string]int{}`) will be marshalled to `nil`.
 func Marshal(v interface{}) ([]byte, error) {
@@ -44,3 +55,25 @@ func Marshal(v interface{}) ([]byte, error) {
 	}
 	return b.Bytes(), nil
 }
+
+// RedactionMask is the text MarshalRedacted writes in place of secrets.
+const RedactionMask = "****"
+
+// MarshalRedacted is like Marshal, but replaces the value of every field
+// tagged `piml:",secret"` with RedactionMask:
+//
+//	type DBConfig struct {
+//		User     string `piml:"user"`
+//		Password string `piml:"password,secret"`
+//	}
+//
+// Use an Encoder with Redact to choose a different mask.
+func MarshalRedacted(v interface{}) ([]byte, error) {
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.Redact(RedactionMask)
+	if err := e.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -1,14 +1,31 @@
 package piml
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"text/template"
 	"time"
+	"unsafe"
 )
 
 // --- Simple Roundtrip ---
@@ -703,6 +720,62 @@ func TestMultilineWithError(t *testing.T) {
 	}
 }
 
+func TestMultilineListItems(t *testing.T) {
+	type Config struct {
+		Lines []string      `piml:"lines"`
+		Any   []interface{} `piml:"any"`
+	}
+	input := Config{
+		Lines: []string{"one\ntwo", "three", "four\n  indented"},
+		Any:   []interface{}{"a\nb", "c"},
+	}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := "(lines)\n  >\n    one\n    two\n  > three\n  >\n    four\n      indented\n(any)\n  >\n    a\n    b\n  > c\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+	var output Config
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\nOutput:\n%#v", input, output)
+	}
+
+	// Nodes read and write them the same way.
+	n, err := ParseNode(data)
+	if err != nil {
+		t.Fatalf("ParseNode() error = %v", err)
+	}
+	if out, err := Marshal(n); err != nil || string(out) != want {
+		t.Fatalf("Marshal() of the node = %q, %v", out, err)
+	}
+
+	// Items may start with blank lines.
+	input = Config{Lines: []string{"\nx", "\n\ny\n", "z"}}
+	data, err = Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	output = Config{}
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v in:\n%s", err, data)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\nOutput:\n%#v", input, output)
+	}
+	// Spaces at the start of lines that are otherwise blank are lost,
+	// as at the ends of values, but the item still reads as one string.
+	data, _ = Marshal([]string{" \n x", "y"})
+	var items []string
+	if err := Unmarshal(data, &items); err != nil || len(items) != 2 || items[1] != "y" {
+		t.Fatalf("Unmarshal() = %q, %v from:\n%s", items, err, data)
+	}
+}
+
 func TestComments(t *testing.T) {
 	type Config struct {
 		SomeKey     string `piml:"some key"`
@@ -759,3 +832,6330 @@ func TestEscapedHashRoundtrip(t *testing.T) {
 		t.Fatalf("Roundtrip failed for escaped hash:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
 	}
 }
+
+// --- Front Matter ---
+
+func TestSplitFrontMatter(t *testing.T) {
+	type Page struct {
+		Title string   `piml:"title"`
+		Tags  []string `piml:"tags"`
+	}
+
+	t.Run("With front matter", func(t *testing.T) {
+		data := []byte(`---
+(title) Hello World
+(tags)
+  > go
+  > piml
+---
+# Heading
+
+Body text.
+`)
+		var page Page
+		body, err := SplitFrontMatter(data, &page)
+		if err != nil {
+			t.Fatalf("SplitFrontMatter() error = %v", err)
+		}
+		expected := Page{Title: "Hello World", Tags: []string{"go", "piml"}}
+		if !reflect.DeepEqual(page, expected) {
+			t.Fatalf("Front matter mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, page)
+		}
+		if string(body) != "# Heading\n\nBody text.\n" {
+			t.Fatalf("Body mismatch, got %q", body)
+		}
+	})
+
+	t.Run("Without front matter", func(t *testing.T) {
+		data := []byte("# Just Markdown\n")
+		var page Page
+		body, err := SplitFrontMatter(data, &page)
+		if err != nil {
+			t.Fatalf("SplitFrontMatter() error = %v", err)
+		}
+		if string(body) != string(data) {
+			t.Fatalf("Body mismatch, got %q", body)
+		}
+	})
+
+	t.Run("Unterminated", func(t *testing.T) {
+		data := []byte("---\n(title) Hello\n")
+		var page Page
+		if _, err := SplitFrontMatter(data, &page); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("Expected ErrSyntax, got %v", err)
+		}
+	})
+}
+
+// --- Template Preprocessing ---
+
+func TestTemplatePreprocessing(t *testing.T) {
+	type Config struct {
+		Host     string   `piml:"host"`
+		Port     int      `piml:"port"`
+		Features []string `piml:"features"`
+	}
+
+	t.Run("Render", func(t *testing.T) {
+		pimlData := []byte(`(host) {{ .Host | upper }}
+(port) {{ .Port }}
+(features)
+{{- range .Features }}
+  > {{ . }}
+{{- end }}
+`)
+		data := map[string]interface{}{
+			"Host":     "db.local",
+			"Port":     5432,
+			"Features": []string{"auth", "metrics"},
+		}
+		d := NewDecoder(pimlData)
+		d.UseTemplate(data, template.FuncMap{"upper": strings.ToUpper})
+
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Config{Host: "DB.LOCAL", Port: 5432, Features: []string{"auth", "metrics"}}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Error line numbers", func(t *testing.T) {
+		pimlData := []byte(`(host) localhost
+{{- if .Extra }}
+(features)
+{{- range .Extra }}
+  > {{ . }}
+{{- end }}
+{{- end }}
+(port) {{ .Port }}
+`)
+		data := map[string]interface{}{
+			"Extra": []string{"a", "b", "c"},
+			"Port":  "not-a-number",
+		}
+		d := NewDecoder(pimlData)
+		d.UseTemplate(data, nil)
+
+		var output Config
+		err := d.Decode(&output)
+		if err == nil {
+			t.Fatal("Expected error, got nil")
+		}
+		if !strings.Contains(err.Error(), "line 8:") {
+			t.Fatalf("Expected error on template line 8, got %v", err)
+		}
+	})
+}
+
+// --- Secret Redaction ---
+
+type SecretConfig struct {
+	User     string  `piml:"user"`
+	Password string  `piml:"password,secret"`
+	Token    *string `piml:"token,secret"`
+}
+
+func TestMarshalRedacted(t *testing.T) {
+	input := SecretConfig{User: "admin", Password: "hunter2"}
+
+	data, err := MarshalRedacted(input)
+	if err != nil {
+		t.Fatalf("MarshalRedacted() error = %v", err)
+	}
+	expectedPIML := `(user) admin
+(password) ****
+(token) nil
+`
+	if string(data) != expectedPIML {
+		t.Fatalf("MarshalRedacted() output mismatch:\nExpected:\n%s\nGot:\n%s", expectedPIML, string(data))
+	}
+
+	// A custom mask through the Encoder.
+	var b strings.Builder
+	e := NewEncoder(&b)
+	e.Redact("[hidden]")
+	if err := e.Encode(input); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !strings.Contains(b.String(), "(password) [hidden]") {
+		t.Fatalf("Expected custom mask, got:\n%s", b.String())
+	}
+
+	// Plain Marshal keeps the secret, and the tag options don't affect decoding.
+	data, err = Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var output SecretConfig
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- External Resolvers ---
+
+func TestResolvers(t *testing.T) {
+	type Config struct {
+		User     string   `piml:"user"`
+		Password string   `piml:"password"`
+		Token    string   `piml:"token"`
+		Hosts    []string `piml:"hosts"`
+	}
+
+	t.Setenv("PIML_TEST_PASSWORD", "hunter2")
+	t.Setenv("PIML_TEST_HOST", "db.internal")
+	tokenFile := t.TempDir() + "/token"
+	if err := os.WriteFile(tokenFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	pimlData := []byte(`
+(user) admin
+(password) env://PIML_TEST_PASSWORD
+(token) file://` + tokenFile + `
+(hosts)
+  > env://PIML_TEST_HOST
+  > https://example.com
+`)
+
+	t.Run("Resolved", func(t *testing.T) {
+		d := NewDecoder(pimlData)
+		d.RegisterResolver("env", EnvResolver{})
+		d.RegisterResolver("file", FileResolver{})
+
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Config{
+			User:     "admin",
+			Password: "hunter2",
+			Token:    "s3cr3t",
+			Hosts:    []string{"db.internal", "https://example.com"},
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Not registered", func(t *testing.T) {
+		var output Config
+		if err := Unmarshal(pimlData, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Password != "env://PIML_TEST_PASSWORD" {
+			t.Fatalf("Expected the raw reference, got %q", output.Password)
+		}
+	})
+
+	t.Run("Resolver error", func(t *testing.T) {
+		d := NewDecoder([]byte(`(password) env://PIML_TEST_UNSET`))
+		d.RegisterResolver("env", EnvResolver{})
+		var output Config
+		err := d.Decode(&output)
+		if err == nil || !strings.Contains(err.Error(), "PIML_TEST_UNSET is not set") {
+			t.Fatalf("Expected unset variable error, got %v", err)
+		}
+	})
+}
+
+// --- Profiles ---
+
+func TestProfiles(t *testing.T) {
+	type Config struct {
+		Name     string            `piml:"name"`
+		Database *DBConfig         `piml:"database"`
+		Features []string          `piml:"features"`
+		Labels   map[string]string `piml:"labels"`
+	}
+
+	pimlData := []byte(`
+(name) app
+(profile:production)
+  (database)
+    (host) db.prod.internal
+  (features)
+    > metrics
+  (labels)
+    (env) prod
+(database)
+  (host) localhost
+  (port) 5432
+(profile:staging)
+  (name) app-staging
+(features)
+  > debug
+  > metrics
+(labels)
+  (team) core
+  (env) dev
+`)
+
+	t.Run("No profile", func(t *testing.T) {
+		var output Config
+		if err := Unmarshal(pimlData, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		expected := Config{
+			Name:     "app",
+			Database: &DBConfig{Host: "localhost", Port: 5432},
+			Features: []string{"debug", "metrics"},
+			Labels:   map[string]string{"team": "core", "env": "dev"},
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Unmarshal() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Production", func(t *testing.T) {
+		d := NewDecoder(pimlData)
+		d.UseProfile("production")
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Config{
+			Name:     "app",
+			Database: &DBConfig{Host: "db.prod.internal", Port: 5432},
+			Features: []string{"metrics"},
+			Labels:   map[string]string{"team": "core", "env": "prod"},
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Nested profile", func(t *testing.T) {
+		nested := []byte(`
+(database)
+  (host) localhost
+  (profile:staging)
+    (host) db.staging.internal
+  (port) 5432
+(name) app
+`)
+		d := NewDecoder(nested)
+		d.UseProfile("staging")
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if output.Database.Host != "db.staging.internal" || output.Database.Port != 5432 || output.Name != "app" {
+			t.Fatalf("Unexpected result: %+v %+v", output, output.Database)
+		}
+	})
+}
+
+// --- Cross-File References ---
+
+func TestRefs(t *testing.T) {
+	type Config struct {
+		Host  string   `piml:"host"`
+		Port  int      `piml:"port"`
+		Notes string   `piml:"notes"`
+		Peers []string `piml:"peers"`
+	}
+
+	fsys := fstest.MapFS{
+		"shared.piml": {Data: []byte(`
+(database)
+  (engine) postgres
+  (host) db.internal
+  (port) 5432
+(banner)
+  Line one
+  Line two
+(peer) $ref: nested/peers.piml#primary
+`)},
+		"nested/peers.piml":       {Data: []byte("(primary) peer-1.internal\n(backup) $ref: more/backup.piml#host\n(port) $ref: ../shared.piml#database.port\n")},
+		"nested/more/backup.piml": {Data: []byte(`(host) peer-2.internal`)},
+		"a.piml":                  {Data: []byte(`(value) $ref: b.piml#value`)},
+		"b.piml":                  {Data: []byte(`(value) $ref: a.piml#value`)},
+	}
+
+	t.Run("Resolved", func(t *testing.T) {
+		d := NewDecoder([]byte(`
+(host) $ref: shared.piml#database.host
+(port) $ref: shared.piml#database.port
+(notes) $ref: shared.piml#banner
+(peers)
+  > $ref: shared.piml#peer
+`))
+		d.UseRefFS(fsys)
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Config{
+			Host:  "db.internal",
+			Port:  5432,
+			Notes: "Line one\nLine two",
+			Peers: []string{"peer-1.internal"},
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Missing key", func(t *testing.T) {
+		d := NewDecoder([]byte(`(host) $ref: shared.piml#database.user`))
+		d.UseRefFS(fsys)
+		var output Config
+		err := d.Decode(&output)
+		if err == nil || !strings.Contains(err.Error(), `key "database.user" not found`) {
+			t.Fatalf("Expected missing key error, got %v", err)
+		}
+	})
+
+	t.Run("Cycle", func(t *testing.T) {
+		d := NewDecoder([]byte(`(host) $ref: a.piml#value`))
+		d.UseRefFS(fsys)
+		var output Config
+		err := d.Decode(&output)
+		if err == nil || !strings.Contains(err.Error(), "reference cycle: a.piml#value -> b.piml#value -> a.piml#value") {
+			t.Fatalf("Expected cycle error, got %v", err)
+		}
+	})
+
+	t.Run("Relative to the file", func(t *testing.T) {
+		// References in referenced files start from their directory.
+		d := NewDecoder([]byte("(host) $ref: nested/peers.piml#backup\n(port) $ref: nested/peers.piml#port\n"))
+		d.UseRefFS(fsys)
+		var output Config
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if output.Host != "peer-2.internal" || output.Port != 5432 {
+			t.Fatalf("Unexpected output %+v", output)
+		}
+	})
+}
+
+// --- Generic Decoding ---
+
+func TestUnmarshalInterface(t *testing.T) {
+	pimlData := []byte(`
+(name) app
+(database)
+  (host) localhost
+  (port) 5432
+(features)
+  > auth
+  > metrics
+(roles)
+  >| admin
+  >| dev
+(notes)
+  Line one
+  Line two
+(extra) nil
+`)
+	var output interface{}
+	if err := Unmarshal(pimlData, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	expected := map[string]interface{}{
+		"name":     "app",
+		"database": map[string]interface{}{"host": "localhost", "port": "5432"},
+		"features": []interface{}{"auth", "metrics"},
+		"roles":    []interface{}{"admin", "dev"},
+		"notes":    "Line one\nLine two",
+		"extra":    nil,
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Fatalf("Unmarshal() mismatch:\nExpected:\n%#v\nGot:\n%#v", expected, output)
+	}
+
+	// The generic form marshals back to an equivalent document.
+	data, err := Marshal(output)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var again interface{}
+	if err := Unmarshal(data, &again); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(again, expected) {
+		t.Fatalf("Roundtrip failed:\nExpected:\n%#v\nGot:\n%#v", expected, again)
+	}
+}
+
+// --- Migrations ---
+
+func TestLoadWithMigrations(t *testing.T) {
+	type Config struct {
+		Version int       `piml:"version"`
+		Name    string    `piml:"name"`
+		DB      *DBConfig `piml:"db"`
+	}
+
+	m := NewMigrations("version", 2)
+	// v0 -> v1: "title" was renamed to "name".
+	m.Register(0, func(doc map[string]interface{}) error {
+		doc["name"] = doc["title"]
+		delete(doc, "title")
+		return nil
+	})
+	// v1 -> v2: flat db_host/db_port moved under (db).
+	m.Register(1, func(doc map[string]interface{}) error {
+		doc["db"] = map[string]interface{}{"host": doc["db_host"], "port": doc["db_port"]}
+		delete(doc, "db_host")
+		delete(doc, "db_port")
+		return nil
+	})
+
+	expected := Config{Version: 2, Name: "app", DB: &DBConfig{Host: "localhost", Port: 5432}}
+	docs := map[string]string{
+		"v0": "(title) app\n(db_host) localhost\n(db_port) 5432\n",
+		"v1": "(version) 1\n(name) app\n(db_host) localhost\n(db_port) 5432\n",
+		"v2": "(version) 2\n(name) app\n(db)\n  (host) localhost\n  (port) 5432\n",
+	}
+	for name, doc := range docs {
+		t.Run(name, func(t *testing.T) {
+			var output Config
+			if err := LoadWithMigrations([]byte(doc), &output, m); err != nil {
+				t.Fatalf("LoadWithMigrations() error = %v", err)
+			}
+			if !reflect.DeepEqual(output, expected) {
+				t.Fatalf("Mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+			}
+		})
+	}
+
+	t.Run("Options", func(t *testing.T) {
+		// Profile blocks survive the steps, and apply when decoding.
+		doc := "(version) 1\n(name) app\n(db_host) localhost\n(db_port) 5432\n(profile:prod)\n  (name) prod-app\n"
+		if err := LoadWithMigrations([]byte(doc), new(Config), m); err != nil {
+			t.Fatalf("LoadWithMigrations() error = %v", err)
+		}
+		sum := sha256.Sum256([]byte(doc))
+		signed := doc + "(checksum) sha256:" + hex.EncodeToString(sum[:]) + "\n"
+		var output Config
+		if err := LoadWithMigrationsOptions([]byte(signed), &output, m, DecoderOptions{Profile: "prod", Checksum: true}); err != nil {
+			t.Fatalf("LoadWithMigrationsOptions() error = %v", err)
+		}
+		want := Config{Version: 2, Name: "prod-app", DB: &DBConfig{Host: "localhost", Port: 5432}}
+		if !reflect.DeepEqual(output, want) {
+			t.Fatalf("Mismatch:\nExpected:\n%+v\nGot:\n%+v", want, output)
+		}
+		err := LoadWithMigrationsOptions([]byte(doc), &output, m, DecoderOptions{Checksum: true})
+		if !errors.Is(err, ErrChecksum) {
+			t.Fatalf("Expected ErrChecksum, got %v", err)
+		}
+	})
+
+	t.Run("Too new", func(t *testing.T) {
+		var output Config
+		err := LoadWithMigrations([]byte("(version) 3\n"), &output, m)
+		if err == nil || !strings.Contains(err.Error(), "newer than supported") {
+			t.Fatalf("Expected version error, got %v", err)
+		}
+	})
+
+	t.Run("Lists", func(t *testing.T) {
+		type Server struct {
+			Host  string `piml:"host"`
+			Notes string `piml:"notes"`
+		}
+		type Doc struct {
+			Version int      `piml:"version"`
+			Servers []Server `piml:"servers"`
+			Motd    []string `piml:"motd"`
+		}
+
+		lm := NewMigrations("version", 2)
+		// v0 -> v1: a message of the day was added.
+		lm.Register(0, func(doc map[string]interface{}) error {
+			doc["motd"] = []interface{}{"welcome\nto the server", "bye"}
+			return nil
+		})
+		// v1 -> v2: hosts got a port.
+		lm.RegisterNode(1, func(doc *Node) error {
+			for _, s := range doc.Lookup("servers").Children {
+				s.Lookup("host").Value += ":80"
+			}
+			return nil
+		})
+
+		input := "(servers)\n  > (item)\n      (host) a\n      (notes)\n        line 1\n        line 2\n  > (item)\n      (host) b\n"
+		expected := Doc{
+			Version: 2,
+			Servers: []Server{{Host: "a:80", Notes: "line 1\nline 2"}, {Host: "b:80"}},
+			Motd:    []string{"welcome\nto the server", "bye"},
+		}
+		var output Doc
+		if err := LoadWithMigrations([]byte(input), &output, lm); err != nil {
+			t.Fatalf("LoadWithMigrations() error = %v", err)
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+
+		// The keys keep their order, with new ones after them.
+		var n Node
+		if err := LoadWithMigrations([]byte(input), &n, lm); err != nil {
+			t.Fatalf("LoadWithMigrations() error = %v", err)
+		}
+		data, err := Marshal(&n)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		want := "(version) 2\n(servers)\n  > (item)\n      (host) a:80\n      (notes)\n        line 1\n        line 2\n  > (item)\n      (host) b:80\n(motd)\n  >\n    welcome\n    to the server\n  > bye\n"
+		if string(data) != want {
+			t.Fatalf("Mismatch:\nExpected:\n%s\nGot:\n%s", want, data)
+		}
+	})
+}
+
+// --- math/big Types ---
+
+func TestBigNumbers(t *testing.T) {
+	type Ledger struct {
+		Supply   *big.Int   `piml:"supply"`
+		Balance  big.Int    `piml:"balance"`
+		Rate     *big.Float `piml:"rate"`
+		Ratio    *big.Rat   `piml:"ratio"`
+		Holdings []*big.Int `piml:"holdings"`
+		Missing  *big.Int   `piml:"missing"`
+	}
+
+	supply, _ := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	rate, _ := new(big.Float).SetPrec(200).SetString("3.14159265358979323846264338327950288")
+	input := Ledger{
+		Supply:   supply,
+		Balance:  *big.NewInt(-42),
+		Rate:     rate,
+		Ratio:    big.NewRat(1, 3),
+		Holdings: []*big.Int{big.NewInt(1), big.NewInt(2)},
+	}
+
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !strings.Contains(string(data), "(supply) "+supply.String()) ||
+		!strings.Contains(string(data), "(ratio) 1/3") {
+		t.Fatalf("Unexpected Marshal() output:\n%s", data)
+	}
+
+	var output Ledger
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Supply.Cmp(input.Supply) != 0 ||
+		output.Balance.Cmp(&input.Balance) != 0 ||
+		output.Rate.Text('g', -1) != input.Rate.Text('g', -1) ||
+		output.Ratio.Cmp(input.Ratio) != 0 ||
+		len(output.Holdings) != 2 || output.Holdings[1].Int64() != 2 ||
+		output.Missing != nil {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		var output Ledger
+		err := Unmarshal([]byte(`(supply) 12abc`), &output)
+		if err == nil || !strings.Contains(err.Error(), "invalid big.Int value") {
+			t.Fatalf("Expected big.Int error, got %v", err)
+		}
+	})
+}
+
+// --- Nullable Types ---
+
+func TestNullableTypes(t *testing.T) {
+	type Record struct {
+		Name     sql.NullString    `piml:"name"`
+		Age      sql.NullInt64     `piml:"age"`
+		Active   sql.NullBool      `piml:"active"`
+		Seen     sql.NullTime      `piml:"seen"`
+		Score    sql.Null[float64] `piml:"score"`
+		Nickname Nullable[string]  `piml:"nickname"`
+		Bio      Nullable[string]  `piml:"bio"`
+	}
+
+	input := Record{
+		Name:     sql.NullString{String: "Alice", Valid: true},
+		Age:      sql.NullInt64{},
+		Active:   sql.NullBool{Bool: true, Valid: true},
+		Seen:     sql.NullTime{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Valid: true},
+		Score:    sql.Null[float64]{},
+		Nickname: Nullable[string]{},
+		Bio:      NullableOf("Line one\nLine two"),
+	}
+
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	expectedPIML := `(name) Alice
+(age) nil
+(active) true
+(seen) 2024-01-02T03:04:05Z
+(score) nil
+(nickname) nil
+(bio)
+  Line one
+  Line two
+`
+	if string(data) != expectedPIML {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expectedPIML, string(data))
+	}
+
+	// Start from valid values to check that nil clears them.
+	output := Record{Age: sql.NullInt64{Int64: 7, Valid: true}, Nickname: NullableOf("x")}
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- Network Types ---
+
+func TestNetworkTypes(t *testing.T) {
+	type NetConfig struct {
+		Listen  netip.Addr      `piml:"listen"`
+		Allowed netip.Prefix    `piml:"allowed"`
+		Gateway net.IP          `piml:"gateway"`
+		Subnet  *net.IPNet      `piml:"subnet"`
+		Peers   []netip.Addr    `piml:"peers"`
+		Backup  *netip.AddrPort `piml:"backup"`
+	}
+
+	pimlData := []byte(`
+(listen) 127.0.0.1
+(allowed) 10.0.0.0/8
+(gateway) 192.168.1.1
+(subnet) 172.16.0.0/12
+(peers)
+  > ::1
+  > 10.0.0.2
+(backup) nil
+`)
+	var output NetConfig
+	if err := Unmarshal(pimlData, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	_, subnet, _ := net.ParseCIDR("172.16.0.0/12")
+	expected := NetConfig{
+		Listen:  netip.MustParseAddr("127.0.0.1"),
+		Allowed: netip.MustParsePrefix("10.0.0.0/8"),
+		Gateway: net.ParseIP("192.168.1.1"),
+		Subnet:  subnet,
+		Peers:   []netip.Addr{netip.MustParseAddr("::1"), netip.MustParseAddr("10.0.0.2")},
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Fatalf("Unmarshal() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+	}
+
+	data, err := Marshal(expected)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if strings.TrimSpace(string(data)) != strings.TrimSpace(string(pimlData)) {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", pimlData, data)
+	}
+
+	t.Run("ZeroIPNet", func(t *testing.T) {
+		type Route struct {
+			Net net.IPNet `piml:"net"`
+		}
+		data, err := Marshal(Route{})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != "(net) nil\n" {
+			t.Fatalf("Expected the zero IPNet as nil, got:\n%s", data)
+		}
+		output := Route{Net: *subnet}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(output, Route{}) {
+			t.Fatalf("Expected the zero IPNet, got %+v", output)
+		}
+	})
+
+	t.Run("ZeroNetip", func(t *testing.T) {
+		type Peer struct {
+			Addr   netip.Addr     `piml:"addr"`
+			Prefix netip.Prefix   `piml:"prefix"`
+			Remote netip.AddrPort `piml:"remote"`
+			Addrs  []netip.Addr   `piml:"addrs"`
+		}
+		input := Peer{Addrs: []netip.Addr{{}, netip.MustParseAddr("::1")}}
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		want := "(addr) nil\n(prefix) nil\n(remote) nil\n(addrs)\n  > nil\n  > ::1\n"
+		if string(data) != want {
+			t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+		}
+		output := Peer{
+			Addr:   netip.MustParseAddr("10.0.0.1"),
+			Prefix: netip.MustParsePrefix("10.0.0.0/8"),
+			Remote: netip.MustParseAddrPort("10.0.0.1:53"),
+		}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(output, input) {
+			t.Fatalf("Expected %+v, got %+v", input, output)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		var output NetConfig
+		err := Unmarshal([]byte(`(allowed) 10.0.0.0/99`), &output)
+		if err == nil || !strings.Contains(err.Error(), "invalid netip.Prefix value") {
+			t.Fatalf("Expected prefix error, got %v", err)
+		}
+	})
+}
+
+// --- Civil Date and Time ---
+
+func TestCivilTypes(t *testing.T) {
+	type Schedule struct {
+		Start    Date       `piml:"start"`
+		Holidays []Date     `piml:"holidays"`
+		Opens    TimeOfDay  `piml:"opens"`
+		Closes   TimeOfDay  `piml:"closes"`
+		Backup   *TimeOfDay `piml:"backup"`
+	}
+
+	pimlData := []byte(`
+(start) 2024-03-01
+(holidays)
+  > 2024-12-25
+  > 2025-01-01
+(opens) 09:00
+(closes) 17:30:15
+(backup) nil
+`)
+	var output Schedule
+	if err := Unmarshal(pimlData, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	expected := Schedule{
+		Start:    Date{Year: 2024, Month: time.March, Day: 1},
+		Holidays: []Date{{2024, time.December, 25}, {2025, time.January, 1}},
+		Opens:    TimeOfDay{Hour: 9},
+		Closes:   TimeOfDay{Hour: 17, Minute: 30, Second: 15},
+	}
+	if !reflect.DeepEqual(output, expected) {
+		t.Fatalf("Unmarshal() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+	}
+
+	data, err := Marshal(expected)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if strings.TrimSpace(string(data)) != strings.TrimSpace(string(pimlData)) {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", pimlData, data)
+	}
+
+	if got := expected.Opens.On(expected.Start, time.UTC); !got.Equal(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Fatalf("On() = %v", got)
+	}
+
+	t.Run("Zero", func(t *testing.T) {
+		data, err := Marshal(Schedule{})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if !strings.HasPrefix(string(data), "(start) 0000-00-00\n") {
+			t.Fatalf("Expected the zero date as 0000-00-00, got:\n%s", data)
+		}
+		var output Schedule
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !output.Start.IsZero() {
+			t.Fatalf("Expected the zero date, got %v", output.Start)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		var output Schedule
+		if err := Unmarshal([]byte(`(start) 2024-02-30`), &output); err == nil {
+			t.Fatal("Expected error for an invalid date, got nil")
+		}
+		if err := Unmarshal([]byte(`(opens) 25:00`), &output); err == nil {
+			t.Fatal("Expected error for an invalid time, got nil")
+		}
+	})
+}
+
+// --- Time Layouts ---
+
+func TestTimeLayouts(t *testing.T) {
+	type Events struct {
+		Created time.Time   `piml:"created"`
+		Updated time.Time   `piml:"updated"`
+		Seen    []time.Time `piml:"seen"`
+	}
+
+	pimlData := []byte(`
+(created) 2023-11-10T15:30:00.123Z
+(updated) 2023-11-11 08:00:00
+(seen)
+  > 1700000000
+  > 2023-11-12T00:00:00Z
+`)
+
+	t.Run("Fallback layouts", func(t *testing.T) {
+		d := NewDecoder(pimlData)
+		d.SetTimeLayouts(time.RFC3339Nano, time.DateTime, TimeLayoutUnix)
+		var output Events
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Events{
+			Created: time.Date(2023, 11, 10, 15, 30, 0, 123000000, time.UTC),
+			Updated: time.Date(2023, 11, 11, 8, 0, 0, 0, time.UTC),
+			Seen: []time.Time{
+				time.Unix(1700000000, 0).UTC(),
+				time.Date(2023, 11, 12, 0, 0, 0, 0, time.UTC),
+			},
+		}
+		if !reflect.DeepEqual(output, expected) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%+v\nGot:\n%+v", expected, output)
+		}
+	})
+
+	t.Run("Default layout", func(t *testing.T) {
+		var output Events
+		err := Unmarshal(pimlData, &output)
+		if err == nil || !strings.Contains(err.Error(), "invalid time format") {
+			t.Fatalf("Expected time format error, got %v", err)
+		}
+	})
+
+	t.Run("No match", func(t *testing.T) {
+		d := NewDecoder([]byte(`(created) yesterday`))
+		d.SetTimeLayouts(time.RFC3339, time.DateOnly)
+		var output Events
+		err := d.Decode(&output)
+		if err == nil || !strings.Contains(err.Error(), `"yesterday" matches none of the layouts`) {
+			t.Fatalf("Expected layouts error, got %v", err)
+		}
+	})
+}
+
+// --- Byte Sizes ---
+
+func TestByteSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ByteSize
+		out  string
+	}{
+		{"512MiB", 512 * sizeMiB, "512MiB"},
+		{"10GB", 10 * sizeGB, "10GB"},
+		{"4k", 4 * sizeKiB, "4KiB"},
+		{"1.5GiB", 1536 * sizeMiB, "1536MiB"},
+		{"1000", 1000, "1KB"},
+		{"100 b", 100, "100B"},
+		{"0", 0, "0B"},
+	}
+	for _, tt := range tests {
+		got, err := ParseByteSize(tt.in)
+		if err != nil {
+			t.Errorf("ParseByteSize(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+		if got.String() != tt.out {
+			t.Errorf("ByteSize(%d).String() = %q, want %q", got, got.String(), tt.out)
+		}
+	}
+
+	for _, in := range []string{"12XB", "0.3B", "16EiB", "abc"} {
+		if _, err := ParseByteSize(in); err == nil {
+			t.Errorf("ParseByteSize(%q) expected error", in)
+		}
+	}
+
+	type Limits struct {
+		Memory ByteSize `piml:"memory"`
+		Disk   ByteSize `piml:"disk"`
+	}
+	input := Limits{Memory: 512 * sizeMiB, Disk: 20 * sizeGB}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != "(memory) 512MiB\n(disk) 20GB\n" {
+		t.Fatalf("Unexpected Marshal() output:\n%s", data)
+	}
+	var output Limits
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output != input {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- Decimals ---
+
+func TestDecimal(t *testing.T) {
+	type Pricing struct {
+		Price    Decimal   `piml:"price"`
+		Discount Decimal   `piml:"discount,omitzero"`
+		Tiers    []Decimal `piml:"tiers"`
+		Fee      *Decimal  `piml:"fee"`
+	}
+
+	// The text is kept exactly, trailing zeros included.
+	data := []byte("(price) 19.990\n(discount) 0.00\n(tiers)\n  > 0.10\n  > 1e-2\n  > -12345678901234567890.123456789\n(fee) nil\n")
+	var cfg Pricing
+	if err := Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Price != "19.990" || len(cfg.Tiers) != 3 || cfg.Tiers[2] != "-12345678901234567890.123456789" || cfg.Fee != nil {
+		t.Fatalf("Unexpected output: %+v", cfg)
+	}
+	out, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := "(price) 19.990\n(tiers)\n  > 0.10\n  > 1e-2\n  > -12345678901234567890.123456789\n(fee) nil\n"
+	if string(out) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, out)
+	}
+
+	if sum := new(big.Rat).Add(Decimal("0.1").Rat(), Decimal("0.2").Rat()); sum.Cmp(Decimal("0.3").Rat()) != 0 {
+		t.Errorf("Expected 0.1 + 0.2 = 0.3, got %s", sum.FloatString(20))
+	}
+	if Decimal("1.50").Cmp("1.5") != 0 || Decimal("2").Cmp("1e1") != -1 {
+		t.Error("Unexpected Cmp results")
+	}
+	if f, err := Decimal("").Float64(); err != nil || f != 0 {
+		t.Errorf("Expected the zero Decimal to be 0, got %v, %v", f, err)
+	}
+
+	for _, bad := range []string{"", "1.", ".5", "1e", "1,5", "NaN", "0x10", "1.5.5", "--1"} {
+		if _, err := ParseDecimal(bad); err == nil {
+			t.Errorf("Expected ParseDecimal(%q) to fail", bad)
+		}
+	}
+	if err := Unmarshal([]byte("(price) 12 EUR\n"), &cfg); err == nil || !strings.Contains(err.Error(), `invalid decimal "12 EUR"`) {
+		t.Errorf("Expected an invalid decimal error, got %v", err)
+	}
+}
+
+// --- Enums ---
+
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func init() {
+	RegisterEnum(map[string]LogLevel{
+		"debug": LogDebug,
+		"info":  LogInfo,
+		"warn":  LogWarn,
+		"error": LogError,
+	})
+}
+
+func TestEnums(t *testing.T) {
+	type LogConfig struct {
+		Level  LogLevel   `piml:"level"`
+		Levels []LogLevel `piml:"levels"`
+		Format string     `piml:"format,enum=text|json"`
+	}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		input := LogConfig{Level: LogWarn, Levels: []LogLevel{LogDebug, LogError}, Format: "json"}
+		expected := "(level) warn\n(levels)\n  > debug\n  > error\n(format) json\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output LogConfig
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+		}
+	})
+
+	t.Run("UnknownName", func(t *testing.T) {
+		var output LogConfig
+		err := Unmarshal([]byte("(level) verbose"), &output)
+		if err == nil || !strings.Contains(err.Error(), `"verbose", must be one of debug, info, warn, error`) {
+			t.Fatalf("Expected an error listing valid levels, got %v", err)
+		}
+	})
+
+	t.Run("UnknownValue", func(t *testing.T) {
+		_, err := Marshal(LogConfig{Level: 42})
+		if err == nil || !strings.Contains(err.Error(), "must be one of debug, info, warn, error") {
+			t.Fatalf("Expected an error listing valid levels, got %v", err)
+		}
+	})
+
+	t.Run("Aliases", func(t *testing.T) {
+		type Color int
+		RegisterEnum(map[string]Color{"gray": 1, "grey": 1, "silver": 1, "red": 2, "crimson": 2})
+		for i := 0; i < 50; i++ {
+			data, err := Marshal([]Color{1, 2})
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if want := "> gray\n> crimson\n"; string(data) != want {
+				t.Fatalf("Expected %q, got %q", want, data)
+			}
+		}
+		var c Color
+		if err := Unmarshal([]byte("silver"), &c); err != nil || c != 1 {
+			t.Fatalf("Expected 1, got %d, error %v", c, err)
+		}
+	})
+
+	t.Run("TagEnforcement", func(t *testing.T) {
+		var output LogConfig
+		err := Unmarshal([]byte("(format) xml"), &output)
+		if err == nil || !strings.Contains(err.Error(), `line 1: error setting field "format": piml: invalid value "xml", must be one of text, json`) {
+			t.Fatalf("Expected an enum tag error, got %v", err)
+		}
+	})
+}
+
+// --- Flags ---
+
+type Perm uint8
+
+const (
+	PermRead Perm = 1 << iota
+	PermWrite
+	PermExec
+)
+
+func init() {
+	RegisterFlags(map[string]Perm{
+		"read":  PermRead,
+		"write": PermWrite,
+		"exec":  PermExec,
+	})
+}
+
+func TestFlags(t *testing.T) {
+	type ShareConfig struct {
+		Owner Perm `piml:"owner"`
+		Group Perm `piml:"group"`
+		Other Perm `piml:"other"`
+	}
+
+	input := ShareConfig{Owner: PermRead | PermWrite | PermExec, Group: PermRead | PermExec}
+	expected := "(owner) read,write,exec\n(group) read,exec\n(other) 0\n"
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+	var output ShareConfig
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output != input {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+
+	// Order and spacing don't matter when decoding.
+	if err := Unmarshal([]byte("(owner) exec, read"), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Owner != PermRead|PermExec {
+		t.Errorf("Expected read,exec, got %v", output.Owner)
+	}
+
+	err = Unmarshal([]byte("(owner) read,delete"), &output)
+	if err == nil || !strings.Contains(err.Error(), `flag "delete", must be one of read, write, exec`) {
+		t.Errorf("Expected an unknown flag error, got %v", err)
+	}
+
+	if _, err := Marshal(ShareConfig{Owner: 1 << 5}); err == nil {
+		t.Error("Expected an error for unknown flag bits")
+	}
+}
+
+// --- Scalar Arrays ---
+
+func TestScalarArrays(t *testing.T) {
+	type History struct {
+		Deploys  []time.Time     `piml:"deploys"`
+		Checks   []*time.Time    `piml:"checks"`
+		Days     []Date          `piml:"days"`
+		Limits   []ByteSize      `piml:"limits"`
+		Retries  []sql.NullInt64 `piml:"retries"`
+		Balances []*big.Int      `piml:"balances"`
+	}
+
+	t1 := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	t2 := time.Date(2024, 6, 7, 8, 9, 10, 500, time.UTC)
+	input := History{
+		Deploys:  []time.Time{t1, t2},
+		Checks:   []*time.Time{&t1, nil, &t2},
+		Days:     []Date{{2024, time.March, 1}},
+		Limits:   []ByteSize{64 * sizeMiB, 2 * sizeGB},
+		Retries:  []sql.NullInt64{{Int64: 3, Valid: true}, {}},
+		Balances: []*big.Int{big.NewInt(12345678901234)},
+	}
+	expected := `(deploys)
+  > 2024-01-02T03:04:05Z
+  > 2024-06-07T08:09:10.0000005Z
+(checks)
+  > 2024-01-02T03:04:05Z
+  > nil
+  > 2024-06-07T08:09:10.0000005Z
+(days)
+  > 2024-03-01
+(limits)
+  > 64MiB
+  > 2GB
+(retries)
+  > 3
+  > nil
+(balances)
+  > 12345678901234
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output History
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- Pointer Maps ---
+
+type Server struct {
+	Host     string             `piml:"host"`
+	Port     int                `piml:"port"`
+	Replicas map[string]*Server `piml:"replicas"`
+}
+
+func TestPointerMaps(t *testing.T) {
+	type Cluster struct {
+		Servers map[string]*Server `piml:"servers"`
+	}
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		input := Cluster{Servers: map[string]*Server{
+			"primary": {
+				Host: "db1", Port: 5432,
+				Replicas: map[string]*Server{"east": {Host: "db2", Port: 5433}},
+			},
+			"standby": nil,
+		}}
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var output Cluster
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v\n\nPIML:\n%s", input, output, data)
+		}
+		if s, ok := output.Servers["standby"]; !ok || s != nil {
+			t.Errorf("Expected a nil standby entry, got %v (present: %v)", s, ok)
+		}
+	})
+
+	t.Run("MergeExisting", func(t *testing.T) {
+		primary := &Server{Host: "db1", Port: 5432}
+		output := Cluster{Servers: map[string]*Server{"primary": primary}}
+		input := "(servers)\n  (primary)\n    (port) 6432\n  (backup)\n    (host) db3\n"
+		if err := Unmarshal([]byte(input), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Servers["primary"] != primary || primary.Host != "db1" || primary.Port != 6432 {
+			t.Errorf("Expected the existing primary to be updated in place, got %+v", output.Servers["primary"])
+		}
+		if b := output.Servers["backup"]; b == nil || b.Host != "db3" {
+			t.Errorf("Expected a new backup entry, got %+v", b)
+		}
+	})
+}
+
+// --- Nested Maps ---
+
+func TestNestedMaps(t *testing.T) {
+	type Region string
+	type Routing struct {
+		Hosts   map[string]map[string]string             `piml:"hosts"`
+		Weights map[Region]map[string]map[string]int     `piml:"weights"`
+		Empty   map[string]map[string]string             `piml:"empty"`
+		Sparse  map[string]map[string]map[string]*Server `piml:"sparse"`
+	}
+
+	input := Routing{
+		Hosts: map[string]map[string]string{
+			"prod":    {"web": "10.0.0.1", "api": "10.0.0.2"},
+			"staging": nil,
+		},
+		Weights: map[Region]map[string]map[string]int{
+			"eu": {"web": {"blue": 90, "green": 10}},
+		},
+		Sparse: map[string]map[string]map[string]*Server{
+			"a": {"b": {"c": {Host: "deep", Port: 1}}},
+		},
+	}
+	expected := `(hosts)
+  (prod)
+    (api) 10.0.0.2
+    (web) 10.0.0.1
+  (staging) nil
+(weights)
+  (eu)
+    (web)
+      (blue) 90
+      (green) 10
+(empty) nil
+(sparse)
+  (a)
+    (b)
+      (c)
+        (host) deep
+        (port) 1
+        (replicas) nil
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output Routing
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- Slices of Maps ---
+
+func TestSliceOfMaps(t *testing.T) {
+	type Routes struct {
+		Rules   []map[string]string `piml:"rules"`
+		Servers []*Server           `piml:"servers"`
+		Weights []map[string][]int  `piml:"weights"`
+	}
+
+	input := Routes{
+		Rules: []map[string]string{
+			{"path": "/api", "target": "backend"},
+			nil,
+			{"path": "/", "target": "frontend"},
+		},
+		Servers: []*Server{{Host: "a", Port: 1}, nil},
+		Weights: []map[string][]int{{"blue": {1, 2}}},
+	}
+	expected := `(rules)
+  > (item)
+      (path) /api
+      (target) backend
+  > nil
+  > (item)
+      (path) /
+      (target) frontend
+(servers)
+  > (Server)
+      (host) a
+      (port) 1
+      (replicas) nil
+  > nil
+(weights)
+  > (item)
+      (blue)
+        > 1
+        > 2
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output Routes
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+}
+
+// --- Typed Sets ---
+
+func TestTypedSets(t *testing.T) {
+	type Tag string
+	type Firewall struct {
+		Ports  map[int]struct{}        `piml:"ports"`
+		Tags   map[Tag]struct{}        `piml:"tags"`
+		Levels map[LogLevel]struct{}   `piml:"levels"`
+		Hosts  map[netip.Addr]struct{} `piml:"hosts"`
+		Flags  map[string]bool         `piml:"flags"`
+	}
+
+	input := Firewall{
+		Ports:  map[int]struct{}{443: {}, 22: {}, 8080: {}},
+		Tags:   map[Tag]struct{}{"edge": {}, "dmz": {}},
+		Levels: map[LogLevel]struct{}{LogWarn: {}, LogError: {}},
+		Hosts:  map[netip.Addr]struct{}{netip.MustParseAddr("10.0.0.1"): {}},
+	}
+	expected := `(ports)
+  >| 22
+  >| 443
+  >| 8080
+(tags)
+  >| dmz
+  >| edge
+(levels)
+  >| warn
+  >| error
+(hosts)
+  >| 10.0.0.1
+(flags) nil
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output Firewall
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+
+	t.Run("BoolSet", func(t *testing.T) {
+		var output Firewall
+		if err := Unmarshal([]byte("(flags)\n  >| verbose\n  >| dry-run\n"), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(output.Flags, map[string]bool{"verbose": true, "dry-run": true}) {
+			t.Errorf("Unexpected flags: %v", output.Flags)
+		}
+	})
+
+	t.Run("InvalidItem", func(t *testing.T) {
+		var output Firewall
+		err := Unmarshal([]byte("(ports)\n  >| 22\n  >| ssh\n"), &output)
+		if err == nil || !strings.Contains(err.Error(), "line 3") {
+			t.Errorf("Expected an error on line 3, got %v", err)
+		}
+	})
+
+	t.Run("MultiLineItem", func(t *testing.T) {
+		input := Firewall{Tags: map[Tag]struct{}{"a\nb": {}}}
+		if _, err := Marshal(input); err == nil || !strings.Contains(err.Error(), "set items can't span lines") {
+			t.Errorf("Expected an error for a multi-line item, got %v", err)
+		}
+		n := &Node{Kind: ObjectNode, Children: []*Node{{Kind: SetNode, Key: "tags", Children: []*Node{{Kind: ScalarNode, Value: "a\nb"}}}}}
+		if _, err := Marshal(n); err == nil {
+			t.Error("Expected an error for a multi-line node item")
+		}
+	})
+}
+
+// --- Root Arrays and Scalars ---
+
+func TestRootArraysAndScalars(t *testing.T) {
+	roundtrip := func(t *testing.T, input interface{}, expected string, output interface{}) {
+		t.Helper()
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%q\nGot:\n%q", expected, data)
+		}
+		if err := Unmarshal(data, output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if got := reflect.ValueOf(output).Elem().Interface(); !reflect.DeepEqual(input, got) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, got)
+		}
+	}
+
+	t.Run("Primitives", func(t *testing.T) {
+		roundtrip(t, []int{1, 2, 3}, "> 1\n> 2\n> 3\n", new([]int))
+	})
+	t.Run("Objects", func(t *testing.T) {
+		input := []Server{{Host: "a", Port: 1}, {Host: "b", Port: 2}}
+		expected := "> (Server)\n    (host) a\n    (port) 1\n    (replicas) nil\n> (Server)\n    (host) b\n    (port) 2\n    (replicas) nil\n"
+		roundtrip(t, input, expected, new([]Server))
+	})
+	t.Run("Int", func(t *testing.T) {
+		roundtrip(t, 8080, "8080\n", new(int))
+	})
+	t.Run("String", func(t *testing.T) {
+		roundtrip(t, "hello world", "hello world\n", new(string))
+	})
+	t.Run("MultiLineString", func(t *testing.T) {
+		roundtrip(t, "first\nsecond", "first\nsecond\n", new(string))
+	})
+	t.Run("EscapedStrings", func(t *testing.T) {
+		for _, tt := range []struct{ input, expected string }{
+			{"(a) b", "\\(a) b\n"},
+			{">", "\\>\n"},
+			{">| x", "\\>| x\n"},
+			{"nil", "\\nil\n"},
+			{"#x", "\\#x\n"},
+			{"\\(a)", "\\\\(a)\n"},
+			{"\\nil", "\\\\nil\n"},
+			{"\\x", "\\x\n"},
+			{"---", "\\---\n"},
+			{"\\---", "\\\\---\n"},
+			{"(a)\n---", "\\(a)\n\\---\n"},
+		} {
+			roundtrip(t, tt.input, tt.expected, new(string))
+		}
+	})
+	t.Run("Time", func(t *testing.T) {
+		roundtrip(t, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "2024-01-02T03:04:05Z\n", new(time.Time))
+	})
+	t.Run("Nil", func(t *testing.T) {
+		output := new(int)
+		data, err := Marshal((*int)(nil))
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != "nil\n" {
+			t.Fatalf("Expected nil, got %q", data)
+		}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output != nil {
+			t.Fatalf("Expected a nil pointer, got %v", *output)
+		}
+	})
+	t.Run("InvalidScalar", func(t *testing.T) {
+		var n int
+		err := Unmarshal([]byte("# port\neighty\n"), &n)
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Fatalf("Expected an error on line 2, got %v", err)
+		}
+	})
+}
+
+// --- Root Maps ---
+
+func TestRootMaps(t *testing.T) {
+	t.Run("Generic", func(t *testing.T) {
+		input := map[string]interface{}{
+			"name": "api",
+			"database": map[string]interface{}{
+				"host": "localhost",
+				"port": "5432",
+			},
+			"tags": []interface{}{"web", "public"},
+		}
+		expected := "(database)\n  (host) localhost\n  (port) 5432\n(name) api\n(tags)\n  > web\n  > public\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output map[string]interface{}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+
+	t.Run("Typed", func(t *testing.T) {
+		input := map[string]*Server{"web": {Host: "a", Port: 80}}
+		expected := "(web)\n  (host) a\n  (port) 80\n  (replicas) nil\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output map[string]*Server
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+
+	t.Run("Set", func(t *testing.T) {
+		input := map[int]struct{}{3: {}, 1: {}}
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != ">| 1\n>| 3\n" {
+			t.Fatalf("Unexpected Marshal() output:\n%q", data)
+		}
+		var output map[int]struct{}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+}
+
+// --- Embedded Struct Tags ---
+
+func TestEmbeddedStructTags(t *testing.T) {
+	type NestedSettings struct {
+		BaseSettings `piml:"base"`
+		AppName      string `piml:"app_name"`
+	}
+
+	t.Run("Promoted", func(t *testing.T) {
+		input := AppSettings{BaseSettings: BaseSettings{Timeout: 30}, AppName: "My-App"}
+		expected := "(timeout) 30\n(app_name) My-App\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output AppSettings
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output != input {
+			t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+		}
+	})
+
+	t.Run("Nested", func(t *testing.T) {
+		input := NestedSettings{BaseSettings: BaseSettings{Timeout: 30}, AppName: "My-App"}
+		expected := "(base)\n  (timeout) 30\n(app_name) My-App\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output NestedSettings
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output != input {
+			t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+		}
+	})
+
+	t.Run("NestedNotPromoted", func(t *testing.T) {
+		var output NestedSettings
+		if err := Unmarshal([]byte("(timeout) 30\n"), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Timeout != 0 {
+			t.Errorf("Expected a top-level timeout to be ignored, got %d", output.Timeout)
+		}
+	})
+}
+
+// --- Unexported Fields ---
+
+type privateBase struct {
+	Region string `piml:"region"`
+	token  string
+}
+
+type PrivateFields struct {
+	privateBase
+	*BaseSettings
+	Name    string `piml:"name"`
+	created time.Time
+	cache   map[string]*Server
+	peers   []privateBase
+	parent  *PrivateFields
+}
+
+func TestUnexportedFields(t *testing.T) {
+	input := PrivateFields{
+		privateBase:  privateBase{Region: "eu", token: "secret"},
+		BaseSettings: &BaseSettings{Timeout: 5},
+		Name:         "edge",
+		created:      time.Now(),
+		cache:        map[string]*Server{"a": {Host: "a"}},
+		peers:        []privateBase{{Region: "us"}},
+	}
+	input.parent = &input
+
+	expected := "(region) eu\n(timeout) 5\n(name) edge\n"
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output PrivateFields
+	if err := Unmarshal([]byte("(region) eu\n(token) leaked\n(name) edge\n(created) 2024-01-01T00:00:00Z\n"), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Region != "eu" || output.Name != "edge" {
+		t.Errorf("Expected exported fields to be set, got %+v", output)
+	}
+	if output.token != "" || !output.created.IsZero() {
+		t.Errorf("Expected unexported fields to be left alone, got %+v", output)
+	}
+}
+
+// --- Omit Zero ---
+
+type Quota struct {
+	Limit int
+}
+
+// IsZero treats negative limits as unset.
+func (q *Quota) IsZero() bool {
+	return q.Limit <= 0
+}
+
+func TestOmitZero(t *testing.T) {
+	type Job struct {
+		Name     string            `piml:"name"`
+		Retries  int               `piml:"retries,omitzero"`
+		Enabled  bool              `piml:"enabled,omitzero"`
+		Started  time.Time         `piml:"started,omitzero"`
+		Deadline *time.Time        `piml:"deadline,omitzero"`
+		Day      Date              `piml:"day,omitzero"`
+		Quota    Quota             `piml:"quota,omitzero"`
+		Labels   map[string]string `piml:"labels,omitzero"`
+		Tags     []string          `piml:"tags"`
+	}
+
+	t.Run("Zero", func(t *testing.T) {
+		// A non-UTC zero time is not == time.Time{}, but IsZero still reports it.
+		input := Job{Name: "backup", Started: time.Time{}.In(time.FixedZone("X", 3600)), Quota: Quota{Limit: -1}}
+		expected := "(name) backup\n(tags) nil\n"
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+	})
+
+	t.Run("NonZero", func(t *testing.T) {
+		started := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		input := Job{
+			Name:     "backup",
+			Retries:  3,
+			Enabled:  true,
+			Started:  started,
+			Deadline: &started,
+			Day:      Date{2024, time.January, 2},
+			Quota:    Quota{Limit: 10},
+			Labels:   map[string]string{"team": "ops"},
+		}
+		expected := `(name) backup
+(retries) 3
+(enabled) true
+(started) 2024-01-02T03:04:05Z
+(deadline) 2024-01-02T03:04:05Z
+(day) 2024-01-02
+(quota)
+  (limit) 10
+(labels)
+  (team) ops
+(tags) nil
+`
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output Job
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+		}
+	})
+}
+
+// --- Field Order ---
+
+func TestFieldOrder(t *testing.T) {
+	type Service struct {
+		BaseSettings
+		Replicas int    `piml:"replicas,order=3"`
+		Image    string `piml:"image,order=2"`
+		Name     string `piml:"name,order=1"`
+		Debug    bool   `piml:"debug"`
+	}
+	input := Service{BaseSettings: BaseSettings{Timeout: 30}, Replicas: 2, Image: "nginx", Name: "web"}
+
+	tests := []struct {
+		name     string
+		order    FieldOrder
+		expected string
+	}{
+		{"Declared", OrderDeclared, "(timeout) 30\n(replicas) 2\n(image) nginx\n(name) web\n(debug) false\n"},
+		{"Alphabetical", OrderAlphabetical, "(debug) false\n(image) nginx\n(name) web\n(replicas) 2\n(timeout) 30\n"},
+		{"Weighted", OrderWeighted, "(name) web\n(image) nginx\n(replicas) 2\n(timeout) 30\n(debug) false\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			e := NewEncoder(&b)
+			e.SetFieldOrder(tt.order)
+			if err := e.Encode(input); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if b.String() != tt.expected {
+				t.Fatalf("Encode() output mismatch:\nExpected:\n%s\nGot:\n%s", tt.expected, b.String())
+			}
+		})
+	}
+
+	t.Run("InvalidWeight", func(t *testing.T) {
+		type Bad struct {
+			Name string `piml:"name,order=first"`
+		}
+		e := NewEncoder(io.Discard)
+		e.SetFieldOrder(OrderWeighted)
+		if err := e.Encode(Bad{}); err == nil || !strings.Contains(err.Error(), `invalid order "first"`) {
+			t.Fatalf("Expected an invalid order error, got %v", err)
+		}
+	})
+}
+
+// --- Spacing ---
+
+func TestSpacing(t *testing.T) {
+	type Listener struct {
+		Host    string `piml:"host,group=addr"`
+		Port    int    `piml:"port,group=addr"`
+		CertPEM string `piml:"cert,group=tls"`
+		KeyPEM  string `piml:"key,group=tls"`
+		Debug   bool   `piml:"debug"`
+	}
+	type Gateway struct {
+		Name     string            `piml:"name,group=meta"`
+		Version  int               `piml:"version,group=meta"`
+		Listener Listener          `piml:"listener"`
+		Routes   []string          `piml:"routes"`
+		Headers  map[string]string `piml:"headers"`
+	}
+
+	input := Gateway{
+		Name:     "edge",
+		Version:  2,
+		Listener: Listener{Host: "0.0.0.0", Port: 443, CertPEM: "cert.pem", KeyPEM: "key.pem"},
+		Routes:   []string{"/api", "/web"},
+		Headers:  map[string]string{"x-a": "1", "x-b": "2"},
+	}
+
+	tests := []struct {
+		name     string
+		spacing  Spacing
+		expected string
+	}{
+		{"None", SpacingNone, `(name) edge
+(version) 2
+(listener)
+  (host) 0.0.0.0
+  (port) 443
+  (cert) cert.pem
+  (key) key.pem
+  (debug) false
+(routes)
+  > /api
+  > /web
+(headers)
+  (x-a) 1
+  (x-b) 2
+`},
+		{"Sections", SpacingSections, `(name) edge
+
+(version) 2
+
+(listener)
+  (host) 0.0.0.0
+  (port) 443
+  (cert) cert.pem
+  (key) key.pem
+  (debug) false
+
+(routes)
+  > /api
+  > /web
+
+(headers)
+  (x-a) 1
+  (x-b) 2
+`},
+		{"Groups", SpacingGroups, `(name) edge
+(version) 2
+
+(listener)
+  (host) 0.0.0.0
+  (port) 443
+
+  (cert) cert.pem
+  (key) key.pem
+
+  (debug) false
+(routes)
+  > /api
+  > /web
+(headers)
+  (x-a) 1
+  (x-b) 2
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			e := NewEncoder(&b)
+			e.SetSpacing(tt.spacing)
+			if err := e.Encode(input); err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if b.String() != tt.expected {
+				t.Fatalf("Encode() output mismatch:\nExpected:\n%s\nGot:\n%s", tt.expected, b.String())
+			}
+
+			var output Gateway
+			if err := Unmarshal([]byte(b.String()), &output); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(input, output) {
+				t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+			}
+		})
+	}
+}
+
+// --- Hash Escaping ---
+
+func TestHashEscaping(t *testing.T) {
+	type Notes struct {
+		Title string              `piml:"title"`
+		Tags  []string            `piml:"tags"`
+		Refs  map[string]struct{} `piml:"refs"`
+		Body  string              `piml:"body"`
+	}
+
+	input := Notes{
+		Title: "# Heading",
+		Tags:  []string{"#go", `\#escaped`, "plain"},
+		Refs:  map[string]struct{}{"#1": {}},
+		Body:  "intro\n# not a comment\n  # indented\n\\# literal",
+	}
+	expected := `(title) \# Heading
+(tags)
+  > \#go
+  > \\#escaped
+  > plain
+(refs)
+  >| \#1
+(body)
+  intro
+  \# not a comment
+    \# indented
+  \\# literal
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output Notes
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+	}
+
+	t.Run("RootScalar", func(t *testing.T) {
+		data, err := Marshal("#hashtag")
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var output string
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output != "#hashtag" {
+			t.Fatalf("Expected %q, got %q from %q", "#hashtag", output, data)
+		}
+	})
+}
+
+// --- Scalar Escaping ---
+
+func TestScalarEscaping(t *testing.T) {
+	type Form struct {
+		S    string              `piml:"s"`
+		P    *string             `piml:"p"`
+		L    []string            `piml:"l"`
+		M    map[string]string   `piml:"m"`
+		Set  map[string]struct{} `piml:"set"`
+		Any  interface{}         `piml:"any"`
+		Keep string              `piml:"keep"`
+	}
+	nilText := "nil"
+	input := Form{
+		S:    "nil",
+		P:    &nilText,
+		L:    []string{"(a) b", ">", ">| x", "nil", `\nil`, `\(a)`, "> (x)"},
+		M:    map[string]string{"a": "nil", "b": "(b) c"},
+		Set:  map[string]struct{}{"nil": {}, "(x)": {}},
+		Any:  []interface{}{"nil", nil, "(a)"},
+		Keep: "(a)",
+	}
+	expected := `(s) \nil
+(p) \nil
+(l)
+  > \(a) b
+  > \>
+  > \>| x
+  > \nil
+  > \\nil
+  > \\(a)
+  > \> (x)
+(m)
+  (a) \nil
+  (b) (b) c
+(set)
+  >| (x)
+  >| \nil
+(any)
+  > \nil
+  > nil
+  > \(a)
+(keep) (a)
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+	var output Form
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+	}
+
+	t.Run("GenericMap", func(t *testing.T) {
+		input := map[string]interface{}{"a": "nil", "b": nil, "c": "> x"}
+		data, err := Marshal(input)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		var output map[string]interface{}
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v\nfrom:\n%s", input, output, data)
+		}
+	})
+
+	t.Run("InlineComments", func(t *testing.T) {
+		var b bytes.Buffer
+		e := NewEncoder(&b)
+		e.SetInlineComments(true)
+		if err := e.Encode(input); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		d := NewDecoder(b.Bytes())
+		d.SetInlineComments(true)
+		var output Form
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+
+	t.Run("Node", func(t *testing.T) {
+		n, err := ParseNode(data)
+		if err != nil {
+			t.Fatalf("ParseNode() error = %v", err)
+		}
+		if s := n.Lookup("s"); s == nil || s.Kind != ScalarNode || s.Value != "nil" {
+			t.Fatalf("Expected the string nil, got %+v", s)
+		}
+		if item := n.Lookup("any[1]"); item == nil || item.Kind != NilNode {
+			t.Fatalf("Expected nil, got %+v", item)
+		}
+		again, err := Marshal(n)
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(again) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, again)
+		}
+	})
+
+	t.Run("Editor", func(t *testing.T) {
+		ed, err := NewEditor([]byte("(s) x\n"))
+		if err != nil {
+			t.Fatalf("NewEditor() error = %v", err)
+		}
+		if err := ed.Set("s", "nil"); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if n := ed.Lookup("s"); n == nil || n.Kind != ScalarNode || n.Value != "nil" {
+			t.Fatalf("Expected the string nil, got %+v in:\n%s", n, ed.Bytes())
+		}
+		var output Form
+		if err := Unmarshal(ed.Bytes(), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.S != "nil" {
+			t.Fatalf("Expected the string nil, got %q", output.S)
+		}
+	})
+
+	t.Run("Values", func(t *testing.T) {
+		// Values after a key that start like a key or an item are not
+		// ambiguous, so they are written and read as they are.
+		type Contact struct {
+			Phone string `piml:"phone"`
+			Note  string `piml:"note"`
+		}
+		data, err := Marshal(Contact{Phone: "(555) 123", Note: "> x"})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != "(phone) (555) 123\n(note) > x\n" {
+			t.Fatalf("Unexpected output:\n%s", data)
+		}
+		var output Contact
+		if err := Unmarshal([]byte("(phone) \\(555) 123\n(note) \\> x\n"), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Phone != `\(555) 123` || output.Note != `\> x` {
+			t.Fatalf("Expected backslashes to be kept, got %+v", output)
+		}
+	})
+
+	t.Run("MultiLine", func(t *testing.T) {
+		text := "(a) b\n  > c\n>| d\n---\n\\(e)\n# f"
+		expected := `(s)
+  \(a) b
+    \> c
+  \>| d
+  \---
+  \\(e)
+  \# f
+(l)
+  >
+    x
+    \(a)
+`
+		type Lines struct {
+			S string   `piml:"s"`
+			L []string `piml:"l"`
+		}
+		data, err := Marshal(Lines{S: text, L: []string{"x\n(a)"}})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		if string(data) != expected {
+			t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+		}
+		var output Lines
+		if err := Unmarshal(data, &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.S != text || len(output.L) != 1 || output.L[0] != "x\n(a)" {
+			t.Fatalf("Roundtrip failed: got %q and %q from:\n%s", output.S, output.L, data)
+		}
+
+	})
+}
+
+// --- Inline Comments ---
+
+func TestInlineComments(t *testing.T) {
+	type Channel struct {
+		Name    string   `piml:"name"`
+		Port    int      `piml:"port"`
+		Topic   string   `piml:"topic"`
+		Members []string `piml:"members"`
+		Notes   string   `piml:"notes"`
+	}
+
+	t.Run("Decode", func(t *testing.T) {
+		input := `(name) general # The default channel
+(port) 6667 # Plain text
+(topic) Issue \#42 and C# # Escaped hashes stay
+(members) # Sorted by join date
+  > alice # Founder
+  > bob
+(notes)
+  Multi-line strings keep # as text.
+`
+		d := NewDecoder([]byte(input))
+		d.SetInlineComments(true)
+		var output Channel
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		expected := Channel{
+			Name:    "general",
+			Port:    6667,
+			Topic:   "Issue #42 and C#",
+			Members: []string{"alice", "bob"},
+			Notes:   "Multi-line strings keep # as text.",
+		}
+		if !reflect.DeepEqual(expected, output) {
+			t.Fatalf("Decode() mismatch:\nExpected:\n%#v\nGot:\n%#v", expected, output)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		var output Channel
+		if err := Unmarshal([]byte("(name) general # Kept\n"), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Name != "general # Kept" {
+			t.Fatalf("Expected the hash to be part of the value, got %q", output.Name)
+		}
+	})
+
+	t.Run("Roundtrip", func(t *testing.T) {
+		input := Channel{
+			Name:    "#ops",
+			Topic:   `see #12, \#13 and a#b`,
+			Members: []string{"# not a comment"},
+			Notes:   "line # one\nline two",
+		}
+		expected := `(name) \#ops
+(port) 0
+(topic) see \#12, \\#13 and a#b
+(members)
+  > \# not a comment
+(notes)
+  line # one
+  line two
+`
+		var b strings.Builder
+		e := NewEncoder(&b)
+		e.SetInlineComments(true)
+		if err := e.Encode(input); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if b.String() != expected {
+			t.Fatalf("Encode() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, b.String())
+		}
+
+		d := NewDecoder([]byte(b.String()))
+		d.SetInlineComments(true)
+		var output Channel
+		if err := d.Decode(&output); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+}
+
+// --- Key Escaping ---
+
+func TestKeyEscaping(t *testing.T) {
+	type Disk struct {
+		Size  int               `piml:"size (bytes)"`
+		Paths map[string]string `piml:"paths"`
+	}
+
+	input := Disk{
+		Size: 1024,
+		Paths: map[string]string{
+			`C:\Temp`:       "windows",
+			`smile :)`:      "emoticon",
+			`ends with \`:   "backslash",
+			`(parenthesis)`: "wrapped",
+		},
+	}
+	expected := `(size (bytes\)) 1024
+(paths)
+  ((parenthesis\)) wrapped
+  (C:\\Temp) windows
+  (ends with \\) backslash
+  (smile :\)) emoticon
+`
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != expected {
+		t.Fatalf("Marshal() output mismatch:\nExpected:\n%s\nGot:\n%s", expected, data)
+	}
+
+	var output Disk
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+	}
+
+	t.Run("LiteralBackslash", func(t *testing.T) {
+		var output map[string]string
+		if err := Unmarshal([]byte(`(C:\Temp) unescaped`), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output[`C:\Temp`] != "unescaped" {
+			t.Fatalf("Expected a literal backslash in the key, got %#v", output)
+		}
+	})
+}
+
+// --- Key Validation ---
+
+func TestKeyValidation(t *testing.T) {
+	tests := []struct {
+		name  string
+		input interface{}
+		want  string
+	}{
+		{"Newline", map[string]int{"first\nsecond": 1}, `"first\nsecond": keys cannot contain line breaks`},
+		{"CarriageReturn", map[string]int{"a\rb": 1}, `"a\rb": keys cannot contain line breaks`},
+		{"Profile", map[string]int{"profile:prod": 1}, `"profile:prod": keys starting with "profile:" are profile blocks`},
+		{"Tag", struct {
+			A int `piml:"multi\nline"`
+		}{}, "keys cannot contain line breaks"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Marshal(tt.input)
+			if !errors.Is(err, ErrInvalidKey) {
+				t.Fatalf("Expected ErrInvalidKey, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Expected error to contain %q, got %q", tt.want, err)
+			}
+		})
+	}
+
+	// Everything else can be escaped and read back.
+	input := map[string]string{"#hash": "1", " spaced ": "2", "": "3", "(x)": "4"}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var output map[string]string
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(input, output) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v\n\nPIML:\n%s", input, output, data)
+	}
+}
+
+// --- Windows Line Endings ---
+
+func TestWindowsLineEndings(t *testing.T) {
+	type Doc struct {
+		Title string   `piml:"title"`
+		Tags  []string `piml:"tags"`
+		Body  string   `piml:"body"`
+	}
+	input := Doc{Title: "Report", Tags: []string{"q1", "q2"}, Body: "line one\nline two"}
+
+	t.Run("DecodeCRLFAndBOM", func(t *testing.T) {
+		data := "\uFEFF(title) Report\r\n(tags)\r\n  > q1\r\n  > q2\r\n(body)\r\n  line one\r\n  line two\r\n"
+		var output Doc
+		if err := Unmarshal([]byte(data), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Unmarshal() mismatch:\nExpected:\n%#v\nGot:\n%#v", input, output)
+		}
+	})
+
+	t.Run("DecodeTrailingCR", func(t *testing.T) {
+		// Only the \r of the line ending is dropped.
+		data := "(body)\r\n  line one\r\r\n  line two\r\n"
+		var output Doc
+		if err := Unmarshal([]byte(data), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if output.Body != "line one\r\nline two" {
+			t.Fatalf("Expected the \\r to be kept, got %q", output.Body)
+		}
+	})
+
+	t.Run("EncodeCRLF", func(t *testing.T) {
+		var b strings.Builder
+		e := NewEncoder(&b)
+		e.SetCRLF(true)
+		if err := e.Encode(input); err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		expected := "(title) Report\r\n(tags)\r\n  > q1\r\n  > q2\r\n(body)\r\n  line one\r\n  line two\r\n"
+		if b.String() != expected {
+			t.Fatalf("Encode() output mismatch:\nExpected:\n%q\nGot:\n%q", expected, b.String())
+		}
+		var output Doc
+		if err := Unmarshal([]byte(b.String()), &output); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if !reflect.DeepEqual(input, output) {
+			t.Fatalf("Roundtrip failed:\nInput:\n%#v\n\nOutput:\n%#v", input, output)
+		}
+	})
+
+	t.Run("FrontMatterBOM", func(t *testing.T) {
+		var meta Doc
+		body, err := SplitFrontMatter([]byte("\uFEFF---\r\n(title) Post\r\n---\r\nBody\r\n"), &meta)
+		if err != nil {
+			t.Fatalf("SplitFrontMatter() error = %v", err)
+		}
+		if meta.Title != "Post" || string(body) != "Body\r\n" {
+			t.Fatalf("Unexpected front matter %+v and body %q", meta, body)
+		}
+	})
+}
+
+// --- Unicode Keys and Whitespace ---
+
+func TestUnicodeWhitespace(t *testing.T) {
+	type Place struct {
+		Name string `piml:"name"`
+		Geo  struct {
+			Lat string `piml:"lat"`
+		} `piml:"geo"`
+	}
+
+	var output Place
+	err := Unmarshal([]byte("(name) Zürich\n(geo)\n \u00a0(lat) 47.37\n"), &output)
+	if !errors.Is(err, ErrSyntax) {
+		t.Fatalf("Expected ErrSyntax, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 3, column 2: U+00A0 is not allowed in indentation") {
+		t.Fatalf("Expected the position of the non-breaking space, got %v", err)
+	}
+}
+
+func TestKeyNormalizer(t *testing.T) {
+	// A stand-in for norm.NFC.String covering the composed "é".
+	nfc := strings.NewReplacer("e\u0301", "\u00e9").Replace
+
+	type Cafe struct {
+		Menu string `piml:"café"`
+	}
+	decomposed := []byte("(cafe\u0301) espresso\n")
+
+	var output Cafe
+	if err := Unmarshal(decomposed, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Menu != "" {
+		t.Fatalf("Expected no match without a normalizer, got %q", output.Menu)
+	}
+
+	d := NewDecoder(decomposed)
+	d.SetKeyNormalizer(nfc)
+	if err := d.Decode(&output); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if output.Menu != "espresso" {
+		t.Fatalf("Expected the normalized key to match, got %q", output.Menu)
+	}
+
+	var b strings.Builder
+	e := NewEncoder(&b)
+	e.SetKeyNormalizer(nfc)
+	if err := e.Encode(map[string]string{"cafe\u0301": "latte"}); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if b.String() != "(caf\u00e9) latte\n" {
+		t.Fatalf("Expected a normalized key, got %q", b.String())
+	}
+}
+
+// --- Strict Indentation ---
+
+func TestIndentUnit(t *testing.T) {
+	type Server struct {
+		Host  string   `piml:"host"`
+		Port  int      `piml:"port"`
+		Tags  []string `piml:"tags"`
+		Notes string   `piml:"notes"`
+	}
+	type Config struct {
+		Server Server `piml:"server"`
+	}
+
+	valid := "(server)\n  (host) localhost\n  (port) 8080\n  (tags)\n    > a\n    > b\n  (notes)\n      indented\n    text\n"
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{"valid", valid, ""},
+		{"odd indent", "(server)\n   (host) localhost\n   (port) 8080\n", "line 2: indented by 3 spaces, which is not a multiple of 2"},
+		{"uneven siblings", "(server)\n  (host) localhost\n    (port) 8080\n", "line 3: indented by 4 spaces, but line 2 of the same block is indented by 2"},
+		{"uneven items", "(server)\n  (tags)\n      > a\n    > b\n", "line 4: indented by 4 spaces, but line 3 of the same block is indented by 6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Without a unit, every input is accepted.
+			var output Config
+			if err := Unmarshal([]byte(tt.input), &output); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+
+			d := NewDecoder([]byte(tt.input))
+			d.SetIndentUnit(2)
+			err := d.Decode(&output)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Decode() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrSyntax) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Expected %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// --- Streaming ---
+
+func TestStreamingArrays(t *testing.T) {
+	type User struct {
+		ID   int    `piml:"id"`
+		Name string `piml:"name"`
+	}
+
+	var b strings.Builder
+	e := NewEncoder(&b)
+	if err := e.EncodeArrayItem(User{}); err == nil {
+		t.Fatal("Expected an error for an item without a header")
+	}
+	if err := e.EncodeArrayHeader("users"); err != nil {
+		t.Fatalf("EncodeArrayHeader() error = %v", err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := e.EncodeArrayItem(&User{ID: i, Name: fmt.Sprintf("user%d", i)}); err != nil {
+			t.Fatalf("EncodeArrayItem() error = %v", err)
+		}
+	}
+	if err := e.EncodeArrayItem(nil); err != nil {
+		t.Fatalf("EncodeArrayItem() error = %v", err)
+	}
+	// An empty array is closed as nil.
+	if err := e.EncodeArrayHeader("groups"); err != nil {
+		t.Fatalf("EncodeArrayHeader() error = %v", err)
+	}
+	if err := e.EncodeArrayHeader("tags"); err != nil {
+		t.Fatalf("EncodeArrayHeader() error = %v", err)
+	}
+	for _, tag := range []string{"a", "#b"} {
+		if err := e.EncodeArrayItem(tag); err != nil {
+			t.Fatalf("EncodeArrayItem() error = %v", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	want := `(users)
+  > (User)
+      (id) 1
+      (name) user1
+  > (User)
+      (id) 2
+      (name) user2
+  > (User)
+      (id) 3
+      (name) user3
+  > nil
+(groups) nil
+(tags)
+  > a
+  > \#b
+`
+	if b.String() != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, b.String())
+	}
+
+	// The stream reads back like a slice written by Marshal.
+	var output struct {
+		Users  []*User  `piml:"users"`
+		Groups []string `piml:"groups"`
+		Tags   []string `piml:"tags"`
+	}
+	if err := Unmarshal([]byte(b.String()), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(output.Users) != 4 || output.Users[2].Name != "user3" || output.Users[3] != nil {
+		t.Fatalf("Unexpected users: %+v", output.Users)
+	}
+	if output.Groups != nil || !reflect.DeepEqual(output.Tags, []string{"a", "#b"}) {
+		t.Fatalf("Unexpected lists: %+v", output)
+	}
+
+	t.Run("root list", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := bufio.NewWriter(&buf)
+		e := NewEncoder(w)
+		e.SetCRLF(true)
+		e.EncodeArrayHeader("")
+		e.EncodeArrayItem(1)
+		e.EncodeArrayItem(2)
+		if err := e.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if buf.String() != "> 1\r\n> 2\r\n" {
+			t.Fatalf("Expected a flushed root list, got %q", buf.String())
+		}
+	})
+}
+
+// --- Token Writer ---
+
+func TestEncodeToken(t *testing.T) {
+	tokens := []Token{
+		Comment("Generated"),
+		Key("name"), "demo",
+		Key("port"), 8080,
+		Key("motd"), "hello\nworld",
+		Key("database"), ObjectStart,
+		Key("host"), "localhost",
+		Key("password"), nil,
+		ObjectEnd,
+		Key("users"), ArrayStart,
+		ObjectStart, Key("id"), 1, ObjectEnd,
+		ObjectStart, ObjectEnd,
+		nil,
+		ArrayEnd,
+		Key("tags"), SetStart, "a", "#b", SetEnd,
+		Key("empty"), ArrayStart, ArrayEnd,
+	}
+
+	var b strings.Builder
+	e := NewEncoder(&b)
+	for _, tok := range tokens {
+		if err := e.EncodeToken(tok); err != nil {
+			t.Fatalf("EncodeToken(%v) error = %v", tok, err)
+		}
+	}
+
+	want := `# Generated
+(name) demo
+(port) 8080
+(motd)
+  hello
+  world
+(database)
+  (host) localhost
+  (password) nil
+(users)
+  > (item)
+      (id) 1
+  > (item)
+  > nil
+(tags)
+  >| a
+  >| \#b
+(empty) nil
+`
+	if b.String() != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, b.String())
+	}
+
+	var output struct {
+		Name  string              `piml:"name"`
+		Port  int                 `piml:"port"`
+		Users []*struct{ ID int } `piml:"users"`
+		Tags  map[string]struct{} `piml:"tags"`
+	}
+	if err := Unmarshal([]byte(b.String()), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Port != 8080 || len(output.Users) != 3 || output.Users[0].ID != 1 || len(output.Tags) != 2 {
+		t.Fatalf("Unexpected output: %+v", output)
+	}
+
+	t.Run("root values", func(t *testing.T) {
+		for _, tt := range []struct {
+			tokens []Token
+			want   string
+		}{
+			{[]Token{"#1"}, "\\#1\n"},
+			{[]Token{ArrayStart, 1, 2, ArrayEnd}, "> 1\n> 2\n"},
+			{[]Token{ArrayStart, "multi\nline", ArrayEnd}, ">\n  multi\n  line\n"},
+			{[]Token{ObjectStart, Key("a"), true, ObjectEnd}, "(a) true\n"},
+		} {
+			var b strings.Builder
+			e := NewEncoder(&b)
+			for _, tok := range tt.tokens {
+				if err := e.EncodeToken(tok); err != nil {
+					t.Fatalf("EncodeToken(%v) error = %v", tok, err)
+				}
+			}
+			if b.String() != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, b.String())
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for _, tokens := range [][]Token{
+			{Key("a"), Key("b")},
+			{Key("a"), "x", "y"},
+			{ObjectEnd},
+			{Key("a"), ArrayStart, ObjectEnd},
+			{Key("a"), ArrayStart, ArrayStart},
+			{Key("a"), SetStart, nil},
+			{"root", Key("a")},
+			{Key("a"), []int{1}},
+		} {
+			e := NewEncoder(io.Discard)
+			var err error
+			for _, tok := range tokens {
+				if err = e.EncodeToken(tok); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				t.Errorf("Expected an error for %v", tokens)
+			}
+		}
+	})
+}
+
+// --- Walk ---
+
+func TestWalk(t *testing.T) {
+	input := `# Inventory
+(name) shop
+(address)
+  (city) Izmir
+(items)
+  > (Item)
+      (sku) A1
+  > nil
+(tags)
+  >| new
+(notes)
+  first
+  second
+(empty)
+`
+	var events []string
+	record := func(name string) func(Position) error {
+		return func(pos Position) error {
+			events = append(events, fmt.Sprintf("%s@%d:%d", name, pos.Line, pos.Column))
+			return nil
+		}
+	}
+	h := Handler{
+		OnKey: func(key string, pos Position) error {
+			events = append(events, fmt.Sprintf("key %s@%d:%d", key, pos.Line, pos.Column))
+			return nil
+		},
+		OnScalar: func(value string, pos Position) error {
+			events = append(events, fmt.Sprintf("scalar %q@%d:%d", value, pos.Line, pos.Column))
+			return nil
+		},
+		OnObjectStart: record("{"),
+		OnObjectEnd:   record("}"),
+		OnArrayStart:  record("["),
+		OnArrayEnd:    record("]"),
+		OnSetStart:    record("<"),
+		OnSetEnd:      record(">"),
+	}
+	if err := Walk([]byte(input), h); err != nil {
+		t.Fatalf("Walk() error = %v", err)
+	}
+
+	want := []string{
+		"{@2:1",
+		"key name@2:1", `scalar "shop"@2:1`,
+		"key address@3:1", "{@3:1", "key city@4:3", `scalar "Izmir"@4:3`, "}@3:1",
+		"key items@5:1", "[@5:1",
+		"{@6:3", "key sku@7:7", `scalar "A1"@7:7`, "}@6:3",
+		`scalar "nil"@8:3`,
+		"]@5:1",
+		"key tags@9:1", "<@9:1", `scalar "new"@10:3`, ">@9:1",
+		"key notes@11:1", `scalar "first\nsecond"@12:3`,
+		"key empty@14:1", "{@14:1", "}@14:1",
+		"}@2:1",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("Expected events:\n%s\nGot:\n%s", strings.Join(want, "\n"), strings.Join(events, "\n"))
+	}
+
+	t.Run("root list", func(t *testing.T) {
+		var items []string
+		err := Walk([]byte("> a\n> b\n"), Handler{
+			OnScalar: func(value string, pos Position) error {
+				items = append(items, value)
+				return nil
+			},
+		})
+		if err != nil || !reflect.DeepEqual(items, []string{"a", "b"}) {
+			t.Fatalf("Walk() = %v, %v", items, err)
+		}
+		if err := Walk([]byte("> a\n(b) c\n"), Handler{}); !errors.Is(err, ErrSyntax) {
+			t.Fatalf("Expected ErrSyntax for a key after a root list, got %v", err)
+		}
+	})
+
+	t.Run("handler error", func(t *testing.T) {
+		stop := errors.New("stop")
+		var keys int
+		err := Walk([]byte(input), Handler{
+			OnKey: func(key string, pos Position) error {
+				keys++
+				if key == "address" {
+					return stop
+				}
+				return nil
+			},
+		})
+		if err != stop || keys != 2 {
+			t.Fatalf("Expected the walk to stop at the second key, got %v after %d keys", err, keys)
+		}
+	})
+}
+
+// --- Input Offset ---
+
+func TestInputOffset(t *testing.T) {
+	type Doc struct {
+		Name string `piml:"name"`
+	}
+	first := "(name) one\r\n# trailing comment\r\n"
+	second := "(name) two\n"
+	input := first + "---\n" + second + "---\nbinary payload"
+
+	d := NewDecoder([]byte(input))
+	var doc Doc
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if doc.Name != "one" || d.InputOffset() != int64(len(first)) {
+		t.Fatalf("Expected %q at offset %d, got %q at %d", "one", len(first), doc.Name, d.InputOffset())
+	}
+
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := int64(len(first) + len("---\n") + len(second))
+	if doc.Name != "two" || d.InputOffset() != want {
+		t.Fatalf("Expected %q at offset %d, got %q at %d", "two", want, doc.Name, d.InputOffset())
+	}
+
+	rest, err := io.ReadAll(d.Buffered())
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if string(rest) != "---\nbinary payload" {
+		t.Fatalf("Unexpected buffered data %q", rest)
+	}
+
+	// Without a separator, the document runs to the end of the input.
+	d = NewDecoder([]byte(second + "\n# end"))
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if d.InputOffset() != int64(len(second)+len("\n# end")) {
+		t.Fatalf("Expected the whole input to be consumed, got offset %d", d.InputOffset())
+	}
+}
+
+// --- Fast Paths ---
+
+func TestFastPaths(t *testing.T) {
+	// Named types take the reflective paths, so both can be compared.
+	type Strings []string
+	type Ints []int
+	type StringMap map[string]string
+	type AnyMap map[string]interface{}
+
+	type Fast struct {
+		Names  []string               `piml:"names"`
+		Counts []int                  `piml:"counts"`
+		Labels map[string]string      `piml:"labels"`
+		Extra  map[string]interface{} `piml:"extra"`
+	}
+	type Slow struct {
+		Names  Strings   `piml:"names"`
+		Counts Ints      `piml:"counts"`
+		Labels StringMap `piml:"labels"`
+		Extra  AnyMap    `piml:"extra"`
+	}
+
+	fast := Fast{
+		Names:  []string{"a", "#b", ""},
+		Counts: []int{1, -2, 3},
+		Labels: map[string]string{"z": "last", "a": "first\nsecond", "k(1)": "#"},
+		Extra:  map[string]interface{}{"n": 1, "s": "x", "nil": nil, "list": []string{"y"}},
+	}
+	slow := Slow{
+		Names:  Strings(fast.Names),
+		Counts: Ints(fast.Counts),
+		Labels: StringMap(fast.Labels),
+		Extra:  AnyMap(fast.Extra),
+	}
+
+	fastOut, err := Marshal(fast)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	slowOut, err := Marshal(slow)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(fastOut) != string(slowOut) {
+		t.Fatalf("Fast path output differs:\n%s\nReflective output:\n%s", fastOut, slowOut)
+	}
+
+	var fastIn Fast
+	if err := Unmarshal(fastOut, &fastIn); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	var slowIn Slow
+	if err := Unmarshal(fastOut, &slowIn); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(fastIn.Names, []string(slowIn.Names)) ||
+		!reflect.DeepEqual(fastIn.Counts, []int(slowIn.Counts)) ||
+		!reflect.DeepEqual(fastIn.Labels, map[string]string(slowIn.Labels)) ||
+		!reflect.DeepEqual(fastIn.Extra, map[string]interface{}(slowIn.Extra)) {
+		t.Fatalf("Fast path result %+v differs from reflective result %+v", fastIn, slowIn)
+	}
+
+	// Errors are the same too.
+	for _, input := range []string{
+		"(names)\n  > a\n  > nil\n",
+		"(counts)\n  > 1\n  > x\n",
+		"(counts)\n  > 99999999999999999999\n",
+		"(counts)\n  > (item)\n    (a) 1\n",
+		"(labels)\n  (a) nil\n",
+	} {
+		fastErr := Unmarshal([]byte(input), &Fast{})
+		slowErr := Unmarshal([]byte(input), &Slow{})
+		if fastErr == nil || slowErr == nil || fastErr.Error() != slowErr.Error() {
+			t.Errorf("For %q, fast path error %v differs from reflective error %v", input, fastErr, slowErr)
+		}
+	}
+}
+
+// --- Key Interning ---
+
+func TestKeyInterning(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("(events)\n")
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&input, "  > (Event)\n      (level) info\n      (message) event %d\n", i)
+	}
+
+	var output struct {
+		Events []map[string]string `piml:"events"`
+	}
+	if err := Unmarshal([]byte(input.String()), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if len(output.Events) != 100 || output.Events[99]["message"] != "event 99" {
+		t.Fatalf("Unexpected events: %v", output.Events)
+	}
+
+	// Every map shares the same key strings, rather than each holding
+	// on to the line its keys were read from.
+	keyData := func(m map[string]string, key string) *byte {
+		for k := range m {
+			if k == key {
+				return unsafe.StringData(k)
+			}
+		}
+		return nil
+	}
+	first := keyData(output.Events[0], "level")
+	for i, event := range output.Events {
+		if keyData(event, "level") != first {
+			t.Fatalf("Key of event %d was not interned", i)
+		}
+	}
+}
+
+// --- Decode Statistics ---
+
+func TestDecodeStats(t *testing.T) {
+	input := "# config\n(name) demo\n(server)\n  (host) localhost\n  (ports)\n    > 80\n    > 443\n"
+
+	var hooked DecodeStats
+	calls := 0
+	d := NewDecoder([]byte(input))
+	d.SetStatsHook(func(stats DecodeStats, err error) {
+		hooked = stats
+		calls++
+		if err != nil {
+			t.Errorf("Unexpected error in hook: %v", err)
+		}
+	})
+
+	var output struct {
+		Name   string `piml:"name"`
+		Server struct {
+			Host  string `piml:"host"`
+			Ports []int  `piml:"ports"`
+		} `piml:"server"`
+	}
+	if err := d.Decode(&output); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	stats := d.Stats()
+	if calls != 1 || hooked != stats {
+		t.Fatalf("Expected the hook to get %+v once, got %+v after %d calls", stats, hooked, calls)
+	}
+	if stats.Lines != 7 || stats.Keys != 4 || stats.MaxDepth != 3 || stats.Bytes != int64(len(input)) {
+		t.Fatalf("Unexpected stats: %+v", stats)
+	}
+
+	// Failed decodes are reported too.
+	d = NewDecoder([]byte("(port) x\n"))
+	var hookErr error
+	d.SetStatsHook(func(stats DecodeStats, err error) { hookErr = err })
+	var port struct {
+		Port int `piml:"port"`
+	}
+	if err := d.Decode(&port); err == nil || err != hookErr {
+		t.Fatalf("Expected the hook to get the error %v, got %v", err, hookErr)
+	}
+}
+
+// --- Decoder Reuse ---
+
+func TestDecoderReset(t *testing.T) {
+	type Doc struct {
+		Name  string   `piml:"name"`
+		Items []string `piml:"items"`
+		Notes string   `piml:"notes"`
+	}
+
+	d := NewDecoder([]byte("(name) {{.}}\n"))
+	d.UseTemplate("first", nil)
+	d.SetIndentUnit(2)
+	var doc Doc
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if doc.Name != "first" {
+		t.Fatalf("Expected %q, got %q", "first", doc.Name)
+	}
+
+	// Settings, including the template, apply to the new input.
+	d.Reset([]byte("(name) {{.}} again\n(items)\n  > a\n  > b\n(notes)\n  x\n  y\n"))
+	doc = Doc{}
+	if err := d.Decode(&doc); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := Doc{Name: "first again", Items: []string{"a", "b"}, Notes: "x\ny"}
+	if !reflect.DeepEqual(doc, want) {
+		t.Fatalf("Expected %+v, got %+v", want, doc)
+	}
+	if d.InputOffset() != int64(len(d.src)) || d.Stats().Lines != 7 {
+		t.Fatalf("Expected the new input to be read in full, got offset %d and %+v", d.InputOffset(), d.Stats())
+	}
+
+	d.Reset([]byte("(name) x\n   (items) y\n"))
+	if err := d.Decode(&doc); !errors.Is(err, ErrSyntax) {
+		t.Fatalf("Expected SetIndentUnit to still apply, got %v", err)
+	}
+
+	// Reusing a decoder allocates less than creating a new one.
+	input := []byte("(name) demo\n(items)\n  > a\n  > b\n")
+	fresh := testing.AllocsPerRun(100, func() {
+		var doc Doc
+		NewDecoder(input).Decode(&doc)
+	})
+	reused := NewDecoder(nil)
+	allocs := testing.AllocsPerRun(100, func() {
+		var doc Doc
+		reused.Reset(input)
+		reused.Decode(&doc)
+	})
+	if allocs >= fresh {
+		t.Fatalf("Expected fewer than %v allocations with Reset, got %v", fresh, allocs)
+	}
+}
+
+func TestDecoderResetReader(t *testing.T) {
+	type Doc struct {
+		Name string `piml:"name"`
+	}
+
+	d := NewDecoder(nil)
+	for _, name := range []string{"a much longer first name", "short"} {
+		if err := d.ResetReader(strings.NewReader("(name) " + name + "\n")); err != nil {
+			t.Fatalf("ResetReader() error = %v", err)
+		}
+		var doc Doc
+		if err := d.Decode(&doc); err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		if doc.Name != name {
+			t.Fatalf("Expected %q, got %q", name, doc.Name)
+		}
+	}
+
+	readErr := errors.New("read failed")
+	if err := d.ResetReader(io.MultiReader(strings.NewReader("(name) x\n"), iotest.ErrReader(readErr))); !errors.Is(err, readErr) {
+		t.Fatalf("Expected the read error, got %v", err)
+	}
+
+	// Once warmed up, Reset and ResetReader don't allocate.
+	input := []byte("(name) demo\n")
+	if allocs := testing.AllocsPerRun(100, func() { d.Reset(input) }); allocs != 0 {
+		t.Fatalf("Expected Reset not to allocate, got %v allocations", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { d.ResetReader(bytes.NewReader(input)) }); allocs > 1 {
+		t.Fatalf("Expected ResetReader to reuse its buffer, got %v allocations", allocs)
+	}
+}
+
+// --- Custom Scalars ---
+
+// Version is a third-party-style struct written as "1.2.3".
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// Celsius has a TextMarshaler that RegisterScalar overrides.
+type Celsius float64
+
+func (c Celsius) MarshalText() ([]byte, error) {
+	return []byte(strconv.FormatFloat(float64(c), 'f', -1, 64) + "C"), nil
+}
+
+func init() {
+	RegisterScalar(func(v Version) (string, error) {
+		return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch), nil
+	}, func(s string) (Version, error) {
+		var v Version
+		_, err := fmt.Sscanf(s, "%d.%d.%d", &v.Major, &v.Minor, &v.Patch)
+		return v, err
+	})
+	RegisterScalar(func(c Celsius) (string, error) {
+		if c < -273.15 {
+			return "", errors.New("below absolute zero")
+		}
+		return strconv.FormatFloat(float64(c), 'f', 1, 64) + " degC", nil
+	}, func(s string) (Celsius, error) {
+		f, err := strconv.ParseFloat(strings.TrimSuffix(s, " degC"), 64)
+		return Celsius(f), err
+	})
+}
+
+func TestRegisterScalar(t *testing.T) {
+	type Release struct {
+		Version  Version            `piml:"version"`
+		Previous *Version           `piml:"previous"`
+		Skipped  []Version          `piml:"skipped"`
+		Temp     Celsius            `piml:"temp"`
+		Pinned   map[string]Version `piml:"pinned"`
+	}
+
+	input := Release{
+		Version:  Version{1, 2, 3},
+		Previous: &Version{1, 1, 0},
+		Skipped:  []Version{{1, 1, 1}, {1, 1, 2}},
+		Temp:     21.5,
+		Pinned:   map[string]Version{"go": {1, 25, 0}},
+	}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := "(version) 1.2.3\n(previous) 1.1.0\n(skipped)\n  > 1.1.1\n  > 1.1.2\n(temp) 21.5 degC\n(pinned)\n  (go) 1.25.0\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var output Release
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(output, input) {
+		t.Fatalf("Roundtrip failed:\nInput:\n%+v\n\nOutput:\n%+v", input, output)
+	}
+
+	err = Unmarshal([]byte("(version) latest\n"), &output)
+	if err == nil || !strings.Contains(err.Error(), `invalid piml.Version value "latest"`) {
+		t.Fatalf("Expected an invalid value error, got %v", err)
+	}
+	if _, err := Marshal(Release{Temp: -300}); err == nil || !strings.Contains(err.Error(), "below absolute zero") {
+		t.Fatalf("Expected the encode error, got %v", err)
+	}
+}
+
+// --- Struct Generation ---
+
+func TestGenerateStruct(t *testing.T) {
+	sample := []byte(`(service_name) billing
+(port) 8080
+(ratio) 0.75
+(debug) false
+(zip) 01234
+(started_at) 2024-01-02T03:04:05Z
+(api_urls)
+  > https://a.example.com
+  > https://b.example.com
+(ports)
+  >| 80
+  >| 443
+(owner) nil
+(extra)
+(database)
+  (host) db
+  (max_conns) 10
+(users)
+  > (item)
+    (id) 1
+    (name) Alice
+  > (item)
+    (id) 2
+    (name) Bob
+    (admin) true
+(server)
+  (database)
+    (host) other
+`)
+	src, err := GenerateStruct(sample, "config")
+	if err != nil {
+		t.Fatalf("GenerateStruct() error = %v", err)
+	}
+	want := "type Config struct {\n" +
+		"\tServiceName string                 `piml:\"service_name\"`\n" +
+		"\tPort        int                    `piml:\"port\"`\n" +
+		"\tRatio       float64                `piml:\"ratio\"`\n" +
+		"\tDebug       bool                   `piml:\"debug\"`\n" +
+		"\tZip         string                 `piml:\"zip\"`\n" +
+		"\tStartedAt   time.Time              `piml:\"started_at\"`\n" +
+		"\tAPIURLs     []string               `piml:\"api_urls\"`\n" +
+		"\tPorts       map[int]struct{}       `piml:\"ports\"`\n" +
+		"\tOwner       interface{}            `piml:\"owner\"`\n" +
+		"\tExtra       map[string]interface{} `piml:\"extra\"`\n" +
+		"\tDatabase    Database               `piml:\"database\"`\n" +
+		"\tUsers       []User                 `piml:\"users\"`\n" +
+		"\tServer      Server                 `piml:\"server\"`\n" +
+		"}\n\n" +
+		"type Database struct {\n" +
+		"\tHost     string `piml:\"host\"`\n" +
+		"\tMaxConns int    `piml:\"max_conns\"`\n" +
+		"}\n\n" +
+		"type User struct {\n" +
+		"\tID    int    `piml:\"id\"`\n" +
+		"\tName  string `piml:\"name\"`\n" +
+		"\tAdmin bool   `piml:\"admin\"`\n" +
+		"}\n\n" +
+		"type Server struct {\n" +
+		"\tDatabase ServerDatabase `piml:\"database\"`\n" +
+		"}\n\n" +
+		"type ServerDatabase struct {\n" +
+		"\tHost string `piml:\"host\"`\n" +
+		"}\n"
+	if string(src) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, src)
+	}
+
+	if _, err := GenerateStruct([]byte("> a\n> b\n"), "List"); err == nil {
+		t.Error("Expected an error for a root array")
+	}
+}
+
+// --- Documentation Generation ---
+
+type docServer struct {
+	Host   string     `piml:"host" pimldefault:"localhost" pimlcomment:"Address to bind"`
+	Parent *docServer `piml:"parent"`
+}
+
+func TestGenerateDocs(t *testing.T) {
+	type Config struct {
+		Name    string               `piml:"name,required" pimlcomment:"Service name | shown in logs"`
+		Port    int                  `piml:"port" pimldefault:"8080"`
+		Format  string               `piml:"format,enum=text|json"`
+		Timeout *time.Time           `piml:"timeout"`
+		Tags    map[string]struct{}  `piml:"tags"`
+		Servers []docServer          `piml:"servers"`
+		Zones   map[string]docServer `piml:"zones"`
+		Skipped string               `piml:"-"`
+	}
+
+	md, err := GenerateDocs(&Config{}, DocMarkdown)
+	if err != nil {
+		t.Fatalf("GenerateDocs() error = %v", err)
+	}
+	want := "| Key | Type | Default | Required | Description |\n" +
+		"| --- | --- | --- | --- | --- |\n" +
+		"| `name` | string |  | yes | Service name \\| shown in logs |\n" +
+		"| `port` | int | `8080` |  |  |\n" +
+		"| `format` | one of text, json |  |  |  |\n" +
+		"| `timeout` | time.Time |  |  |  |\n" +
+		"| `tags` | set of string |  |  |  |\n" +
+		"| `servers` | list of object |  |  |  |\n" +
+		"| `servers[].host` | string | `localhost` |  | Address to bind |\n" +
+		"| `servers[].parent` | object |  |  |  |\n" +
+		"| `zones` | map of object |  |  |  |\n" +
+		"| `zones.*.host` | string | `localhost` |  | Address to bind |\n" +
+		"| `zones.*.parent` | object |  |  |  |\n"
+	if string(md) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, md)
+	}
+
+	page, err := GenerateDocs(docServer{}, DocHTML)
+	if err != nil {
+		t.Fatalf("GenerateDocs() error = %v", err)
+	}
+	if !strings.Contains(string(page), "<tr><td><code>host</code></td><td>string</td><td><code>localhost</code></td><td></td><td>Address to bind</td></tr>") {
+		t.Fatalf("Unexpected HTML:\n%s", page)
+	}
+
+	if _, err := GenerateDocs(map[string]int{}, DocMarkdown); err == nil {
+		t.Error("Expected an error for a map")
+	}
+}
+
+// --- Example Configs ---
+
+type exampleNode struct {
+	Name string       `piml:"name" pimldefault:"root"`
+	Next *exampleNode `piml:"next"`
+}
+
+func TestExampleFor(t *testing.T) {
+	type Upstream struct {
+		URL    string `piml:"url" pimldefault:"http://localhost:9000" pimlcomment:"Where requests go"`
+		Weight int    `piml:"weight" pimldefault:"1"`
+	}
+	type Config struct {
+		Host      string        `piml:"host" pimldefault:"localhost" pimlcomment:"Address to bind\nUse 0.0.0.0 for all interfaces"`
+		Port      int           `piml:"port,required" pimldefault:"8080"`
+		Timeout   time.Duration `piml:"timeout" pimldefault:"5000000000"`
+		Name      string        `piml:"name,required" pimlcomment:"Service name"`
+		Upstreams []Upstream    `piml:"upstreams"`
+		Tree      *exampleNode  `piml:"tree"`
+	}
+
+	input := &Config{Port: 9090}
+	data, err := ExampleFor(input)
+	if err != nil {
+		t.Fatalf("ExampleFor() error = %v", err)
+	}
+	want := "# Address to bind\n# Use 0.0.0.0 for all interfaces\n(host) localhost\n" +
+		"# (required)\n(port) 9090\n" +
+		"(timeout) 5000000000\n" +
+		"# Service name (required)\n(name) \n" +
+		"(upstreams)\n  > (Upstream)\n      # Where requests go\n      (url) http://localhost:9000\n      (weight) 1\n" +
+		"(tree)\n  (name) root\n  (next) nil\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+	if input.Host != "" || input.Upstreams != nil || input.Tree != nil {
+		t.Fatalf("Expected the input to be left alone, got %+v", input)
+	}
+
+	// The example reads back, comments and all.
+	var output Config
+	if err := Unmarshal(data, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Host != "localhost" || output.Port != 9090 || output.Timeout != 5*time.Second || len(output.Upstreams) != 1 || output.Tree.Name != "root" {
+		t.Fatalf("Unexpected output: %+v", output)
+	}
+
+	type Bad struct {
+		Port int `piml:"port" pimldefault:"http"`
+	}
+	if _, err := ExampleFor(Bad{}); err == nil || !strings.Contains(err.Error(), "invalid default for port") {
+		t.Fatalf("Expected an invalid default error, got %v", err)
+	}
+}
+
+// --- CSV ---
+
+func TestCSV(t *testing.T) {
+	type Host struct {
+		Name    string   `piml:"name"`
+		Port    int      `piml:"port"`
+		Level   LogLevel `piml:"level"`
+		Weight  *float64 `piml:"weight"`
+		Price   Decimal  `piml:"price"`
+		Skipped string   `piml:"-"`
+	}
+	weight := 0.5
+	hosts := []*Host{
+		{Name: "web, 1", Port: 80, Level: LogDebug, Weight: &weight, Price: "1.10"},
+		nil,
+		{Name: "db", Port: 5432, Level: LogInfo},
+	}
+
+	data, err := MarshalCSV(hosts)
+	if err != nil {
+		t.Fatalf("MarshalCSV() error = %v", err)
+	}
+	want := "name,port,level,weight,price\n\"web, 1\",80,debug,0.5,1.10\n,,,,\ndb,5432,info,,0\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var output []*Host
+	if err := UnmarshalCSV([]byte("port,name,extra,weight\n80,web,x,0.5\n5432,db,,\n"), &output); err != nil {
+		t.Fatalf("UnmarshalCSV() error = %v", err)
+	}
+	if len(output) != 2 || output[0].Name != "web" || output[0].Port != 80 || *output[0].Weight != 0.5 || output[1].Port != 5432 || output[1].Weight != nil {
+		t.Fatalf("Unexpected output: %+v", output)
+	}
+	// The string nil reads back as itself, and nil still clears pointers.
+	data, err = MarshalCSV([]Host{{Name: "nil"}})
+	if err != nil {
+		t.Fatalf("MarshalCSV() error = %v", err)
+	}
+	if err := UnmarshalCSV(data, &output); err != nil {
+		t.Fatalf("UnmarshalCSV() error = %v", err)
+	}
+	if len(output) != 1 || output[0].Name != "nil" {
+		t.Fatalf("Expected the name nil, got %+v from:\n%s", output, data)
+	}
+	if err := UnmarshalCSV([]byte("name,weight\nnil,nil\n"), &output); err != nil || output[0].Name != "nil" || output[0].Weight != nil {
+		t.Fatalf("Unexpected output %+v, error %v", output, err)
+	}
+	err = UnmarshalCSV([]byte("name,port\nweb,80\ndb,http\n"), &output)
+	if err == nil || !strings.Contains(err.Error(), `line 3: column "port"`) {
+		t.Fatalf("Expected an error on line 3, got %v", err)
+	}
+	type Nested struct {
+		Tags []string `piml:"tags"`
+	}
+	if _, err := MarshalCSV([]Nested{}); !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("Expected ErrUnsupportedType for a nested field, got %v", err)
+	}
+
+	// Tables round trip through PIML documents.
+	doc, err := CSVToPIML([]byte("name,port\nweb,80\ndb,\n"), "hosts")
+	if err != nil {
+		t.Fatalf("CSVToPIML() error = %v", err)
+	}
+	wantDoc := "(hosts)\n  > (item)\n      (name) web\n      (port) 80\n  > (item)\n      (name) db\n"
+	if string(doc) != wantDoc {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", wantDoc, doc)
+	}
+	table, err := PIMLToCSV(append([]byte("(title) x\n"), doc...), "hosts")
+	if err != nil || string(table) != "name,port\nweb,80\ndb,\n" {
+		t.Fatalf("Unexpected table %q, error %v", table, err)
+	}
+	if _, err := PIMLToCSV([]byte("(hosts)\n  > (item)\n      (tags)\n        > a\n"), "hosts"); err == nil {
+		t.Error("Expected an error for a nested list")
+	}
+	if _, err := PIMLToCSV(doc, "other"); err == nil {
+		t.Error("Expected an error for a missing key")
+	}
+}
+
+// --- Environment Variables ---
+
+func TestToEnv(t *testing.T) {
+	doc := []byte(`(database)
+  (host) localhost
+  (port) 5432
+  (max-conns) 10
+(tags)
+  > web
+  > api
+(owner) nil
+(motd)
+  hello
+  world
+`)
+	env, err := ToEnv(doc, "APP")
+	if err != nil {
+		t.Fatalf("ToEnv() error = %v", err)
+	}
+	want := []string{
+		"APP_DATABASE_HOST=localhost",
+		"APP_DATABASE_PORT=5432",
+		"APP_DATABASE_MAX_CONNS=10",
+		"APP_TAGS_0=web",
+		"APP_TAGS_1=api",
+		"APP_MOTD=hello\nworld",
+	}
+	if !reflect.DeepEqual(env, want) {
+		t.Fatalf("Expected %q, got %q", want, env)
+	}
+
+	env, err = ToEnv([]byte("(port) 80\n"), "")
+	if err != nil || !reflect.DeepEqual(env, []string{"PORT=80"}) {
+		t.Fatalf("Unexpected %q, error %v", env, err)
+	}
+
+	// Both paths make APP_A_B.
+	if env, err := ToEnv([]byte("(a-b) 1\n(a)\n  (b) 2\n"), "APP"); err == nil {
+		t.Fatalf("Expected an error for clashing names, got %q", env)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	env := []string{
+		"PATH=/usr/bin",
+		"APP_TAGS_1=api",
+		"APP_DATABASE_PORT=5432",
+		"APP_DATABASE_HOST=localhost",
+		"APP_TAGS_0=web",
+		"APPLE=1",
+	}
+	data, err := FromEnv(env, "APP")
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	want := "(database)\n  (host) localhost\n  (port) 5432\n(tags)\n  > web\n  > api\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var cfg struct {
+		Database struct {
+			Host string `piml:"host"`
+			Port int    `piml:"port"`
+		} `piml:"database"`
+		Tags []string `piml:"tags"`
+	}
+	if err := Unmarshal(data, &cfg); err != nil || cfg.Database.Port != 5432 || len(cfg.Tags) != 2 {
+		t.Fatalf("Unexpected %+v, error %v", cfg, err)
+	}
+
+	if _, err := FromEnv([]string{"APP_DB=x", "APP_DB_HOST=y"}, "APP"); err == nil {
+		t.Fatal("Expected an error for a variable that is both a value and a section")
+	}
+	if _, err := FromEnv([]string{"APP_DB__HOST=y"}, "APP"); err == nil {
+		t.Fatal("Expected an error for an empty key")
+	}
+
+	// Keys with dots or dashes come back nested; line breaks are kept.
+	env, err = ToEnv([]byte("(db.host) x\n(max-conns) 10\n(motd)\n  hello\n  world\n"), "APP")
+	if err != nil {
+		t.Fatalf("ToEnv() error = %v", err)
+	}
+	data, err = FromEnv(env, "APP")
+	if err != nil {
+		t.Fatalf("FromEnv() error = %v", err)
+	}
+	want = "(db)\n  (host) x\n(max)\n  (conns) 10\n(motd)\n  hello\n  world\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+}
+
+// --- Dotted Keys ---
+
+func TestDottedKeys(t *testing.T) {
+	type Config struct {
+		Name     string `piml:"name"`
+		PoolSize int    `piml:"db.pool.size"`
+		Host     string `piml:"db.host"`
+		Timeout  int    `piml:"db.pool.timeout,omitzero"`
+		Debug    bool   `piml:"debug"`
+		Replica  string `piml:"replica.host,omitzero"`
+	}
+	cfg := Config{Name: "app", PoolSize: 10, Host: "localhost", Debug: true}
+	data, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `(name) app
+(db)
+  (pool)
+    (size) 10
+  (host) localhost
+(debug) true
+`
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var got Config
+	doc := `(db)
+  (host) db.internal
+  (pool)
+    (timeout) 30
+    (unknown) 1
+(name) app
+(db)
+  (pool)
+    (size) 20
+`
+	if err := Unmarshal([]byte(doc), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want2 := Config{Name: "app", PoolSize: 20, Host: "db.internal", Timeout: 30}
+	if got != want2 {
+		t.Fatalf("Expected %+v, got %+v", want2, got)
+	}
+
+	// A field keyed db shadows the dotted keys under it.
+	type Shadowed struct {
+		DB   map[string]string `piml:"db"`
+		Host string            `piml:"db.host"`
+	}
+	var s Shadowed
+	if err := Unmarshal([]byte("(db)\n  (host) x\n"), &s); err != nil || s.DB["host"] != "x" || s.Host != "" {
+		t.Fatalf("Unexpected %+v, error %v", s, err)
+	}
+}
+
+// --- Flat Documents ---
+
+func TestFlat(t *testing.T) {
+	type Server struct {
+		Host string `piml:"host"`
+	}
+	type Config struct {
+		Name    string            `piml:"name"`
+		DB      map[string]string `piml:"db"`
+		Tags    []string          `piml:"tags"`
+		Servers []Server          `piml:"servers"`
+		Notes   string            `piml:"notes"`
+		Owner   *string           `piml:"owner"`
+	}
+	cfg := Config{
+		Name:    "app",
+		DB:      map[string]string{"host": "localhost", "port": "5432"},
+		Tags:    []string{"web", "api"},
+		Servers: []Server{{Host: "a"}, {Host: "b"}},
+		Notes:   "line 1\n# line 2",
+	}
+
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.SetFlat(true)
+	if err := e.Encode(cfg); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	want := `(name) app
+(db.host) localhost
+(db.port) 5432
+(tags.0) web
+(tags.1) api
+(servers.0.host) a
+(servers.1.host) b
+(notes)
+  line 1
+  \# line 2
+(owner) nil
+`
+	if b.String() != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, b.String())
+	}
+
+	var got Config
+	d := NewDecoder(b.Bytes())
+	d.SetFlat(true)
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Fatalf("Expected %+v, got %+v", cfg, got)
+	}
+
+	// Flat and nested keys mix.
+	var mixed Config
+	err := UnmarshalWithOptions([]byte("(db.host) x\n(db)\n  (port) 1\n"), &mixed, DecoderOptions{Flat: true})
+	if err != nil || mixed.DB["host"] != "x" || mixed.DB["port"] != "1" {
+		t.Fatalf("Unexpected %+v, error %v", mixed, err)
+	}
+	if err := UnmarshalWithOptions([]byte("(db) x\n(db.host) y\n"), &mixed, DecoderOptions{Flat: true}); err == nil {
+		t.Fatal("Expected an error for a key that is both a value and nested keys")
+	}
+
+	data, err := MarshalWithOptions(42, EncoderOptions{Flat: true})
+	if err != nil || string(data) != "42\n" {
+		t.Fatalf("Unexpected %q, error %v", data, err)
+	}
+
+	// Dots and backslashes in keys are escaped, so they read back whole.
+	type Labels struct {
+		M map[string]string `piml:"m"`
+	}
+	labels := Labels{M: map[string]string{"a.b": "1", `c\d`: "2", `e\.f`: "3"}}
+	data, err = MarshalWithOptions(labels, EncoderOptions{Flat: true})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if want := "(m.a\\\\.b) 1\n(m.c\\\\\\\\d) 2\n(m.e\\\\\\\\\\\\.f) 3\n"; string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+	var back Labels
+	if err := UnmarshalWithOptions(data, &back, DecoderOptions{Flat: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(back, labels) {
+		t.Fatalf("Expected %+v, got %+v", labels, back)
+	}
+}
+
+// --- Key Filters ---
+
+func TestKeyPrefixes(t *testing.T) {
+	doc := []byte(`(name) shared
+(database)
+  (host) db.internal
+  (pool)
+    (size) 10
+  (legacy)
+    (mode) old
+(cache)
+  (host) cache.internal
+  (broken
+(databases) 2
+`)
+	type Config struct {
+		Name     string `piml:"name"`
+		Database struct {
+			Host string            `piml:"host"`
+			Pool map[string]int    `piml:"pool"`
+			Old  map[string]string `piml:"legacy"`
+		} `piml:"database"`
+		Cache     map[string]string `piml:"cache"`
+		Databases int               `piml:"databases"`
+	}
+
+	var cfg Config
+	err := UnmarshalWithOptions(doc, &cfg, DecoderOptions{OnlyPrefixes: []string{"database."}})
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Name != "" || cfg.Cache != nil || cfg.Databases != 0 ||
+		cfg.Database.Host != "db.internal" || cfg.Database.Pool["size"] != 10 || cfg.Database.Old["mode"] != "old" {
+		t.Fatalf("Unexpected %+v", cfg)
+	}
+
+	cfg = Config{}
+	d := NewDecoder(doc)
+	d.SetOnlyPrefixes("database.pool.", "name")
+	d.SetSkipPrefixes("cache.")
+	if err := d.Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if cfg.Name != "shared" || cfg.Database.Host != "" || cfg.Database.Pool["size"] != 10 || cfg.Database.Old != nil {
+		t.Fatalf("Unexpected %+v", cfg)
+	}
+
+	cfg = Config{}
+	if err := UnmarshalWithOptions(doc, &cfg, DecoderOptions{SkipPrefixes: []string{"database.legacy.", "cache."}}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Name != "shared" || cfg.Database.Old != nil || cfg.Database.Host != "db.internal" || cfg.Databases != 2 {
+		t.Fatalf("Unexpected %+v", cfg)
+	}
+}
+
+// --- Nodes ---
+
+func TestNode(t *testing.T) {
+	doc := `(name) app
+(server)
+  (port) 8080
+  (tags)
+    >| a
+    >| b
+(admins)
+  > (item)
+      (name) ada
+  > nil
+(notes)
+  line 1
+  line 2
+(owner) nil
+(empty)
+`
+	n, err := ParseNode([]byte(doc))
+	if err != nil {
+		t.Fatalf("ParseNode() error = %v", err)
+	}
+	if n.Kind != ObjectNode || len(n.Children) != 6 || n.Children[0].Key != "name" {
+		t.Fatalf("Unexpected node %+v", n)
+	}
+	for path, want := range map[string]string{
+		"server.port":     "8080",
+		"server.tags[1]":  "b",
+		"admins[0].name":  "ada",
+		"notes":           "line 1\nline 2",
+		"server.missing":  "",
+		"admins[5]":       "",
+		"name[0]":         "",
+		"server..port":    "",
+		"admins[1]":       "",
+		"owner":           "",
+		"server.tags[0]x": "",
+	} {
+		got := ""
+		if c := n.Lookup(path); c != nil {
+			got = c.Value
+		}
+		if got != want {
+			t.Errorf("Lookup(%q) = %q, want %q", path, got, want)
+		}
+	}
+	if c := n.Lookup("owner"); c == nil || c.Kind != NilNode {
+		t.Errorf("Expected owner to be a nil node, got %+v", c)
+	}
+	if c := n.Lookup("empty"); c == nil || c.Kind != ObjectNode {
+		t.Errorf("Expected empty to be an object node, got %+v", c)
+	}
+
+	data, err := Marshal(n)
+	if err != nil || string(data) != doc {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", doc, data, err)
+	}
+
+	// Nodes in structs hold whatever is under their key.
+	var cfg struct {
+		Name   string `piml:"name"`
+		Server Node   `piml:"server"`
+		Owner  *Node  `piml:"owner"`
+	}
+	if err := Unmarshal([]byte(doc), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Server.Kind != ObjectNode || cfg.Server.Lookup("port").Value != "8080" || cfg.Owner.Kind != NilNode {
+		t.Fatalf("Unexpected %+v", cfg)
+	}
+	data, err = Marshal(cfg)
+	want := "(name) app\n(server)\n  (port) 8080\n  (tags)\n    >| a\n    >| b\n(owner) nil\n"
+	if err != nil || string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s\nError: %v", want, data, err)
+	}
+}
+
+func TestDoc(t *testing.T) {
+	type Admin struct {
+		Name string `piml:"name"`
+	}
+	doc := NewDoc().
+		Set("server.port", 8080).
+		Set("server.host", "localhost").
+		Set("debug", true).
+		AppendArray("admins", Admin{Name: "ada"}, Admin{Name: "bob"}).
+		AppendArray("tags", "web").
+		AppendArray("tags", "api").
+		Set("admins[1].name", "eve").
+		Set("server.port", 9090)
+	data, err := doc.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	want := `(server)
+  (port) 9090
+  (host) localhost
+(debug) true
+(admins)
+  > (item)
+      (name) ada
+  > (item)
+      (name) eve
+(tags)
+  > web
+  > api
+`
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+	if doc.Node().Lookup("server.host").Value != "localhost" {
+		t.Fatal("Expected the node tree to hold server.host")
+	}
+
+	for name, d := range map[string]*Doc{
+		"index past the end": NewDoc().Set("admins[0].name", "x"),
+		"key in a scalar":    NewDoc().Set("port", 1).Set("port.x", 2),
+		"list of lists":      NewDoc().AppendArray("a", []int{1}),
+		"append to a scalar": NewDoc().Set("a", 1).AppendArray("a", 2),
+		"bad path":           NewDoc().Set("a..b", 1),
+	} {
+		if _, err := d.Bytes(); err == nil || d.Err() == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// --- Editor ---
+
+func TestEditor(t *testing.T) {
+	src := "# Server settings\n" +
+		"(server)\n" +
+		"    (host) localhost\n" +
+		"    # the port\n" +
+		"    (port) 8080\n" +
+		"\n" +
+		"(features)\n" +
+		"    > a\n" +
+		"    > b\n" +
+		"(admins)\n" +
+		"    > (item)\n" +
+		"            (name) ada\n" +
+		"(legacy) nil\n" +
+		"# footer\n"
+	ed, err := NewEditor([]byte(src))
+	if err != nil {
+		t.Fatalf("NewEditor() error = %v", err)
+	}
+	for _, set := range []struct {
+		path  string
+		value interface{}
+	}{
+		{"server.port", 9090},
+		{"server.tls.cert", "x.pem"},
+		{"admins[0].role", "root"},
+		{"legacy.on", true},
+		{"features[1]", "c"},
+		{"debug", false},
+	} {
+		if err := ed.Set(set.path, set.value); err != nil {
+			t.Fatalf("Set(%q) error = %v", set.path, err)
+		}
+	}
+	if err := ed.Delete("features[0]"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	want := "# Server settings\n" +
+		"(server)\n" +
+		"    (host) localhost\n" +
+		"    # the port\n" +
+		"    (port) 9090\n" +
+		"    (tls)\n" +
+		"        (cert) x.pem\n" +
+		"\n" +
+		"(features)\n" +
+		"    > c\n" +
+		"(admins)\n" +
+		"    > (item)\n" +
+		"            (name) ada\n" +
+		"            (role) root\n" +
+		"(legacy)\n" +
+		"    (on) true\n" +
+		"(debug) false\n" +
+		"# footer\n"
+	if got := string(ed.Bytes()); got != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+	if n := ed.Lookup("server.tls.cert"); n == nil || n.Value != "x.pem" {
+		t.Fatalf("Lookup() = %v, want x.pem", n)
+	}
+
+	// The last item leaves a nil list, and values are replaced whole.
+	if err := ed.Delete("features[0]"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if err := ed.Set("server", map[string]int{"port": 1}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	want = "# Server settings\n" +
+		"(server)\n" +
+		"    (port) 1\n" +
+		"\n" +
+		"(features) nil\n"
+	if got := string(ed.Bytes()); !strings.HasPrefix(got, want) {
+		t.Fatalf("Expected a document starting with:\n%s\nGot:\n%s", want, got)
+	}
+
+	for path, value := range map[string]interface{}{
+		"admins[3]":        1,
+		"admins[0].name.x": 1,
+		"":                 1,
+	} {
+		if err := ed.Set(path, value); err == nil {
+			t.Errorf("Set(%q) expected an error", path)
+		}
+	}
+	if err := ed.Delete("missing"); err == nil {
+		t.Error("Delete() of a missing key expected an error")
+	}
+	if _, err := NewEditor([]byte("(a\n")); err == nil {
+		t.Error("NewEditor() of invalid PIML expected an error")
+	}
+}
+
+func TestDelete(t *testing.T) {
+	src := "(name) app\n" +
+		"\n" +
+		"# Old settings, to be removed\n" +
+		"(legacy_section)\n" +
+		"  (mode) classic\n" +
+		"\n" +
+		"(features)\n" +
+		"  > a\n" +
+		"  # the b feature\n" +
+		"  > b\n" +
+		"  > c\n"
+	data, err := Delete([]byte(src), "features[1]")
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	want := strings.Replace(src, "  > b\n", "", 1)
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	ed, err := NewEditor([]byte(src))
+	if err != nil {
+		t.Fatalf("NewEditor() error = %v", err)
+	}
+	ed.SetDeleteComments(true)
+	for _, path := range []string{"legacy_section", "features[1]"} {
+		if err := ed.Delete(path); err != nil {
+			t.Fatalf("Delete(%q) error = %v", path, err)
+		}
+	}
+	want = "(name) app\n" +
+		"\n" +
+		"(features)\n" +
+		"  > a\n" +
+		"  > c\n"
+	if got := string(ed.Bytes()); got != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+
+	if _, err := Delete([]byte(src), "features[3]"); err == nil {
+		t.Error("Delete() of a missing item expected an error")
+	}
+
+	doc := NewDoc().Set("a", 1).Set("b.c", 2).AppendArray("l", "x", "y").Delete("b.c").Delete("l[0]").Delete("a")
+	data, err = doc.Bytes()
+	if err != nil {
+		t.Fatalf("Bytes() error = %v", err)
+	}
+	if want := "(b)\n(l)\n  > y\n"; string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+	if NewDoc().Delete("a").Err() == nil {
+		t.Error("Doc.Delete() of a missing key expected an error")
+	}
+}
+
+func TestEditorFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.piml")
+	if err := os.WriteFile(name, []byte("(name) app\r\n(port) 80"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ed, err := OpenEditor(name)
+	if err != nil {
+		t.Fatalf("OpenEditor() error = %v", err)
+	}
+	if err := ed.Set("port", 8080); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := ed.Set("debug", true); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := ed.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(name) app\r\n(port) 8080\r\n(debug) true\r\n"; string(data) != want {
+		t.Fatalf("Expected %q, got %q", want, data)
+	}
+	if err := (&Editor{}).Save(); err == nil {
+		t.Error("Save() without a file expected an error")
+	}
+}
+
+// --- Highlighting ---
+
+func TestHighlight(t *testing.T) {
+	src := "# Config\n(name) app # the name\n(tags)\n  > web\n(ids)\n  >| 1\n(admins)\n  > (item)\n      (owner) nil\n(text)\n  line\n"
+	var b bytes.Buffer
+	if err := Highlight(&b, []byte(src), HighlightOptions{InlineComments: true}); err != nil {
+		t.Fatalf("Highlight() error = %v", err)
+	}
+	key, scalar, null, marker, comment, reset := "\x1b[36m", "\x1b[32m", "\x1b[35m", "\x1b[33m", "\x1b[90m", "\x1b[0m"
+	want := comment + "# Config" + reset + "\n" +
+		key + "(name)" + reset + " " + scalar + "app" + reset + " " + comment + "# the name" + reset + "\n" +
+		key + "(tags)" + reset + "\n" +
+		"  " + marker + ">" + reset + " " + scalar + "web" + reset + "\n" +
+		key + "(ids)" + reset + "\n" +
+		"  " + marker + ">|" + reset + " " + scalar + "1" + reset + "\n" +
+		key + "(admins)" + reset + "\n" +
+		"  " + marker + ">" + reset + " " + key + "(item)" + reset + "\n" +
+		"      " + key + "(owner)" + reset + " " + null + "nil" + reset + "\n" +
+		key + "(text)" + reset + "\n" +
+		"  " + scalar + "line" + reset + "\n"
+	if b.String() != want {
+		t.Fatalf("Expected:\n%q\nGot:\n%q", want, b.String())
+	}
+
+	// Without colors, only the line numbers are added.
+	b.Reset()
+	src = strings.Repeat("(a) 1\r\n", 10) + "(broken"
+	if err := Highlight(&b, []byte(src), HighlightOptions{LineNumbers: true, NoColor: true}); err != nil {
+		t.Fatalf("Highlight() error = %v", err)
+	}
+	want = " 1 | (a) 1\r\n 2 | (a) 1\r\n"
+	if got := b.String(); !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "10 | (a) 1\r\n11 | (broken") {
+		t.Fatalf("Got:\n%q", got)
+	}
+}
+
+// --- Tree ---
+
+func TestTree(t *testing.T) {
+	src := `(server)
+  (host) localhost
+  (port) 8080
+(admins)
+  > (User)
+      (name) ada
+      (role) nil
+  > (User)
+      (name) bob
+      (role) admin
+(tags)
+  >| web
+  >| api
+(ratio) 1.5
+(limits)
+  > 1
+  > 2.5
+(mixed)
+  > 1
+  > (item)
+(legacy) nil
+`
+	got, err := Tree([]byte(src))
+	if err != nil {
+		t.Fatalf("Tree() error = %v", err)
+	}
+	want := "object (7 keys)\n" +
+		"|-- server: object (2 keys)\n" +
+		"|   |-- host: string\n" +
+		"|   `-- port: int\n" +
+		"|-- admins: list[2] of User (2 keys)\n" +
+		"|   |-- name: string\n" +
+		"|   `-- role: string\n" +
+		"|-- tags: set[2] of string\n" +
+		"|-- ratio: float\n" +
+		"|-- limits: list[2] of float\n" +
+		"|-- mixed: list[2] of mixed\n" +
+		"`-- legacy: nil\n"
+	if got != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, got)
+	}
+
+	if got, _ := Tree([]byte("> a\n> b\n")); got != "list[2] of string\n" {
+		t.Errorf("Tree() of a root list = %q", got)
+	}
+	if got, _ := Tree([]byte("> (item)\n    (a) 1\n")); got != "list[1] of object (1 key)\n`-- a: int\n" {
+		t.Errorf("Tree() of a list of unnamed items = %q", got)
+	}
+	if _, err := Tree([]byte("(a\n")); err == nil {
+		t.Error("Tree() of invalid PIML expected an error")
+	}
+}
+
+// --- Inspection ---
+
+func TestInspect(t *testing.T) {
+	src := `# Service config
+(name) api
+(server)
+  (port) 8080
+  (ratio) 0.5
+(admins)
+  > (item)
+      (name) ada
+      (active) true
+  > (item)
+      (name) bob
+(tags)
+  >| web
+(legacy) nil
+`
+	info, err := Inspect([]byte(src))
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	want := Inspection{
+		Bytes:    len(src),
+		Lines:    14,
+		Comments: 1,
+		Keys:     10,
+		MaxDepth: 3,
+		Lists:    map[string]int{"admins": 2, "tags": 1},
+		Scalars:  map[string]int{"string": 4, "int": 1, "float": 1, "bool": 1, "nil": 1},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Fatalf("Expected %+v, got %+v", want, info)
+	}
+
+	if info, _ := Inspect(nil); info.MaxDepth != 0 || info.Keys != 0 {
+		t.Errorf("Inspect() of an empty document = %+v", info)
+	}
+	if info, _ := Inspect([]byte("42\n")); info.MaxDepth != 1 || info.Scalars["int"] != 1 {
+		t.Errorf("Inspect() of a root scalar = %+v", info)
+	}
+	if _, err := Inspect([]byte("(a\n")); err == nil {
+		t.Error("Inspect() of invalid PIML expected an error")
+	}
+}
+
+// --- Lenient Coercion ---
+
+func TestLenientCoercion(t *testing.T) {
+	type Limits struct {
+		Max int `piml:"max"`
+	}
+	type Config struct {
+		Port    int     `piml:"port"`
+		Mask    uint32  `piml:"mask"`
+		Ratio   float64 `piml:"ratio"`
+		Debug   bool    `piml:"debug"`
+		Verbose bool    `piml:"verbose"`
+		IDs     []int   `piml:"ids"`
+		Exact   int     `piml:"exact,strict"`
+		Loose   Limits  `piml:"loose,lenient"`
+	}
+	doc := []byte(`(port) 8_080.0
+(mask) 0xff
+(ratio) 1_000.5
+(debug) Yes
+(verbose) off
+(ids)
+  > 0b11
+  > 1_0
+(exact) 7
+(loose)
+  (max) 1e3
+`)
+	var cfg Config
+	if err := UnmarshalWithOptions(doc, &cfg, DecoderOptions{Lenient: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{Port: 8080, Mask: 255, Ratio: 1000.5, Debug: true, IDs: []int{3, 10}, Exact: 7, Loose: Limits{Max: 1000}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// Strict fields stay strict, and lenient ones are lenient on their own.
+	var strict Config
+	err := UnmarshalWithOptions([]byte("(exact) 7.0\n"), &strict, DecoderOptions{Lenient: true})
+	if err == nil {
+		t.Error("Expected an error for a sloppy strict field")
+	}
+	if err := Unmarshal([]byte("(loose)\n  (max) 2_000\n"), &strict); err != nil || strict.Loose.Max != 2000 {
+		t.Errorf("Unmarshal() of a lenient field = %+v, %v", strict.Loose, err)
+	}
+	for _, tt := range []struct {
+		src     string
+		lenient bool
+	}{
+		{"(port) 8080.5\n", true},
+		{"(debug) maybe\n", true},
+		{"(port) 1_0\n", false},
+	} {
+		var c Config
+		if err := UnmarshalWithOptions([]byte(tt.src), &c, DecoderOptions{Lenient: tt.lenient}); err == nil {
+			t.Errorf("Unmarshal(%q) expected an error", tt.src)
+		}
+	}
+}
+
+// --- Number Modes ---
+
+func TestNumberMode(t *testing.T) {
+	doc := []byte(`(id) 9007199254740993
+(ratio) 0.5
+(zip) 01234
+(name) app
+(limits)
+  > 10
+  > 2e3
+(ports)
+  >| 80
+`)
+	tests := []struct {
+		mode NumberMode
+		want map[string]interface{}
+	}{
+		{NumberString, map[string]interface{}{
+			"id": "9007199254740993", "ratio": "0.5", "zip": "01234", "name": "app",
+			"limits": []interface{}{"10", "2e3"}, "ports": []interface{}{"80"},
+		}},
+		{NumberInt64, map[string]interface{}{
+			"id": int64(9007199254740993), "ratio": 0.5, "zip": "01234", "name": "app",
+			"limits": []interface{}{int64(10), 2000.0}, "ports": []interface{}{int64(80)},
+		}},
+		{NumberFloat64, map[string]interface{}{
+			"id": 9007199254740992.0, "ratio": 0.5, "zip": "01234", "name": "app",
+			"limits": []interface{}{10.0, 2000.0}, "ports": []interface{}{80.0},
+		}},
+		{NumberType, map[string]interface{}{
+			"id": Number("9007199254740993"), "ratio": Number("0.5"), "zip": "01234", "name": "app",
+			"limits": []interface{}{Number("10"), Number("2e3")}, "ports": []interface{}{Number("80")},
+		}},
+	}
+	for _, tt := range tests {
+		var got map[string]interface{}
+		if err := UnmarshalWithOptions(doc, &got, DecoderOptions{NumberMode: tt.mode}); err != nil {
+			t.Fatalf("mode %d: Unmarshal() error = %v", tt.mode, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mode %d:\nExpected %#v\nGot      %#v", tt.mode, tt.want, got)
+		}
+	}
+
+	// Numbers read as they were written, and write back the same way.
+	var v interface{}
+	if err := UnmarshalWithOptions(doc, &v, DecoderOptions{NumberMode: NumberType}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	n := v.(map[string]interface{})["id"].(Number)
+	if i, err := n.Int64(); err != nil || i != 9007199254740993 {
+		t.Errorf("Int64() = %d, %v", i, err)
+	}
+	data, err := Marshal(map[string]interface{}{"ratio": Number("0.50")})
+	if err != nil || string(data) != "(ratio) 0.50\n" {
+		t.Errorf("Marshal() = %q, %v", data, err)
+	}
+
+	// Value types scalars as the decoder does, such as the text of nodes.
+	for _, tt := range []struct {
+		mode NumberMode
+		in   string
+		want interface{}
+	}{
+		{NumberInt64, "8080", int64(8080)},
+		{NumberInt64, "18446744073709551615", uint64(18446744073709551615)},
+		{NumberInt64, "-9223372036854775809", -9223372036854775809.0},
+		{NumberInt64, "007", "007"},
+		{NumberFloat64, "8080", 8080.0},
+		{NumberType, "1.50", Number("1.50")},
+		{NumberString, "8080", "8080"},
+		{NumberInt64, "true", "true"},
+	} {
+		if got := tt.mode.Value(tt.in); got != tt.want {
+			t.Errorf("mode %d: Value(%q) = %#v, expected %#v", tt.mode, tt.in, got, tt.want)
+		}
+	}
+}
+
+// --- Documents in Strings ---
+
+func TestPIMLString(t *testing.T) {
+	type CacheConfig struct {
+		Size int    `piml:"size"`
+		TTL  string `piml:"ttl"`
+	}
+	type Plugin struct {
+		Name   string       `piml:"name"`
+		Raw    RawMessage   `piml:"raw"`
+		Config *CacheConfig `piml:"config,pimlstring"`
+		None   *CacheConfig `piml:"none,pimlstring"`
+	}
+	in := Plugin{
+		Name:   "cache",
+		Raw:    RawMessage("# Tuned by hand\n(size) 64\n\n(tags)\n  > hot\n"),
+		Config: &CacheConfig{Size: 32, TTL: "5m"},
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `(name) cache
+(raw)
+  | # Tuned by hand
+  | (size) 64
+  |
+  | (tags)
+  |   > hot
+(config)
+  | (size) 32
+  | (ttl) 5m
+(none) nil
+`
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var out Plugin
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("Expected %+v, got %+v", in, out)
+	}
+
+	// The raw document decodes later, and pimlstring fields may be
+	// written in place too.
+	var cache CacheConfig
+	if err := Unmarshal(out.Raw, &cache); err != nil || cache.Size != 64 {
+		t.Errorf("Unmarshal() of the raw document = %+v, %v", cache, err)
+	}
+	out = Plugin{}
+	if err := Unmarshal([]byte("(config)\n  (size) 8\n"), &out); err != nil || out.Config.Size != 8 {
+		t.Errorf("Unmarshal() of a document in place = %+v, %v", out.Config, err)
+	}
+
+	for _, src := range []string{
+		"(config)\n  | (size) big\n",
+		"(config)\n  | (size) 1\n  plain text\n",
+		"(raw)\n  (size) 1\n",
+	} {
+		if err := Unmarshal([]byte(src), &out); err == nil {
+			t.Errorf("Unmarshal(%q) expected an error", src)
+		}
+	}
+	err = Unmarshal([]byte("(config)\n  | (size) big\n"), &out)
+	if err == nil || !strings.Contains(err.Error(), "piml: in the document at line 2: ") {
+		t.Errorf("Expected an error in the document at line 2, got %v", err)
+	}
+
+	// The document is written with the encoder's settings.
+	type Commented struct {
+		Size int    `piml:"size" pimlcomment:"Entries kept"`
+		Note string `piml:"note"`
+	}
+	var commented struct {
+		Config *Commented `piml:"config,pimlstring"`
+	}
+	commented.Config = &Commented{Size: 8, Note: "a # b"}
+	var b bytes.Buffer
+	e := NewEncoder(&b)
+	e.SetComments(true)
+	e.SetInlineComments(true)
+	if err := e.Encode(commented); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	wantCommented := "(config)\n  | # Entries kept\n  | (size) 8\n  | (note) a \\# b\n"
+	if b.String() != wantCommented {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", wantCommented, b.String())
+	}
+	commented.Config = nil
+	d := NewDecoder(b.Bytes())
+	d.SetInlineComments(true)
+	if err := d.Decode(&commented); err != nil || commented.Config.Note != "a # b" {
+		t.Fatalf("Decode() = %+v, %v", commented.Config, err)
+	}
+
+	// The document is read with the decoder's settings, at the path of
+	// its field.
+	type Tags struct {
+		Tags []string `piml:"tags"`
+	}
+	var tagged struct {
+		Config *Tags `piml:"config,pimlstring"`
+	}
+	tagged.Config = &Tags{Tags: []string{"a"}}
+	d = NewDecoder([]byte("(config)\n  | (tags)\n  |   > b\n"))
+	d.SetAppendSlices(true)
+	d.SetPartial(true)
+	if err := d.Decode(&tagged); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if !reflect.DeepEqual(tagged.Config.Tags, []string{"a", "b"}) {
+		t.Errorf("Expected the item to be appended, got %q", tagged.Config.Tags)
+	}
+	if paths := d.DecodedPaths(); len(paths) < 2 || paths[len(paths)-2] != "config.tags" || paths[len(paths)-1] != "config" {
+		t.Errorf("Unexpected decoded paths %q", paths)
+	}
+}
+
+// --- Checksums ---
+
+func TestChecksum(t *testing.T) {
+	type Config struct {
+		Name string `piml:"name"`
+		Port int    `piml:"port"`
+	}
+	in := Config{Name: "app", Port: 8080}
+	for _, crlf := range []bool{false, true} {
+		data, err := MarshalWithOptions(in, EncoderOptions{Checksum: true, CRLF: crlf})
+		if err != nil {
+			t.Fatalf("Marshal() error = %v", err)
+		}
+		body, trailer, _ := strings.Cut(string(data), "(checksum) ")
+		sum := sha256.Sum256([]byte(body))
+		eol := "\n"
+		if crlf {
+			eol = "\r\n"
+		}
+		if want := "sha256:" + hex.EncodeToString(sum[:]) + eol; trailer != want {
+			t.Fatalf("Expected trailer %q, got %q", want, trailer)
+		}
+
+		var out Config
+		if err := UnmarshalWithOptions(data, &out, DecoderOptions{Checksum: true}); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		if out != in {
+			t.Fatalf("Expected %+v, got %+v", in, out)
+		}
+	}
+
+	data, _ := MarshalWithOptions(in, EncoderOptions{Checksum: true})
+	for name, doc := range map[string]string{
+		"tampered":   strings.Replace(string(data), "8080", "8081", 1),
+		"truncated":  string(data[strings.Index(string(data), "(port)"):]),
+		"no trailer": "(name) app\n",
+		"bad sum":    "(name) app\n(checksum) sha256:abc\n",
+	} {
+		var out Config
+		err := UnmarshalWithOptions([]byte(doc), &out, DecoderOptions{Checksum: true})
+		if !errors.Is(err, ErrChecksum) {
+			t.Errorf("%s: expected ErrChecksum, got %v", name, err)
+		}
+	}
+	if err := NewDecoderWithOptions(data, DecoderOptions{Checksum: true}).Walk(Handler{}); err != nil {
+		t.Errorf("Walk() error = %v", err)
+	}
+
+	// Decoding tampered input again fails again.
+	d := NewDecoderWithOptions([]byte(strings.Replace(string(data), "8080", "8081", 1)), DecoderOptions{Checksum: true})
+	for i := 0; i < 2; i++ {
+		var out Config
+		if err := d.Decode(&out); !errors.Is(err, ErrChecksum) {
+			t.Errorf("Decode() #%d: expected ErrChecksum, got %v", i+1, err)
+		}
+	}
+}
+
+// --- Signatures ---
+
+func TestSignatures(t *testing.T) {
+	type Config struct {
+		Name string `piml:"name"`
+		Port int    `piml:"port"`
+	}
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(data []byte) ([]byte, error) {
+		return ed25519.Sign(private, data), nil
+	}
+	verify := func(data, sig []byte) error {
+		if !ed25519.Verify(public, data, sig) {
+			return errors.New("ed25519 verification failed")
+		}
+		return nil
+	}
+
+	in := Config{Name: "edge", Port: 8080}
+	data, err := MarshalWithOptions(in, EncoderOptions{Checksum: true, Signer: sign})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "(checksum) ") || !strings.HasPrefix(lines[3], "(signature) ") {
+		t.Fatalf("Expected checksum and signature trailers, got:\n%s", data)
+	}
+	var out Config
+	if err := UnmarshalWithOptions(data, &out, DecoderOptions{Checksum: true, Verifier: verify}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if out != in {
+		t.Fatalf("Expected %+v, got %+v", in, out)
+	}
+
+	// A detached signature covers the whole file.
+	plain, _ := Marshal(in)
+	out = Config{}
+	opts := DecoderOptions{Verifier: verify, Signature: ed25519.Sign(private, plain)}
+	if err := UnmarshalWithOptions(plain, &out, opts); err != nil || out != in {
+		t.Fatalf("Unmarshal() with a detached signature = %+v, %v", out, err)
+	}
+
+	for name, tt := range map[string]struct {
+		doc  string
+		opts DecoderOptions
+	}{
+		"tampered":     {strings.Replace(string(data), "edge", "evil", 1), DecoderOptions{Verifier: verify}},
+		"unsigned":     {string(plain), DecoderOptions{Verifier: verify}},
+		"bad base64":   {string(plain) + "(signature) !!!\n", DecoderOptions{Verifier: verify}},
+		"wrong detach": {string(plain), DecoderOptions{Verifier: verify, Signature: []byte("nope")}},
+	} {
+		d := NewDecoderWithOptions([]byte(tt.doc), tt.opts)
+		for i := 0; i < 2; i++ {
+			// Decoding again fails again.
+			if err := d.Decode(&out); !errors.Is(err, ErrSignature) {
+				t.Errorf("%s: Decode() #%d: expected ErrSignature, got %v", name, i+1, err)
+			}
+		}
+	}
+
+	failing := func([]byte) ([]byte, error) { return nil, errors.New("no key") }
+	if _, err := MarshalWithOptions(in, EncoderOptions{Signer: failing}); err == nil {
+		t.Error("Marshal() with a failing signer expected an error")
+	}
+}
+
+// --- Encrypted Values ---
+
+// xorCipher is a stand-in for a KMS in tests.
+type xorCipher struct{ key byte }
+
+func (c xorCipher) Encrypt(plaintext []byte) ([]byte, error) {
+	out := make([]byte, len(plaintext))
+	for i, b := range plaintext {
+		out[i] = b ^ c.key
+	}
+	return out, nil
+}
+
+func (c xorCipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) == 0 {
+		return nil, errors.New("empty ciphertext")
+	}
+	return c.Encrypt(ciphertext)
+}
+
+func TestEncryptedValues(t *testing.T) {
+	type DB struct {
+		User     string  `piml:"user"`
+		Password string  `piml:"password,secret"`
+		PIN      int     `piml:"pin,secret"`
+		Token    *string `piml:"token,secret"`
+	}
+	c := xorCipher{key: 0x2a}
+	in := DB{User: "admin", Password: "hunter2", PIN: 1234}
+	data, err := MarshalWithOptions(in, EncoderOptions{Encryptor: c})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	encrypted := func(s string) string {
+		b, _ := c.Encrypt([]byte(s))
+		return "enc:v1:" + base64.StdEncoding.EncodeToString(b)
+	}
+	want := "(user) admin\n" +
+		"(password) " + encrypted("hunter2") + "\n" +
+		"(pin) " + encrypted("1234") + "\n" +
+		"(token) nil\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var out DB
+	if err := UnmarshalWithOptions(data, &out, DecoderOptions{Decryptor: c}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("Expected %+v, got %+v", in, out)
+	}
+
+	// Without a decryptor, values are kept as written.
+	var raw map[string]interface{}
+	if err := Unmarshal(data, &raw); err != nil || raw["password"] != encrypted("hunter2") {
+		t.Errorf("Unmarshal() without a decryptor = %v, %v", raw, err)
+	}
+
+	for _, src := range []string{
+		"(password) enc:v2:AAAA\n",
+		"(password) enc:v1:!!\n",
+		"(password) enc:v1:\n",
+	} {
+		if err := UnmarshalWithOptions([]byte(src), &out, DecoderOptions{Decryptor: c}); err == nil {
+			t.Errorf("Unmarshal(%q) expected an error", src)
+		}
+	}
+	type Bad struct {
+		Keys []string `piml:"keys,secret"`
+	}
+	if _, err := MarshalWithOptions(Bad{Keys: []string{"a"}}, EncoderOptions{Encryptor: c}); err == nil {
+		t.Error("Marshal() of a secret list expected an error")
+	}
+}
+
+// --- Appending to Slices ---
+
+func TestAppendSlices(t *testing.T) {
+	type Rule struct {
+		Path string `piml:"path"`
+	}
+	type Config struct {
+		Hosts  []string      `piml:"hosts"`
+		Ports  []int         `piml:"ports"`
+		Rules  []Rule        `piml:"rules"`
+		Extra  []interface{} `piml:"extra"`
+		Admins []string      `piml:"admins,append"`
+		Tags   []string      `piml:"tags"`
+	}
+	base := Config{
+		Hosts:  []string{"a"},
+		Ports:  []int{80},
+		Rules:  []Rule{{Path: "/"}},
+		Extra:  []interface{}{"x"},
+		Admins: []string{"ada"},
+		Tags:   []string{"old"},
+	}
+	overlay := []byte(`(hosts)
+  > b
+(ports)
+  > 443
+(rules)
+  > (item)
+      (path) /api
+(extra)
+  > y
+(admins)
+  > bob
+(tags) nil
+`)
+	cfg := base
+	if err := UnmarshalWithOptions(overlay, &cfg, DecoderOptions{AppendSlices: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{
+		Hosts:  []string{"a", "b"},
+		Ports:  []int{80, 443},
+		Rules:  []Rule{{Path: "/"}, {Path: "/api"}},
+		Extra:  []interface{}{"x", "y"},
+		Admins: []string{"ada", "bob"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// Without the option, only fields tagged append are appended to.
+	cfg = base
+	if err := Unmarshal(overlay, &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(cfg.Hosts, []string{"b"}) || !reflect.DeepEqual(cfg.Admins, []string{"ada", "bob"}) {
+		t.Fatalf("Expected hosts replaced and admins appended, got %+v", cfg)
+	}
+}
+
+// --- Overlays ---
+
+func TestOverlayDecode(t *testing.T) {
+	type DB struct {
+		Host string `piml:"host"`
+		Port int    `piml:"port"`
+	}
+	type Config struct {
+		Name    string            `piml:"name"`
+		Port    int               `piml:"port"`
+		Timeout *int              `piml:"timeout"`
+		DB      DB                `piml:"db"`
+		Labels  map[string]string `piml:"labels"`
+		Limits  map[string]DB     `piml:"limits"`
+	}
+	timeout := 30
+	base := func() Config {
+		return Config{
+			Name:    "svc",
+			Port:    8080,
+			Timeout: &timeout,
+			DB:      DB{Host: "localhost", Port: 5432},
+			Labels:  map[string]string{"team": "core", "tier": "1"},
+			Limits:  map[string]DB{"a": {Host: "h", Port: 1}},
+		}
+	}
+	overlay := []byte(`(port) 9090
+(timeout) nil
+(db)
+  (host) db.internal
+(labels)
+  (tier) 2
+(limits)
+  (a)
+    (port) 2
+`)
+
+	// Present keys overwrite, absent keys are untouched, nil clears.
+	cfg := base()
+	if err := Unmarshal(overlay, &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{
+		Name:   "svc",
+		Port:   9090,
+		DB:     DB{Host: "db.internal", Port: 5432},
+		Labels: map[string]string{"team": "core", "tier": "2"},
+		Limits: map[string]DB{"a": {Host: "h", Port: 2}},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// With KeepOnNil, nil leaves values and adds no entries.
+	cfg = base()
+	if err := UnmarshalWithOptions(overlay, &cfg, DecoderOptions{KeepOnNil: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want.Timeout = &timeout
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// Values that can't be nil are left as they are too.
+	cfg = base()
+	if err := UnmarshalWithOptions([]byte("(port) nil\n(labels)\n  (env) nil\n"), &cfg, DecoderOptions{KeepOnNil: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Port != 8080 || len(cfg.Labels) != 2 {
+		t.Fatalf("Expected port 8080 and 2 labels, got %+v", cfg)
+	}
+}
+
+func TestZeroTarget(t *testing.T) {
+	type Config struct {
+		Name   string            `piml:"name"`
+		Port   int               `piml:"port"`
+		Hosts  []string          `piml:"hosts"`
+		Labels map[string]string `piml:"labels"`
+	}
+	cfg := Config{
+		Name:   "old",
+		Port:   8080,
+		Hosts:  []string{"a"},
+		Labels: map[string]string{"stale": "yes"},
+	}
+	data := []byte("(name) new\n(labels)\n  (env) prod\n")
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{ZeroTarget: true}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{Name: "new", Labels: map[string]string{"env": "prod"}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+}
+
+// --- Deep Pointers ---
+
+type deepPointerItem struct {
+	X int `piml:"x"`
+}
+
+func TestDeepPointers(t *testing.T) {
+	type Config struct {
+		Name  *string              `piml:"name"`
+		Alias **string             `piml:"alias"`
+		Ports *[]*int              `piml:"ports"`
+		Level ***int               `piml:"level"`
+		Hosts **[]string           `piml:"hosts"`
+		Item  **deepPointerItem    `piml:"item"`
+		Items []**deepPointerItem  `piml:"items"`
+		Tags  *map[string]struct{} `piml:"tags"`
+		Nil   **string             `piml:"nil"`
+	}
+	name, port := "svc", 80
+	pName, pPort := &name, &port
+	ppPort := &pPort
+	var nilName *string
+	item := &deepPointerItem{X: 5}
+	in := Config{
+		Name:  pName,
+		Alias: &pName,
+		Ports: &[]*int{pPort, nil},
+		Level: &ppPort,
+		Hosts: nil,
+		Item:  &item,
+		Items: []**deepPointerItem{&item, nil},
+		Tags:  &map[string]struct{}{"a": {}},
+		Nil:   &nilName,
+	}
+	data, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := `(name) svc
+(alias) svc
+(ports)
+  > 80
+  > nil
+(level) 80
+(hosts) nil
+(item)
+  (x) 5
+(items)
+  > (deepPointerItem)
+      (x) 5
+  > nil
+(tags)
+  >| a
+(nil) nil
+`
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var out Config
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	again, err := Marshal(out)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("Expected a roundtrip, got:\n%s", again)
+	}
+	if **out.Alias != "svc" || ***out.Level != 80 || (*out.Ports)[1] != nil || (**out.Item).X != 5 || out.Items[1] != nil {
+		t.Fatalf("Unexpected decoded values: %+v", out)
+	}
+	// nil at an inner level decodes to a nil outer pointer.
+	if out.Nil != nil || out.Hosts != nil {
+		t.Fatalf("Expected nil pointers, got %v and %v", out.Nil, out.Hosts)
+	}
+}
+
+// --- Interface Fields ---
+
+type concreteHandler interface {
+	Describe() string
+}
+
+type concreteHTTP struct {
+	Addr string `piml:"addr"`
+	Port int    `piml:"port"`
+}
+
+func (h concreteHTTP) Describe() string { return fmt.Sprintf("http %s:%d", h.Addr, h.Port) }
+
+type concreteGRPC struct {
+	Target string `piml:"target"`
+}
+
+func (g *concreteGRPC) Describe() string { return "grpc " + g.Target }
+
+func TestInterfaceFields(t *testing.T) {
+	RegisterType[concreteHTTP]("test-http")
+	RegisterType[concreteGRPC]("test-grpc")
+	type Server struct {
+		Handler concreteHandler  `piml:"handler,as=test-http"`
+		Backend concreteHandler  `piml:"backend,as=test-grpc"`
+		Extra   *concreteHandler `piml:"extra,as=test-http"`
+	}
+
+	var s Server
+	data := []byte(`(handler)
+  (addr) localhost
+  (port) 8080
+(backend)
+  (target) dns:///api
+(extra)
+  (port) 1
+`)
+	if err := Unmarshal(data, &s); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got := s.Handler.Describe(); got != "http localhost:8080" {
+		t.Fatalf("Expected http localhost:8080, got %q", got)
+	}
+	if _, ok := s.Backend.(*concreteGRPC); !ok || s.Backend.Describe() != "grpc dns:///api" {
+		t.Fatalf("Expected a *concreteGRPC, got %#v", s.Backend)
+	}
+	if s.Extra == nil || (*s.Extra).Describe() != "http :1" {
+		t.Fatalf("Expected http :1, got %#v", s.Extra)
+	}
+
+	// Existing values are merged into, and nil clears them.
+	if err := Unmarshal([]byte("(handler)\n  (port) 9090\n(backend) nil\n"), &s); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got := s.Handler.Describe(); got != "http localhost:9090" {
+		t.Fatalf("Expected http localhost:9090, got %q", got)
+	}
+	if s.Backend != nil {
+		t.Fatalf("Expected a nil backend, got %#v", s.Backend)
+	}
+
+	// The encoding roundtrips.
+	out, err := Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	var again Server
+	if err := Unmarshal(out, &again); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(again, s) {
+		t.Fatalf("Expected %#v, got %#v", s, again)
+	}
+
+	errorCases := []struct {
+		name string
+		v    interface{}
+		want string
+	}{
+		{"unregistered", &struct {
+			H concreteHandler `piml:"h,as=test-missing"`
+		}{}, "no type registered"},
+		{"not an interface", &struct {
+			H concreteHTTP `piml:"h,as=test-http"`
+		}{}, "needs an interface field"},
+		{"not implemented", &struct {
+			H fmt.Stringer `piml:"h,as=test-http"`
+		}{}, "does not implement"},
+	}
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Unmarshal([]byte("(h)\n  (port) 1\n"), tc.v)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("Expected an error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
+
+// --- One-Way Fields ---
+
+func TestOneWayFields(t *testing.T) {
+	type Auth struct {
+		User  string `piml:"user"`
+		Token string `piml:"token,readonly"`
+	}
+	type Config struct {
+		Name   string   `piml:"name"`
+		Auth   Auth     `piml:"auth"`
+		Digest string   `piml:"digest,writeonly"`
+		Hosts  []string `piml:"hosts,writeonly"`
+	}
+	data := []byte(`(name) svc
+(auth)
+  (user) ada
+  (token) s3cr3t
+(digest) ignored
+(hosts)
+  > a
+`)
+	cfg := Config{Digest: "kept"}
+	if err := Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{Name: "svc", Auth: Auth{User: "ada", Token: "s3cr3t"}, Digest: "kept"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	cfg.Hosts = []string{"b"}
+	out, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	wantOut := `(name) svc
+(auth)
+  (user) ada
+(digest) kept
+(hosts)
+  > b
+`
+	if string(out) != wantOut {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", wantOut, out)
+	}
+}
+
+// --- Tag Keys ---
+
+func TestTagKeys(t *testing.T) {
+	type Config struct {
+		Name    string `json:"service_name"`
+		Port    int    `json:"port,omitempty" piml:"listen_port"`
+		Secret  string `json:"-"`
+		Region  string `config:"zone"`
+		Comment string
+	}
+	data := []byte(`(service_name) svc
+(listen_port) 8080
+(secret) s
+(zone) eu
+(comment) hi
+`)
+
+	// piml tags first, then json tags.
+	var cfg Config
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{TagKeys: []string{"piml", "json"}}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{Name: "svc", Port: 8080, Region: "", Comment: "hi"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+	var b bytes.Buffer
+	if err := NewEncoderWithOptions(&b, EncoderOptions{TagKeys: []string{"piml", "json"}}).Encode(cfg); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	wantOut := "(service_name) svc\n(listen_port) 8080\n(region) \n(comment) hi\n"
+	if b.String() != wantOut {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", wantOut, b.String())
+	}
+
+	// Only config tags.
+	cfg = Config{}
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{TagKeys: []string{"config"}}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want = Config{Secret: "s", Region: "eu", Comment: "hi"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// The default is unaffected by the other keys.
+	cfg = Config{}
+	if err := Unmarshal(data, &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want = Config{Port: 8080, Secret: "s", Comment: "hi"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+}
+
+// --- Key Casing ---
+
+func TestKeyCases(t *testing.T) {
+	words := map[string][]string{
+		"MaxConns":      {"Max", "Conns"},
+		"HTTPServerURL": {"HTTP", "Server", "URL"},
+		"Retry2Count":   {"Retry2", "Count"},
+		"Max_Conns":     {"Max", "Conns"},
+		"ID":            {"ID"},
+	}
+	for name, want := range words {
+		if got := splitWords(name); !reflect.DeepEqual(got, want) {
+			t.Errorf("splitWords(%q) = %q, want %q", name, got, want)
+		}
+	}
+
+	type Config struct {
+		MaxConns      int
+		HTTPServerURL string
+		ReadTimeout   string
+		Name          string `piml:"service"`
+	}
+	data := []byte(`(MaxConns) 5
+(http_server_url) http://x
+(READ-TIMEOUT) 5s
+(Name) ignored
+`)
+	var cfg Config
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{KeyCases: KeyCaseAll}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{MaxConns: 5, HTTPServerURL: "http://x", ReadTimeout: "5s"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// Only the cases asked for match.
+	cfg = Config{}
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{KeyCases: KeyCaseSnake}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want = Config{HTTPServerURL: "http://x"}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	// By default, only the lower case name matches.
+	cfg = Config{}
+	if err := Unmarshal([]byte("(maxconns) 1\n(MaxConns) 2\n"), &cfg); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.MaxConns != 1 {
+		t.Fatalf("Expected 1, got %d", cfg.MaxConns)
+	}
+}
+
+func TestMatchKey(t *testing.T) {
+	type Config struct {
+		Name string `piml:"name"`
+		Port int    `piml:"db.port"`
+	}
+	match := func(docKey, fieldKey string) bool {
+		return strings.ReplaceAll(strings.ToLower(docKey), "app_", "") == fieldKey
+	}
+	data := []byte(`(APP_NAME) svc
+(app_db)
+  (app_port) 5432
+`)
+	var cfg Config
+	if err := UnmarshalWithOptions(data, &cfg, DecoderOptions{MatchKey: match}); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Name != "svc" || cfg.Port != 5432 {
+		t.Fatalf("Expected svc and 5432, got %+v", cfg)
+	}
+
+	// Field keys are normalized before they are matched.
+	cfg = Config{}
+	upper := DecoderOptions{KeyNormalizer: strings.ToUpper, MatchKey: func(docKey, fieldKey string) bool {
+		return docKey == "SERVICE" && fieldKey == "NAME"
+	}}
+	if err := UnmarshalWithOptions([]byte("(service) svc\n"), &cfg, upper); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if cfg.Name != "svc" {
+		t.Fatalf("Expected svc, got %+v", cfg)
+	}
+}
+
+// --- Dangling Keys ---
+
+func TestRejectDanglingKeys(t *testing.T) {
+	type DB struct {
+		Host string `piml:"host"`
+	}
+	type Config struct {
+		Name  string   `piml:"name"`
+		DB    DB       `piml:"db"`
+		Hosts []string `piml:"hosts"`
+	}
+	opts := DecoderOptions{RejectDangling: true}
+
+	valid := []byte(`(name) svc
+(db)
+
+  (host) localhost
+(hosts)
+  > a
+(unknown)
+  (x) 1
+`)
+	var cfg Config
+	if err := UnmarshalWithOptions(valid, &cfg, opts); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	want := Config{Name: "svc", DB: DB{Host: "localhost"}, Hosts: []string{"a"}}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		line int
+	}{
+		{"truncated", "(name) svc\n(db)\n", 2},
+		{"followed by a sibling", "(db)\n(name) svc\n", 1},
+		{"nested", "(db)\n  (host)\n(name) svc\n", 2},
+		{"unknown key", "(name) svc\n(unknown)\n", 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var cfg Config
+			err := UnmarshalWithOptions([]byte(tc.data), &cfg, opts)
+			if !errors.Is(err, ErrDanglingKey) || !strings.Contains(err.Error(), fmt.Sprintf("line %d:", tc.line)) {
+				t.Fatalf("Expected ErrDanglingKey at line %d, got %v", tc.line, err)
+			}
+			// Without the option, the key is left as it is.
+			if err := Unmarshal([]byte(tc.data), &cfg); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+		})
+	}
+}
+
+// --- Partial Results ---
+
+func TestUnmarshalPartial(t *testing.T) {
+	type DB struct {
+		Host string `piml:"host"`
+		Port int    `piml:"port"`
+	}
+	type Rule struct {
+		Path  string `piml:"path"`
+		Limit uint8  `piml:"limit"`
+	}
+	type Config struct {
+		Name   string            `piml:"name"`
+		Port   int               `piml:"port"`
+		DB     DB                `piml:"db"`
+		Ports  []int             `piml:"ports"`
+		Rules  []Rule            `piml:"rules"`
+		Labels map[string]string `piml:"labels"`
+		Level  string            `piml:"level,enum=debug|info"`
+	}
+	data := []byte(`(name) svc
+(port) eighty
+(db)
+  (host) localhost
+  (port) 5432x
+(ports)
+  > 80
+  > http
+  > 443
+(rules)
+  > (item)
+      (path) /
+      (limit) 300
+  > (item)
+      (path) /api
+(labels)
+  (team) core
+(level) trace
+`)
+	var cfg Config
+	paths, err := UnmarshalPartial(data, &cfg)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{`"port"`, "db.port", "rules[0].limit", `"level"`, "line 8: error setting item [1]"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got %v", want, err)
+		}
+	}
+	if n := len(err.(interface{ Unwrap() []error }).Unwrap()); n != 5 {
+		t.Errorf("Expected 5 errors, got %d: %v", n, err)
+	}
+
+	want := Config{
+		Name:   "svc",
+		DB:     DB{Host: "localhost"},
+		Ports:  []int{80, 443},
+		Rules:  []Rule{{Path: "/"}, {Path: "/api"}},
+		Labels: map[string]string{"team": "core"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("Expected %+v, got %+v", want, cfg)
+	}
+	wantPaths := []string{
+		"name",
+		"db.host",
+		"ports[0]",
+		"ports[2]",
+		"rules[0].path",
+		"rules[1].path",
+		"rules[1]",
+		"labels.team",
+		"labels",
+	}
+	if !reflect.DeepEqual(paths, wantPaths) {
+		t.Fatalf("Expected paths %q, got %q", wantPaths, paths)
+	}
+
+	// Syntax errors still stop the decoding.
+	cfg = Config{}
+	_, err = UnmarshalPartial([]byte("(name) svc\n(port\n(level) info\n"), &cfg)
+	if !errors.Is(err, ErrSyntax) || cfg.Level != "" {
+		t.Fatalf("Expected a syntax error and no level, got %v and %+v", err, cfg)
+	}
+
+	// Without the option, decoding stops at the first error.
+	cfg = Config{}
+	if err := Unmarshal(data, &cfg); err == nil || cfg.DB.Host != "" {
+		t.Fatalf("Expected an error before (db), got %v and %+v", err, cfg)
+	}
+	// Errors in list items name the item.
+	err = Unmarshal([]byte("(rules)\n  > (item)\n      (path) /\n  > (item)\n      (limit) 300\n"), &cfg)
+	if err == nil || !strings.Contains(err.Error(), "error decoding item [1]") {
+		t.Fatalf("Expected an error in item [1], got %v", err)
+	}
+}
+
+// --- Robustness ---
+
+type panickyText struct{}
+
+func (panickyText) MarshalText() ([]byte, error) { panic("marshal boom") }
+
+func (*panickyText) UnmarshalText([]byte) error { panic("unmarshal boom") }
+
+func TestPanicsBecomeErrors(t *testing.T) {
+	type Config struct {
+		Name  string      `piml:"name"`
+		Value panickyText `piml:"value"`
+	}
+
+	var cfg Config
+	err := Unmarshal([]byte("(name) svc\n(value) x\n"), &cfg)
+	if !errors.Is(err, ErrInternal) || !strings.Contains(err.Error(), "unmarshal boom") {
+		t.Fatalf("Expected ErrInternal, got %v", err)
+	}
+
+	_, err = Marshal(Config{})
+	var merr *MarshalerError
+	if !errors.Is(err, ErrInternal) || !errors.As(err, &merr) || merr.Path != "value" {
+		t.Fatalf("Expected ErrInternal at value, got %v", err)
+	}
+
+	err = Walk([]byte("(a) 1\n"), Handler{OnKey: func(string, Position) error { panic("walk boom") }})
+	if !errors.Is(err, ErrInternal) {
+		t.Fatalf("Expected ErrInternal, got %v", err)
+	}
+}
+
+// fuzzTarget has fields of most of the kinds the decoder handles.
+type fuzzTarget struct {
+	Name   string              `piml:"name"`
+	Port   *int                `piml:"port"`
+	Ratio  float32             `piml:"ratio"`
+	On     bool                `piml:"on"`
+	Small  uint8               `piml:"small"`
+	Hosts  []string            `piml:"hosts"`
+	Ports  []int               `piml:"ports"`
+	Tags   map[string]struct{} `piml:"tags"`
+	Items  []fuzzItem          `piml:"items"`
+	Ptrs   []*fuzzItem         `piml:"ptrs"`
+	Any    interface{}         `piml:"any"`
+	Lists  map[string][]int    `piml:"lists"`
+	Fixed  [2]string           `piml:"fixed"`
+	Doc    RawMessage          `piml:"doc"`
+	Nested **fuzzItem          `piml:"nested"`
+	Size   ByteSize            `piml:"size"`
+	When   time.Time           `piml:"when"`
+	Wait   time.Duration       `piml:"wait"`
+	Level  string              `piml:"level,enum=debug|info"`
+	Group  int                 `piml:"group.value"`
+}
+
+type fuzzItem struct {
+	A int       `piml:"a"`
+	B []float64 `piml:"b"`
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, s := range []string{
+		"(name) svc\n(port) 8080\n(hosts)\n  > a\n  > b\n",
+		"(items)\n  > (item)\n      (a) 1\n      (b)\n        > 1.5\n  > nil\n",
+		"(tags)\n  >| a\n  >| b\n",
+		"(any)\n  (x)\n    > 1\n    >| y\n",
+		"(lists)\n  (k)\n    > 1\n",
+		"(doc)\n  | (a) 1\n  |\n",
+		"(nested)\n  (a) 3\n",
+		"(fixed)\n  > a\n  > b\n  > c\n",
+		"(name)\n  multi\n  line\n",
+		"(group)\n  (value) 1\n(size) 1KB\n(wait) 1s\n",
+		"> 1\n> (x)\n    (y) 2\n",
+		"8080\n",
+		"(a) 1\n---\n(b) 2\n",
+	} {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		check := func(what string, err error) {
+			if errors.Is(err, ErrInternal) {
+				t.Fatalf("%s: %v", what, err)
+			}
+		}
+		var v fuzzTarget
+		check("Unmarshal", Unmarshal(data, &v))
+		_, err := Marshal(v)
+		check("Marshal", err)
+		check("UnmarshalWithOptions", UnmarshalWithOptions(data, &fuzzTarget{}, DecoderOptions{Lenient: true, Partial: true, AppendSlices: true}))
+
+		var g interface{}
+		if err := Unmarshal(data, &g); err == nil {
+			_, err = Marshal(g)
+			check("Marshal(interface{})", err)
+		} else {
+			check("Unmarshal(interface{})", err)
+		}
+		var n Node
+		if err := Unmarshal(data, &n); err == nil {
+			_, err = Marshal(n)
+			check("Marshal(Node)", err)
+		} else {
+			check("Unmarshal(Node)", err)
+		}
+		check("Walk", Walk(data, Handler{}))
+	})
+}
+
+// --- Maps of Lists ---
+
+type mapListUser struct {
+	Name string `piml:"name"`
+	Age  int    `piml:"age"`
+}
+
+func TestMapsOfObjectLists(t *testing.T) {
+	type Org struct {
+		Teams map[string][]mapListUser  `piml:"teams"`
+		Ptrs  map[string][]*mapListUser `piml:"ptrs"`
+	}
+	org := Org{
+		Teams: map[string][]mapListUser{
+			"admins": {{Name: "ada", Age: 36}, {Name: "bob", Age: 40}},
+			"guests": nil,
+		},
+		Ptrs: map[string][]*mapListUser{"on-call": {{Name: "eve", Age: 29}, nil}},
+	}
+	want := `(teams)
+  (admins)
+    > (mapListUser)
+        (name) ada
+        (age) 36
+    > (mapListUser)
+        (name) bob
+        (age) 40
+  (guests) nil
+(ptrs)
+  (on-call)
+    > (mapListUser)
+        (name) eve
+        (age) 29
+    > nil
+`
+	data, err := Marshal(org)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var out Org
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(out, org) {
+		t.Fatalf("Expected %+v, got %+v", org, out)
+	}
+
+	// At the root too.
+	var root map[string][]mapListUser
+	if err := Unmarshal([]byte("(admins)\n  > (user)\n      (name) ada\n"), &root); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(root, map[string][]mapListUser{"admins": {{Name: "ada"}}}) {
+		t.Fatalf("Unexpected result: %+v", root)
+	}
+}
+
+func TestGenericListsOfObjects(t *testing.T) {
+	v := map[string]interface{}{
+		"steps": []interface{}{
+			"build",
+			map[string]interface{}{
+				"run":  "test",
+				"env":  map[string]interface{}{"CI": "true"},
+				"deps": []interface{}{map[string]interface{}{"name": "db"}},
+			},
+			nil,
+			struct {
+				Name string `piml:"name"`
+			}{"deploy"},
+		},
+	}
+	want := `(steps)
+  > build
+  > (item)
+      (deps)
+        > (item)
+            (name) db
+      (env)
+        (CI) true
+      (run) test
+  > nil
+  > (item)
+      (name) deploy
+`
+	data, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var out interface{}
+	if err := Unmarshal(data, &out); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	again, err := Marshal(out)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if string(again) != want {
+		t.Fatalf("Expected a roundtrip, got:\n%s", again)
+	}
+}
+
+// --- Type Hints ---
+
+func TestTypeHints(t *testing.T) {
+	input := `(port) !!int 8080
+(ratio) !!float 0.5
+(debug) !!bool true
+(started) !!time 2024-05-01T12:00:00Z
+(name) !!str nil
+(home) !!str env://HOME
+(parent) !!null
+(plain) 42
+(ids)
+  > !!int 1
+  > 2
+(limits)
+  (conns) !!int 10
+`
+	d := NewDecoder([]byte(input))
+	d.SetTypeHints(true)
+	var got interface{}
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := map[string]interface{}{
+		"port":    int64(8080),
+		"ratio":   0.5,
+		"debug":   true,
+		"started": time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		"name":    "nil",
+		"home":    "env://HOME",
+		"parent":  nil,
+		"plain":   "42",
+		"ids":     []interface{}{int64(1), "2"},
+		"limits":  map[string]interface{}{"conns": int64(10)},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %#v, got %#v", want, got)
+	}
+
+	// Typed values drop the hint, except that !!str keeps nil as text.
+	var typed struct {
+		Port   int     `piml:"port"`
+		Name   string  `piml:"name"`
+		Parent *string `piml:"parent"`
+	}
+	d = NewDecoderWithOptions([]byte("(port) !!int 8080\n(name) !!str nil\n(parent) !!null\n"), DecoderOptions{TypeHints: true})
+	if err := d.Decode(&typed); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if typed.Port != 8080 || typed.Name != "nil" || typed.Parent != nil {
+		t.Errorf("Unexpected typed values: %+v", typed)
+	}
+
+	for _, input := range []string{"(a) !!uint 1\n", "(a) !!int x\n", "(a) !!null x\n"} {
+		d := NewDecoder([]byte(input))
+		d.SetTypeHints(true)
+		var v interface{}
+		if err := d.Decode(&v); err == nil {
+			t.Errorf("Expected an error for %q", input)
+		}
+	}
+
+	// Without SetTypeHints, hints are text.
+	var plain map[string]interface{}
+	if err := Unmarshal([]byte("(a) !!int 1\n"), &plain); err != nil || plain["a"] != "!!int 1" {
+		t.Errorf("Expected the hint to be text by default, got %v, %v", plain, err)
+	}
+}
+
+// --- Generic Nodes ---
+
+func TestGenericNodes(t *testing.T) {
+	input := "(zone) eu\n(app)\n  (port) 8080\n  (host) localhost\n(tags)\n  > b\n  > a\n"
+
+	d := NewDecoder([]byte(input))
+	d.SetGenericNodes(true)
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	n, ok := v.(*Node)
+	if !ok {
+		t.Fatalf("Expected a *Node, got %T", v)
+	}
+	var keys []string
+	for _, c := range n.Lookup("app").Children {
+		keys = append(keys, c.Key)
+	}
+	if strings.Join(keys, ",") != "port,host" {
+		t.Errorf("Expected keys in document order, got %v", keys)
+	}
+	out, err := Marshal(v)
+	if err != nil || string(out) != input {
+		t.Errorf("Expected the document back, got %q, %v", out, err)
+	}
+
+	// The values of maps are nodes too, scalars and nil included.
+	d = NewDecoderWithOptions([]byte("(a) 1\n(b) nil\n(c)\n  (d) 2\n"), DecoderOptions{GenericNodes: true})
+	var m map[string]interface{}
+	if err := d.Decode(&m); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if a, ok := m["a"].(*Node); !ok || a.Kind != ScalarNode || a.Value != "1" {
+		t.Errorf("Expected a scalar node, got %#v", m["a"])
+	}
+	if b, ok := m["b"].(*Node); !ok || b.Kind != NilNode {
+		t.Errorf("Expected a nil node, got %#v", m["b"])
+	}
+	if c, ok := m["c"].(*Node); !ok || c.Lookup("d").Value != "2" {
+		t.Errorf("Expected an object node, got %#v", m["c"])
+	}
+
+	// Interface gives the generic values Unmarshal makes.
+	want := map[string]interface{}{
+		"zone": "eu",
+		"app":  map[string]interface{}{"port": "8080", "host": "localhost"},
+		"tags": []interface{}{"b", "a"},
+	}
+	if got := n.Interface(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %#v, got %#v", want, got)
+	}
+	var generic interface{}
+	if err := Unmarshal([]byte(input), &generic); err != nil || !reflect.DeepEqual(generic, want) {
+		t.Errorf("Expected Unmarshal to agree with Interface, got %#v, %v", generic, err)
+	}
+}
+
+// --- Warnings ---
+
+type warnedConfig struct {
+	Name  string `piml:"name"`
+	Port  int    `piml:"port,alias=listen_port|bind_port"`
+	Ratio float32
+	DB    struct {
+		Host  string `piml:"host"`
+		Debug bool   `piml:"debug"`
+	} `piml:"db"`
+}
+
+func TestWarnings(t *testing.T) {
+	input := `(name) api
+(listen_port) 8_080
+(ratio) 0.1
+(db)
+  (hots) localhost
+  (debug) yes
+`
+	var handled []Warning
+	d := NewDecoderWithOptions([]byte(input), DecoderOptions{
+		Lenient:        true,
+		Warnings:       true,
+		WarningHandler: func(w Warning) { handled = append(handled, w) },
+	})
+	var cfg warnedConfig
+	if err := d.Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if cfg.Port != 8080 || cfg.DB.Debug != true {
+		t.Errorf("Unexpected values: %+v", cfg)
+	}
+
+	want := []string{
+		`line 2: listen_port: "listen_port" is deprecated, use "port"`,
+		`line 2: listen_port: "8_080" read as 8080`,
+		`line 3: ratio: 0.1 rounded to 0.10000000149011612 to fit a float32`,
+		`line 5: db.hots: unknown key "hots" of struct { Host string "piml:\"host\""; Debug bool "piml:\"debug\"" }, ignored`,
+		`line 6: db.debug: "yes" read as true`,
+	}
+	kinds := []WarningKind{WarnDeprecatedKey, WarnCoercion, WarnCoercion, WarnUnknownKey, WarnCoercion}
+	got := d.Warnings()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d warnings, got %v", len(want), got)
+	}
+	for i, w := range got {
+		if w.String() != want[i] || w.Kind != kinds[i] {
+			t.Errorf("Warning %d: expected %s (%v), got %s (%v)", i, want[i], kinds[i], w, w.Kind)
+		}
+	}
+	if !reflect.DeepEqual(handled, got) {
+		t.Errorf("Expected the handler to get the same warnings, got %v", handled)
+	}
+
+	// Aliases work without warnings, which aren't recorded by default.
+	d = NewDecoder([]byte("(bind_port) 9090\n(extra) 1\n"))
+	cfg = warnedConfig{}
+	if err := d.Decode(&cfg); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if cfg.Port != 9090 || d.Warnings() != nil {
+		t.Errorf("Expected port 9090 and no warnings, got %d and %v", cfg.Port, d.Warnings())
+	}
+}
+
+// --- Decode Tracing ---
+
+func TestTraceHook(t *testing.T) {
+	t.Setenv("PIML_TRACE_USER", "admin")
+	type traced struct {
+		Port   int               `piml:"port"`
+		User   string            `piml:"user"`
+		Motd   string            `piml:"motd"`
+		Parent *string           `piml:"parent"`
+		Hosts  []string          `piml:"hosts"`
+		Labels map[string]string `piml:"labels"`
+	}
+	input := `(port) 8080
+(user) env://PIML_TRACE_USER
+(motd)
+  Hello
+  World
+(parent) nil
+(hosts)
+  > a
+  > b
+(labels)
+  (team) core
+`
+	var got []string
+	d := NewDecoderWithOptions([]byte(input), DecoderOptions{
+		TraceHook: func(a Assignment) {
+			got = append(got, fmt.Sprintf("%d %s %q %#v", a.Line, a.Path, a.Text, a.Value))
+		},
+	})
+	d.RegisterResolver("env", EnvResolver{})
+	var v traced
+	if err := d.Decode(&v); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	want := []string{
+		`1 port "8080" 8080`,
+		`2 user "env://PIML_TRACE_USER" "admin"`,
+		`3 motd "Hello\nWorld" "Hello\nWorld"`,
+		`6 parent "nil" (*string)(nil)`,
+		`8 hosts[0] "a" "a"`,
+		`9 hosts[1] "b" "b"`,
+		`11 labels.team "core" "core"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+// --- Writing Files ---
+
+type savedServer struct {
+	Host string `piml:"host"`
+	Port int    `piml:"port"`
+}
+
+type savedConfig struct {
+	Name     string      `piml:"name"`
+	Server   savedServer `piml:"server"`
+	Features []string    `piml:"features"`
+	Debug    bool        `piml:"debug,omitempty"`
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.piml")
+	cfg := savedConfig{Name: "app", Server: savedServer{Host: "localhost", Port: 80}}
+	if err := WriteFile(name, cfg, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	var got savedConfig
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cfg) {
+		t.Errorf("Expected %+v, got %+v", cfg, got)
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected permissions 0600, got %v", perm)
+	}
+
+	// An existing file keeps its permissions, and no temporary file is
+	// left behind.
+	if err := os.Chmod(name, 0o640); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Server.Port = 8080
+	if err := WriteFile(name, cfg, 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	if info, err = os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o640 {
+		t.Errorf("Expected permissions 0640, got %v", perm)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only config.piml, got %v", entries)
+	}
+
+	if err := WriteFile(name, func() {}, 0o600); err == nil {
+		t.Error("WriteFile() of a func expected an error")
+	}
+	if err := WriteFile(filepath.Join(dir, "missing", "config.piml"), cfg, 0o600); err == nil {
+		t.Error("WriteFile() into a missing directory expected an error")
+	}
+}
+
+func TestWriteFileKeepComments(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "config.piml")
+	src := "# The application\n" +
+		"(name) app\n" +
+		"\n" +
+		"# Where it listens\n" +
+		"(server)\n" +
+		"    # the usual port\n" +
+		"    (port) 80\n" +
+		"    (host) localhost\n" +
+		"(legacy) yes\n" +
+		"(features)\n" +
+		"    > a\n" +
+		"    > b\n"
+	if err := os.WriteFile(name, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := savedConfig{
+		Name:     "app",
+		Server:   savedServer{Host: "0.0.0.0", Port: 80},
+		Features: []string{"a", "c"},
+		Debug:    true,
+	}
+	if err := WriteFileWithOptions(name, cfg, 0o644, WriteFileOptions{KeepComments: true}); err != nil {
+		t.Fatalf("WriteFileWithOptions() error = %v", err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "# The application\n" +
+		"(name) app\n" +
+		"\n" +
+		"# Where it listens\n" +
+		"(server)\n" +
+		"    # the usual port\n" +
+		"    (port) 80\n" +
+		"    (host) 0.0.0.0\n" +
+		"(features)\n" +
+		"    > a\n" +
+		"    > c\n" +
+		"(debug) true\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	// A file that isn't PIML can't be edited.
+	if err := os.WriteFile(name, []byte("(a)\n\t  (b) 1\n   (c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFileWithOptions(name, cfg, 0o644, WriteFileOptions{KeepComments: true}); err == nil {
+		t.Error("WriteFileWithOptions() over an invalid document expected an error")
+	}
+}
+
+func TestWriteFileBackups(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.piml")
+	opts := WriteFileOptions{Backups: 2}
+	for port := 1; port <= 4; port++ {
+		cfg := savedConfig{Name: "app", Server: savedServer{Port: port}}
+		if err := WriteFileWithOptions(name, cfg, 0o644, opts); err != nil {
+			t.Fatalf("WriteFileWithOptions() error = %v", err)
+		}
+	}
+	// Writing the same document again keeps no backup of it.
+	cfg := savedConfig{Name: "app", Server: savedServer{Port: 4}}
+	if err := WriteFileWithOptions(name, cfg, 0o644, opts); err != nil {
+		t.Fatalf("WriteFileWithOptions() error = %v", err)
+	}
+	backups, err := Backups(name)
+	if err != nil {
+		t.Fatalf("Backups() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %v", backups)
+	}
+
+	port := func() int {
+		t.Helper()
+		var got savedConfig
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Unmarshal(data, &got); err != nil {
+			t.Fatalf("Unmarshal() error = %v", err)
+		}
+		return got.Server.Port
+	}
+	for _, want := range []int{3, 2} {
+		if err := Rollback(name); err != nil {
+			t.Fatalf("Rollback() error = %v", err)
+		}
+		if got := port(); got != want {
+			t.Errorf("Expected port %d after Rollback(), got %d", want, got)
+		}
+	}
+	if err := Rollback(name); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("Expected ErrNoBackup, got %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only config.piml, got %v", entries)
+	}
+}
+
+// --- Field Shadowing ---
+
+type shadowBase struct {
+	ID   int    `piml:"id"`
+	Name string `piml:"name"`
+}
+
+type shadowNode struct {
+	*shadowNode
+	*ShadowLink
+	Value int `piml:"value"`
+}
+
+type ShadowLink struct {
+	*shadowNode
+	Next string `piml:"next"`
+}
+
+func TestFieldShadowing(t *testing.T) {
+	type Meta struct {
+		Name string `piml:"name"`
+		Tags string `piml:"tags"`
+	}
+	type Outer struct {
+		shadowBase
+		*Meta
+		Name string `piml:"name"` // Shadows both embedded names
+	}
+
+	input := Outer{shadowBase: shadowBase{ID: 1, Name: "base"}, Meta: &Meta{Name: "meta", Tags: "x"}, Name: "outer"}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := "(id) 1\n(tags) x\n(name) outer\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	var output Outer
+	if err := Unmarshal([]byte("(name) outer\n(tags) y\n(id) 2\n"), &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	// The nil embedded pointer is allocated for its promoted field.
+	if output.Name != "outer" || output.shadowBase.Name != "" || output.ID != 2 || output.Meta == nil || output.Meta.Tags != "y" || output.Meta.Name != "" {
+		t.Fatalf("Unexpected output: %+v, meta %+v", output, output.Meta)
+	}
+
+	// Recursive embedding terminates, and exported embedded pointers
+	// are followed while unexported ones can't be.
+	var node shadowNode
+	if err := Unmarshal([]byte("(value) 1\n(next) n\n"), &node); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if node.Value != 1 || node.ShadowLink == nil || node.Next != "n" {
+		t.Fatalf("Unexpected node: %+v", node)
+	}
+}
+
+func TestAmbiguousFields(t *testing.T) {
+	type Left struct {
+		Name  string
+		Port  int `piml:"port"`
+		Debug bool
+	}
+	type Right struct {
+		Name  string
+		Port  int
+		Debug bool `piml:"debug"`
+	}
+	type Both struct {
+		Left
+		Right
+		Host string `piml:"host"`
+	}
+
+	// name is ambiguous and left out, while the tagged port and debug
+	// win over the untagged fields at the same depth.
+	input := Both{Left: Left{Name: "l", Port: 1, Debug: true}, Right: Right{Name: "r", Port: 2, Debug: false}, Host: "h"}
+	data, err := Marshal(input)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want := "(port) 1\n(debug) false\n(host) h\n"
+	if string(data) != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%s", want, data)
+	}
+
+	doc := []byte("(name) x\n(port) 3\n(debug) true\n(host) y\n")
+	var output Both
+	if err := Unmarshal(doc, &output); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if output.Left.Name != "" || output.Right.Name != "" || output.Left.Port != 3 || output.Right.Port != 0 || output.Left.Debug || !output.Right.Debug || output.Host != "y" {
+		t.Fatalf("Unexpected output: %+v", output)
+	}
+
+	// Strict decoders report the ambiguous key instead.
+	d := NewDecoder(doc)
+	d.SetStrictFields(true)
+	err = d.Decode(&output)
+	if !errors.Is(err, ErrAmbiguousField) || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("Expected ErrAmbiguousField on line 1, got %v", err)
+	}
+
+	// Unknown keys are still ignored in strict mode.
+	d = NewDecoder([]byte("(other) 1\n"))
+	d.SetStrictFields(true)
+	if err := d.Decode(&output); err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+}
+
+// --- Marshaler Errors ---
+
+func TestMarshalerErrorPath(t *testing.T) {
+	type Plugin struct {
+		Name    string      `piml:"name"`
+		Handler interface{} `piml:"handler"`
+	}
+	type Config struct {
+		Plugins []Plugin                 `piml:"plugins"`
+		Extra   map[string]interface{}   `piml:"extra"`
+		Limits  map[string][]interface{} `piml:"limits"`
+	}
+
+	tests := []struct {
+		name string
+		in   interface{}
+		path string
+	}{
+		{"struct field", Config{Plugins: []Plugin{{Name: "a"}, {Name: "b", Handler: make(chan struct{})}}}, "plugins[1].handler"},
+		{"fast map", Config{Extra: map[string]interface{}{"hook": func() {}}}, "extra.hook"},
+		{"primitive item", Config{Limits: map[string][]interface{}{"cpu": {1, make(chan int)}}}, "limits.cpu[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Marshal(tt.in)
+			var merr *MarshalerError
+			if !errors.As(err, &merr) || merr.Path != tt.path {
+				t.Fatalf("Expected a MarshalerError at %s, got %v", tt.path, err)
+			}
+			if !errors.Is(err, ErrUnsupportedType) {
+				t.Fatalf("Expected the error to wrap ErrUnsupportedType, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.path) {
+				t.Fatalf("Expected the message to name %s, got %q", tt.path, err)
+			}
+		})
+	}
+
+	// The root has no path, and a failed Encode doesn't leak its path
+	// into the next one.
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	if err := e.Encode(make(chan int)); errors.As(err, new(*MarshalerError)) || !errors.Is(err, ErrUnsupportedType) {
+		t.Fatalf("Expected a plain ErrUnsupportedType at the root, got %v", err)
+	}
+	_ = e.Encode(tests[0].in)
+	if err := e.Encode(map[string]interface{}{"x": make(chan int)}); err == nil || err.(*MarshalerError).Path != "x" {
+		t.Fatalf("Expected a MarshalerError at x, got %v", err)
+	}
+
+	// Streamed items are numbered from the start of the array.
+	e = NewEncoder(&buf)
+	e.EncodeArrayHeader("plugins")
+	e.EncodeArrayItem(Plugin{Name: "a"})
+	err := e.EncodeArrayItem(Plugin{Name: "b", Handler: make(chan struct{})})
+	var merr *MarshalerError
+	if !errors.As(err, &merr) || merr.Path != "[1].handler" {
+		t.Fatalf("Expected a MarshalerError at [1].handler, got %v", err)
+	}
+}
+
+// --- Options ---
+
+func TestOptions(t *testing.T) {
+	type Config struct {
+		Port     int    `piml:"port"`
+		Host     string `piml:"host"`
+		Password string `piml:"password,secret"`
+	}
+	cfg := Config{Port: 80, Host: "web # main", Password: "hunter2"}
+
+	data, err := MarshalWithOptions(cfg, EncoderOptions{
+		Redact:         "xxx",
+		FieldOrder:     OrderAlphabetical,
+		InlineComments: true,
+		CRLF:           true,
+		KeyNormalizer:  strings.ToUpper,
+	})
+	if err != nil {
+		t.Fatalf("MarshalWithOptions() error = %v", err)
+	}
+	want := "(HOST) web \\# main\r\n(PASSWORD) xxx\r\n(PORT) 80\r\n"
+	if string(data) != want {
+		t.Fatalf("Expected %q, got %q", want, data)
+	}
+
+	// The zero options are the defaults.
+	data, err = MarshalWithOptions(cfg, EncoderOptions{})
+	if plain, _ := Marshal(cfg); err != nil || string(data) != string(plain) {
+		t.Fatalf("Expected the output of Marshal, got %q, %v", data, err)
+	}
+
+	var stats DecodeStats
+	var output Config
+	err = UnmarshalWithOptions([]byte("(PORT) {{.}}\n(HOST) x\n"), &output, DecoderOptions{
+		KeyNormalizer: strings.ToLower,
+		Template:      true,
+		TemplateData:  8080,
+		StatsHook:     func(s DecodeStats, err error) { stats = s },
+	})
+	if err != nil || output.Port != 8080 || output.Host != "x" || stats.Keys != 2 {
+		t.Fatalf("Unexpected output %+v, stats %+v, error %v", output, stats, err)
+	}
+
+	err = UnmarshalWithOptions([]byte("(port) 1\n (host) x\n"), &output, DecoderOptions{IndentUnit: 2})
+	if !errors.Is(err, ErrSyntax) {
+		t.Fatalf("Expected an indentation error, got %v", err)
+	}
+}
+
+// --- Benchmarks ---
+
+type benchUser struct {
+	ID      int               `piml:"id"`
+	Name    string            `piml:"name"`
+	Email   string            `piml:"email"`
+	Active  bool              `piml:"active"`
+	Score   float64           `piml:"score"`
+	Roles   []string          `piml:"roles"`
+	Labels  map[string]string `piml:"labels"`
+	Created time.Time         `piml:"created"`
+}
+
+type benchConfig struct {
+	Name  string       `piml:"name"`
+	Users []*benchUser `piml:"users"`
+}
+
+func newBenchConfig(n int) *benchConfig {
+	cfg := &benchConfig{Name: "bench"}
+	for i := 0; i < n; i++ {
+		cfg.Users = append(cfg.Users, &benchUser{
+			ID:      i,
+			Name:    fmt.Sprintf("user%d", i),
+			Email:   fmt.Sprintf("user%d@example.com", i),
+			Active:  i%2 == 0,
+			Score:   float64(i) * 1.5,
+			Roles:   []string{"reader", "writer"},
+			Labels:  map[string]string{"team": "core", "region": "eu"},
+			Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		})
+	}
+	return cfg
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	cfg := newBenchConfig(1000)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	cfg := newBenchConfig(1000)
+	e := NewEncoder(io.Discard)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := e.Encode(cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	data, err := Marshal(newBenchConfig(1000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var cfg benchConfig
+		if err := Unmarshal(data, &cfg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
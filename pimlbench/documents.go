@@ -0,0 +1,119 @@
+package pimlbench
+
+import (
+	"fmt"
+	"time"
+)
+
+// Documents returns the representative documents: a small flat config,
+// a nested service config, and a list of a thousand records.
+func Documents() []Document {
+	return []Document{
+		{Name: "small", Value: newSmall(), New: func() interface{} { return new(Small) }},
+		{Name: "service", Value: newService(), New: func() interface{} { return new(Service) }},
+		{Name: "records", Value: newRecords(1000), New: func() interface{} { return new(Records) }},
+	}
+}
+
+// Small is a flat config of a few scalars.
+type Small struct {
+	Name    string  `piml:"name" json:"name" yaml:"name"`
+	Host    string  `piml:"host" json:"host" yaml:"host"`
+	Port    int     `piml:"port" json:"port" yaml:"port"`
+	Debug   bool    `piml:"debug" json:"debug" yaml:"debug"`
+	Ratio   float64 `piml:"ratio" json:"ratio" yaml:"ratio"`
+	Workers int     `piml:"workers" json:"workers" yaml:"workers"`
+}
+
+// Service is a nested config with lists and maps.
+type Service struct {
+	Name     string            `piml:"name" json:"name" yaml:"name"`
+	Server   Server            `piml:"server" json:"server" yaml:"server"`
+	Database Database          `piml:"database" json:"database" yaml:"database"`
+	Features []string          `piml:"features" json:"features" yaml:"features"`
+	Limits   map[string]int    `piml:"limits" json:"limits" yaml:"limits"`
+	Labels   map[string]string `piml:"labels" json:"labels" yaml:"labels"`
+	Routes   []Route           `piml:"routes" json:"routes" yaml:"routes"`
+}
+
+// Server is the listener of a Service.
+type Server struct {
+	Host         string        `piml:"host" json:"host" yaml:"host"`
+	Port         int           `piml:"port" json:"port" yaml:"port"`
+	ReadTimeout  time.Duration `piml:"read_timeout" json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout time.Duration `piml:"write_timeout" json:"write_timeout" yaml:"write_timeout"`
+	TLS          bool          `piml:"tls" json:"tls" yaml:"tls"`
+}
+
+// Database is the database of a Service.
+type Database struct {
+	Driver   string `piml:"driver" json:"driver" yaml:"driver"`
+	DSN      string `piml:"dsn" json:"dsn" yaml:"dsn"`
+	MaxConns int    `piml:"max_conns" json:"max_conns" yaml:"max_conns"`
+}
+
+// Route is an entry of the routing table of a Service.
+type Route struct {
+	Path    string   `piml:"path" json:"path" yaml:"path"`
+	Backend string   `piml:"backend" json:"backend" yaml:"backend"`
+	Methods []string `piml:"methods" json:"methods" yaml:"methods"`
+	Auth    bool     `piml:"auth" json:"auth" yaml:"auth"`
+}
+
+// Records is a long list of records.
+type Records struct {
+	Records []Record `piml:"records" json:"records" yaml:"records"`
+}
+
+// Record is a user record.
+type Record struct {
+	ID      int               `piml:"id" json:"id" yaml:"id"`
+	Name    string            `piml:"name" json:"name" yaml:"name"`
+	Email   string            `piml:"email" json:"email" yaml:"email"`
+	Active  bool              `piml:"active" json:"active" yaml:"active"`
+	Score   float64           `piml:"score" json:"score" yaml:"score"`
+	Roles   []string          `piml:"roles" json:"roles" yaml:"roles"`
+	Labels  map[string]string `piml:"labels" json:"labels" yaml:"labels"`
+	Created time.Time         `piml:"created" json:"created" yaml:"created"`
+}
+
+func newSmall() *Small {
+	return &Small{Name: "api", Host: "0.0.0.0", Port: 8080, Debug: true, Ratio: 0.75, Workers: 8}
+}
+
+func newService() *Service {
+	s := &Service{
+		Name:     "checkout",
+		Server:   Server{Host: "0.0.0.0", Port: 8443, ReadTimeout: 5 * time.Second, WriteTimeout: 10 * time.Second, TLS: true},
+		Database: Database{Driver: "postgres", DSN: "postgres://app@db:5432/checkout?sslmode=require", MaxConns: 20},
+		Features: []string{"payments", "coupons", "gift-cards", "invoices"},
+		Limits:   map[string]int{"requests_per_second": 500, "burst": 50, "max_body": 1 << 20},
+		Labels:   map[string]string{"team": "commerce", "tier": "1", "region": "eu-west-1"},
+	}
+	for i := 0; i < 20; i++ {
+		s.Routes = append(s.Routes, Route{
+			Path:    fmt.Sprintf("/v1/resource%d", i),
+			Backend: fmt.Sprintf("http://backend%d:8080", i%4),
+			Methods: []string{"GET", "POST"},
+			Auth:    i%3 != 0,
+		})
+	}
+	return s
+}
+
+func newRecords(n int) *Records {
+	r := &Records{}
+	for i := 0; i < n; i++ {
+		r.Records = append(r.Records, Record{
+			ID:      i,
+			Name:    fmt.Sprintf("user%d", i),
+			Email:   fmt.Sprintf("user%d@example.com", i),
+			Active:  i%2 == 0,
+			Score:   float64(i) * 1.5,
+			Roles:   []string{"reader", "writer"},
+			Labels:  map[string]string{"team": "core", "region": "eu"},
+			Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		})
+	}
+	return r
+}
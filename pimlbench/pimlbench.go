@@ -0,0 +1,143 @@
+// Package pimlbench measures how fast PIML marshals and unmarshals
+// representative documents next to other formats, so regressions are
+// caught and the cost of adopting PIML can be weighed. Formats are
+// plugged in as Codecs, so any other library can be compared:
+//
+//	var YAML = pimlbench.Codec{Name: "yaml", Marshal: yaml.Marshal, Unmarshal: yaml.Unmarshal}
+//
+//	func BenchmarkFormats(b *testing.B) {
+//		pimlbench.Benchmark(b, []pimlbench.Codec{pimlbench.PIML, pimlbench.JSON, YAML}, pimlbench.Documents())
+//	}
+//
+// Outside of tests, Run measures the same and WriteTable prints the
+// results.
+package pimlbench
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+	"text/tabwriter"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// A Codec is a format to measure.
+type Codec struct {
+	Name      string
+	Marshal   func(v interface{}) ([]byte, error)
+	Unmarshal func(data []byte, v interface{}) error
+}
+
+var (
+	// PIML is the Codec of this package's module.
+	PIML = Codec{Name: "piml", Marshal: piml.Marshal, Unmarshal: piml.Unmarshal}
+
+	// JSON is the Codec of encoding/json.
+	JSON = Codec{Name: "json", Marshal: json.Marshal, Unmarshal: json.Unmarshal}
+)
+
+// A Document is a value to marshal and unmarshal.
+type Document struct {
+	Name  string
+	Value interface{}        // The value marshalled
+	New   func() interface{} // Returns a pointer to unmarshal into
+}
+
+// A Result is the measure of one operation of a Codec on a Document.
+type Result struct {
+	Codec    string
+	Document string
+	Op       string // "marshal" or "unmarshal"
+	Size     int    // Length of the marshalled document
+	testing.BenchmarkResult
+}
+
+// Benchmark runs a sub-benchmark of b for each operation of each codec
+// on each document, named like "piml/service/unmarshal".
+func Benchmark(b *testing.B, codecs []Codec, docs []Document) {
+	for _, c := range codecs {
+		for _, doc := range docs {
+			data, err := encode(c, doc)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.Run(c.Name+"/"+doc.Name+"/marshal", func(b *testing.B) {
+				benchMarshal(b, c, doc, data)
+			})
+			b.Run(c.Name+"/"+doc.Name+"/unmarshal", func(b *testing.B) {
+				benchUnmarshal(b, c, doc, data)
+			})
+		}
+	}
+}
+
+// Run measures each operation of each codec on each document with
+// testing.Benchmark. It fails if a codec can't read back what it
+// wrote.
+func Run(codecs []Codec, docs []Document) ([]Result, error) {
+	var results []Result
+	for _, c := range codecs {
+		for _, doc := range docs {
+			data, err := encode(c, doc)
+			if err != nil {
+				return nil, err
+			}
+			marshal := testing.Benchmark(func(b *testing.B) { benchMarshal(b, c, doc, data) })
+			unmarshal := testing.Benchmark(func(b *testing.B) { benchUnmarshal(b, c, doc, data) })
+			results = append(results,
+				Result{Codec: c.Name, Document: doc.Name, Op: "marshal", Size: len(data), BenchmarkResult: marshal},
+				Result{Codec: c.Name, Document: doc.Name, Op: "unmarshal", Size: len(data), BenchmarkResult: unmarshal})
+		}
+	}
+	return results, nil
+}
+
+// encode marshals doc with c, and checks that c can read it back.
+func encode(c Codec, doc Document) ([]byte, error) {
+	data, err := c.Marshal(doc.Value)
+	if err != nil {
+		return nil, fmt.Errorf("pimlbench: %s can't marshal %s: %w", c.Name, doc.Name, err)
+	}
+	if err := c.Unmarshal(data, doc.New()); err != nil {
+		return nil, fmt.Errorf("pimlbench: %s can't unmarshal %s: %w", c.Name, doc.Name, err)
+	}
+	return data, nil
+}
+
+func benchMarshal(b *testing.B, c Codec, doc Document, data []byte) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.Marshal(doc.Value); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchUnmarshal(b *testing.B, c Codec, doc Document, data []byte) {
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := c.Unmarshal(data, doc.New()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// WriteTable writes results to w as an aligned table, with the time,
+// throughput and allocations of each operation.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "codec\tdocument\top\tsize\tns/op\tMB/s\tB/op\tallocs/op\t")
+	for _, r := range results {
+		var mbs float64
+		if ns := r.NsPerOp(); ns > 0 {
+			mbs = float64(r.Size) * 1e3 / float64(ns)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%.2f\t%d\t%d\t\n",
+			r.Codec, r.Document, r.Op, r.Size, r.NsPerOp(), mbs, r.AllocedBytesPerOp(), r.AllocsPerOp())
+	}
+	return tw.Flush()
+}
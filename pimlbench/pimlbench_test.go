@@ -0,0 +1,46 @@
+package pimlbench
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDocumentsRoundtrip(t *testing.T) {
+	for _, c := range []Codec{PIML, JSON} {
+		for _, doc := range Documents() {
+			data, err := encode(c, doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := doc.New()
+			if err := c.Unmarshal(data, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, doc.Value) {
+				t.Errorf("%s changed %s in a roundtrip", c.Name, doc.Name)
+			}
+		}
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	results := []Result{
+		{Codec: "piml", Document: "small", Op: "marshal", Size: 100, BenchmarkResult: testing.BenchmarkResult{N: 10, T: 10000, MemAllocs: 30, MemBytes: 2000}},
+	}
+	var b strings.Builder
+	if err := WriteTable(&b, results); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a header and a row, got:\n%s", b.String())
+	}
+	if fields := strings.Fields(lines[1]); strings.Join(fields, " ") != "piml small marshal 100 1000 100.00 200 3" {
+		t.Errorf("Unexpected row %q", lines[1])
+	}
+}
+
+func BenchmarkCodecs(b *testing.B) {
+	Benchmark(b, []Codec{PIML, JSON}, Documents())
+}
@@ -0,0 +1,277 @@
+// Package pimltest helps test the config types of programs using PIML,
+// by checking that values survive a Marshal and Unmarshal, and that
+// they are written as golden files say:
+//
+//	func TestConfig(t *testing.T) {
+//		cfg := defaultConfig()
+//		pimltest.AssertRoundtrip(t, cfg)
+//		pimltest.AssertMarshalGolden(t, cfg, "testdata/default.piml")
+//	}
+//
+// Golden files are rewritten with what Marshal writes by running the
+// tests with the -pimltest.update flag.
+//
+// PIML writes empty slices and maps as nil, which is read back as nil
+// slices and maps, so the comparisons of this package treat nil and
+// empty as equal.
+package pimltest
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+var update = flag.Bool("pimltest.update", false, "rewrite the golden files of pimltest.AssertMarshalGolden")
+
+// AssertRoundtrip marshals v, unmarshals the document into a new value
+// of the same type, and reports an error to t unless the two are Equal.
+// Unexported fields are not compared, as they are never written.
+func AssertRoundtrip(t testing.TB, v interface{}) {
+	t.Helper()
+	data, err := piml.Marshal(v)
+	if err != nil {
+		t.Errorf("pimltest: Marshal(%T) error = %v", v, err)
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.IsValid() {
+		t.Errorf("pimltest: can't roundtrip a nil interface")
+		return
+	}
+	got := reflect.New(rv.Type())
+	if err := piml.Unmarshal(data, got.Interface()); err != nil {
+		t.Errorf("pimltest: Unmarshal(%T) error = %v\ndocument:\n%s", v, err, data)
+		return
+	}
+	if !Equal(v, got.Elem().Interface()) {
+		t.Errorf("pimltest: %T changed in a roundtrip\nwant: %#v\ngot:  %#v\ndocument:\n%s", v, v, got.Elem().Interface(), data)
+	}
+}
+
+// AssertMarshalGolden marshals v and reports an error to t unless the
+// document is the content of the file at path. With the -pimltest.update
+// flag, it writes the document to path instead, creating its directory.
+// Line endings of the file are ignored, so golden files checked out
+// with \r\n line endings still match.
+func AssertMarshalGolden(t testing.TB, v interface{}, path string) {
+	t.Helper()
+	data, err := piml.Marshal(v)
+	if err != nil {
+		t.Errorf("pimltest: Marshal(%T) error = %v", v, err)
+		return
+	}
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Errorf("pimltest: %v", err)
+			return
+		}
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Errorf("pimltest: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("pimltest: %v (run the tests with -pimltest.update to create it)", err)
+		return
+	}
+	want = bytes.ReplaceAll(want, []byte("\r\n"), []byte("\n"))
+	if !bytes.Equal(data, want) {
+		t.Errorf("pimltest: %T doesn't match %s at %s (run the tests with -pimltest.update to accept it)\nwant:\n%s\ngot:\n%s",
+			v, path, firstDiff(string(want), string(data)), want, data)
+	}
+}
+
+// firstDiff returns where the documents a and b first differ, such as
+// "line 3".
+func firstDiff(a, b string) string {
+	al, bl := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(al) && i < len(bl); i++ {
+		if al[i] != bl[i] {
+			return fmt.Sprintf("line %d", i+1)
+		}
+	}
+	return fmt.Sprintf("line %d", min(len(al), len(bl))+1)
+}
+
+// Equal reports whether a and b are deeply equal the way PIML sees
+// values: nil and empty slices and maps are equal, values of types
+// with an Equal method, such as time.Time, are compared with it, and
+// unexported fields are ignored.
+func Equal(a, b interface{}) bool {
+	return equal(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+// equal is Equal on reflect values.
+func equal(a, b reflect.Value) bool {
+	if !a.IsValid() || !b.IsValid() {
+		return isEmpty(a) && isEmpty(b)
+	}
+	if a.Type() != b.Type() {
+		return false
+	}
+	if eq, ok := callEqual(a, b); ok {
+		return eq
+	}
+	switch a.Kind() {
+	case reflect.Slice, reflect.Array:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if a.Len() != b.Len() {
+			return false
+		}
+		iter := a.MapRange()
+		for iter.Next() {
+			bv := b.MapIndex(iter.Key())
+			if !bv.IsValid() || !equal(iter.Value(), bv) {
+				return false
+			}
+		}
+		return true
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			// An empty list in an interface{} is read back as nil.
+			return isEmpty(a.Elem()) && isEmpty(b.Elem())
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if !a.Type().Field(i).IsExported() {
+				continue
+			}
+			if !equal(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return a.IsNil() && b.IsNil() || a.Pointer() == b.Pointer()
+	}
+	return a.Equal(b)
+}
+
+// callEqual compares a and b with their Equal method, if their type has
+// one taking the same type and returning a bool.
+func callEqual(a, b reflect.Value) (eq, ok bool) {
+	if !a.CanInterface() {
+		return false, false
+	}
+	m := a.MethodByName("Equal")
+	if !m.IsValid() {
+		return false, false
+	}
+	mt := m.Type()
+	if mt.NumIn() != 1 || mt.In(0) != a.Type() || mt.NumOut() != 1 || mt.Out(0).Kind() != reflect.Bool {
+		return false, false
+	}
+	return m.Call([]reflect.Value{b})[0].Bool(), true
+}
+
+// isEmpty reports whether v is invalid, a nil pointer or interface, or
+// an empty slice or map.
+func isEmpty(v reflect.Value) bool {
+	if !v.IsValid() {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// NormalizeEmpty replaces the empty slices and maps that ptr points to,
+// at any depth, with nil ones, as decoding a marshalled value makes
+// them, so values can be compared with reflect.DeepEqual or other
+// libraries. Unexported fields are left as they are.
+func NormalizeEmpty(ptr interface{}) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		panic("pimltest: NormalizeEmpty needs a non-nil pointer")
+	}
+	normalizeEmpty(v.Elem())
+}
+
+// normalizeEmpty is NormalizeEmpty on a settable value.
+func normalizeEmpty(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.Len() == 0 {
+			if !v.IsNil() && v.CanSet() {
+				v.SetZero()
+			}
+			return
+		}
+		for i := 0; i < v.Len(); i++ {
+			normalizeEmpty(v.Index(i))
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			normalizeEmpty(v.Index(i))
+		}
+	case reflect.Map:
+		if v.Len() == 0 {
+			if !v.IsNil() && v.CanSet() {
+				v.SetZero()
+			}
+			return
+		}
+		// Map values can't be set in place, so they are copied out.
+		iter := v.MapRange()
+		for iter.Next() {
+			item := reflect.New(iter.Value().Type()).Elem()
+			item.Set(iter.Value())
+			normalizeEmpty(item)
+			v.SetMapIndex(iter.Key(), item)
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			normalizeEmpty(v.Elem())
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if isEmpty(v.Elem()) {
+			if v.CanSet() {
+				v.SetZero()
+			}
+			return
+		}
+		item := reflect.New(v.Elem().Type()).Elem()
+		item.Set(v.Elem())
+		normalizeEmpty(item)
+		if v.CanSet() {
+			v.Set(item)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() {
+				normalizeEmpty(v.Field(i))
+			}
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package pimltest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recorder is a testing.TB that records the errors reported to it.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+type config struct {
+	Name    string            `piml:"name"`
+	Tags    []string          `piml:"tags"`
+	Limits  map[string]int    `piml:"limits"`
+	Started time.Time         `piml:"started"`
+	Extra   map[string]string `piml:"extra"`
+	cache   int
+}
+
+// lossy drops its value when encoded.
+type lossy struct {
+	Kept    string `piml:"kept"`
+	Dropped string `piml:"-"`
+}
+
+func TestAssertRoundtrip(t *testing.T) {
+	zone := time.FixedZone("CET", 3600)
+	cfg := config{
+		Name:    "web",
+		Tags:    []string{},
+		Limits:  map[string]int{"conns": 10},
+		Started: time.Date(2024, 5, 1, 12, 0, 0, 0, zone),
+		cache:   42,
+	}
+	rec := &recorder{TB: t}
+	AssertRoundtrip(rec, cfg)
+	AssertRoundtrip(rec, &cfg)
+	if len(rec.errors) != 0 {
+		t.Fatalf("Expected no errors, got %v", rec.errors)
+	}
+
+	AssertRoundtrip(rec, lossy{Kept: "a", Dropped: "b"})
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "changed in a roundtrip") {
+		t.Fatalf("Expected a roundtrip error, got %v", rec.errors)
+	}
+}
+
+func TestAssertMarshalGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "golden", "config.piml")
+	cfg := config{Name: "web", Tags: []string{"a"}}
+
+	*update = true
+	AssertMarshalGolden(t, cfg, path)
+	*update = false
+	AssertMarshalGolden(t, cfg, path)
+
+	// A checkout with \r\n line endings still matches.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlf := strings.ReplaceAll(string(data), "\n", "\r\n")
+	if err := os.WriteFile(path, []byte(crlf), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	AssertMarshalGolden(t, cfg, path)
+
+	rec := &recorder{TB: t}
+	cfg.Tags = []string{"b"}
+	AssertMarshalGolden(rec, cfg, path)
+	if len(rec.errors) != 1 || !strings.Contains(rec.errors[0], "at line 3") {
+		t.Fatalf("Expected a mismatch at line 3, got %v", rec.errors)
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b interface{}
+		want bool
+	}{
+		{[]int{}, []int(nil), true},
+		{map[string]int{}, map[string]int(nil), true},
+		{[]int{1}, []int(nil), false},
+		{map[string]interface{}{"a": []interface{}{}}, map[string]interface{}{"a": nil}, true},
+		{map[string]interface{}{"a": "x"}, map[string]interface{}{"a": nil}, false},
+		{time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 1, 0, 0, 0, time.FixedZone("", 3600)), true},
+		{config{cache: 1}, config{cache: 2}, true},
+		{config{Name: "a"}, config{Name: "b"}, false},
+		{1, int64(1), false},
+		{nil, []string{}, true},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.want {
+			t.Errorf("Equal(%#v, %#v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEmpty(t *testing.T) {
+	v := struct {
+		Tags   []string
+		Nested map[string]interface{}
+		Items  []map[string][]int
+	}{
+		Tags:   []string{},
+		Nested: map[string]interface{}{"a": []interface{}{}, "b": map[string]interface{}{}, "c": "x"},
+		Items:  []map[string][]int{{"a": {}}},
+	}
+	NormalizeEmpty(&v)
+	if v.Tags != nil {
+		t.Errorf("Expected nil tags, got %#v", v.Tags)
+	}
+	want := map[string]interface{}{"a": nil, "b": nil, "c": "x"}
+	if !reflect.DeepEqual(v.Nested, want) {
+		t.Errorf("Expected %#v, got %#v", want, v.Nested)
+	}
+	if v.Items[0]["a"] != nil {
+		t.Errorf("Expected a nil list in the map, got %#v", v.Items[0]["a"])
+	}
+}
@@ -0,0 +1,357 @@
+package pimltest
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"time"
+
+	piml "github.com/fezcode/go-piml"
+)
+
+// DefaultAlphabet is what the strings of CheckRoundtrip are made of
+// when Config.Alphabet is empty: letters, digits, and the characters
+// that mean something in PIML, such as "#", "(", ")", "\", ">", spaces
+// and line breaks. Such strings are also, now and then, a word that
+// means something as a whole, such as "nil" or "---".
+const DefaultAlphabet = "ab1 #()\\>|-:\n\té"
+
+// tokens are strings the default alphabet makes whole strings of now
+// and then, as they are the likeliest to be read back as something else.
+var tokens = []string{"", "nil", " ", "#", "\\#", "> x", ">| x", "(x)", "---", "true", "0", "|", "\nx", " \n x"}
+
+// Config configures CheckRoundtrip. Its zero value, or a nil *Config,
+// uses the defaults.
+type Config struct {
+	Count    int        // Number of values to try, 100 if 0
+	Rand     *rand.Rand // Source of values, seeded with 1 if nil
+	MaxLen   int        // Maximum length of strings, slices and maps, 4 if 0
+	MaxDepth int        // Nesting beyond which pointers, slices and maps are nil, 3 if 0
+	Alphabet string     // Characters of strings, see DefaultAlphabet
+}
+
+// A RoundtripError is a value that changed in a Marshal and Unmarshal,
+// as small as CheckRoundtrip could make it.
+type RoundtripError struct {
+	Value    interface{} // The value marshalled
+	Document []byte      // What Marshal wrote, if it succeeded
+	Got      interface{} // What Unmarshal read, if it succeeded
+	Err      error       // The error of Marshal or Unmarshal, if any
+}
+
+func (e *RoundtripError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "pimltest: %#v doesn't roundtrip", e.Value)
+	if e.Err != nil {
+		fmt.Fprintf(&b, ": %v", e.Err)
+	} else {
+		fmt.Fprintf(&b, ", got %#v", e.Got)
+	}
+	if e.Document != nil {
+		fmt.Fprintf(&b, "\ndocument:\n%s", e.Document)
+	}
+	return b.String()
+}
+
+func (e *RoundtripError) Unwrap() error {
+	return e.Err
+}
+
+// CheckRoundtrip generates random values of typ and checks that each
+// survives a Marshal and Unmarshal, as AssertRoundtrip does. It returns
+// a *RoundtripError for the first value that doesn't, shrunk to the
+// smallest value that still fails by dropping items, emptying strings
+// and zeroing fields, or nil if all of them do:
+//
+//	if err := pimltest.CheckRoundtrip(reflect.TypeOf(Config{}), nil); err != nil {
+//		t.Fatal(err)
+//	}
+//
+// Values follow the piml struct tags: fields tagged "-", readonly or
+// writeonly are left zero, as they are never read back, fields with an
+// enum option hold one of their values, and interface fields are nil.
+// time.Time values are in UTC.
+func CheckRoundtrip(typ reflect.Type, config *Config) error {
+	g := newGenerator(config)
+	count := 100
+	if config != nil && config.Count > 0 {
+		count = config.Count
+	}
+	for i := 0; i < count; i++ {
+		v := reflect.New(typ).Elem()
+		g.fill(v, reflect.StructField{}, 0)
+		if err := roundtrip(v); err != nil {
+			shrink(v)
+			return roundtrip(v)
+		}
+	}
+	return nil
+}
+
+// roundtrip marshals and unmarshals v, returning a *RoundtripError if
+// it changes.
+func roundtrip(v reflect.Value) *RoundtripError {
+	value := v.Interface()
+	data, err := piml.Marshal(value)
+	if err != nil {
+		return &RoundtripError{Value: value, Err: err}
+	}
+	got := reflect.New(v.Type())
+	if err := piml.Unmarshal(data, got.Interface()); err != nil {
+		return &RoundtripError{Value: value, Document: data, Err: err}
+	}
+	if !Equal(value, got.Elem().Interface()) {
+		return &RoundtripError{Value: value, Document: data, Got: got.Elem().Interface()}
+	}
+	return nil
+}
+
+// A generator fills values with random content.
+type generator struct {
+	rand     *rand.Rand
+	maxLen   int
+	maxDepth int
+	alphabet []rune
+	tokens   []string
+}
+
+func newGenerator(config *Config) *generator {
+	var c Config
+	if config != nil {
+		c = *config
+	}
+	g := &generator{rand: c.Rand, maxLen: c.MaxLen, maxDepth: c.MaxDepth, alphabet: []rune(c.Alphabet)}
+	if g.rand == nil {
+		g.rand = rand.New(rand.NewSource(1))
+	}
+	if g.maxLen <= 0 {
+		g.maxLen = 4
+	}
+	if g.maxDepth <= 0 {
+		g.maxDepth = 3
+	}
+	if len(g.alphabet) == 0 {
+		g.alphabet = []rune(DefaultAlphabet)
+		g.tokens = tokens
+	}
+	return g
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// fill sets v, of the field sf if it is one, to a random value.
+func (g *generator) fill(v reflect.Value, sf reflect.StructField, depth int) {
+	if v.Type() == timeType {
+		t := time.Unix(g.rand.Int63n(1<<33), 0).UTC()
+		if g.rand.Intn(2) == 0 {
+			t = t.Add(time.Duration(g.rand.Intn(1e9)))
+		}
+		v.Set(reflect.ValueOf(t))
+		return
+	}
+	if values := enumValues(sf); values != nil && v.Kind() == reflect.String {
+		v.SetString(values[g.rand.Intn(len(values))])
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(g.rand.Intn(2) == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		bits := v.Type().Bits()
+		v.SetInt(g.rand.Int63() >> (64 - bits) * int64(1-2*g.rand.Intn(2)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		v.SetUint(g.rand.Uint64() >> (64 - v.Type().Bits()))
+	case reflect.Float32, reflect.Float64:
+		f := g.rand.NormFloat64() * math.Pow(10, float64(g.rand.Intn(20)-10))
+		if v.Kind() == reflect.Float32 {
+			f = float64(float32(f))
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		v.SetString(g.string(false))
+	case reflect.Ptr:
+		if depth < g.maxDepth && g.rand.Intn(4) != 0 {
+			v.Set(reflect.New(v.Type().Elem()))
+			g.fill(v.Elem(), sf, depth+1)
+		}
+	case reflect.Slice:
+		if depth < g.maxDepth {
+			n := g.rand.Intn(g.maxLen + 1)
+			v.Set(reflect.MakeSlice(v.Type(), n, n))
+			for i := 0; i < n; i++ {
+				g.fill(v.Index(i), sf, depth+1)
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			g.fill(v.Index(i), sf, depth+1)
+		}
+	case reflect.Map:
+		if depth < g.maxDepth {
+			v.Set(reflect.MakeMap(v.Type()))
+			for n := g.rand.Intn(g.maxLen + 1); n > 0; n-- {
+				key := reflect.New(v.Type().Key()).Elem()
+				if key.Kind() == reflect.String {
+					key.SetString(g.string(true))
+				} else {
+					g.fill(key, reflect.StructField{}, g.maxDepth)
+				}
+				item := reflect.New(v.Type().Elem()).Elem()
+				g.fill(item, reflect.StructField{}, depth+1)
+				v.SetMapIndex(key, item)
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			if f.IsExported() && !skipped(f) {
+				g.fill(v.Field(i), f, depth+1)
+			}
+		}
+	}
+}
+
+// string returns a random string, or a key, which never holds the line
+// breaks Marshal rejects.
+func (g *generator) string(key bool) string {
+	if g.tokens != nil && g.rand.Intn(4) == 0 {
+		return g.tokens[g.rand.Intn(len(g.tokens))]
+	}
+	var b strings.Builder
+	for n := g.rand.Intn(g.maxLen*2 + 1); n > 0; n-- {
+		r := g.alphabet[g.rand.Intn(len(g.alphabet))]
+		if key && (r == '\n' || r == '\r') {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// skipped reports whether the field sf is never read back.
+func skipped(sf reflect.StructField) bool {
+	tag, ok := sf.Tag.Lookup("piml")
+	if !ok {
+		return false
+	}
+	name, opts, _ := strings.Cut(tag, ",")
+	if name == "-" && opts == "" {
+		return true
+	}
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == "readonly" || opt == "writeonly" {
+			return true
+		}
+	}
+	return false
+}
+
+// enumValues returns the values of the enum option of the field sf, or
+// nil if it has none.
+func enumValues(sf reflect.StructField) []string {
+	_, opts, _ := strings.Cut(sf.Tag.Get("piml"), ",")
+	for _, opt := range strings.Split(opts, ",") {
+		if values, ok := strings.CutPrefix(opt, "enum="); ok {
+			return strings.Split(values, "|")
+		}
+	}
+	return nil
+}
+
+// shrink makes v, which fails to roundtrip, as small as it can while it
+// still fails, one simplification at a time.
+func shrink(v reflect.Value) {
+	for shrinkOnce(v, v, func() {}) {
+	}
+}
+
+// shrinkOnce tries the simplifications of v, part of root, and keeps
+// the first one after which root still fails to roundtrip. Map items
+// are shrunk in copies, which store puts back into their maps.
+func shrinkOnce(root, v reflect.Value, store func()) bool {
+	// try sets v to x and keeps it if root still fails.
+	try := func(x reflect.Value) bool {
+		old := reflect.New(v.Type()).Elem()
+		old.Set(v)
+		v.Set(x)
+		store()
+		if roundtrip(root) != nil {
+			return true
+		}
+		v.Set(old)
+		store()
+		return false
+	}
+
+	if !v.IsZero() && try(reflect.Zero(v.Type())) {
+		return true
+	}
+	switch v.Kind() {
+	case reflect.String:
+		// Drop one character at a time.
+		s := []rune(v.String())
+		for i := range s {
+			shorter := string(s[:i]) + string(s[i+1:])
+			if try(reflect.ValueOf(shorter).Convert(v.Type())) {
+				return true
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			shorter := reflect.MakeSlice(v.Type(), 0, v.Len()-1)
+			shorter = reflect.AppendSlice(shorter, v.Slice(0, i))
+			shorter = reflect.AppendSlice(shorter, v.Slice(i+1, v.Len()))
+			if try(shorter) {
+				return true
+			}
+		}
+		for i := 0; i < v.Len(); i++ {
+			if shrinkOnce(root, v.Index(i), store) {
+				return true
+			}
+		}
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if shrinkOnce(root, v.Index(i), store) {
+				return true
+			}
+		}
+	case reflect.Map:
+		for _, key := range v.MapKeys() {
+			smaller := reflect.MakeMap(v.Type())
+			iter := v.MapRange()
+			for iter.Next() {
+				if !iter.Key().Equal(key) {
+					smaller.SetMapIndex(iter.Key(), iter.Value())
+				}
+			}
+			if try(smaller) {
+				return true
+			}
+		}
+		for _, key := range v.MapKeys() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(key))
+			storeItem := func() {
+				v.SetMapIndex(key, item)
+				store()
+			}
+			if shrinkOnce(root, item, storeItem) {
+				return true
+			}
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return shrinkOnce(root, v.Elem(), store)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && shrinkOnce(root, v.Field(i), store) {
+				return true
+			}
+		}
+	}
+	return false
+}
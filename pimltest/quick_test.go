@@ -0,0 +1,67 @@
+package pimltest
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type quickServer struct {
+	Host    string            `piml:"host"`
+	Port    uint16            `piml:"port"`
+	Weight  float64           `piml:"weight"`
+	Enabled bool              `piml:"enabled"`
+	Mode    string            `piml:"mode,enum=fast|safe"`
+	Started time.Time         `piml:"started"`
+	Tags    []string          `piml:"tags"`
+	Labels  map[string]string `piml:"labels"`
+	Backup  *quickServer      `piml:"backup"`
+	Token   string            `piml:"token,readonly"`
+	Skipped string            `piml:"-"`
+}
+
+func TestCheckRoundtrip(t *testing.T) {
+	config := &Config{Alphabet: "abcxyz019-_."}
+	if err := CheckRoundtrip(reflect.TypeOf(quickServer{}), config); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRoundtrip(reflect.TypeOf(map[string][]int64{}), config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckRoundtripShrinks(t *testing.T) {
+	// Leading and trailing spaces are trimmed from values, so a space
+	// is the smallest string that changes.
+	err := CheckRoundtrip(reflect.TypeOf(quickServer{}), &Config{Alphabet: "ab "})
+	var rerr *RoundtripError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("Expected a *RoundtripError, got %v", err)
+	}
+	if rerr.Got == nil || rerr.Document == nil {
+		t.Fatalf("Expected the document and the value read back, got %v", err)
+	}
+	v := rerr.Value.(quickServer)
+	strs := []string{v.Host}
+	strs = append(strs, v.Tags...)
+	for k, s := range v.Labels {
+		strs = append(strs, k, s)
+	}
+	spaces := 0
+	for _, s := range strs {
+		if s == " " {
+			spaces++
+		} else if s != "" {
+			t.Errorf("Expected %q to be shrunk", s)
+		}
+	}
+	if spaces != 1 || v.Port != 0 || v.Weight != 0 || v.Backup != nil || !v.Started.IsZero() {
+		t.Errorf("Expected a single space in an otherwise zero value, got %#v", v)
+	}
+
+	// The default alphabet finds values that don't even decode.
+	if err := CheckRoundtrip(reflect.TypeOf(""), nil); err == nil {
+		t.Error("Expected the default alphabet to find a string that doesn't roundtrip")
+	}
+}
@@ -0,0 +1,42 @@
+package piml
+
+import (
+	"bytes"
+	"sync"
+)
+
+// lineChunk is the number of lines allocated at once by newLine.
+const lineChunk = 64
+
+// newLine returns a zeroed lineInfo from the decoder's arena. Lines are
+// allocated in chunks, as documents have many of them but they are
+// small and short-lived. Reset reuses the last chunk.
+func (d *Decoder) newLine() *lineInfo {
+	if len(d.lines) == cap(d.lines) {
+		// Lines handed out from a full chunk may still be in use,
+		// so it is left to them and the garbage collector.
+		d.lines = make([]lineInfo, 0, lineChunk)
+	}
+	d.lines = append(d.lines, lineInfo{})
+	return &d.lines[len(d.lines)-1]
+}
+
+// bufferPool holds buffers for building decoded strings.
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	return bufferPool.Get().(*bytes.Buffer)
+}
+
+// putBuffer returns b to the pool, unless it has grown too large to be
+// worth keeping.
+func putBuffer(b *bytes.Buffer) {
+	if b.Cap() > 64<<10 {
+		return
+	}
+	b.Reset()
+	bufferPool.Put(b)
+}
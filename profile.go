@@ -0,0 +1,25 @@
+package piml
+
+// profilePrefix marks a key whose block only applies under a profile,
+// e.g. (profile:production).
+const profilePrefix = "profile:"
+
+// UseProfile selects the profile whose (profile:name) blocks are applied.
+//
+// A profile block may appear anywhere a key can. When its profile is
+// active, its keys are deep-merged over the surrounding object once the
+// rest of that object has been decoded, so they win regardless of where
+// the block sits in the file:
+//
+//	(database)
+//	  (host) localhost
+//	  (port) 5432
+//	(profile:production)
+//	  (database)
+//	    (host) db.prod.internal
+//
+// Blocks for other profiles are skipped, as are all profile blocks
+// when no profile is selected.
+func (d *Decoder) UseProfile(name string) {
+	d.profile = name
+}
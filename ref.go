@@ -0,0 +1,115 @@
+package piml
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+)
+
+// refPrefix starts a scalar that refers to a value in another
+// document, e.g. "$ref: shared.piml#database.host".
+const refPrefix = "$ref:"
+
+// UseRefFS makes the decoder resolve "$ref: file#key.path" scalars by
+// reading file from fsys and looking up the dotted key path in it:
+//
+//	(host) $ref: shared.piml#database.host
+//
+// The referenced value may itself be a reference; cycles are reported
+// as errors. The file of a reference in the decoded document is a path
+// in fsys, and that of a reference in a referenced file is relative to
+// the directory of that file, as with includes elsewhere, so shared
+// files can refer to their neighbours wherever they are. Without a file
+// system, references are kept as-is.
+func (d *Decoder) UseRefFS(fsys fs.FS) {
+	d.refFS = fsys
+}
+
+// resolveRef loads the value a "$ref:" scalar points at.
+func (d *Decoder) resolveRef(valueStr string) (string, error) {
+	target := strings.TrimSpace(strings.TrimPrefix(valueStr, refPrefix))
+	file, keyPath, _ := strings.Cut(target, "#")
+	if file == "" || keyPath == "" {
+		return "", fmt.Errorf("piml: invalid reference %q, want \"$ref: file#key.path\"", valueStr)
+	}
+	if n := len(d.refStack); n > 0 {
+		current, _, _ := strings.Cut(d.refStack[n-1], "#")
+		file = path.Join(path.Dir(current), file)
+	}
+	file = path.Clean(file)
+	target = file + "#" + keyPath
+
+	for i, seen := range d.refStack {
+		if seen == target {
+			chain := append(d.refStack[i:len(d.refStack):len(d.refStack)], target)
+			return "", fmt.Errorf("piml: reference cycle: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	data, err := fs.ReadFile(d.refFS, file)
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot resolve %q: %w", valueStr, err)
+	}
+
+	sub := d.cloneSettings(data)
+	sub.traceHook = nil // The value is reported where it is stored
+	sub.refStack = append(d.refStack[:len(d.refStack):len(d.refStack)], target)
+	s, err := sub.lookup(strings.Split(keyPath, "."))
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot resolve %q: %w", valueStr, err)
+	}
+	return s, nil
+}
+
+// lookup finds the scalar at the given key path and returns its text,
+// resolving it in turn if needed.
+func (d *Decoder) lookup(keys []string) (string, error) {
+	parentIndent := -1
+	for i, key := range keys {
+		line, err := d.findKey(d.normalizeKey(key), parentIndent)
+		if err != nil {
+			return "", err
+		}
+		if line == nil {
+			return "", fmt.Errorf("key %q not found", strings.Join(keys[:i+1], "."))
+		}
+		d.consume()
+
+		if i < len(keys)-1 {
+			if line.lineType != lineKeyOnly {
+				return "", fmt.Errorf("key %q is not an object", strings.Join(keys[:i+1], "."))
+			}
+			parentIndent = line.indent
+			continue
+		}
+
+		// The last key holds the value, possibly as a multi-line string.
+		s := line.value
+		if line.lineType == lineKeyOnly {
+			if err := d.decodeValue(reflect.ValueOf(&s), line.indent); err != nil {
+				return "", err
+			}
+			return s, nil
+		}
+		return d.resolve(s)
+	}
+	return "", nil
+}
+
+// findKey skips ahead to the child of the current object with the given
+// key. It returns nil if the object ends first.
+func (d *Decoder) findKey(key string, parentIndent int) (*lineInfo, error) {
+	for {
+		line, err := d.peekChild(parentIndent)
+		if err != nil || line == nil {
+			return nil, err
+		}
+		if (line.lineType == lineKeyValue || line.lineType == lineKeyOnly) && line.key == key {
+			return line, nil
+		}
+		d.consume()
+		d.consumeChildren(line.indent)
+	}
+}
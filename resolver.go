@@ -0,0 +1,93 @@
+package piml
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A Resolver looks up the real value behind a scalar of the form
+// "scheme://ref", such as "env://DB_PASSWORD". It receives the part
+// after "://" and returns the text to decode in its place.
+type Resolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// The ResolverFunc type is an adapter to allow the use of
+// ordinary functions as resolvers.
+type ResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f ResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// EnvResolver resolves "env://NAME" to the value of the
+// environment variable NAME. Unset variables are an error.
+type EnvResolver struct{}
+
+// Resolve returns the value of the environment variable ref.
+func (EnvResolver) Resolve(ref string) (string, error) {
+	v, ok := os.LookupEnv(ref)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", ref)
+	}
+	return v, nil
+}
+
+// FileResolver resolves "file:///path/to/file" to the contents of the
+// file, without its trailing newline. It suits secrets mounted as files,
+// like Docker and Kubernetes secrets.
+type FileResolver struct{}
+
+// Resolve returns the contents of the file at path ref.
+func (FileResolver) Resolve(ref string) (string, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+// RegisterResolver makes the decoder resolve scalars of the form
+// "scheme://ref" through r before assigning them. No schemes are
+// registered by default, so such values are normally kept as-is:
+//
+//	d := piml.NewDecoder(data)
+//	d.RegisterResolver("env", piml.EnvResolver{})
+//	d.RegisterResolver("file", piml.FileResolver{})
+func (d *Decoder) RegisterResolver(scheme string, r Resolver) {
+	if d.resolvers == nil {
+		d.resolvers = make(map[string]Resolver)
+	}
+	d.resolvers[scheme] = r
+}
+
+// resolve replaces a "scheme://ref" scalar with the value
+// supplied by the resolver registered for scheme, a "$ref:" scalar
+// with the value it refers to, and an "enc:" scalar with its plaintext.
+func (d *Decoder) resolve(valueStr string) (string, error) {
+	if d.refFS != nil && strings.HasPrefix(valueStr, refPrefix) {
+		return d.resolveRef(valueStr)
+	}
+	if d.decryptor != nil && strings.HasPrefix(valueStr, encPrefix) {
+		return d.decrypt(valueStr)
+	}
+	if len(d.resolvers) == 0 {
+		return valueStr, nil
+	}
+	scheme, ref, ok := strings.Cut(valueStr, "://")
+	if !ok {
+		return valueStr, nil
+	}
+	r, ok := d.resolvers[scheme]
+	if !ok {
+		return valueStr, nil
+	}
+	resolved, err := r.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("piml: cannot resolve %q: %w", valueStr, err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,189 @@
+package piml
+
+import (
+	"encoding"
+	"fmt"
+	"math/big"
+	"net"
+	"net/netip"
+	"reflect"
+	"time"
+)
+
+// Types that PIML writes as scalars despite not being basic kinds.
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	bigIntType          = reflect.TypeOf(big.Int{})
+	bigFloatType        = reflect.TypeOf(big.Float{})
+	bigRatType          = reflect.TypeOf(big.Rat{})
+	ipNetType           = reflect.TypeOf(net.IPNet{})
+	netipAddrType       = reflect.TypeOf(netip.Addr{})
+	netipPrefixType     = reflect.TypeOf(netip.Prefix{})
+	netipAddrPortType   = reflect.TypeOf(netip.AddrPort{})
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// formatScalar returns the PIML text of v if its type is written as a
+// scalar without being a basic kind: registered enums, flags and
+// scalars, time.Time, the
+// math/big numbers, net.IPNet, and any encoding.TextMarshaler.
+func formatScalar(v reflect.Value) (string, bool, error) {
+	if n, ok := lookupNamed(v.Type()); ok {
+		s, err := n.format(v)
+		return s, true, err
+	}
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).Format(time.RFC3339Nano), true, nil
+	case bigIntType:
+		return addressable(v).Addr().Interface().(*big.Int).String(), true, nil
+	case bigFloatType:
+		// String() rounds to 10 digits; 'g' with -1 keeps every digit.
+		return addressable(v).Addr().Interface().(*big.Float).Text('g', -1), true, nil
+	case bigRatType:
+		return addressable(v).Addr().Interface().(*big.Rat).String(), true, nil
+	case ipNetType:
+		return addressable(v).Addr().Interface().(*net.IPNet).String(), true, nil
+	}
+
+	var m encoding.TextMarshaler
+	if v.Type().Implements(textMarshalerType) {
+		m = v.Interface().(encoding.TextMarshaler)
+	} else if reflect.PointerTo(v.Type()).Implements(textMarshalerType) {
+		m = addressable(v).Addr().Interface().(encoding.TextMarshaler)
+	} else {
+		return "", false, nil
+	}
+	text, err := m.MarshalText()
+	if err != nil {
+		return "", true, fmt.Errorf("piml: error marshalling %s: %w", v.Type(), err)
+	}
+	return string(text), true, nil
+}
+
+// zeroIsNil reports whether the zero value of t is written as nil, and
+// nil read back as the zero value. Such types have no text for their
+// zero value: net.IPNet, and netip.Addr, netip.Prefix and
+// netip.AddrPort, whose zero values write as empty text.
+func zeroIsNil(t reflect.Type) bool {
+	switch t {
+	case ipNetType, netipAddrType, netipPrefixType, netipAddrPortType:
+		return true
+	}
+	return false
+}
+
+// isScalarType reports whether values of t are written by formatScalar.
+func isScalarType(t reflect.Type) bool {
+	if _, ok := lookupNamed(t); ok {
+		return true
+	}
+	switch t {
+	case timeType, bigIntType, bigFloatType, bigRatType, ipNetType:
+		return true
+	}
+	return t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// parseScalar sets v from valueStr if its type is one of those handled
+// by formatScalar, or an encoding.TextUnmarshaler. It reports whether
+// v was such a type. v must be addressable.
+//
+// time.Time is handled by the Decoder, which knows the accepted layouts.
+func parseScalar(v reflect.Value, valueStr string) (bool, error) {
+	if n, ok := lookupNamed(v.Type()); ok {
+		return true, n.parse(v, valueStr)
+	}
+	switch v.Type() {
+	case bigIntType:
+		if _, ok := v.Addr().Interface().(*big.Int).SetString(valueStr, 10); !ok {
+			return true, fmt.Errorf("piml: invalid big.Int value: %q", valueStr)
+		}
+		return true, nil
+	case bigFloatType:
+		f := v.Addr().Interface().(*big.Float)
+		if f.Prec() == 0 {
+			// Keep every digit of the input; 4 bits per digit is enough.
+			f.SetPrec(max(64, uint(4*len(valueStr))))
+		}
+		if _, ok := f.SetString(valueStr); !ok {
+			return true, fmt.Errorf("piml: invalid big.Float value: %q", valueStr)
+		}
+		return true, nil
+	case bigRatType:
+		if _, ok := v.Addr().Interface().(*big.Rat).SetString(valueStr); !ok {
+			return true, fmt.Errorf("piml: invalid big.Rat value: %q", valueStr)
+		}
+		return true, nil
+	case ipNetType:
+		_, ipNet, err := net.ParseCIDR(valueStr)
+		if err != nil {
+			return true, fmt.Errorf("piml: invalid CIDR value: %w", err)
+		}
+		v.Set(reflect.ValueOf(*ipNet))
+		return true, nil
+	}
+
+	if !reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		return false, nil
+	}
+	if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(valueStr)); err != nil {
+		return true, fmt.Errorf("piml: invalid %s value: %w", v.Type(), err)
+	}
+	return true, nil
+}
+
+// scalarType is a type registered with RegisterScalar.
+type scalarType[T any] struct {
+	encode func(T) (string, error)
+	decode func(string) (T, error)
+}
+
+// RegisterScalar makes values of type T be written with encode and read
+// with decode, so third-party types such as decimals or versions can be
+// used as scalars without wrapping them:
+//
+//	piml.RegisterScalar(
+//		func(v semver.Version) (string, error) { return v.String(), nil },
+//		semver.Parse,
+//	)
+//
+// This takes precedence over everything else PIML knows about T,
+// including its encoding.TextMarshaler methods and the handling of
+// time.Time. T must not be a pointer or interface type; pointers to T
+// are written like T, or as nil. Registering T again replaces its
+// functions, as well as any enum or flags registered for it.
+func RegisterScalar[T any](encode func(T) (string, error), decode func(string) (T, error)) {
+	namedTypes.Store(reflect.TypeFor[T](), &scalarType[T]{encode: encode, decode: decode})
+}
+
+// format returns the text of v, a T.
+func (s *scalarType[T]) format(v reflect.Value) (string, error) {
+	text, err := s.encode(v.Interface().(T))
+	if err != nil {
+		return "", fmt.Errorf("piml: error marshalling %s: %w", v.Type(), err)
+	}
+	return text, nil
+}
+
+// parse sets v, a T, from text.
+func (s *scalarType[T]) parse(v reflect.Value, text string) error {
+	value, err := s.decode(text)
+	if err != nil {
+		return fmt.Errorf("piml: invalid %s value %q: %w", v.Type(), text, err)
+	}
+	v.Set(reflect.ValueOf(&value).Elem())
+	return nil
+}
+
+// addressable returns v itself if it is addressable, or an
+// addressable copy of it, so pointer methods can be called.
+func addressable(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	return c
+}
@@ -0,0 +1,67 @@
+package piml
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// signaturePrefix starts the trailer line of a signed document.
+const signaturePrefix = "(signature) "
+
+// A SignFunc signs the bytes of a document, e.g. with ed25519.Sign and a
+// private key.
+type SignFunc func(data []byte) ([]byte, error)
+
+// A VerifyFunc checks that signature is a valid signature of data, e.g.
+// with ed25519.Verify and a public key, returning an error if it isn't.
+type VerifyFunc func(data, signature []byte) error
+
+// SetSigner makes the encoder end every document with a trailer line
+// holding the signature of everything written before it, checksum
+// trailer included, in base64:
+//
+//	(signature) 3q2+7wE...
+//
+// The document can then be authenticated by a decoder with SetVerifier,
+// e.g. on the devices the configuration is distributed to.
+func (e *Encoder) SetSigner(sign SignFunc) {
+	e.signer = sign
+}
+
+// SetVerifier makes the decoder authenticate its input with verify
+// before decoding anything. The signature is the trailer written by
+// Encoder.SetSigner, which is not part of the document, or the one given
+// to SetSignature. A missing or invalid signature is an error wrapping
+// ErrSignature, and so is any error from verify.
+func (d *Decoder) SetVerifier(verify VerifyFunc) {
+	d.verifier = verify
+}
+
+// SetSignature gives the decoder a detached signature of its whole
+// input, for SetVerifier to check, for documents that are signed in a
+// file of their own. Set it to nil to use the signature trailer.
+func (d *Decoder) SetSignature(signature []byte) {
+	d.signature = signature
+}
+
+// verifySignature checks the signature of the input, and drops its
+// trailer if it has one.
+func (d *Decoder) verifySignature() error {
+	data, sig := d.src, d.signature
+	if sig == nil {
+		body, text, ok := cutTrailer(data, signaturePrefix)
+		if !ok {
+			return fmt.Errorf("%w: no %q trailer", ErrSignature, signaturePrefix)
+		}
+		var err error
+		if sig, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return fmt.Errorf("%w: %w", ErrSignature, err)
+		}
+		data = body
+	}
+	if err := d.verifier(data, sig); err != nil {
+		return fmt.Errorf("%w: %w", ErrSignature, err)
+	}
+	d.setInput(data)
+	return nil
+}
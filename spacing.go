@@ -0,0 +1,48 @@
+package piml
+
+// Spacing controls where an Encoder inserts blank lines between keys.
+type Spacing int
+
+const (
+	// SpacingNone writes keys without blank lines. This is the default.
+	SpacingNone Spacing = iota
+
+	// SpacingSections writes a blank line between top-level keys.
+	SpacingSections
+
+	// SpacingGroups writes a blank line between struct fields whose
+	// `group` tag options differ, e.g. `piml:"host,group=server"`,
+	// at any depth.
+	SpacingGroups
+)
+
+// SetSpacing sets where blank lines are written between keys, so
+// generated files are visually sectioned. Blank lines are ignored
+// when decoding.
+func (e *Encoder) SetSpacing(spacing Spacing) {
+	e.spacing = spacing
+}
+
+// writeSpacing writes a blank line before a key, if the encoder's
+// Spacing calls for one. prev is the key written before it at the same
+// level, or nil for the first.
+func (e *Encoder) writeSpacing(prev, cur *structField, indent int) error {
+	if prev == nil {
+		return nil
+	}
+	switch e.spacing {
+	case SpacingSections:
+		if indent != -1 {
+			return nil
+		}
+	case SpacingGroups:
+		prevGroup, _ := prev.opts.Get("group")
+		curGroup, _ := cur.opts.Get("group")
+		if prevGroup == curGroup {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return e.writeString("\n")
+}
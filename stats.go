@@ -0,0 +1,42 @@
+package piml
+
+import "time"
+
+// DecodeStats describes the work done by a call to Decoder.Decode.
+type DecodeStats struct {
+	Lines    int           // Lines scanned, including blank and comment lines
+	Keys     int           // Key lines read
+	MaxDepth int           // Deepest nesting of values, 1 for a flat document
+	Bytes    int64         // Bytes of input scanned
+	Duration time.Duration // Time spent in Decode
+}
+
+// Stats returns the statistics of the last call to Decode.
+func (d *Decoder) Stats() DecodeStats {
+	return d.stats
+}
+
+// SetStatsHook makes the decoder call hook at the end of every Decode
+// with its statistics and the error it returns, if any, e.g. to record
+// them as metrics.
+func (d *Decoder) SetStatsHook(hook func(stats DecodeStats, err error)) {
+	d.statsHook = hook
+}
+
+// beginStats starts counting for a call to Decode.
+func (d *Decoder) beginStats() {
+	d.stats = DecodeStats{}
+	d.statsStart = time.Now()
+	d.statsLine = d.line
+	d.statsBytes = d.scanned
+}
+
+// endStats finishes counting for a call to Decode that returns err.
+func (d *Decoder) endStats(err error) {
+	d.stats.Lines = d.line - d.statsLine
+	d.stats.Bytes = int64(d.scanned - d.statsBytes)
+	d.stats.Duration = time.Since(d.statsStart)
+	if d.statsHook != nil {
+		d.statsHook(d.stats, err)
+	}
+}
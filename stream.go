@@ -0,0 +1,110 @@
+package piml
+
+import (
+	"errors"
+	"reflect"
+)
+
+// arrayStream is the state of an array written item by item.
+type arrayStream struct {
+	indent int // Indentation of the items
+	items  int // Number of items written
+}
+
+// EncodeArrayHeader starts writing the array under key at the root of
+// the document. Its items are then written one at a time with
+// EncodeArrayItem, so a large list never has to be held in memory:
+//
+//	e.EncodeArrayHeader("users")
+//	for rows.Next() {
+//		...
+//		e.EncodeArrayItem(user)
+//	}
+//	e.Close()
+//
+// An empty key streams a list at the root of the document instead.
+// Starting another array, or calling Close, ends the current one; an
+// array with no items is written as nil.
+func (e *Encoder) EncodeArrayHeader(key string) error {
+	return e.write(func() error {
+		if err := e.endArray(); err != nil {
+			return err
+		}
+		if key == "" {
+			e.stream = &arrayStream{indent: 0}
+			return nil
+		}
+		if err := e.writeKey("", key); err != nil {
+			return err
+		}
+		e.stream = &arrayStream{indent: 1}
+		return nil
+	})
+}
+
+// EncodeArrayItem writes v as the next item of the array started by
+// EncodeArrayHeader, exactly as it would be written in a slice.
+func (e *Encoder) EncodeArrayItem(v interface{}) error {
+	if e.stream == nil {
+		return errors.New("piml: EncodeArrayItem called without EncodeArrayHeader")
+	}
+	return e.write(func() error {
+		if e.stream.items == 0 {
+			// The header's line is only ended once it's known not to be nil.
+			if e.stream.indent > 0 {
+				if err := e.writeString("\n"); err != nil {
+					return err
+				}
+			}
+		}
+		e.pushIndex(e.stream.items)
+		e.stream.items++
+
+		indentStr := indentString(e.stream.indent)
+		rv := reflect.ValueOf(v)
+		if !rv.IsValid() {
+			return e.writeString(indentStr, "> nil\n")
+		}
+
+		t := rv.Type()
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if !isObjectType(t) {
+			return e.writePrimitiveArrayItem(rv, e.stream.indent)
+		}
+		if isNilOrEmpty(rv) {
+			return e.writeString(indentStr, "> nil\n")
+		}
+		return e.encodeValue(rv, e.stream.indent, true)
+	})
+}
+
+// Close ends the array being streamed, if any, and flushes the
+// underlying writer if it has a Flush method, such as a *bufio.Writer.
+// The encoder can still be used afterwards.
+func (e *Encoder) Close() error {
+	if err := e.write(e.endArray); err != nil {
+		return err
+	}
+	if f, ok := e.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// endArray finishes the array being streamed.
+func (e *Encoder) endArray() error {
+	s := e.stream
+	if s == nil {
+		return nil
+	}
+	e.stream = nil
+	if s.items > 0 {
+		return nil
+	}
+	if s.indent == 0 {
+		return e.writeString("nil\n")
+	}
+	return e.writeString(" nil\n")
+}
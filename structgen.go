@@ -0,0 +1,339 @@
+package piml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// GenerateStruct writes the Go source of a struct type named name, with
+// piml tags, that the sample document data decodes into. Nested objects
+// become struct types of their own, listed after it, so an existing
+// config can be turned into Go types instead of writing them by hand:
+//
+//	src, err := piml.GenerateStruct(sample, "Config")
+//
+// Field types are guessed from the sample values: bool, int, float64,
+// time.Time (RFC 3339) and string, in that order of preference, with
+// the items of every array merged to find their type. Keys whose only
+// value is nil become interface{}, empty blocks map[string]interface{},
+// and numbers with leading zeros, such as postal codes, stay strings.
+//
+// The output is gofmt-formatted declarations, without a package clause
+// or imports.
+func GenerateStruct(data []byte, name string) ([]byte, error) {
+	root, err := sampleDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.kind != sampleObject && root.kind != sampleUnknown {
+		return nil, errors.New("piml: the sample document must be an object")
+	}
+
+	g := &structGen{names: map[string]bool{}}
+	g.declare(root, exportedName(name))
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("piml: generated invalid Go code: %w", err)
+	}
+	return src, nil
+}
+
+// sampleKind is the kind of value found at a place of a sample document.
+type sampleKind int
+
+const (
+	sampleUnknown sampleKind = iota // Only nil or empty blocks
+	sampleScalar
+	sampleObject
+	sampleArray
+	sampleSet
+	sampleMixed // Different kinds in different samples
+)
+
+// sampleNode gathers every value found at a place of a sample
+// document, such as a key of the root, or the items of an array.
+type sampleNode struct {
+	kind   sampleKind
+	keys   []string               // Keys of an object, in order of appearance
+	fields map[string]*sampleNode // By key
+	items  *sampleNode            // Items of an array or set, merged
+	values []string               // Scalars, nil excluded
+	empty  bool                   // Whether an empty block was found
+}
+
+// setKind records that a value of kind k was found at n.
+func (n *sampleNode) setKind(k sampleKind) {
+	if n.kind == sampleUnknown {
+		n.kind = k
+	} else if n.kind != k {
+		n.kind = sampleMixed
+	}
+}
+
+// sampleDocument walks data, merging the values found at each place.
+func sampleDocument(data []byte) (*sampleNode, error) {
+	type frame struct {
+		node *sampleNode
+		key  string // Key waiting for its value, in an object
+	}
+	root := &sampleNode{}
+	var stack []*frame
+	rootUsed := false
+
+	// next returns the node of the value that comes next.
+	next := func() *sampleNode {
+		if len(stack) == 0 {
+			rootUsed = true
+			return root
+		}
+		f := stack[len(stack)-1]
+		if f.node.kind != sampleObject {
+			if f.node.items == nil {
+				f.node.items = &sampleNode{}
+			}
+			return f.node.items
+		}
+		child, ok := f.node.fields[f.key]
+		if !ok {
+			child = &sampleNode{}
+			f.node.keys = append(f.node.keys, f.key)
+			f.node.fields[f.key] = child
+		}
+		return child
+	}
+	start := func(k sampleKind) func(Position) error {
+		return func(Position) error {
+			n := next()
+			if k != sampleObject {
+				n.setKind(k)
+			}
+			n.empty = true // Until a key or item shows up
+			stack = append(stack, &frame{node: n})
+			return nil
+		}
+	}
+	end := func(Position) error {
+		stack = stack[:len(stack)-1]
+		return nil
+	}
+	// scalar returns the node of the scalar that comes next, which
+	// makes the block holding it non-empty.
+	scalar := func() *sampleNode {
+		if len(stack) > 0 {
+			stack[len(stack)-1].node.empty = false
+		}
+		return next()
+	}
+
+	err := Walk(data, Handler{
+		OnKey: func(key string, _ Position) error {
+			f := stack[len(stack)-1]
+			f.node.setKind(sampleObject)
+			if f.node.fields == nil {
+				f.node.fields = map[string]*sampleNode{}
+			}
+			f.node.empty = false
+			f.key = key
+			return nil
+		},
+		OnScalar: func(value string, _ Position) error {
+			n := scalar()
+			n.setKind(sampleScalar)
+			n.values = append(n.values, value)
+			return nil
+		},
+		OnObjectStart: start(sampleObject),
+		OnObjectEnd:   end,
+		OnArrayStart:  start(sampleArray),
+		OnArrayEnd:    end,
+		OnSetStart:    start(sampleSet),
+		OnSetEnd:      end,
+		onNil: func(Position) error {
+			scalar()
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !rootUsed {
+		root.empty = true
+	}
+	return root, nil
+}
+
+// structGen writes the struct types of a sample document.
+type structGen struct {
+	buf   bytes.Buffer
+	names map[string]bool // Type names used so far
+	queue []pendingType   // Nested types still to be written
+}
+
+// pendingType is a nested struct type to be written.
+type pendingType struct {
+	node *sampleNode
+	name string
+}
+
+// declare writes the struct type of object n, named name, followed by
+// the types of the objects nested in it.
+func (g *structGen) declare(n *sampleNode, name string) {
+	g.names[name] = true
+	g.queue = append(g.queue, pendingType{n, name})
+	for len(g.queue) > 0 {
+		t := g.queue[0]
+		g.queue = g.queue[1:]
+		g.writeStruct(t.node, t.name)
+	}
+}
+
+// writeStruct writes the struct type of object n.
+func (g *structGen) writeStruct(n *sampleNode, name string) {
+	if g.buf.Len() > 0 {
+		g.buf.WriteString("\n")
+	}
+	fmt.Fprintf(&g.buf, "type %s struct {\n", name)
+	fieldNames := map[string]bool{}
+	for _, key := range n.keys {
+		fieldName := uniqueName(exportedName(key), fieldNames)
+		fieldNames[fieldName] = true
+		fmt.Fprintf(&g.buf, "\t%s %s `piml:%s`\n", fieldName, g.goType(n.fields[key], name, fieldName, false), strconv.Quote(key))
+	}
+	g.buf.WriteString("}\n")
+}
+
+// goType returns the Go type of the values gathered in n, found under
+// field fieldName of struct type parent, queueing the struct types it
+// needs. item is set for the items of an array.
+func (g *structGen) goType(n *sampleNode, parent, fieldName string, item bool) string {
+	switch n.kind {
+	case sampleScalar:
+		return scalarGoType(n.values)
+	case sampleObject:
+		typeName := fieldName
+		if item {
+			typeName = singular(typeName)
+		}
+		if g.names[typeName] {
+			typeName = uniqueName(parent+typeName, g.names)
+		}
+		g.names[typeName] = true
+		g.queue = append(g.queue, pendingType{n, typeName})
+		return typeName
+	case sampleArray:
+		if n.items == nil {
+			return "[]interface{}"
+		}
+		return "[]" + g.goType(n.items, parent, fieldName, true)
+	case sampleSet:
+		if n.items == nil || n.items.kind != sampleScalar {
+			return "map[string]struct{}"
+		}
+		return "map[" + scalarGoType(n.items.values) + "]struct{}"
+	case sampleUnknown:
+		if n.empty {
+			return "map[string]interface{}"
+		}
+	}
+	return "interface{}"
+}
+
+// scalarGoType returns the Go type that fits all of values.
+func scalarGoType(values []string) string {
+	isBool, isInt, isFloat, isTime := true, true, true, true
+	for _, v := range values {
+		if v != "true" && v != "false" {
+			isBool = false
+		}
+		leadingZero := len(v) > 1 && v[0] == '0' && v[1] != '.'
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil || leadingZero {
+			isInt = false
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil || leadingZero || !strings.ContainsAny(v, "0123456789") {
+			isFloat = false
+		}
+		if _, err := time.Parse(time.RFC3339Nano, v); err != nil {
+			isTime = false
+		}
+	}
+	switch {
+	case len(values) == 0:
+		return "interface{}"
+	case isBool:
+		return "bool"
+	case isInt:
+		return "int"
+	case isFloat:
+		return "float64"
+	case isTime:
+		return "time.Time"
+	}
+	return "string"
+}
+
+// commonInitialisms are written in capitals in Go names, as in UserID.
+var commonInitialisms = map[string]bool{
+	"API": true, "CPU": true, "DNS": true, "HTML": true, "HTTP": true,
+	"HTTPS": true, "ID": true, "IP": true, "JSON": true, "SQL": true,
+	"SSH": true, "TCP": true, "TLS": true, "TTL": true, "UDP": true,
+	"UI": true, "URI": true, "URL": true, "UUID": true, "XML": true,
+}
+
+// exportedName turns a key such as "max_conn-count" into an exported Go
+// name such as MaxConnCount.
+func exportedName(key string) string {
+	words := strings.FieldsFunc(key, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, w := range words {
+		upper := strings.ToUpper(w)
+		if commonInitialisms[upper] {
+			b.WriteString(upper)
+			continue
+		}
+		if plural, ok := strings.CutSuffix(upper, "S"); ok && commonInitialisms[plural] {
+			b.WriteString(plural + "s") // IDs, URLs
+			continue
+		}
+		r := []rune(w)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) || !unicode.IsUpper([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// singular guesses the singular of a plural name, for the item types of
+// arrays: Users becomes User, and Entries Entry.
+func singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return name[:len(name)-3] + "y"
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") && len(name) > 1:
+		return name[:len(name)-1]
+	}
+	return name + "Item"
+}
+
+// uniqueName returns name, or name followed by a number if it is
+// already in use.
+func uniqueName(name string, used map[string]bool) string {
+	if !used[name] {
+		return name
+	}
+	for i := 2; ; i++ {
+		if n := name + strconv.Itoa(i); !used[n] {
+			return n
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package piml
+
+import (
+	"reflect"
+	"strings"
+)
+
+// SetTagKeys makes the decoder read struct tags under keys instead of
+// "piml", trying each key in turn and using the first tag present, so
+// structs annotated for another package can be reused without tagging
+// every field again:
+//
+//	d.SetTagKeys("piml", "json") // json tags where there are no piml tags
+//	d.SetTagKeys("config")       // Only config tags
+//
+// Options that mean nothing to PIML, such as json's omitempty, are
+// ignored. Calling SetTagKeys with no keys restores "piml".
+func (d *Decoder) SetTagKeys(keys ...string) {
+	d.tagKeys = keys
+}
+
+// SetTagKeys makes the encoder read struct tags under keys instead of
+// "piml", as Decoder.SetTagKeys does.
+func (e *Encoder) SetTagKeys(keys ...string) {
+	e.tagKeys = keys
+}
+
+// lookupTag returns the first of the tags of sf under keys, or its piml
+// tag if keys is empty.
+func lookupTag(sf reflect.StructField, keys []string) string {
+	if len(keys) == 0 {
+		return sf.Tag.Get("piml")
+	}
+	for _, key := range keys {
+		if tag, ok := sf.Tag.Lookup(key); ok {
+			return tag
+		}
+	}
+	return ""
+}
+
+// joinTagKeys returns the cache key of a list of tag keys.
+func joinTagKeys(keys []string) string {
+	if len(keys) == 0 {
+		return "piml"
+	}
+	return strings.Join(keys, ",")
+}
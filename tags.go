@@ -0,0 +1,44 @@
+package piml
+
+import "strings"
+
+// tagOptions is the string following a comma in a struct field's "piml"
+// tag, or the empty string. It does not include the leading comma.
+type tagOptions string
+
+// parseTag splits a struct field's piml tag into its name and
+// comma-separated options.
+func parseTag(tag string) (string, tagOptions) {
+	name, opt, _ := strings.Cut(tag, ",")
+	return name, tagOptions(opt)
+}
+
+// Contains reports whether a comma-separated list of options
+// contains a particular optionName flag.
+func (o tagOptions) Contains(optionName string) bool {
+	if len(o) == 0 {
+		return false
+	}
+	s := string(o)
+	for s != "" {
+		var name string
+		name, s, _ = strings.Cut(s, ",")
+		if name == optionName {
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the value of a `name=value` option, and whether it was present.
+func (o tagOptions) Get(optionName string) (string, bool) {
+	s := string(o)
+	for s != "" {
+		var opt string
+		opt, s, _ = strings.Cut(s, ",")
+		if value, ok := strings.CutPrefix(opt, optionName+"="); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
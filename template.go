@@ -0,0 +1,126 @@
+package piml
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"text/template"
+)
+
+// templateConfig holds the settings for template preprocessing.
+type templateConfig struct {
+	data  interface{}
+	funcs template.FuncMap
+}
+
+// lineMarker wraps the source line number injected at the start of
+// every template line, so rendered lines can be traced back.
+const lineMarker = '\x00'
+
+// UseTemplate makes the decoder run its input through text/template,
+// executed with data and funcs, before parsing.
+//
+// Line numbers in errors reported by Decode refer to the lines of the
+// original template, not the rendered output.
+func (d *Decoder) UseTemplate(data interface{}, funcs template.FuncMap) {
+	d.tmpl = &templateConfig{data: data, funcs: funcs}
+}
+
+// render executes the template and swaps the scanner over to the output.
+// It runs once per input, the first time Decode is called.
+func (d *Decoder) render() error {
+	cfg := d.tmpl
+	d.rendered = true
+
+	t, err := template.New("piml").Funcs(cfg.funcs).Parse(string(markLines(d.src)))
+	if err != nil {
+		return fmt.Errorf("piml: template: %w", err)
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, cfg.data); err != nil {
+		return fmt.Errorf("piml: template: %w", err)
+	}
+
+	rendered, lineMap := unmarkLines(out.Bytes())
+	d.setInput(rendered)
+	d.lineMap = lineMap
+	return nil
+}
+
+// markLines injects a marker holding its line number into each source line.
+//
+// The marker goes after the line's indentation, so trim markers ("-}}")
+// on the previous line behave as they would without it. Lines opening
+// with a left-trim action ("{{-") get the marker after that action instead,
+// and lines continuing a multi-line action get none at all.
+func markLines(src []byte) []byte {
+	var b bytes.Buffer
+	depth := 0
+	for i, line := range bytes.Split(src, []byte("\n")) {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		at := -1
+		if depth == 0 {
+			content := bytes.TrimLeft(line, " ")
+			at = len(line) - len(content)
+			if bytes.HasPrefix(content, []byte("{{-")) {
+				at = -1
+				if end := bytes.Index(line, []byte("}}")); end != -1 {
+					at = end + 2
+				}
+			}
+		}
+		depth += bytes.Count(line, []byte("{{")) - bytes.Count(line, []byte("}}"))
+		if at == -1 {
+			b.Write(line)
+			continue
+		}
+		b.Write(line[:at])
+		b.WriteByte(lineMarker)
+		b.WriteString(strconv.Itoa(i + 1))
+		b.WriteByte(lineMarker)
+		b.Write(line[at:])
+	}
+	return b.Bytes()
+}
+
+// unmarkLines strips the markers injected by markLines from the rendered
+// output. It returns the clean output and, for every output line, the
+// source line it came from.
+//
+// A line takes the number of the last marker seen before its content;
+// lines without one (e.g. produced by a function) inherit the previous number.
+func unmarkLines(rendered []byte) ([]byte, []int) {
+	var b bytes.Buffer
+	var lineMap []int
+	current := 1
+	for len(rendered) > 0 {
+		line, rest, more := cutLine(rendered)
+		sawContent := false
+		for i := 0; i < len(line); i++ {
+			if line[i] != lineMarker {
+				if line[i] != ' ' {
+					sawContent = true
+				}
+				b.WriteByte(line[i])
+				continue
+			}
+			end := bytes.IndexByte(line[i+1:], lineMarker)
+			if end == -1 {
+				b.WriteByte(line[i]) // Not one of ours
+				continue
+			}
+			if n, err := strconv.Atoi(string(line[i+1 : i+1+end])); err == nil && !sawContent {
+				current = n
+			}
+			i += end + 1
+		}
+		if more {
+			b.WriteByte('\n')
+		}
+		lineMap = append(lineMap, current)
+		rendered = rest
+	}
+	return b.Bytes(), lineMap
+}
@@ -0,0 +1,62 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// TimeLayoutUnix is a pseudo-layout for SetTimeLayouts that
+// accepts Unix timestamps in seconds, e.g. "1700000000".
+const TimeLayoutUnix = "unix"
+
+// defaultTimeLayouts are the layouts accepted for time.Time
+// values unless SetTimeLayouts says otherwise.
+var defaultTimeLayouts = []string{time.RFC3339Nano}
+
+// SetTimeLayouts sets the layouts accepted when decoding time.Time values.
+// They are tried in order and the first one that parses wins, so files
+// mixing formats can be read:
+//
+//	d.SetTimeLayouts(time.RFC3339Nano, time.DateTime, piml.TimeLayoutUnix)
+//
+// The default is RFC3339Nano alone, which also accepts RFC3339.
+// Encoding always uses RFC3339Nano.
+func (d *Decoder) SetTimeLayouts(layouts ...string) {
+	d.layouts = layouts
+}
+
+// setTime parses valueStr with the decoder's time layouts and stores it in v.
+func (d *Decoder) setTime(v reflect.Value, valueStr string) error {
+	layouts := d.layouts
+	if len(layouts) == 0 {
+		layouts = defaultTimeLayouts
+	}
+
+	var firstErr error
+	for _, layout := range layouts {
+		var t time.Time
+		var err error
+		if layout == TimeLayoutUnix {
+			var sec int64
+			if sec, err = strconv.ParseInt(valueStr, 10, 64); err == nil {
+				t = time.Unix(sec, 0).UTC()
+			}
+		} else {
+			t, err = time.Parse(layout, valueStr)
+		}
+		if err == nil {
+			v.Set(reflect.ValueOf(t))
+			return nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if len(layouts) == 1 {
+		return fmt.Errorf("piml: invalid time format: %w", firstErr)
+	}
+	return fmt.Errorf("piml: invalid time format: %q matches none of the layouts %q", valueStr, layouts)
+}
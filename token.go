@@ -0,0 +1,205 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Token is a piece of a PIML document, as written by
+// Encoder.EncodeToken. It holds a value of one of these types:
+//
+//	Key                 (key)
+//	Delim               the start or end of an object, array or set
+//	Comment             # comment
+//	string              a scalar, written on several lines if it has any
+//	bool, int, float64  and the other basic kinds, as scalars
+//	nil                 nil
+type Token interface{}
+
+// A Key is a token naming the value that follows it.
+type Key string
+
+// A Comment is a token holding the text of a full-line comment.
+type Comment string
+
+// A Delim is a token starting or ending an object, array or set.
+type Delim int
+
+// The delimiters of a token stream. An object in an array is written as
+// "> (item)", with its fields below it.
+const (
+	ObjectStart Delim = iota
+	ObjectEnd
+	ArrayStart
+	ArrayEnd
+	SetStart
+	SetEnd
+)
+
+func (d Delim) String() string {
+	switch d {
+	case ObjectStart:
+		return "ObjectStart"
+	case ObjectEnd:
+		return "ObjectEnd"
+	case ArrayStart:
+		return "ArrayStart"
+	case ArrayEnd:
+		return "ArrayEnd"
+	case SetStart:
+		return "SetStart"
+	case SetEnd:
+		return "SetEnd"
+	}
+	return fmt.Sprintf("Delim(%d)", int(d))
+}
+
+// tokenFrame is an object, array or set being written by EncodeToken.
+type tokenFrame struct {
+	kind   Delim  // ObjectStart, ArrayStart or SetStart
+	indent int    // Indentation of the children
+	key    bool   // Whether a key is waiting for its value
+	empty  string // Written to end the header line if there are no children
+	keys   bool   // Whether any key has been written
+	done   bool   // At the root, whether a bare value ended the document
+}
+
+// EncodeToken writes the next token of a document, so tools working
+// on token streams, like format converters and filters, can write PIML
+// without building Go values first:
+//
+//	e.EncodeToken(piml.Key("ports"))
+//	e.EncodeToken(piml.ArrayStart)
+//	e.EncodeToken(8080)
+//	e.EncodeToken(piml.ArrayEnd)
+//
+// The root of the document is an object, whose keys can be written
+// with or without an ObjectStart and ObjectEnd around them, but it may
+// also be a single scalar, an array or a set. EncodeToken
+// returns an error for tokens that are out of place, such as a scalar
+// without a key in an object.
+func (e *Encoder) EncodeToken(t Token) error {
+	return e.write(func() error {
+		if e.tokens == nil {
+			e.tokens = []*tokenFrame{{kind: ObjectStart}}
+		}
+		f := e.tokens[len(e.tokens)-1]
+		root := len(e.tokens) == 1
+		if f.done {
+			return fmt.Errorf("piml: unexpected token after the root value")
+		}
+
+		switch t := t.(type) {
+		case Key:
+			if f.kind != ObjectStart || f.key {
+				return fmt.Errorf("piml: unexpected key %q", string(t))
+			}
+			if err := e.startChild(f); err != nil {
+				return err
+			}
+			f.key, f.keys = true, true
+			return e.writeKey(indentString(f.indent), string(t))
+
+		case Comment:
+			if f.key {
+				return fmt.Errorf("piml: unexpected comment %q after a key", string(t))
+			}
+			if err := e.startChild(f); err != nil {
+				return err
+			}
+			return e.writeComment(indentString(f.indent), string(t))
+
+		case Delim:
+			switch t {
+			case ObjectStart, ArrayStart, SetStart:
+				return e.startBlock(f, t, root)
+			case ObjectEnd, ArrayEnd, SetEnd:
+				if root || f.kind != t-1 || f.key {
+					return fmt.Errorf("piml: unexpected %s", t)
+				}
+				e.tokens = e.tokens[:len(e.tokens)-1]
+				if f.empty != "" {
+					return e.writeString(f.empty)
+				}
+				return nil
+			}
+			return fmt.Errorf("piml: invalid delimiter %s", t)
+		}
+
+		// Anything else is a scalar.
+		s := "nil"
+		if t != nil {
+			var err error
+			if s, err = formatPrimitive(reflect.ValueOf(t)); err != nil {
+				return err
+			}
+		}
+		if f.kind == ObjectStart && !f.key && (!root || f.keys) {
+			return fmt.Errorf("piml: unexpected value %q without a key", s)
+		}
+		if err := e.startChild(f); err != nil {
+			return err
+		}
+		switch {
+		case f.kind == SetStart:
+			if t == nil {
+				return fmt.Errorf("piml: unexpected nil in a set")
+			}
+			return e.writeSetItem(indentString(f.indent), s)
+		case f.kind == ArrayStart:
+			if t == nil {
+				return e.writeString(indentString(f.indent), "> nil\n")
+			}
+			return e.encodeString(s, f.indent, true)
+		case f.key:
+			f.key = false
+			if t == nil {
+				return e.writeString(" nil\n")
+			}
+			return e.encodeString(s, f.indent, false)
+		}
+		// A scalar at the root is the whole document.
+		f.done = true
+		if t == nil {
+			return e.writeString("nil\n")
+		}
+		return e.encodeString(s, -1, false)
+	})
+}
+
+// startBlock writes the header of an object, array or set, and makes it
+// the frame that receives the following tokens.
+func (e *Encoder) startBlock(f *tokenFrame, kind Delim, root bool) error {
+	switch {
+	case f.key:
+		// (key), followed by the block on the next lines.
+		f.key = false
+		e.tokens = append(e.tokens, &tokenFrame{kind: kind, indent: f.indent + 1, empty: " nil\n"})
+		return nil
+	case f.kind == ArrayStart && kind == ObjectStart:
+		// > (item), with the fields two levels deeper.
+		if err := e.startChild(f); err != nil {
+			return err
+		}
+		if err := e.writeString(indentString(f.indent), "> (item)"); err != nil {
+			return err
+		}
+		e.tokens = append(e.tokens, &tokenFrame{kind: kind, indent: f.indent + 2, empty: "\n"})
+		return nil
+	case root && !f.keys:
+		// The block is the whole document.
+		f.done = true
+		e.tokens = append(e.tokens, &tokenFrame{kind: kind})
+		return nil
+	}
+	return fmt.Errorf("piml: unexpected %s", kind)
+}
+
+// startChild ends the header line of frame f before its first child.
+func (e *Encoder) startChild(f *tokenFrame) error {
+	if f.empty == "" {
+		return nil
+	}
+	f.empty = ""
+	return e.writeString("\n")
+}
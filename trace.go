@@ -0,0 +1,35 @@
+package piml
+
+import "reflect"
+
+// An Assignment is a scalar of the document that the decoder stored in
+// a Go value, as reported to SetTraceHook.
+type Assignment struct {
+	Path  string      // Path to the value, as in db.port or hosts[1]
+	Line  int         // Line of the value, 0 for the root
+	Text  string      // The scalar as written, before resolvers and coercion
+	Value interface{} // The Go value it was stored as
+}
+
+// SetTraceHook makes the decoder call fn for every scalar it stores,
+// after storing it, for audit logs, or to tell which line of which file
+// a setting came from when several are decoded into the same value.
+// Values that fail to decode are not reported. A nil fn stops the calls.
+//
+// Tracing makes decoding slower, as the common maps and slices are then
+// read one value at a time.
+func (d *Decoder) SetTraceHook(fn func(Assignment)) {
+	d.traceHook = fn
+}
+
+// traceAssign reports that valueStr was stored in v.
+func (d *Decoder) traceAssign(v reflect.Value, valueStr string) {
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+	var value interface{}
+	if v.CanInterface() {
+		value = v.Interface()
+	}
+	d.traceHook(Assignment{Path: formatPath(d.path), Line: d.valueLine, Text: valueStr, Value: value})
+}
@@ -0,0 +1,185 @@
+package piml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Tree returns the hierarchy of keys of the PIML document data as an
+// ASCII tree, with the type of each value and the size of objects and
+// lists, to get to know a large config file at a glance:
+//
+//	object (2 keys)
+//	|-- server: object (2 keys)
+//	|   |-- host: string
+//	|   `-- port: int
+//	`-- admins: list[2] of User (2 keys)
+//	    |-- name: string
+//	    `-- role: string
+//
+// Scalars are typed by how they read: int, float, bool or string. The
+// objects in a list are named by the label of their items, such as the
+// type name in "> (User)", unless they are labelled item. Their fields
+// are shown once, merged over all items, and nil values only count
+// where no item has another value.
+func Tree(data []byte) (string, error) {
+	root, err := ParseNode(data)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	shape := nodeShape([]*Node{root})
+	b.WriteString(shape.desc)
+	b.WriteByte('\n')
+	shape.writeChildren(&b, "")
+	return b.String(), nil
+}
+
+// A treeShape is what the values at one place of a document look like,
+// merged over the items of the lists on the way.
+type treeShape struct {
+	desc   string
+	keys   []string // Keys of the fields, in order
+	fields map[string]*treeShape
+}
+
+// writeChildren writes the fields of s, each line after prefix.
+func (s *treeShape) writeChildren(b *strings.Builder, prefix string) {
+	for i, k := range s.keys {
+		branch, next := "|-- ", "|   "
+		if i == len(s.keys)-1 {
+			branch, next = "`-- ", "    "
+		}
+		c := s.fields[k]
+		fmt.Fprintf(b, "%s%s%s: %s\n", prefix, branch, k, c.desc)
+		c.writeChildren(b, prefix+next)
+	}
+}
+
+// nodeShape returns the shape of values, which are the values at the
+// same place in the items of a list, or the root alone.
+func nodeShape(values []*Node) *treeShape {
+	var set []*Node // Values that aren't nil
+	for _, n := range values {
+		if n.Kind != NilNode {
+			set = append(set, n)
+		}
+	}
+	if len(set) == 0 {
+		return &treeShape{desc: "nil"}
+	}
+	kind := set[0].Kind
+	for _, n := range set {
+		if n.Kind != kind {
+			return &treeShape{desc: "mixed"}
+		}
+	}
+
+	switch kind {
+	case ScalarNode:
+		return &treeShape{desc: scalarsType(set)}
+	case ObjectNode:
+		s := &treeShape{fields: map[string]*treeShape{}}
+		byKey := map[string][]*Node{}
+		for _, n := range set {
+			for _, c := range n.Children {
+				if _, ok := byKey[c.Key]; !ok {
+					s.keys = append(s.keys, c.Key)
+				}
+				byKey[c.Key] = append(byKey[c.Key], c)
+			}
+		}
+		for _, k := range s.keys {
+			s.fields[k] = nodeShape(byKey[k])
+		}
+		s.desc = fmt.Sprintf("object (%d keys)", len(s.keys))
+		if len(s.keys) == 1 {
+			s.desc = "object (1 key)"
+		}
+		return s
+	}
+
+	// Lists, whose size is shown if it is the same for all of them.
+	name := "list"
+	if kind == SetNode {
+		name = "set"
+	}
+	size := len(set[0].Children)
+	var items []*Node
+	for _, n := range set {
+		if len(n.Children) != size {
+			size = -1
+		}
+		items = append(items, n.Children...)
+	}
+	if size >= 0 {
+		name += "[" + strconv.Itoa(size) + "]"
+	}
+	item := nodeShape(items)
+	if label := itemsLabel(items); label != "" {
+		item.desc = label + strings.TrimPrefix(item.desc, "object")
+	}
+	item.desc = name + " of " + item.desc
+	return item
+}
+
+// itemsLabel returns the label that the objects in a list all have,
+// such as the User of "> (User)", or "" if they differ or are only
+// labelled item.
+func itemsLabel(items []*Node) string {
+	label := ""
+	for _, n := range items {
+		switch {
+		case n.Kind == NilNode:
+		case n.Kind != ObjectNode || n.Key == "" || n.Key == "item":
+			return ""
+		case label == "":
+			label = n.Key
+		case n.Key != label:
+			return ""
+		}
+	}
+	return label
+}
+
+// scalarsType returns the type that all the scalars read as, or
+// "mixed".
+func scalarsType(scalars []*Node) string {
+	typ := ""
+	for _, n := range scalars {
+		t := readType(n.Value)
+		switch {
+		case typ == "" || typ == t:
+			typ = t
+		case typ == "int" && t == "float" || typ == "float" && t == "int":
+			typ = "float"
+		default:
+			return "mixed"
+		}
+	}
+	return typ
+}
+
+// readType returns the type that a scalar reads as.
+func readType(s string) string {
+	switch {
+	case s == "true" || s == "false":
+		return "bool"
+	case isInt(s):
+		return "int"
+	case strings.ContainsAny(s, "0123456789") && isFloat(s):
+		return "float"
+	}
+	return "string"
+}
+
+func isInt(s string) bool {
+	_, err := strconv.ParseInt(s, 10, 64)
+	return err == nil
+}
+
+func isFloat(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
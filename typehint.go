@@ -0,0 +1,86 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SetTypeHints makes the decoder read a "!!type " prefix of a scalar as
+// the type of its value, for schemaless consumers decoding into
+// interface{}, where every scalar is otherwise a string:
+//
+//	(port) !!int 8080
+//	(ratio) !!float 0.5
+//	(debug) !!bool true
+//	(started) !!time 2024-05-01T12:00:00Z
+//	(name) !!str nil
+//	(parent) !!null
+//
+// These read as an int64, a float64, a bool, a time.Time, the string
+// "nil" and nil. "!!str" keeps the text as it is written, with no
+// resolvers applied, so it also keeps values such as "nil" and
+// "env://HOME" as strings. Into values of other types, the hint is
+// dropped and the rest is decoded as usual. An unknown hint, or a value
+// that doesn't read as its hint, is an error.
+func (d *Decoder) SetTypeHints(on bool) {
+	d.typeHints = on
+}
+
+// typeHints are the hints SetTypeHints reads.
+var typeHints = map[string]bool{"str": true, "int": true, "float": true, "bool": true, "time": true, "null": true}
+
+// cutTypeHint splits a scalar such as "!!int 8080" into its hint and
+// its value, reporting whether it has a hint.
+func cutTypeHint(s string) (hint, value string, ok bool) {
+	rest, ok := strings.CutPrefix(s, "!!")
+	if !ok {
+		return "", s, false
+	}
+	hint, value, _ = strings.Cut(rest, " ")
+	return hint, value, true
+}
+
+// setHinted sets v to the scalar value, typed by hint.
+func (d *Decoder) setHinted(v reflect.Value, hint, value string) error {
+	if !typeHints[hint] {
+		return fmt.Errorf("piml: unknown type hint !!%s", hint)
+	}
+	if hint == "null" {
+		if value != "" {
+			return fmt.Errorf("piml: !!null can't have a value, got %q", value)
+		}
+		return d.setPrimitive(v, "nil")
+	}
+	target := indirect(v, true)
+	if !isEmptyInterface(target) {
+		if hint == "str" {
+			return d.setScalar(target, value) // As written, even nil
+		}
+		return d.setPrimitive(v, value)
+	}
+
+	var typed interface{}
+	var err error
+	switch hint {
+	case "str":
+		typed = value
+	case "int":
+		typed, err = strconv.ParseInt(value, 10, 64)
+	case "float":
+		typed, err = strconv.ParseFloat(value, 64)
+	case "bool":
+		typed, err = strconv.ParseBool(value)
+	case "time":
+		var t time.Time
+		err = d.setTime(reflect.ValueOf(&t).Elem(), value)
+		typed = t
+	}
+	if err != nil {
+		return fmt.Errorf("piml: invalid !!%s value %q: %w", hint, value, err)
+	}
+	target.Set(reflect.ValueOf(typed))
+	return nil
+}
@@ -5,26 +5,110 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 )
 
 // A Decoder reads and decodes PIML values from an input byte slice.
 type Decoder struct {
-	s       *bufio.Scanner
-	peekBuf *lineInfo // Buffer for one-line lookahead
+	src      []byte
+	s        *bufio.Scanner
+	scanner  bufio.Scanner   // Storage for s
+	reader   bytes.Reader    // Reader of src for scanner
+	scanBuf  []byte          // Initial buffer of scanner
+	split    bufio.SplitFunc // scanLines, bound once
+	readBuf  []byte          // Input read by ResetReader
+	peekBuf  *lineInfo       // Buffer for one-line lookahead
+	replay   []*lineInfo     // Lines to read again before scanning further
+	line     int             // Number of lines scanned so far
+	lineMap  []int           // Maps scanned line numbers to source lines (templated input)
+	scanned  int             // Number of bytes scanned so far
+	start    int             // Input offset of the last line scanned
+	offset   int             // Number of bytes consumed, see InputOffset
+	sep      *lineInfo       // Document separator ending the current document
+	tmpl     *templateConfig
+	rendered bool       // Whether the template has been run on the input
+	lines    []lineInfo // Arena the lines are allocated from, see newLine
+
+	resolvers map[string]Resolver // Keyed by scheme
+	profile   string              // Active profile, see UseProfile
+	refFS     fs.FS               // Source of $ref documents
+	refStack  []string            // References being resolved, for cycle detection
+	layouts   []string            // Accepted time.Time layouts, in order
+
+	inlineComments bool                // " #" starts a comment in values
+	keyNormalizer  func(string) string // Applied to keys and struct tags
+	matchKey       MatchKeyFunc        // Compares keys, see SetMatchKey
+	tagKeys        []string            // Struct tag keys, see SetTagKeys
+	keyCases       KeyCase             // Other keys of untagged fields, see SetKeyCases
+	indentUnit     int                 // Required indentation step, 0 for any
+	strictFields   bool                // Report keys of ambiguous fields
+	flat           bool                // Split keys at dots, see SetFlat
+	expanded       bool                // Whether the input was split, for flat
+	onlyPrefixes   []string            // Key paths to decode, see SetOnlyPrefixes
+	skipPrefixes   []string            // Key paths to skip, see SetSkipPrefixes
+	section        string              // Path of the object being decoded, while filtering
+	filtering      bool                // Whether keys are checked against the prefixes
+	lenient        bool                // Coerce sloppy scalars, see SetLenient
+	checksum       bool                // Verify the checksum trailer, see SetChecksum
+	verifier       VerifyFunc          // Checks the signature, see SetVerifier
+	signature      []byte              // Detached signature, see SetSignature
+	decryptor      Decryptor           // Decrypts "enc:" scalars, see SetDecryptor
+	appendSlices   bool                // Append to slices, see SetAppendSlices
+	keepOnNil      bool                // Leave values on nil, see SetKeepOnNil
+	zeroTarget     bool                // Zero the value first, see SetZeroTarget
+	rejectDangling bool                // Reject keys without values, see SetRejectDanglingKeys
+	partial        bool                // Carry on past errors, see SetPartial
+	path           []pathElem          // Path to the value being decoded, with partial
+	base           []pathElem          // Path the document is at, see cloneSettings
+	decoded        []string            // Paths read by the last Decode, see DecodedPaths
+	partialErrs    []error             // Errors skipped by the last Decode
+	warnings       bool                // Record warnings, see SetWarnings
+	warnHandler    func(Warning)       // Called with each warning
+	warned         []Warning           // Warnings of the last Decode
+	valueLine      int                 // Line of the value being decoded, for warnings
+	traceHook      func(Assignment)    // Called for every scalar stored, see SetTraceHook
+	tracing        bool                // Whether a scalar is being stored, with traceHook
+	verified       bool                // Whether the input was verified
+	verifyErr      error               // Why verification failed, returned by every Decode
+	numberMode     NumberMode          // How numbers are read into interface{}
+	typeHints      bool                // Read "!!type " prefixes, see SetTypeHints
+	genericNodes   bool                // Read interface{} values as nodes
+	keys           *keyCache           // Recent keys, see intern
+
+	stats      DecodeStats              // Statistics of the last Decode
+	statsHook  func(DecodeStats, error) // Called at the end of Decode
+	statsStart time.Time                // Start of the current Decode
+	statsLine  int                      // Lines scanned before it
+	statsBytes int                      // Bytes scanned before it
+	depth      int                      // Nesting of the value being decoded
 }
 
+// byteOrderMark is the UTF-8 byte order mark, ignored at the start of input.
+const byteOrderMark = "\uFEFF"
+
 // lineInfo stores the parsed data from a single line.
 type lineInfo struct {
 	indent   int    // Number of leading spaces
 	key      string // Key (if present)
 	value    string // Value (if present)
+	literal  bool   // Whether the value or line was escaped, so nil is the string "nil"
+	line     int    // Line number in the source, starting at 1
+	end      int    // Input offset just past the line
 	lineType lineType
 }
 
+// isNil reports whether the value of the line is nil, not escaped.
+func (li *lineInfo) isNil() bool {
+	return li.value == "nil" && !li.literal
+}
+
 // lineType categorizes the parsed line.
 type lineType int
 
@@ -40,20 +124,157 @@ const (
 
 // NewDecoder returns a new decoder that reads from data.
 func NewDecoder(data []byte) *Decoder {
-	return &Decoder{
-		s: bufio.NewScanner(bytes.NewReader(data)),
+	d := &Decoder{}
+	d.setInput(data)
+	return d
+}
+
+// cloneSettings returns a decoder reading from data, a document held in
+// the one d is reading or referred to by it, with the settings of d.
+// Paths in it start at the value d is decoding. Settings that apply to
+// d's input as a whole, like checksums, templates, flat keys and key
+// filters, are not copied.
+func (d *Decoder) cloneSettings(data []byte) *Decoder {
+	sub := NewDecoder(data)
+	sub.resolvers = d.resolvers
+	sub.profile = d.profile
+	sub.refFS = d.refFS
+	sub.refStack = d.refStack
+	sub.layouts = d.layouts
+	sub.inlineComments = d.inlineComments
+	sub.keyNormalizer = d.keyNormalizer
+	sub.matchKey = d.matchKey
+	sub.tagKeys = d.tagKeys
+	sub.keyCases = d.keyCases
+	sub.indentUnit = d.indentUnit
+	sub.strictFields = d.strictFields
+	sub.lenient = d.lenient
+	sub.decryptor = d.decryptor
+	sub.appendSlices = d.appendSlices
+	sub.keepOnNil = d.keepOnNil
+	sub.rejectDangling = d.rejectDangling
+	sub.partial = d.partial
+	sub.base = append([]pathElem(nil), d.path...)
+	sub.warnings = d.warnings
+	sub.warnHandler = d.warnHandler
+	sub.traceHook = d.traceHook
+	sub.numberMode = d.numberMode
+	sub.typeHints = d.typeHints
+	sub.genericNodes = d.genericNodes
+	return sub
+}
+
+// Reset makes the decoder read from data, as if it had just been
+// created with NewDecoder, but keeping the settings made with its Set
+// and Use methods and the memory it has allocated. This lets servers
+// that parse many small documents reuse decoders, e.g. from a sync.Pool:
+//
+//	d := decoders.Get().(*piml.Decoder)
+//	defer decoders.Put(d)
+//	d.Reset(data)
+//	err := d.Decode(&cfg)
+//
+// Reset itself doesn't allocate.
+func (d *Decoder) Reset(data []byte) {
+	d.setInput(data)
+	d.replay = nil
+	d.lineMap = nil
+	d.rendered = false
+	d.expanded = false
+	d.verified = false
+	d.verifyErr = nil
+	d.stats = DecodeStats{}
+	d.depth = 0
+	// Nothing refers to the lines of the last document any more.
+	d.lines = d.lines[:0]
+}
+
+// ResetReader is like Reset, but reads all of r first, into a buffer
+// the decoder reuses on the next call. The input from the last call is
+// overwritten, including what Buffered returns. If reading fails, the
+// decoder is left with no input.
+func (d *Decoder) ResetReader(r io.Reader) error {
+	b := bytes.NewBuffer(d.readBuf[:0])
+	_, err := b.ReadFrom(r)
+	d.readBuf = b.Bytes()
+	if err != nil {
+		d.Reset(nil)
+		return fmt.Errorf("piml: %w", err)
+	}
+	d.Reset(d.readBuf)
+	return nil
+}
+
+// setInput makes the decoder scan data from the start.
+func (d *Decoder) setInput(data []byte) {
+	d.src = data
+	// The reader, scanner and its buffer are reused by Reset.
+	d.reader.Reset(data)
+	if d.split == nil {
+		d.split = d.scanLines
+		d.scanBuf = make([]byte, 4096)
+	}
+	d.scanner = *bufio.NewScanner(&d.reader)
+	d.scanner.Buffer(d.scanBuf, bufio.MaxScanTokenSize)
+	d.scanner.Split(d.split)
+	d.s = &d.scanner
+	d.peekBuf = nil
+	d.line = 0
+	d.scanned = 0
+	d.start = 0
+	d.offset = 0
+	d.sep = nil
+}
+
+// scanLines splits the input into lines like bufio.ScanLines,
+// counting the bytes scanned.
+func (d *Decoder) scanLines(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := bufio.ScanLines(data, atEOF)
+	if advance > 0 {
+		d.start = d.scanned
+		d.scanned += advance
 	}
+	return advance, token, err
 }
 
 // Decode reads the next PIML-encoded value from its
 // input and stores it in the value pointed to by v.
-func (d *Decoder) Decode(v interface{}) error {
+func (d *Decoder) Decode(v interface{}) (err error) {
+	defer catchPanic(&err)
 	rv := reflect.ValueOf(v)
 	if rv.Kind() != reflect.Ptr || rv.IsNil() {
 		return ErrInvalidUnmarshal
 	}
+	if d.checksum || d.verifier != nil {
+		if err := d.verifyInput(); err != nil {
+			return err
+		}
+	}
+	if d.tmpl != nil && !d.rendered {
+		if err := d.render(); err != nil {
+			return err
+		}
+	}
+	if d.flat && !d.expanded {
+		if err := d.expand(); err != nil {
+			return err
+		}
+	}
+	if d.zeroTarget {
+		rv.Elem().SetZero()
+	}
+	d.nextDocument()
+	d.section, d.filtering = "", len(d.onlyPrefixes) > 0 || len(d.skipPrefixes) > 0
+	d.path, d.decoded, d.partialErrs = append(d.path[:0], d.base...), nil, nil
+	d.warned, d.valueLine = nil, 0
+	d.beginStats()
 	// We start with -1, as the root has no indentation.
-	return d.decodeValue(rv, -1)
+	err = d.decodeValue(rv, -1)
+	if len(d.partialErrs) > 0 {
+		err = errors.Join(append(d.partialErrs, err)...)
+	}
+	d.endStats(err)
+	return err
 }
 
 // peek gets the next line, parses it, and stores it in the buffer.
@@ -61,21 +282,40 @@ func (d *Decoder) peek() (*lineInfo, error) {
 	if d.peekBuf != nil {
 		return d.peekBuf, nil
 	}
+	if len(d.replay) > 0 {
+		d.peekBuf = d.replay[0]
+		d.replay = d.replay[1:]
+		return d.peekBuf, nil
+	}
+	if d.sep != nil {
+		return nil, nil // The document has ended
+	}
 
 	for d.s.Scan() {
+		d.line++
 		fullLine := d.s.Text() // The original, unmodified line
+		lineNum := d.sourceLine(d.line)
+
+		// Files edited on Windows may start with a BOM. Their \r\n line
+		// endings are already gone: scanLines drops the \r, and only it.
+		if d.line == 1 {
+			fullLine = strings.TrimPrefix(fullLine, byteOrderMark)
+		}
+
+		// A separator line ends the document, see Decoder.InputOffset.
+		if fullLine == documentSeparator {
+			d.offset = d.start
+			d.sep = &lineInfo{line: lineNum, end: d.scanned}
+			return nil, nil
+		}
 
 		// 1. Check for comments and escaped hashes.
-		trimmedForCommentCheck := strings.TrimSpace(fullLine)
-		if strings.HasPrefix(trimmedForCommentCheck, `\#`) {
-			// This is an escaped hash, not a comment.
-			// We need to remove the escape character before processing.
-			if idx := strings.Index(fullLine, `\`); idx != -1 {
-				fullLine = fullLine[:idx] + fullLine[idx+1:]
-			}
-		} else if strings.HasPrefix(trimmedForCommentCheck, "#") {
+		if strings.HasPrefix(strings.TrimSpace(fullLine), "#") {
 			continue // It's a full-line comment, skip.
 		}
+		// An escaped hash (\#, or \\# for a literal \#) is not a comment.
+		// We need to remove one escape character before processing.
+		fullLine = unescapeHash(fullLine)
 
 		// The line is not a comment line.
 		cleanLine := fullLine
@@ -87,7 +327,11 @@ func (d *Decoder) peek() (*lineInfo, error) {
 				indent++
 			} else if r == '\t' {
 				// Per spec, tabs are not allowed.
-				return nil, fmt.Errorf("%w: tabs are not allowed (line: %q)", ErrSyntax, fullLine)
+				return nil, fmt.Errorf("%w: line %d: tabs are not allowed (line: %q)", ErrSyntax, lineNum, fullLine)
+			} else if unicode.IsSpace(r) {
+				// Nor is any other whitespace, such as non-breaking spaces,
+				// which look like indentation but aren't counted as such.
+				return nil, fmt.Errorf("%w: line %d, column %d: %U is not allowed in indentation, use spaces (line: %q)", ErrSyntax, lineNum, indent+1, r, fullLine)
 			} else {
 				// We found the first non-space char
 				break
@@ -97,35 +341,41 @@ func (d *Decoder) peek() (*lineInfo, error) {
 		// 3. Check for blank lines (after calculating indent)
 		trimmedLine := strings.TrimSpace(cleanLine)
 		if trimmedLine == "" {
-			li := &lineInfo{indent: indent, lineType: lineBlank}
+			li := d.newLine()
+			*li = lineInfo{indent: indent, line: lineNum, end: d.scanned, lineType: lineBlank}
 			d.peekBuf = li
 			return li, nil
 		}
 
 		// 4. Parse the line based on its *trimmed* content
-		li := &lineInfo{indent: indent}
+		li := d.newLine()
+		*li = lineInfo{indent: indent, line: lineNum, end: d.scanned}
 		lineContent := trimmedLine // Use the trimmed line for parsing content
 
 		if strings.HasPrefix(lineContent, "> (") {
 			// > (item)
 			li.lineType = lineArrayObject
-			// Key is ignored, per spec
+			// The key is only a label, such as the type of the item,
+			// which decoding ignores, per spec.
+			li.key, _, _ = cutKey(lineContent[3:])
 		} else if strings.HasPrefix(lineContent, ">|") {
 			// >| value
 			li.lineType = lineSetItem
-			li.value = strings.TrimSpace(lineContent[2:])
+			li.value, li.literal = d.cleanValue(lineContent[2:], unescapeNil)
 		} else if strings.HasPrefix(lineContent, ">") {
 			// > value
 			li.lineType = lineArrayItem
-			li.value = strings.TrimSpace(lineContent[1:])
+			li.value, li.literal = d.cleanValue(lineContent[1:], unescapeScalar)
 		} else if strings.HasPrefix(lineContent, "(") {
 			// (key) value  OR (key)
-			closeParen := strings.Index(lineContent, ")")
-			if closeParen == -1 {
-				return nil, fmt.Errorf("%w: invalid key format, missing ')' (line: %q)", ErrSyntax, fullLine)
+			// Keys escape ')' and '\' with a backslash.
+			key, rest, ok := cutKey(lineContent[1:])
+			if !ok {
+				return nil, fmt.Errorf("%w: line %d: invalid key format, missing ')' (line: %q)", ErrSyntax, lineNum, fullLine)
 			}
-			li.key = lineContent[1:closeParen]
-			li.value = strings.TrimSpace(lineContent[closeParen+1:])
+			li.key = d.intern(d.normalizeKey(key))
+			d.stats.Keys++
+			li.value, li.literal = d.cleanValue(rest, unescapeNil)
 
 			if li.value == "" {
 				li.lineType = lineKeyOnly
@@ -137,7 +387,7 @@ func (d *Decoder) peek() (*lineInfo, error) {
 			li.lineType = lineMultiLine
 			// For multi-line, the value is the *full line*
 			// with its indentation preserved, post-comment-stripping.
-			li.value = cleanLine
+			li.value, li.literal = unescapeLine(cleanLine)
 		}
 
 		d.peekBuf = li
@@ -147,71 +397,118 @@ func (d *Decoder) peek() (*lineInfo, error) {
 		return nil, err
 	}
 	// End of file
+	d.offset = d.scanned
 	return nil, nil
 }
 
+// cleanValue trims a single-line value, removing its inline comment
+// if they are enabled, and unescapes it with unescape, reporting
+// whether it was escaped.
+func (d *Decoder) cleanValue(value string, unescape func(string) (string, bool)) (string, bool) {
+	value = strings.TrimSpace(value)
+	if d.inlineComments {
+		value = stripInlineComment(value)
+	} else {
+		value = unescapeHash(value)
+	}
+	return unescape(value)
+}
+
+// sourceLine maps a scanned line number back to the line
+// number in the original input.
+func (d *Decoder) sourceLine(n int) int {
+	if d.lineMap != nil && n <= len(d.lineMap) {
+		return d.lineMap[n-1]
+	}
+	return n
+}
+
 // consume moves the scanner past the buffered line.
 func (d *Decoder) consume() {
+	if d.peekBuf != nil {
+		// Replayed lines were consumed once already.
+		d.offset = max(d.offset, d.peekBuf.end)
+	}
 	d.peekBuf = nil
 }
 
 // decodeValue is the main recursive unmarshalling function.
 func (d *Decoder) decodeValue(v reflect.Value, currentIndent int) error {
-	for { // Loop to skip any intermediate blank lines.
-		line, err := d.peek()
-		if err != nil {
-			return err
-		}
-		if line == nil {
-			return nil // End of file
-		}
-
-		// If the line is not indented deeper, it's not part of this value.
-		if line.indent <= currentIndent {
-			return nil
-		}
+	if n, ok := nodeTarget(v); ok {
+		return d.decodeNode(n, currentIndent)
+	}
+	if n, ok := d.genericNode(v); ok {
+		return d.decodeNode(n, currentIndent)
+	}
+	if isRawMessage(v) {
+		return d.decodeDocument(v, currentIndent)
+	}
 
-		// If it's a blank line, consume and peek at the next one.
-		if line.lineType == lineBlank {
-			d.consume()
-			continue
+	// Skip any intermediate blank lines.
+	line, err := d.peekChild(currentIndent)
+	if err != nil {
+		return err
+	}
+	if line == nil {
+		// End of file, or the line is not indented deeper,
+		// so it's not part of this value.
+		return nil
+	}
+	d.depth++
+	defer func() { d.depth-- }()
+	d.stats.MaxDepth = max(d.stats.MaxDepth, d.depth)
+
+	// We have a non-blank line, so we can process it.
+	switch line.lineType {
+	case lineKeyOnly, lineKeyValue:
+		// (key) or (key) value
+		// This is the start of an object.
+		return d.decodeObject(v, currentIndent, "")
+
+	case lineArrayItem, lineArrayObject:
+		// > value  OR  > (item)
+		// This must be a slice.
+		return d.decodeSlice(v, currentIndent)
+
+	case lineSetItem:
+		// >| value
+		return d.decodeSet(v, currentIndent)
+
+	case lineMultiLine:
+		//   value
+		// At the root, this is a lone scalar, e.g. "8080".
+		if currentIndent == -1 {
+			return d.decodeRootScalar(v, line.line)
 		}
+		// This must be a multi-line string.
+		return d.decodeMultiLineString(v, currentIndent)
 
-		// We have a non-blank line, so we can process it.
-		switch line.lineType {
-		case lineKeyOnly, lineKeyValue:
-			// (key) or (key) value
-			// This is the start of an object.
-			return d.decodeObject(v, currentIndent)
-
-		case lineArrayItem, lineArrayObject:
-			// > value  OR  > (item)
-			// This must be a slice.
-			return d.decodeSlice(v, currentIndent)
-
-		case lineSetItem:
-			// >| value
-			return d.decodeSet(v, currentIndent)
-
-		case lineMultiLine:
-			//   value
-			// This must be a multi-line string.
-			return d.decodeMultiLineString(v, currentIndent)
-
-		default:
-			// Should be impossible
-			return fmt.Errorf("%w: unknown line type %v", ErrSyntax, line.lineType)
-		}
+	default:
+		// Should be impossible
+		return fmt.Errorf("%w: unknown line type %v", ErrSyntax, line.lineType)
 	}
 }
 
-// decodeObject unmarshals into a struct or map.
-func (d *Decoder) decodeObject(v reflect.Value, currentIndent int) error {
+// decodeObject unmarshals into a struct or map. For a struct, prefix is
+// the path of keys leading to the object within it, such as "db.", when
+// the object holds fields with dotted keys; it is empty otherwise.
+func (d *Decoder) decodeObject(v reflect.Value, currentIndent int, prefix string) error {
 	v = indirect(v, true) // forceAlloc=true to create nil struct pointers
 	if !v.IsValid() {
 		return errors.New("piml: cannot unmarshal into invalid value")
 	}
 
+	if isEmptyInterface(v) {
+		// Generic object, keeping any existing entries.
+		m, _ := v.Interface().(map[string]interface{})
+		mv := reflect.ValueOf(&m).Elem()
+		if err := d.decodeObject(mv, currentIndent, ""); err != nil {
+			return err
+		}
+		v.Set(mv)
+		return nil
+	}
+
 	isMap := v.Kind() == reflect.Map
 	isStruct := v.Kind() == reflect.Struct
 
@@ -227,36 +524,147 @@ func (d *Decoder) decodeObject(v reflect.Value, currentIndent int) error {
 			v.Set(reflect.MakeMap(v.Type()))
 		}
 	}
+	var strMap map[string]string
+	var anyMap map[string]interface{}
+	if isMap && !d.typeHints && !d.genericNodes && d.traceHook == nil {
+		strMap, anyMap = fastMaps(v)
+	}
+
+	// Lines of the active profile's blocks, applied once the base is done.
+	var profileLines []*lineInfo
+	profileIndent := 0
+	var first *lineInfo // First field, for SetIndentUnit
+
+	// Key filters apply below the path of this object, see filterKey.
+	section, filtering := d.section, d.filtering
+	// So do the strict, lenient and append options of the field being
+	// decoded.
+	lenient, appendSlices := d.lenient, d.appendSlices
+	// And the path to the field, with SetPartial.
+	depth := len(d.path)
+	defer func() {
+		d.section, d.filtering = section, filtering
+		d.lenient, d.appendSlices = lenient, appendSlices
+		d.path = d.path[:depth]
+	}()
 
 	for {
-		line, err := d.peek()
+		d.section, d.filtering = section, filtering
+		d.lenient, d.appendSlices = lenient, appendSlices
+
+		// Blank lines between fields are skipped
+		line, err := d.peekChild(currentIndent)
 		if err != nil {
 			return err
 		}
-		if line == nil || line.indent <= currentIndent {
+		if line == nil {
 			break // End of this object
 		}
-
-		// Skip blank lines between fields
-		if line.lineType == lineBlank {
-			d.consume()
-			continue
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
 		}
 
 		if line.lineType != lineKeyValue && line.lineType != lineKeyOnly {
 			// This is a child of the object, it *must* be a key.
 			// e.g. Array items (>) are not allowed here.
-			return fmt.Errorf("%w: expected (key) or (key) value, got line type %v", ErrSyntax, line.lineType)
+			return fmt.Errorf("%w: line %d: expected (key) or (key) value, got line type %v", ErrSyntax, line.line, line.lineType)
 		}
 
 		key := line.key
+		if d.rejectDangling && line.lineType == lineKeyOnly {
+			if err := d.checkDangling(line); err != nil {
+				return err
+			}
+		}
+		d.valueLine = line.line
+		if d.partial || d.warning() || d.traceHook != nil {
+			d.pushPath(depth, key)
+		}
+
+		// (profile:name) blocks only apply when their profile is active.
+		if name, ok := strings.CutPrefix(key, profilePrefix); ok {
+			d.consume()
+			if d.profile != "" && name == d.profile && line.lineType == lineKeyOnly {
+				if profileLines == nil {
+					profileIndent = line.indent
+				}
+				lines, err := d.collectChildren(line.indent)
+				if err != nil {
+					return err
+				}
+				profileLines = append(profileLines, lines...)
+			} else {
+				d.consumeChildren(line.indent)
+			}
+			continue
+		}
+
+		if d.filtering {
+			path := d.section + key
+			switch d.filterKey(path) {
+			case filterSkip:
+				d.consume()
+				if line.lineType == lineKeyOnly {
+					d.consumeChildren(line.indent)
+				}
+				continue
+			case filterKeep:
+				d.filtering = false
+			case filterDescend:
+				d.section = path + "."
+			}
+		}
+
+		if d.keepOnNil && line.lineType == lineKeyValue && line.isNil() {
+			d.consume()
+			continue
+		}
+
+		// Scalars in the most common maps skip reflection.
+		if line.lineType == lineKeyValue && (strMap != nil || anyMap != nil) {
+			d.consume()
+			if err := d.setFastMapEntry(strMap, anyMap, key, line); err != nil {
+				err = fmt.Errorf("piml: line %d: error setting field %q: %w", line.line, key, err)
+				if d.skipError(err) {
+					continue
+				}
+				return err
+			}
+			if d.partial {
+				d.decodedPath()
+			}
+			continue
+		}
 
 		// Find the target field/map entry
 		var targetV reflect.Value
+		var opts tagOptions
+		var assign func() // Stores a concrete value, see RegisterType
 		if isStruct {
-			targetV, err = findStructField(v, key)
+			targetV, opts, err = d.findStructField(v, prefix+key)
+			if err != nil && !errors.Is(err, ErrAmbiguousField) {
+				if aliasV, aliasOpts, name, ok := d.findAlias(v, prefix+key); ok {
+					d.warn(WarnDeprecatedKey, "%q is deprecated, use %q", key, name)
+					targetV, opts, err = aliasV, aliasOpts, nil
+				}
+			}
 			if err != nil {
+				if d.strictFields && errors.Is(err, ErrAmbiguousField) {
+					return fmt.Errorf("piml: line %d: %w", line.line, err)
+				}
+				// The key holds fields with dotted keys.
+				if line.lineType == lineKeyOnly && isFieldGroup(v.Type(), prefix+key, d.keyMatcher(), d.tagKeys) {
+					d.consume()
+					if err := d.decodeObject(v, line.indent, prefix+key+"."); err != nil {
+						return fmt.Errorf("piml: error decoding field %q: %w", key, err)
+					}
+					continue
+				}
 				// Field not found, but we just consume and ignore
+				d.warn(WarnUnknownKey, "unknown key %q of %s, ignored", key, v.Type())
 				d.consume() // Consume the (key) or (key) value
 				// We also need to consume its children if it's (key) only
 				if line.lineType == lineKeyOnly {
@@ -264,129 +672,302 @@ func (d *Decoder) decodeObject(v reflect.Value, currentIndent int) error {
 				}
 				continue
 			}
+			// Fields tagged writeonly are written, but never read back.
+			if opts.Contains("writeonly") {
+				d.consume()
+				if line.lineType == lineKeyOnly {
+					d.consumeChildren(line.indent)
+				}
+				continue
+			}
+			d.setFieldCoercion(opts)
+			if opts.Contains("append") {
+				d.appendSlices = true
+			}
+			if name, ok := opts.Get("as"); ok && (line.lineType == lineKeyOnly || !line.isNil()) {
+				if targetV, assign, err = concreteTarget(targetV, name); err != nil {
+					return fmt.Errorf("piml: line %d: error setting field %q: %w", line.line, key, err)
+				}
+			}
 		} else if isMap {
-			// This is the new, robust logic
+			// Decode into a copy of any existing entry, so it is merged
+			// into rather than replaced, just like a struct field.
 			elemType := v.Type().Elem()
 			targetV = reflect.New(elemType)
+			if existing := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key())); existing.IsValid() {
+				targetV.Elem().Set(existing)
+			}
 		} else {
 			// Should be impossible
 			return errors.New("piml: invalid state in decodeObject")
 		}
 
 		// We have our targetV (either a struct field or a map element)
+		errs := len(d.partialErrs) // Errors skipped before this field
 		if line.lineType == lineKeyValue {
 			// (key) value
 			d.consume() // Consume the line
-			if err := d.setPrimitive(targetV, line.value); err != nil {
-				return fmt.Errorf("piml: error setting field %q: %w", key, err)
+			err = nil
+			if allowed, ok := opts.Get("enum"); ok && !line.isNil() {
+				err = checkEnumTag(allowed, line.value)
+			}
+			if err == nil {
+				err = d.setLineValue(targetV, line)
+			}
+			if err != nil {
+				err = fmt.Errorf("piml: line %d: error setting field %q: %w", line.line, key, err)
+				if d.skipError(err) {
+					continue
+				}
+				return err
 			}
 		} else {
 			// (key)
 			// This is a complex value, recurse
 			d.consume() // Consume the (key) line before recursing
-			if err := d.decodeValue(targetV, line.indent); err != nil {
-				return fmt.Errorf("piml: error decoding field %q: %w", key, err)
+			if opts.Contains("pimlstring") {
+				err = d.decodeDocument(targetV, line.indent)
+			} else {
+				err = d.decodeValue(targetV, line.indent)
+			}
+			if err != nil {
+				err = fmt.Errorf("piml: error decoding field %q: %w", key, err)
+				if d.skipError(err) {
+					d.consumeChildren(line.indent) // What is left of the value
+					continue
+				}
+				return err
 			}
 		}
 
+		if assign != nil {
+			assign()
+		}
+
 		// If it was a map, set the value in the map
 		if isMap {
 			// targetV is a *pointer* to the element type.
 			// We need to set the dereferenced element.
-			v.SetMapIndex(reflect.ValueOf(key), targetV.Elem())
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), targetV.Elem())
+		}
+		if d.partial && len(d.partialErrs) == errs {
+			d.decodedPath()
 		}
 	}
 
+	if profileLines != nil {
+		// Replay the profile's keys over the base, ahead of whatever
+		// line ended this object.
+		if d.peekBuf != nil {
+			profileLines = append(profileLines, d.peekBuf)
+			d.peekBuf = nil
+		}
+		d.replay = append(profileLines, d.replay...)
+		return d.decodeObject(v, profileIndent, prefix)
+	}
+
 	return nil
 }
 
 // decodeSlice unmarshals into a Go slice.
 func (d *Decoder) decodeSlice(v reflect.Value, currentIndent int) error {
-	v = indirect(v, false)
+	v = indirect(v, true) // Allocate nil pointers to the slice, at any depth
+	if isEmptyInterface(v) {
+		var s []interface{}
+		if d.appendSlices && !v.IsNil() {
+			s, _ = v.Elem().Interface().([]interface{})
+		}
+		sv := reflect.ValueOf(&s).Elem()
+		if err := d.decodeSlice(sv, currentIndent); err != nil {
+			return err
+		}
+		v.Set(sv)
+		return nil
+	}
 	if v.Kind() != reflect.Slice {
 		return fmt.Errorf("piml: cannot unmarshal array into %s", v.Kind())
 	}
+	// Items are decoded one by one with SetPartial, so each can fail,
+	// with SetTypeHints, so each can have a hint, and with SetTraceHook,
+	// so each is reported.
+	if !d.partial && !d.typeHints && d.traceHook == nil {
+		if ok, err := d.decodeFastSlice(v, currentIndent); ok {
+			return err
+		}
+	}
 
-	// Clear the slice
-	v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	// Clear the slice, unless appending to it
+	if !d.appendSlices {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
 	elemType := v.Type().Elem()
-
-	for {
-		line, err := d.peek()
+	var first *lineInfo // First item, for SetIndentUnit
+	depth := len(d.path)
+	defer func() { d.path = d.path[:depth] }()
+
+	// index counts the items of the document, failed ones included, so
+	// paths and errors name the items as they are written.
+	for index := 0; ; index++ {
+		// Blank lines between array items are skipped
+		line, err := d.peekChild(currentIndent)
 		if err != nil {
 			return err
 		}
-		if line == nil || line.indent <= currentIndent {
+		if line == nil {
 			break // End of array
 		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
+		}
 
-		// Skip blank lines between array items
-		if line.lineType == lineBlank {
-			d.consume()
-			continue
+		if line.lineType != lineArrayObject && line.lineType != lineArrayItem {
+			// This line is not an array item, so we're done.
+			break
 		}
 
-		// Allocate a new element
-		// We pass a pointer to the element type to decodeValue/setPrimitive
-		elemVPtr := reflect.New(elemType)
+		// Append a zero element and decode straight into it.
+		// We pass a pointer to the element to decodeValue/setPrimitive
+		v.Set(reflect.Append(v, reflect.Zero(elemType)))
+		elemVPtr := v.Index(v.Len() - 1).Addr()
+		d.valueLine = line.line
+		if d.partial || d.warning() || d.traceHook != nil {
+			d.pushPathIndex(depth, index)
+		}
+		errs := len(d.partialErrs) // Errors skipped before this item
 
 		if line.lineType == lineArrayObject {
 			// > (item)
 			// This is a list of objects.
 			d.consume() // Consume the '> (item)' line. It's just metadata.
 			// Now we decode the object *inside* the list item.
-			if err := d.decodeValue(elemVPtr, line.indent); err != nil {
-				return err
+			if err = d.decodeValue(elemVPtr, line.indent); err != nil {
+				err = fmt.Errorf("piml: error decoding item [%d]: %w", index, err)
 			}
-		} else if line.lineType == lineArrayItem {
-			// > value
+		} else {
+			// > value, or > alone with a multi-line string below it
 			d.consume() // Consume the line
-			if err := d.setPrimitive(elemVPtr, line.value); err != nil {
-				return err
+			var multi bool
+			if multi, err = d.multiLineItem(line); multi {
+				err = d.decodeMultiLineString(elemVPtr, line.indent)
+			} else if err == nil {
+				err = d.setLineValue(elemVPtr, line)
+			}
+			if err != nil {
+				err = fmt.Errorf("piml: line %d: error setting item [%d]: %w", line.line, index, err)
 			}
-		} else {
-			// This line is not an array item, so we're done.
-			break
 		}
-
-		// Append the new element (dereferenced from the pointer)
-		v.Set(reflect.Append(v, elemVPtr.Elem()))
+		if err != nil {
+			v.SetLen(v.Len() - 1) // Drop the element that failed
+			if d.skipError(err) {
+				if line.lineType == lineArrayObject {
+					d.consumeChildren(line.indent) // What is left of the item
+				}
+				continue
+			}
+			return err
+		}
+		if d.partial && len(d.partialErrs) == errs {
+			d.decodedPath()
+		}
 	}
 
 	return nil
 }
 
+// multiLineItem reports whether the array item line just consumed is a
+// > alone with the lines of a multi-line string below it, which are
+// then its value.
+func (d *Decoder) multiLineItem(line *lineInfo) (bool, error) {
+	if line.value != "" {
+		return false, nil
+	}
+	// The string may start with blank lines, as decodeMultiLineString
+	// reads them.
+	next, err := d.peekPastBlank()
+	if err != nil || next == nil {
+		return false, err
+	}
+	return next.lineType == lineMultiLine && next.indent > line.indent, nil
+}
+
+// peekPastBlank returns the first line after any blank lines, leaving
+// them all to be read again.
+func (d *Decoder) peekPastBlank() (*lineInfo, error) {
+	offset := d.offset
+	var blanks []*lineInfo
+	for {
+		line, err := d.peek()
+		if err != nil {
+			return nil, err
+		}
+		if line == nil || line.lineType != lineBlank {
+			if len(blanks) > 0 {
+				rest := blanks[1:]
+				if line != nil {
+					rest = append(rest, line)
+				}
+				d.replay = append(rest, d.replay...)
+				d.peekBuf = blanks[0]
+				d.offset = offset
+			}
+			return line, nil
+		}
+		blanks = append(blanks, line)
+		d.consume()
+	}
+}
+
 // decodeSet unmarshals into a Go map[string]struct{}.
 func (d *Decoder) decodeSet(v reflect.Value, currentIndent int) error {
-	v = indirect(v, false)
-
-	// We'll treat sets as map[string]struct{} or map[string]bool
-	if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
-		return fmt.Errorf("piml: sets must be unmarshalled into map[string]struct{} or map[string]bool")
+	v = indirect(v, true)
+	if isEmptyInterface(v) {
+		// Generic sets become a sorted list of their items.
+		var set map[string]bool
+		if err := d.decodeSet(reflect.ValueOf(&set).Elem(), currentIndent); err != nil {
+			return err
+		}
+		items := make([]string, 0, len(set))
+		for item := range set {
+			items = append(items, item)
+		}
+		sort.Strings(items)
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = d.genericScalar(item)
+		}
+		v.Set(reflect.ValueOf(list))
+		return nil
 	}
 
-	if v.IsNil() {
-		v.Set(reflect.MakeMap(v.Type()))
+	// We'll treat sets as map[T]struct{} or map[T]bool
+	if v.Kind() != reflect.Map {
+		return fmt.Errorf("piml: sets must be unmarshalled into map[T]struct{} or map[T]bool, not %s", v.Type())
 	}
 
 	elemType := v.Type().Elem()
-	var setValue reflect.Value
-	if elemType.Kind() == reflect.Struct && elemType.NumField() == 0 {
-		// map[string]struct{}
-		setValue = reflect.New(elemType).Elem()
-	} else if elemType.Kind() == reflect.Bool {
-		// map[string]bool
-		setValue = reflect.ValueOf(true)
-	} else {
-		return fmt.Errorf("piml: set must be map[string]struct{} or map[string]bool, not %s", v.Type())
+	setValue := reflect.New(elemType).Elem()
+	if elemType.Kind() == reflect.Bool {
+		// map[T]bool
+		setValue.SetBool(true)
+	} else if elemType.Kind() != reflect.Struct || elemType.NumField() != 0 {
+		return fmt.Errorf("piml: set must be map[T]struct{} or map[T]bool, not %s", v.Type())
+	}
+
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
 	}
+	var first *lineInfo // First item, for SetIndentUnit
 
 	for {
-		line, err := d.peek()
+		// Blank lines between set items are skipped
+		line, err := d.peekChild(currentIndent)
 		if err != nil {
 			return err
 		}
-		if line == nil || line.indent <= currentIndent {
+		if line == nil {
 			break // End of set
 		}
 
@@ -394,23 +975,126 @@ func (d *Decoder) decodeSet(v reflect.Value, currentIndent int) error {
 			// This line is not a set item, we're done.
 			break
 		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
+		}
 
 		d.consume()
-		keyV := reflect.ValueOf(line.value)
-		v.SetMapIndex(keyV, setValue)
+		// Items are parsed like any other scalar, e.g. map[int]struct{}
+		keyV := reflect.New(v.Type().Key())
+		if err := d.setLineValue(keyV, line); err != nil {
+			return fmt.Errorf("piml: line %d: %w", line.line, err)
+		}
+		v.SetMapIndex(keyV.Elem(), setValue)
 	}
 
 	return nil
 }
 
+// decodeRootScalar decodes a document holding a single scalar, written
+// as it would be after a key: "8080", "nil", etc. A document of
+// several lines is a multi-line string.
+func (d *Decoder) decodeRootScalar(v reflect.Value, line int) error {
+	s, literal, err := d.rootScalar()
+	if err != nil {
+		return err
+	}
+	if literal {
+		err = d.setLiteral(v, s)
+	} else {
+		err = d.setPrimitive(v, s)
+	}
+	if err != nil {
+		return fmt.Errorf("piml: line %d: %w", line, err)
+	}
+	return nil
+}
+
+// rootScalar reads the scalar of a document holding only that,
+// reporting whether it was escaped. A scalar on a single line is
+// trimmed and unescaped; one of several lines is a multi-line string.
+func (d *Decoder) rootScalar() (s string, literal bool, err error) {
+	first, err := d.peek()
+	if err != nil {
+		return "", false, err
+	}
+	// A line escaped as a line of a multi-line string is already
+	// unescaped.
+	escaped := first != nil && first.literal
+	if err := d.decodeMultiLineString(reflect.ValueOf(&s), -1); err != nil {
+		return "", false, err
+	}
+	if strings.Contains(s, "\n") {
+		return s, false, nil
+	}
+	if escaped {
+		return strings.TrimSpace(s), true, nil
+	}
+	s, literal = unescapeScalar(strings.TrimSpace(s))
+	return s, literal, nil
+}
+
+// setLineValue sets v to the value of line, which, if it was escaped,
+// is set as it is written.
+func (d *Decoder) setLineValue(v reflect.Value, line *lineInfo) error {
+	if line.literal {
+		return d.setLiteral(v, line.value)
+	}
+	return d.setPrimitive(v, line.value)
+}
+
+// setLiteral sets v to the text s as it is written, even nil, with no
+// resolvers applied.
+func (d *Decoder) setLiteral(v reflect.Value, s string) error {
+	if n, ok := nodeTarget(v); ok {
+		*n = Node{Kind: ScalarNode, Key: n.Key, Value: s}
+		return nil
+	}
+	if n, ok := d.genericNode(v); ok {
+		*n = Node{Kind: ScalarNode, Value: s}
+		return nil
+	}
+	target := indirect(v, true)
+	if isEmptyInterface(target) {
+		target.Set(reflect.ValueOf(s))
+		return nil
+	}
+	if err := d.setScalar(target, s); err != nil {
+		return err
+	}
+	if d.traceHook != nil {
+		d.traceAssign(target, s)
+	}
+	return nil
+}
+
 // decodeMultiLineString unmarshals a multi-line string.
 func (d *Decoder) decodeMultiLineString(v reflect.Value, currentIndent int) error {
 	v = indirect(v, true) // true = force allocation
+	if isEmptyInterface(v) {
+		var s string
+		if err := d.decodeMultiLineString(reflect.ValueOf(&s), currentIndent); err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(s))
+		return nil
+	}
+	if i, ok := nullableValueField(v.Type()); ok {
+		if err := d.decodeMultiLineString(v.Field(i), currentIndent); err != nil {
+			return err
+		}
+		v.FieldByName("Valid").SetBool(true)
+		return nil
+	}
 	if v.Kind() != reflect.String {
 		return fmt.Errorf("piml: cannot unmarshal multi-line string into %s", v.Kind())
 	}
 
-	var b strings.Builder
+	b := getBuffer()
+	defer putBuffer(b)
 	var baseIndent = -1 // -1 means not set yet
 
 	for {
@@ -457,16 +1141,53 @@ func (d *Decoder) decodeMultiLineString(v reflect.Value, currentIndent int) erro
 	}
 
 	v.SetString(b.String())
+	if d.traceHook != nil {
+		d.traceAssign(v, b.String())
+	}
 	return nil
 }
 
 // setPrimitive sets a primitive value (string, int, etc.)
-func (d *Decoder) setPrimitive(v reflect.Value, valueStr string) error {
+func (d *Decoder) setPrimitive(v reflect.Value, valueStr string) (err error) {
+	if d.traceHook != nil && !d.tracing {
+		// Hints and nullable types set values through nested calls,
+		// which the outermost one reports.
+		d.tracing = true
+		defer func() {
+			d.tracing = false
+			if err == nil {
+				d.traceAssign(v, valueStr)
+			}
+		}()
+	}
+	if n, ok := nodeTarget(v); ok {
+		setNode(n, valueStr) // Nodes keep nil and references as written
+		return nil
+	}
+	if n, ok := d.genericNode(v); ok {
+		setNode(n, valueStr)
+		return nil
+	}
+	if d.typeHints {
+		if hint, value, ok := cutTypeHint(valueStr); ok {
+			return d.setHinted(v, hint, value)
+		}
+	}
+
 	// 1. Handle "nil" first.
 	if valueStr == "nil" {
 		if !v.CanSet() {
+			if v.Kind() != reflect.Ptr || v.IsNil() {
+				return fmt.Errorf("piml: cannot assign nil to unaddressable %s", v.Type())
+			}
 			v = v.Elem()
 		}
+		// sql.NullString and friends become invalid, and net.IPNet and
+		// the netip types zero
+		if _, ok := nullableValueField(v.Type()); ok || zeroIsNil(v.Type()) {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
 		// Check if the target type can be nil
 		switch v.Kind() {
 		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
@@ -480,13 +1201,49 @@ func (d *Decoder) setPrimitive(v reflect.Value, valueStr string) error {
 		}
 	}
 
-	// 2. Dereference pointer
-	v = indirect(v, true) // true = force allocation
+	// 2. Resolve external references, e.g. env://NAME
+	resolved, err := d.resolve(valueStr)
+	if err != nil {
+		return err
+	}
+
+	// 3. Dereference pointer
+	return d.setScalar(indirect(v, true), resolved) // true = force allocation
+}
+
+// setScalar sets a dereferenced, non-nil primitive value.
+func (d *Decoder) setScalar(v reflect.Value, valueStr string) error {
+	if v.Type() == timeType {
+		if _, ok := lookupNamed(timeType); !ok {
+			return d.setTime(v, valueStr)
+		}
+	}
+	// big.Int, encoding.TextUnmarshaler, etc. parse themselves
+	if ok, err := parseScalar(v, valueStr); ok {
+		return err
+	}
+
+	if d.lenient {
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64, reflect.Bool:
+			if coerced := coerce(v.Kind(), valueStr); coerced != valueStr {
+				d.warn(WarnCoercion, "%q read as %s", valueStr, coerced)
+				valueStr = coerced
+			}
+		}
+	}
 
-	// 3. Set value based on kind
 	switch v.Kind() {
 	case reflect.String:
 		v.SetString(valueStr)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return fmt.Errorf("piml: cannot unmarshal primitive into %s", v.Type())
+		}
+		// Generic scalars are strings, or numbers, see SetNumberMode.
+		v.Set(reflect.ValueOf(d.genericScalar(valueStr)))
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		i, err := strconv.ParseInt(valueStr, 10, 64)
 		if err != nil {
@@ -513,6 +1270,9 @@ func (d *Decoder) setPrimitive(v reflect.Value, valueStr string) error {
 		if v.OverflowFloat(f) {
 			return fmt.Errorf("piml: float overflow: %s", valueStr)
 		}
+		if v.Kind() == reflect.Float32 && float64(float32(f)) != f {
+			d.warn(WarnCoercion, "%s rounded to %v to fit a float32", valueStr, float64(float32(f)))
+		}
 		v.SetFloat(f)
 	case reflect.Bool:
 		b, err := strconv.ParseBool(valueStr)
@@ -520,22 +1280,28 @@ func (d *Decoder) setPrimitive(v reflect.Value, valueStr string) error {
 			return fmt.Errorf("piml: invalid boolean value: %w", err)
 		}
 		v.SetBool(b)
-	case reflect.Struct: // <-- NEW CASE
-		if v.Type() == reflect.TypeOf(time.Time{}) {
-			t, err := time.Parse(time.RFC3339Nano, valueStr)
-			if err != nil {
-				return fmt.Errorf("piml: invalid time format: %w", err)
+	case reflect.Struct:
+		// sql.NullString and friends hold a valid value
+		if i, ok := nullableValueField(v.Type()); ok {
+			if err := d.setScalar(indirect(v.Field(i), true), valueStr); err != nil {
+				return err
 			}
-			v.Set(reflect.ValueOf(t))
-		} else {
-			return fmt.Errorf("piml: cannot unmarshal primitive into %s", v.Kind())
+			v.FieldByName("Valid").SetBool(true)
+			return nil
 		}
+		return fmt.Errorf("piml: cannot unmarshal primitive into %s", v.Kind())
 	default:
 		return fmt.Errorf("piml: cannot unmarshal primitive into %s", v.Kind())
 	}
 	return nil
 }
 
+// isEmptyInterface reports whether v is an interface{} that can
+// hold a generic value.
+func isEmptyInterface(v reflect.Value) bool {
+	return v.Kind() == reflect.Interface && v.NumMethod() == 0
+}
+
 // indirect dereferences pointers until it gets a non-pointer.
 // If forceAlloc is true, it will allocate new pointers.
 func indirect(v reflect.Value, forceAlloc bool) reflect.Value {
@@ -552,47 +1318,90 @@ func indirect(v reflect.Value, forceAlloc bool) reflect.Value {
 	return v
 }
 
-// findStructField finds a field in a struct by its piml tag.
-func findStructField(v reflect.Value, key string) (reflect.Value, error) {
-	t := v.Type()
-	for i := 0; i < t.NumField(); i++ {
-		fieldT := t.Field(i)
-		fieldV := v.Field(i)
-
-		// 1. Check tag
-		tag := fieldT.Tag.Get("piml")
-		if tag == key {
-			return fieldV, nil
+// findStructField finds a field in a struct by its key, allocating
+// any nil embedded pointers leading to it, and returns it along with
+// its tag's options. Keys are compared with the decoder's keyMatcher,
+// and tags are read under its tag keys. Untagged fields also match in
+// the ways set with SetKeyCases.
+func (d *Decoder) findStructField(v reflect.Value, key string) (reflect.Value, tagOptions, error) {
+	match := d.keyMatcher()
+	info := cachedFields(v.Type(), d.tagKeys...)
+	i, ok := info.byName[key]
+	if match != nil {
+		ok = false
+		for j, f := range info.fields {
+			if match(key, f.name) {
+				i, ok = j, true
+				break
+			}
 		}
-
-		// 2. Check default name (if no tag)
-		if tag == "" && strings.ToLower(fieldT.Name) == key {
-			return fieldV, nil
+	}
+	if !ok && d.keyCases != 0 {
+		for j, f := range info.fields {
+			if !f.tagged && matchesCase(key, f.goName, d.keyCases) {
+				i, ok = j, true
+				break
+			}
 		}
-
-		// 3. Recurse into anonymous/embedded structs *regardless* of tag
-		if fieldT.Anonymous && fieldT.Type.Kind() == reflect.Struct {
-			if f, err := findStructField(fieldV, key); err == nil {
-				return f, nil // Found in embedded struct
+	}
+	if !ok {
+		for _, name := range info.ambiguous {
+			if name == key || (match != nil && match(key, name)) {
+				return reflect.Value{}, "", fmt.Errorf("%w: %q matches more than one field of %s", ErrAmbiguousField, key, v.Type())
 			}
 		}
+		return reflect.Value{}, "", fmt.Errorf("field %q not found", key)
+	}
+	f := info.fields[i]
+	fieldV, _ := fieldByIndex(v, f.index, true)
+	return fieldV, f.opts, nil
+}
+
+// collectChildren consumes and returns all lines that are
+// indented more than the given indent.
+func (d *Decoder) collectChildren(currentIndent int) ([]*lineInfo, error) {
+	var lines []*lineInfo
+	for {
+		line, err := d.peekChild(currentIndent)
+		if err != nil {
+			return nil, err
+		}
+		if line == nil {
+			return lines, nil
+		}
+		lines = append(lines, line)
+		d.consume()
 	}
-	return reflect.Value{}, fmt.Errorf("field %q not found", key)
 }
 
 // consumeChildren peeks and consumes all lines that are
 // indented more than the given indent.
 func (d *Decoder) consumeChildren(currentIndent int) {
 	for {
-		line, err := d.peek()
+		line, err := d.peekChild(currentIndent)
 		if err != nil || line == nil {
-			return // EOF or error
+			return // EOF, error, or a line at our level or above
 		}
+		d.consume()
+	}
+}
 
-		if line.indent > currentIndent {
+// peekChild peeks at the next non-blank line, if it is indented more
+// than the given indent. Blank lines are consumed along the way: they
+// never end a block, however they are indented.
+func (d *Decoder) peekChild(currentIndent int) (*lineInfo, error) {
+	for {
+		line, err := d.peek()
+		if err != nil || line == nil {
+			return nil, err
+		}
+		if line.lineType == lineBlank {
 			d.consume()
-		} else {
-			return // We found a line at our level or above
+			continue
+		}
+		if line.indent <= currentIndent {
+			return nil, nil
 		}
+		return line, nil
 	}
 }
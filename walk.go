@@ -0,0 +1,279 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Position is the location of a line in a PIML document.
+type Position struct {
+	Line   int // Line number, starting at 1
+	Column int // Column of the first character after the indentation, starting at 1
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, column %d", p.Line, p.Column)
+}
+
+// A Handler receives the events of Walk. Nil callbacks are skipped, so
+// only the events of interest need to be handled. An error returned by
+// a callback stops the walk and is returned by Walk.
+//
+// Scalars are reported as written, once unescaped, so nil is the scalar
+// "nil", as is the string nil, written `\nil`. The end of a block is
+// reported with the position of its start.
+type Handler struct {
+	OnKey         func(key string, pos Position) error
+	OnScalar      func(value string, pos Position) error
+	OnObjectStart func(pos Position) error
+	OnObjectEnd   func(pos Position) error
+	OnArrayStart  func(pos Position) error
+	OnArrayEnd    func(pos Position) error
+	OnSetStart    func(pos Position) error
+	OnSetEnd      func(pos Position) error
+
+	onLabel func(label string)       // Called with the label of an object in a list, for decodeNode
+	onNil   func(pos Position) error // Called instead of OnScalar for nil, but not `\nil`, if set
+}
+
+// Walk parses data in a single pass and calls h for every key, scalar
+// and block it finds, without decoding into Go values. This makes it
+// cheap to gather statistics over documents too large to decode:
+//
+//	var keys int
+//	err := piml.Walk(data, piml.Handler{
+//		OnKey: func(key string, pos piml.Position) error {
+//			keys++
+//			return nil
+//		},
+//	})
+//
+// The root of a document is reported as an object, an array, a set or
+// a scalar, depending on its first line.
+func Walk(data []byte, h Handler) error {
+	return NewDecoder(data).Walk(h)
+}
+
+// Walk is like the Walk function, but reads the decoder's input, with
+// its settings such as SetInlineComments and SetIndentUnit.
+func (d *Decoder) Walk(h Handler) (err error) {
+	defer catchPanic(&err)
+	if d.checksum || d.verifier != nil {
+		if err := d.verifyInput(); err != nil {
+			return err
+		}
+	}
+	if d.tmpl != nil && !d.rendered {
+		if err := d.render(); err != nil {
+			return err
+		}
+	}
+	if d.flat && !d.expanded {
+		if err := d.expand(); err != nil {
+			return err
+		}
+	}
+	d.nextDocument()
+	line, err := d.peekChild(-1)
+	if err != nil || line == nil {
+		return err // An empty document has no root value
+	}
+	if err := d.walkValue(h, -1, linePosition(line)); err != nil {
+		return err
+	}
+	// Whatever is left didn't fit in the root block.
+	line, err = d.peekChild(-1)
+	if err != nil || line == nil {
+		return err
+	}
+	return fmt.Errorf("%w: line %d: unexpected line type %v after the root value", ErrSyntax, line.line, line.lineType)
+}
+
+// walkValue reports the value of a key at currentIndent, or of the
+// root, whose position is pos. The key line must have been consumed.
+func (d *Decoder) walkValue(h Handler, currentIndent int, pos Position) error {
+	line, err := d.peekChild(currentIndent)
+	if err != nil {
+		return err
+	}
+	if line == nil {
+		// (key) with nothing below it is an empty object.
+		return d.walkObject(h, currentIndent, pos)
+	}
+
+	switch line.lineType {
+	case lineArrayItem, lineArrayObject:
+		return d.walkArray(h, currentIndent, pos)
+	case lineSetItem:
+		return d.walkSet(h, currentIndent, pos)
+	case lineMultiLine:
+		if currentIndent == -1 {
+			s, literal, err := d.rootScalar()
+			if err != nil {
+				return err
+			}
+			return walkScalar(h, s, literal, linePosition(line))
+		}
+		var s string
+		if err := d.decodeMultiLineString(reflect.ValueOf(&s), currentIndent); err != nil {
+			return err
+		}
+		return call(h.OnScalar, s, linePosition(line))
+	}
+	return d.walkObject(h, currentIndent, pos)
+}
+
+// walkObject reports the fields of the object at currentIndent.
+func (d *Decoder) walkObject(h Handler, currentIndent int, pos Position) error {
+	if err := callPos(h.OnObjectStart, pos); err != nil {
+		return err
+	}
+	var first *lineInfo // First field, for SetIndentUnit
+	for {
+		line, err := d.peekChild(currentIndent)
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			break // End of this object
+		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
+		}
+		if line.lineType != lineKeyValue && line.lineType != lineKeyOnly {
+			return fmt.Errorf("%w: line %d: expected (key) or (key) value, got line type %v", ErrSyntax, line.line, line.lineType)
+		}
+
+		d.consume()
+		linePos := linePosition(line)
+		if err := call(h.OnKey, line.key, linePos); err != nil {
+			return err
+		}
+		if line.lineType == lineKeyValue {
+			err = walkScalar(h, line.value, line.literal, linePos)
+		} else {
+			err = d.walkValue(h, line.indent, linePos)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return callPos(h.OnObjectEnd, pos)
+}
+
+// walkArray reports the items of the array at currentIndent.
+func (d *Decoder) walkArray(h Handler, currentIndent int, pos Position) error {
+	if err := callPos(h.OnArrayStart, pos); err != nil {
+		return err
+	}
+	var first *lineInfo // First item, for SetIndentUnit
+	for {
+		line, err := d.peekChild(currentIndent)
+		if err != nil {
+			return err
+		}
+		if line == nil || (line.lineType != lineArrayItem && line.lineType != lineArrayObject) {
+			break // End of array
+		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
+		}
+
+		d.consume()
+		if line.lineType == lineArrayItem {
+			err = d.walkItem(h, line)
+		} else {
+			// > (item), with the object's fields below it
+			if h.onLabel != nil {
+				h.onLabel(line.key)
+			}
+			err = d.walkObject(h, line.indent, linePosition(line))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return callPos(h.OnArrayEnd, pos)
+}
+
+// walkItem reports the scalar of the array item line just consumed,
+// which may be a multi-line string below it.
+func (d *Decoder) walkItem(h Handler, line *lineInfo) error {
+	multi, err := d.multiLineItem(line)
+	if err != nil {
+		return err
+	}
+	if !multi {
+		return walkScalar(h, line.value, line.literal, linePosition(line))
+	}
+	var s string
+	if err := d.decodeMultiLineString(reflect.ValueOf(&s), line.indent); err != nil {
+		return err
+	}
+	return call(h.OnScalar, s, linePosition(line))
+}
+
+// walkSet reports the items of the set at currentIndent.
+func (d *Decoder) walkSet(h Handler, currentIndent int, pos Position) error {
+	if err := callPos(h.OnSetStart, pos); err != nil {
+		return err
+	}
+	var first *lineInfo // First item, for SetIndentUnit
+	for {
+		line, err := d.peekChild(currentIndent)
+		if err != nil {
+			return err
+		}
+		if line == nil || line.lineType != lineSetItem {
+			break // End of set
+		}
+		if first == nil {
+			first = line
+		}
+		if err := d.checkIndent(line, first); err != nil {
+			return err
+		}
+
+		d.consume()
+		if err := walkScalar(h, line.value, line.literal, linePosition(line)); err != nil {
+			return err
+		}
+	}
+	return callPos(h.OnSetEnd, pos)
+}
+
+// walkScalar reports the scalar s, which is nil if it is "nil" and was
+// not escaped.
+func walkScalar(h Handler, s string, literal bool, pos Position) error {
+	if s == "nil" && !literal && h.onNil != nil {
+		return h.onNil(pos)
+	}
+	return call(h.OnScalar, s, pos)
+}
+
+// linePosition returns the position of the content of line.
+func linePosition(line *lineInfo) Position {
+	return Position{Line: line.line, Column: line.indent + 1}
+}
+
+// call calls an OnKey or OnScalar callback, if it is set.
+func call(fn func(string, Position) error, s string, pos Position) error {
+	if fn == nil {
+		return nil
+	}
+	return fn(s, pos)
+}
+
+// callPos calls a block callback, if it is set.
+func callPos(fn func(Position) error, pos Position) error {
+	if fn == nil {
+		return nil
+	}
+	return fn(pos)
+}
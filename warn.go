@@ -0,0 +1,116 @@
+package piml
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// WarningKind is the kind of a Warning.
+type WarningKind int
+
+const (
+	// WarnUnknownKey is a key that matches no field of a struct, and
+	// was skipped.
+	WarnUnknownKey WarningKind = iota
+
+	// WarnDeprecatedKey is a key that matched a field through one of
+	// its old names, tagged `piml:"port,alias=listen_port"`.
+	WarnDeprecatedKey
+
+	// WarnCoercion is a value that was read as something other than
+	// what it says: a sloppy number or boolean read by SetLenient, or a
+	// float rounded to fit a float32.
+	WarnCoercion
+)
+
+func (k WarningKind) String() string {
+	switch k {
+	case WarnUnknownKey:
+		return "unknown key"
+	case WarnDeprecatedKey:
+		return "deprecated key"
+	case WarnCoercion:
+		return "coercion"
+	}
+	return fmt.Sprintf("WarningKind(%d)", int(k))
+}
+
+// A Warning is a problem the decoder got past without failing, such as
+// a misspelled key, which an application may want to log.
+type Warning struct {
+	Kind    WarningKind
+	Line    int    // Line of the value, 0 for the root
+	Path    string // Path to the value, as in db.port or hosts[1]
+	Message string
+}
+
+func (w Warning) String() string {
+	var b strings.Builder
+	if w.Line > 0 {
+		fmt.Fprintf(&b, "line %d: ", w.Line)
+	}
+	if w.Path != "" {
+		b.WriteString(w.Path + ": ")
+	}
+	b.WriteString(w.Message)
+	return b.String()
+}
+
+// SetWarnings makes the decoder record warnings, returned by Warnings
+// after Decode.
+func (d *Decoder) SetWarnings(on bool) {
+	d.warnings = on
+}
+
+// SetWarningHandler makes the decoder call fn with each warning as it
+// comes across it, for instance to log it. A nil fn stops the calls.
+func (d *Decoder) SetWarningHandler(fn func(Warning)) {
+	d.warnHandler = fn
+}
+
+// Warnings returns the warnings of the last Decode, in the order of the
+// document. They are only recorded with SetWarnings.
+func (d *Decoder) Warnings() []Warning {
+	return d.warned
+}
+
+// warning reports whether the decoder reports warnings at all.
+func (d *Decoder) warning() bool {
+	return d.warnings || d.warnHandler != nil
+}
+
+// warn reports a warning about the value being decoded.
+func (d *Decoder) warn(kind WarningKind, format string, args ...interface{}) {
+	if !d.warning() {
+		return
+	}
+	w := Warning{Kind: kind, Line: d.valueLine, Path: formatPath(d.path), Message: fmt.Sprintf(format, args...)}
+	if d.warnings {
+		d.warned = append(d.warned, w)
+	}
+	if d.warnHandler != nil {
+		d.warnHandler(w)
+	}
+}
+
+// findAlias finds a field of the struct v with key among the old names
+// in its alias option, returning it along with its tag's options and
+// its current key.
+func (d *Decoder) findAlias(v reflect.Value, key string) (reflect.Value, tagOptions, string, bool) {
+	match := d.keyMatcher()
+	info := cachedFields(v.Type(), d.tagKeys...)
+	for _, f := range info.fields {
+		aliases, ok := f.opts.Get("alias")
+		if !ok {
+			continue
+		}
+		for _, alias := range strings.Split(aliases, "|") {
+			if alias == key || (match != nil && match(key, alias)) {
+				fieldV, _ := fieldByIndex(v, f.index, true)
+				return fieldV, f.opts, f.name, true
+			}
+		}
+	}
+	return reflect.Value{}, "", "", false
+}
@@ -0,0 +1,134 @@
+//go:build js && wasm
+
+// Command wasm exposes PIML to JavaScript, for browser-based editors and
+// playgrounds that should use the exact implementation of the Go backend.
+// Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o piml.wasm ./wasm
+//
+// and load it with the wasm_exec.js shipped with Go. It sets a global
+// piml object with these functions:
+//
+//	piml.parse(text)             // The document as an object
+//	piml.format(text)            // The document as Marshal writes it
+//	piml.convert(text, from, to) // The document in another format
+//
+// The formats are piml, json, xml, ini and properties. Each function
+// returns {value: ...}, or {error: "..."} if it fails, rather than
+// throwing, as a panic would end the program.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"syscall/js"
+
+	piml "github.com/fezcode/go-piml"
+	"github.com/fezcode/go-piml/convert"
+)
+
+func main() {
+	js.Global().Set("piml", js.ValueOf(map[string]interface{}{
+		"parse":   function(1, parse),
+		"format":  function(1, format),
+		"convert": function(3, convertText),
+	}))
+	select {} // Keep the functions alive
+}
+
+// function wraps fn, which takes n string arguments, as a JavaScript
+// function returning {value} or {error}.
+func function(n int, fn func(args []string) (interface{}, error)) js.Func {
+	return js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		if len(args) != n {
+			return result(nil, fmt.Errorf("expected %d arguments, got %d", n, len(args)))
+		}
+		strs := make([]string, n)
+		for i, arg := range args {
+			if arg.Type() != js.TypeString {
+				return result(nil, fmt.Errorf("argument %d is a %s, not a string", i+1, arg.Type()))
+			}
+			strs[i] = arg.String()
+		}
+		return result(fn(strs))
+	})
+}
+
+// result returns the JavaScript object of a value or an error.
+func result(v interface{}, err error) interface{} {
+	if err != nil {
+		return map[string]interface{}{"error": err.Error()}
+	}
+	return map[string]interface{}{"value": v}
+}
+
+// parse returns the document in args[0] as generic values, which
+// js.ValueOf turns into objects, arrays, strings and null.
+func parse(args []string) (interface{}, error) {
+	var v interface{}
+	if err := piml.Unmarshal([]byte(args[0]), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// format returns the document in args[0] as Marshal writes it. It goes
+// through a Node, so profile blocks are written back as they are.
+func format(args []string) (interface{}, error) {
+	n, err := piml.ParseNode([]byte(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	out, err := piml.Marshal(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(out), nil
+}
+
+// convertText converts the document in args[0] from the format args[1]
+// to the format args[2], through PIML.
+func convertText(args []string) (interface{}, error) {
+	data, from, to := []byte(args[0]), args[1], args[2]
+	var err error
+	switch from {
+	case "piml":
+	case "json":
+		var v interface{}
+		if err = json.Unmarshal(data, &v); err == nil {
+			data, err = piml.Marshal(v)
+		}
+	case "xml":
+		data, err = convert.XMLToPIML(data, convert.XMLOptions{})
+	case "ini":
+		data, err = convert.INIToPIML(data)
+	case "properties":
+		data, err = convert.PropertiesToPIML(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", from)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch to {
+	case "piml":
+	case "json":
+		var v interface{}
+		if err = piml.Unmarshal(data, &v); err == nil {
+			data, err = json.MarshalIndent(v, "", "  ")
+		}
+	case "xml":
+		data, err = convert.PIMLToXML(data, convert.XMLOptions{})
+	case "ini":
+		data, err = convert.PIMLToINI(data)
+	case "properties":
+		data, err = convert.PIMLToProperties(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q", to)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,22 @@
+//go:build js && wasm
+
+package main
+
+import "testing"
+
+// Run with
+//
+//	GOOS=js GOARCH=wasm go test -exec="$(go env GOROOT)/lib/wasm/go_js_wasm_exec" ./wasm
+
+func TestFormat(t *testing.T) {
+	in := "(name)   app\n(port) 80\n(profile:prod)\n  (port)   443\n"
+	want := "(name) app\n(port) 80\n(profile:prod)\n  (port) 443\n"
+	out, err := format([]string{in})
+	if err != nil || out != want {
+		t.Fatalf("Expected:\n%s\nGot:\n%v\nError: %v", want, out, err)
+	}
+
+	if _, err := format([]string{"(a\n"}); err == nil {
+		t.Fatal("Expected an error for a malformed document")
+	}
+}
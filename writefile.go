@@ -0,0 +1,199 @@
+package piml
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WriteFileOptions holds the settings of WriteFileWithOptions. Its zero
+// value writes files as WriteFile does.
+type WriteFileOptions struct {
+	Encoder      EncoderOptions // Settings of the encoding
+	KeepComments bool           // Edit an existing file instead of replacing it
+	Backups      int            // Number of timestamped backups to keep, none if 0
+}
+
+// WriteFile marshals v and writes it to the named file, as
+// os.WriteFile writes data, but atomically: the document is written to
+// a temporary file in the same directory, synced to disk and renamed
+// over the file, so a crash or a full disk never leaves a file that is
+// half written. An existing file keeps its permissions, and a new one
+// gets perm, as it is, whatever the umask. A symbolic link is written
+// through, to the file it points to.
+//
+//	if err := piml.WriteFile("config.piml", cfg, 0o644); err != nil {
+//		return err
+//	}
+func WriteFile(name string, v interface{}, perm fs.FileMode) error {
+	return WriteFileWithOptions(name, v, perm, WriteFileOptions{})
+}
+
+// WriteFileWithOptions is like WriteFile, with the settings in opts.
+//
+// With KeepComments, an existing file is changed the way an Editor
+// changes it rather than replaced: only the values that differ from v
+// are written anew, and comments, blank lines and the order of keys
+// are kept, with new keys added at the end of their objects. The file
+// must then be valid PIML. KeepComments has no effect with a Checksum
+// or a Signer, as the trailer covers the whole document.
+//
+// With Backups, the file is first copied next to itself under a
+// timestamped name, such as config.piml.20240501T120000.000000000Z.bak,
+// unless it is left unchanged, and only the newest Backups backups are
+// kept. Rollback restores them, newest first:
+//
+//	opts := piml.WriteFileOptions{KeepComments: true, Backups: 10}
+//	if err := piml.WriteFileWithOptions("config.piml", cfg, 0o644, opts); err != nil {
+//		return err
+//	}
+func WriteFileWithOptions(name string, v interface{}, perm fs.FileMode, opts WriteFileOptions) error {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	data, err := MarshalWithOptions(v, opts.Encoder)
+	if err != nil {
+		return err
+	}
+	if opts.KeepComments && !opts.Encoder.Checksum && opts.Encoder.Signer == nil {
+		if data, err = keepComments(name, data); err != nil {
+			return err
+		}
+	}
+	if opts.Backups > 0 {
+		if err := backup(name, data, opts.Backups); err != nil {
+			return err
+		}
+	}
+	return writeAtomic(name, data, perm)
+}
+
+// keepComments returns the named file changed into the document data,
+// or data if there is no such file.
+func keepComments(name string, data []byte) ([]byte, error) {
+	old, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return data, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ed, err := NewEditor(old)
+	if err != nil {
+		return nil, &fs.PathError{Op: "edit", Path: name, Err: err}
+	}
+	oldNode, err := ParseNode(old)
+	if err != nil {
+		return nil, err
+	}
+	newNode, err := ParseNode(data)
+	if err != nil {
+		return nil, err
+	}
+	if !editable(oldNode) || !editable(newNode) {
+		return data, nil
+	}
+	if err := editInto(ed, nil, oldNode, newNode); err != nil {
+		return nil, err
+	}
+	return ed.Bytes(), nil
+}
+
+// editable reports whether the fields of n can be edited one by one,
+// which needs n to be an object with keys that paths can name.
+func editable(n *Node) bool {
+	if n.Kind != ObjectNode {
+		return false
+	}
+	for _, c := range n.Children {
+		if c.Key == "" || strings.ContainsAny(c.Key, ".[") {
+			return false
+		}
+	}
+	return true
+}
+
+// editInto changes the value at steps in ed, which is old, into n,
+// setting only the values that differ.
+func editInto(ed *Editor, steps []nodeStep, old, n *Node) error {
+	switch {
+	case old.Kind == ScalarNode && n.Kind == ScalarNode && old.Value == n.Value,
+		old.Kind == NilNode && n.Kind == NilNode:
+		return nil
+
+	case editable(old) && editable(n):
+		for _, c := range old.Children {
+			step := nodeStep{key: c.Key, index: -1}
+			if n.child(step) == nil {
+				if err := ed.Delete(nodePath(append(steps[:len(steps):len(steps)], step))); err != nil {
+					return err
+				}
+			}
+		}
+		for _, c := range n.Children {
+			step := nodeStep{key: c.Key, index: -1}
+			path := append(steps[:len(steps):len(steps)], step)
+			if o := old.child(step); o != nil {
+				if err := editInto(ed, path, o, c); err != nil {
+					return err
+				}
+			} else if err := ed.Set(nodePath(path), c); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case (old.Kind == ArrayNode || old.Kind == SetNode) && old.Kind == n.Kind && len(old.Children) == len(n.Children):
+		for i, c := range n.Children {
+			path := append(steps[:len(steps):len(steps)], nodeStep{index: i})
+			if err := editInto(ed, path, old.Children[i], c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ed.Set(nodePath(steps), n)
+}
+
+// writeAtomic writes data to the named file through a temporary file
+// renamed over it. The file keeps its permissions if it exists.
+func writeAtomic(name string, data []byte, perm fs.FileMode) (err error) {
+	if info, err := os.Stat(name); err == nil {
+		perm = info.Mode().Perm()
+	}
+	dir := filepath.Dir(name)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	// Sync the directory too, so the rename itself is on disk. Not all
+	// systems can, so this is done as well as it can be.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}